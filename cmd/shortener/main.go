@@ -7,15 +7,22 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/BuzzLyutic/url-shortener/internal/config"
 	"github.com/BuzzLyutic/url-shortener/internal/handler"
+	"github.com/BuzzLyutic/url-shortener/internal/logging"
 	"github.com/BuzzLyutic/url-shortener/internal/service"
 	"github.com/BuzzLyutic/url-shortener/internal/storage"
+	"github.com/BuzzLyutic/url-shortener/internal/tracing"
 )
 
+// purgeInterval - период окончательного удаления ссылок с истекшим сроком восстановления
+// и отправленных доставок вебхуков с истекшим сроком хранения
+const purgeInterval = time.Hour
+
 func main() {
 	if err := run(); err != nil {
 		slog.Error("application error", slog.Any("error", err))
@@ -24,22 +31,53 @@ func main() {
 }
 
 func run() error {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "export":
+			return runExport(args[1:])
+		case "import":
+			return runImport(args[1:])
+		case "migrate-storage":
+			return runMigrateStorage(args[1:])
+		}
+	}
+
 	// Загрузка конфигурации
-	cfg, err := config.Load()
+	cfg, err := config.LoadArgs(args)
 	if err != nil {
 		return err
 	}
 
 	// Установка логгера
-	logger := setupLogger(cfg.LogLevel)
+	logger, logLevel := setupLogger(cfg.LogLevel)
 	slog.SetDefault(logger)
 
 	logger.Info("Starting URL shortener",
 		slog.String("address", cfg.ServerAddress),
 		slog.String("storage", cfg.StorageType),
 		slog.String("base_url", cfg.BaseURL),
+		slog.String("path_prefix", cfg.PathPrefix()),
+		slog.Any("domains", cfg.Domains),
 	)
 
+	// Инициализация трассировки
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:     cfg.TraceExporter,
+		File:         cfg.TraceFile,
+		OTLPEndpoint: cfg.OTLPEndpoint,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("tracing shutdown failed", slog.Any("error", err))
+		}
+	}()
+
 	// Инициализация хранилища
 	store, err := initStorage(cfg, logger)
 	if err != nil {
@@ -48,13 +86,39 @@ func run() error {
 	defer store.Close()
 
 	// Инициализация сервиса
-	svc := service.New(store, service.Config{
-		BaseURL:    cfg.BaseURL,
-		DefaultTTL: cfg.DefaultTTL,
-	})
+	svc := newService(cfg, store)
+
+	// Фоновые задачи: применение запланированных смен адреса,
+	// окончательное удаление ссылок с истекшим сроком восстановления
+	// и старых доставок, отправка вебхуков
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	if cfg.SwitchInterval > 0 {
+		go runSwitches(jobsCtx, svc, cfg.SwitchInterval, logger)
+	}
+	if cfg.RestoreWindow > 0 || cfg.WebhookRetention > 0 {
+		go runPurge(jobsCtx, svc, logger)
+	}
+	if cfg.WebhookInterval > 0 {
+		go runWebhooks(jobsCtx, svc, cfg.WebhookInterval, logger)
+	}
 
 	// Инициализация хэндлера
-	h := handler.New(svc, logger)
+	handlerCfg := handler.Config{
+		PathPrefix:    cfg.PathPrefix(),
+		CookieSecret:  []byte(cfg.CookieSecret),
+		UnlockTTL:     cfg.UnlockTTL,
+		SecureCookies: strings.HasPrefix(cfg.BaseURL, "https://"),
+	}
+	if cfg.CookieSecret == "" {
+		logger.Warn("cookie secret is not set, unlocked links will require the password again after restart")
+	}
+	if cfg.PreviewTemplate != "" {
+		if handlerCfg.PreviewTemplate, err = handler.ParsePreviewTemplate(cfg.PreviewTemplate); err != nil {
+			return err
+		}
+	}
+	h := handler.New(svc, logger, handlerCfg)
 
 	// Установка HTTP сервера
 	mux := http.NewServeMux()
@@ -64,6 +128,8 @@ func run() error {
 	var httpHandler http.Handler = mux
 	httpHandler = handler.Logging(logger)(httpHandler)
 	httpHandler = handler.Recovery(logger)(httpHandler)
+	httpHandler = handler.Tracing()(httpHandler)
+	httpHandler = handler.RequestID()(httpHandler)
 
 	server := &http.Server{
 		Addr:         cfg.ServerAddress,
@@ -73,24 +139,181 @@ func run() error {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Перезагрузка конфигурации по SIGHUP: применяются только безопасные настройки
+	reload := func() {
+		next, err := config.LoadArgs(args)
+		if err != nil {
+			logger.Error("config reload failed", slog.Any("error", err))
+			return
+		}
+		if err := cfg.CheckReload(next); err != nil {
+			logger.Error("config reload rejected", slog.Any("error", err))
+			return
+		}
+
+		logLevel.Set(parseLevel(next.LogLevel))
+		svc.SetDefaultTTL(next.DefaultTTL)
+		svc.SetPasswordLimits(next.PasswordMaxAttempts, next.PasswordLockout)
+		svc.SetUTMTemplates(utmTemplates(next.UTMTemplates))
+		svc.SetWorkspaces(workspaces(next.Workspaces))
+		cfg = next
+
+		logger.Info("config reloaded",
+			slog.String("log_level", next.LogLevel),
+			slog.Duration("default_ttl", next.DefaultTTL),
+			slog.Int("password_max_attempts", next.PasswordMaxAttempts),
+			slog.Int("utm_templates", len(next.UTMTemplates)),
+			slog.Int("workspaces", len(next.Workspaces)),
+		)
+	}
+
 	// Graceful shutdown
-	return runServer(server, logger)
+	return runServer(server, logger, reload)
+}
+
+// newService создает сервис по конфигурации
+func newService(cfg *config.Config, store storage.Storage) *service.Shortener {
+	ws, keys := workspaces(cfg.Workspaces)
+	return service.New(store, service.Config{
+		BaseURL:    cfg.BaseURL,
+		DefaultTTL: cfg.DefaultTTL,
+		Domains:    cfg.Domains,
+
+		PasswordMaxAttempts: cfg.PasswordMaxAttempts,
+		PasswordLockout:     cfg.PasswordLockout,
+
+		RestoreWindow: cfg.RestoreWindow,
+
+		WebhookMaxAttempts:  cfg.WebhookMaxAttempts,
+		WebhookBackoff:      cfg.WebhookBackoff,
+		WebhookRetention:    cfg.WebhookRetention,
+		WebhookAllowPrivate: cfg.WebhookAllowPrivate,
+
+		UTMTemplates: utmTemplates(cfg.UTMTemplates),
+
+		Workspaces: ws,
+		APIKeys:    keys,
+	})
+}
+
+// utmTemplates переводит шаблоны UTM-меток из конфигурации в шаблоны сервиса
+func utmTemplates(templates map[string]config.UTMTemplate) map[string]service.UTM {
+	result := make(map[string]service.UTM, len(templates))
+	for name, t := range templates {
+		result[name] = service.UTM(t)
+	}
+	return result
+}
+
+// workspaces переводит рабочие пространства из конфигурации в настройки и ключи API сервиса
+func workspaces(spaces map[string]config.Workspace) (map[string]service.Workspace, []service.APIKey) {
+	result := make(map[string]service.Workspace, len(spaces))
+	var keys []service.APIKey
+	for name, ws := range spaces {
+		result[name] = service.Workspace{
+			DefaultTTL: (*time.Duration)(ws.DefaultTTL),
+			MaxLinks:   ws.MaxLinks,
+		}
+		for _, k := range ws.APIKeys {
+			keys = append(keys, service.APIKey{Key: k.Key, Name: k.Name, Workspace: name, Role: service.Role(k.Role)})
+		}
+	}
+	return result, keys
+}
+
+// runSwitches периодически применяет наступившие смены адреса ссылок до отмены ctx
+func runSwitches(ctx context.Context, svc *service.Shortener, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			applied, err := svc.ApplySwitches(ctx, now)
+			if err != nil {
+				logger.Error("applying scheduled switches failed", slog.Any("error", err))
+			}
+			if applied > 0 {
+				logger.Info("scheduled switches applied", slog.Int("count", applied))
+			}
+		}
+	}
+}
+
+// runPurge раз в purgeInterval окончательно удаляет ссылки с истекшим сроком восстановления
+// и отправленные доставки с истекшим сроком хранения до отмены ctx
+func runPurge(ctx context.Context, svc *service.Shortener, logger *slog.Logger) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			purged, err := svc.PurgeDeleted(ctx, now)
+			if err != nil {
+				logger.Error("purging deleted links failed", slog.Any("error", err))
+			}
+			if purged > 0 {
+				logger.Info("deleted links purged", slog.Int("count", purged))
+			}
+			deliveries, err := svc.PurgeDeliveries(ctx, now)
+			if err != nil {
+				logger.Error("purging webhook deliveries failed", slog.Any("error", err))
+			}
+			if deliveries > 0 {
+				logger.Info("webhook deliveries purged", slog.Int("count", deliveries))
+			}
+		}
+	}
+}
+
+// runWebhooks периодически записывает истечение сроков ссылок и отправляет
+// доставки вебхуков, время которых наступило, до отмены ctx
+func runWebhooks(ctx context.Context, svc *service.Shortener, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := svc.NotifyExpirations(ctx, now); err != nil {
+				logger.Error("recording link expirations failed", slog.Any("error", err))
+			}
+			delivered, err := svc.DeliverWebhooks(ctx, now)
+			if err != nil {
+				logger.Error("delivering webhooks failed", slog.Any("error", err))
+			}
+			if delivered > 0 {
+				logger.Debug("webhooks delivered", slog.Int("count", delivered))
+			}
+		}
+	}
 }
 
-func setupLogger(level string) *slog.Logger {
-	var logLevel slog.Level
+func setupLogger(level string) (*slog.Logger, *slog.LevelVar) {
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(parseLevel(level))
+	opts := &slog.HandlerOptions{Level: logLevel}
+	return slog.New(logging.NewContextHandler(slog.NewJSONHandler(os.Stdout, opts))), logLevel
+}
+
+func parseLevel(level string) slog.Level {
 	switch level {
 	case "debug":
-		logLevel = slog.LevelDebug
+		return slog.LevelDebug
 	case "warn":
-		logLevel = slog.LevelWarn
+		return slog.LevelWarn
 	case "error":
-		logLevel = slog.LevelError
+		return slog.LevelError
 	default:
-		logLevel = slog.LevelInfo
+		return slog.LevelInfo
 	}
-	opts := &slog.HandlerOptions{Level: logLevel}
-	return slog.New(slog.NewJSONHandler(os.Stdout, opts))
 }
 
 func initStorage(cfg *config.Config, logger *slog.Logger) (storage.Storage, error) {
@@ -107,11 +330,15 @@ func initStorage(cfg *config.Config, logger *slog.Logger) (storage.Storage, erro
 	}
 }
 
-func runServer(server *http.Server, logger *slog.Logger) error {
+func runServer(server *http.Server, logger *slog.Logger, reload func()) error {
 	// Создаем канал для получения сигналов
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
 	// Канал для получения ошибок сервера
 	serverErr := make(chan error, 1)
 
@@ -122,26 +349,33 @@ func runServer(server *http.Server, logger *slog.Logger) error {
 	}()
 
 	// Ожидание завершения работы или ошибки
-	select {
-	case err := <-serverErr:
-		if errors.Is(err, http.ErrServerClosed) {
-			return err
-		}
-	case sig := <-shutdown:
-		logger.Info("shutdown signal received", slog.String("signal", sig.String()))
+	for {
+		select {
+		case err := <-serverErr:
+			if !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			logger.Info("server stopped")
+			return nil
+		case <-hangup:
+			logger.Info("reload signal received")
+			reload()
+		case sig := <-shutdown:
+			logger.Info("shutdown signal received", slog.String("signal", sig.String()))
 
-		// Ожидание дополнительно 10 секунд для завершения обрабатываемых запросов
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+			// Ожидание дополнительно 10 секунд для завершения обрабатываемых запросов
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
 
-		if err := server.Shutdown(ctx); err != nil {
-			// Насильное завершение работы
-			server.Close()
-			return err
+			if err := server.Shutdown(ctx); err != nil {
+				// Насильное завершение работы
+				server.Close()
+				return err
+			}
+			logger.Info("server stopped")
+			return nil
 		}
 	}
-	logger.Info("server stopped")
-	return nil
 }
 
 func maskDSN(dsn string) string {
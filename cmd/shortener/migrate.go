@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/BuzzLyutic/url-shortener/internal/migrate"
+	"github.com/BuzzLyutic/url-shortener/internal/service"
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+)
+
+// runMigrateStorage выполняет команду migrate-storage: перенос всех ссылок
+// между хранилищами с последующей сверкой.
+//
+//	shortener migrate-storage --from=memory|postgres://... --to=memory|postgres://... [--batch=500]
+//	    [--checkpoint=file] [--overwrite] [--sample=0.01] [--log-level=info]
+func runMigrateStorage(args []string) error {
+	fs := flag.NewFlagSet("shortener migrate-storage", flag.ContinueOnError)
+	from := fs.String("from", "", "Source storage: memory or PostgreSQL connection string")
+	to := fs.String("to", "", "Target storage: memory or PostgreSQL connection string")
+	batch := fs.Int("batch", migrate.DefaultBatchSize, "Records per batch")
+	checkpoint := fs.String("checkpoint", "migrate-storage.checkpoint", "Checkpoint file for resuming; empty disables resuming")
+	overwrite := fs.Bool("overwrite", false, "Replace target records that differ from the source")
+	sample := fs.Float64("sample", 0.01, "Share of records verified by checksum (0-1)")
+	logLevel := fs.String("log-level", "info", "Log level: debug, info, warn, error")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return errors.New("both --from and --to are required")
+	}
+	if *sample < 0 || *sample > 1 {
+		return fmt.Errorf("invalid --sample: %v (must be between 0 and 1)", *sample)
+	}
+	logger := cliLogger(*logLevel)
+
+	source, err := openStorage(*from, logger)
+	if err != nil {
+		return fmt.Errorf("opening source: %w", err)
+	}
+	defer source.Close()
+	target, err := openStorage(*to, logger)
+	if err != nil {
+		return fmt.Errorf("opening target: %w", err)
+	}
+	defer target.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := warnWebhooks(ctx, source, logger); err != nil {
+		return err
+	}
+
+	result, err := migrate.Copy(ctx, source, target, migrate.Config{
+		BatchSize:  *batch,
+		Checkpoint: *checkpoint,
+		Overwrite:  *overwrite,
+		Logger:     logger,
+	})
+	if err != nil {
+		if *checkpoint != "" {
+			logger.Error("migration stopped, run the same command again to resume", slog.String("checkpoint", *checkpoint))
+		}
+		return err
+	}
+	logger.Info("copy finished",
+		slog.Int("copied", result.Copied),
+		slog.Int("replaced", result.Replaced),
+		slog.Int("unchanged", result.Unchanged),
+		slog.Int("revisions", result.Revisions),
+		slog.Int("tombstones", result.Tombstones),
+		slog.Int("audit", result.Audit),
+	)
+
+	v, err := migrate.Verify(ctx, source, target, *sample)
+	if err != nil {
+		return fmt.Errorf("verifying: %w", err)
+	}
+	for _, m := range v.Mismatches {
+		logger.Warn("record differs", slog.String("record", m))
+	}
+	logger.Info("verification finished",
+		slog.Int("source_count", v.SourceCount),
+		slog.Int("target_count", v.TargetCount),
+		slog.Int("source_revisions", v.SourceRevisions),
+		slog.Int("target_revisions", v.TargetRevisions),
+		slog.Int("source_tombstones", v.SourceTombstones),
+		slog.Int("target_tombstones", v.TargetTombstones),
+		slog.Int("source_audit", v.SourceAudit),
+		slog.Int("target_audit", v.TargetAudit),
+		slog.Int("sampled", v.Sampled),
+		slog.Int("mismatches", len(v.Mismatches)),
+	)
+	if !v.OK() {
+		return errors.New("verification failed")
+	}
+	return nil
+}
+
+// openStorage открывает хранилище по описанию: memory или строка подключения PostgreSQL
+func openStorage(spec string, logger *slog.Logger) (storage.Storage, error) {
+	switch {
+	case spec == "memory":
+		// Хранилище в памяти живет только внутри процесса
+		logger.Warn("memory storage starts empty in a new process")
+		return storage.NewMemoryStorage(), nil
+	case strings.HasPrefix(spec, "postgres://"), strings.HasPrefix(spec, "postgresql://"):
+		return storage.NewPostgresStorage(storage.DefaultPostgresConfig(spec))
+	default:
+		return nil, fmt.Errorf("unknown storage %q (must be 'memory' or a postgres:// connection string)", spec)
+	}
+}
+
+// warnWebhooks предупреждает о подписках на вебхуки в исходном хранилище:
+// подписки и их доставки не переносятся и создаются в новом хранилище заново
+func warnWebhooks(ctx context.Context, source storage.Storage, logger *slog.Logger) error {
+	var workspaces []string
+	for _, event := range service.WebhookEvents {
+		subscribed, err := source.SubscribedWorkspaces(ctx, event)
+		if err != nil {
+			return fmt.Errorf("listing webhooks: %w", err)
+		}
+		for _, w := range subscribed {
+			if !slices.Contains(workspaces, w) {
+				workspaces = append(workspaces, w)
+			}
+		}
+	}
+	for _, w := range workspaces {
+		hooks, err := source.ListWebhooks(ctx, w)
+		if err != nil {
+			return fmt.Errorf("listing webhooks: %w", err)
+		}
+		logger.Warn("webhooks are not migrated, recreate them in the target storage",
+			slog.String("workspace", w),
+			slog.Int("webhooks", len(hooks)),
+		)
+	}
+	return nil
+}
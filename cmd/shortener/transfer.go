@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/BuzzLyutic/url-shortener/internal/config"
+	"github.com/BuzzLyutic/url-shortener/internal/service"
+	"github.com/BuzzLyutic/url-shortener/internal/transfer"
+)
+
+// runExport выполняет команду export: выгрузку ссылок в файл или stdout.
+//
+//	shortener export [--format=jsonl|csv] [--domain=d] [--workspace=w] [--output=file] [флаги конфигурации]
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("shortener export", flag.ContinueOnError)
+	format := fs.String("format", transfer.FormatJSONL, "Output format: jsonl or csv")
+	domain := fs.String("domain", "", "Export only this short domain (default: all domains)")
+	output := fs.String("output", "", "Output file (default: stdout)")
+	workspace := fs.String("workspace", "", "Export links of this workspace (default: the default workspace)")
+
+	cfg, err := config.LoadFlagSet(fs, args)
+	if err != nil {
+		return err
+	}
+	logger := cliLogger(cfg.LogLevel)
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("creating output file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	writer, err := transfer.NewWriter(out, *format)
+	if err != nil {
+		return err
+	}
+
+	store, err := initStorage(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	svc := newService(cfg, store)
+
+	domains := svc.Domains()
+	if isFlagSet(fs, "domain") {
+		domains = []string{*domain}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx = cliPrincipal(ctx, *workspace)
+
+	n, err := svc.Export(ctx, domains, writer)
+	if err != nil {
+		return err
+	}
+	logger.Info("export finished", slog.Int("records", n))
+	return nil
+}
+
+// runImport выполняет команду import: загрузку ссылок из файла или stdin.
+// Итог и отклоненные записи пишутся в лог.
+//
+//	shortener import [--format=jsonl|csv] [--conflict=skip|overwrite|fail] [--workspace=w] [--dry-run] [файл] [флаги конфигурации]
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("shortener import", flag.ContinueOnError)
+	format := fs.String("format", transfer.FormatJSONL, "Input format: jsonl or csv")
+	conflict := fs.String("conflict", service.ConflictSkip, "Conflict policy: skip, overwrite or fail")
+	dryRun := fs.Bool("dry-run", false, "Validate records and report without saving")
+	workspace := fs.String("workspace", "", "Import links into this workspace (default: the default workspace)")
+
+	cfg, err := config.LoadFlagSet(fs, args)
+	if err != nil {
+		return err
+	}
+	logger := cliLogger(cfg.LogLevel)
+
+	var in io.Reader = os.Stdin
+	if path := fs.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("opening input file: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	reader, err := transfer.NewReader(bufio.NewReader(in), *format)
+	if err != nil {
+		return err
+	}
+
+	store, err := initStorage(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	svc := newService(cfg, store)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx = cliPrincipal(ctx, *workspace)
+
+	report, err := svc.Import(ctx, reader, service.ImportOptions{Conflict: *conflict, DryRun: *dryRun})
+	if report != nil {
+		for _, e := range report.Errors {
+			logger.Warn("record not imported",
+				slog.Int("line", e.Line),
+				slog.String("short_code", e.ShortCode),
+				slog.String("reason", e.Message),
+			)
+		}
+		logger.Info("import finished",
+			slog.Bool("dry_run", report.DryRun),
+			slog.Int("total", report.Total),
+			slog.Int("created", report.Created),
+			slog.Int("overwritten", report.Overwritten),
+			slog.Int("skipped", report.Skipped),
+			slog.Int("tombstones", report.Tombstones),
+			slog.Int("invalid", report.Invalid),
+		)
+	}
+	return err
+}
+
+// cliPrincipal выполняет команду от имени администратора рабочего пространства:
+// выгрузка и загрузка затрагивают только его ссылки, загрузка учитывает его квоту
+func cliPrincipal(ctx context.Context, workspace string) context.Context {
+	return service.WithPrincipal(ctx, service.Principal{Workspace: workspace, KeyName: "cli", Role: service.RoleAdmin})
+}
+
+// cliLogger пишет в stderr, чтобы не смешивать лог с выгрузкой в stdout
+func cliLogger(level string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: parseLevel(level)}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -2,10 +2,15 @@
 package config
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 )
 
 // Config содержит конфиг приложения
@@ -13,59 +18,342 @@ type Config struct {
 	// Настройки сервера
 	ServerAddress string
 	BaseURL       string
+	Domains       []string // Дополнительные короткие домены
 
 	// Настройки хранилища
 	StorageType string // либо в памяти приложения, либо Postgres
 	DatabaseURL string
 
 	// Настройки URL
-	DefaultTTL time.Duration
+	DefaultTTL     time.Duration
+	SwitchInterval time.Duration // Период проверки запланированных смен адреса (0 = не применять)
+	RestoreWindow  time.Duration // Срок восстановления удаленных ссылок (0 = без ограничения)
+
+	// Вебхуки
+	WebhookInterval    time.Duration // Период отправки доставок (0 = не отправлять)
+	WebhookMaxAttempts int           // Попыток доставки до списка неотправленных (0 = по умолчанию)
+	WebhookBackoff     time.Duration // Пауза после первой неудачной попытки, удваивается с каждой следующей (0 = по умолчанию)
+	WebhookRetention   time.Duration // Срок хранения отправленных доставок (0 = хранить бессрочно)
+	// WebhookAllowPrivate разрешает вебхуки на адреса внутренней сети: локальные,
+	// частные и link-local, в том числе метаданные облака
+	WebhookAllowPrivate bool
+
+	// Страница предпросмотра
+	PreviewTemplate string // Путь к пользовательскому HTML-шаблону
+
+	// Ссылки с паролем
+	CookieSecret        string        // Ключ подписи cookie разблокировки
+	UnlockTTL           time.Duration // Срок действия cookie разблокировки
+	PasswordMaxAttempts int           // Неудачных попыток до блокировки (0 = без ограничения)
+	PasswordLockout     time.Duration // Окно подсчета попыток и время блокировки
+
+	// Именованные шаблоны UTM-меток для POST /api/shorten
+	UTMTemplates map[string]UTMTemplate
+
+	// Рабочие пространства команд по именам. Если задан хотя бы один ключ API,
+	// запросы к API без действующего ключа отклоняются
+	Workspaces map[string]Workspace
 
 	// Логирование
 	LogLevel string
+
+	// Трассировка
+	TraceExporter string // none, stdout или otlp
+	TraceFile     string // Файл для stdout экспортера
+	OTLPEndpoint  string // URL OTLP/HTTP коллектора
 }
 
-// Load загружает конфиг из флагов и переменных окружения
-func Load() (*Config, error) {
-	cfg := &Config{}
+// UTMTemplate - набор UTM-меток. Пустые поля не добавляются к адресу
+type UTMTemplate struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// Workspace - рабочее пространство команды: ключи API, квота и срок жизни ссылок
+type Workspace struct {
+	APIKeys    []APIKey  `json:"api_keys"`
+	DefaultTTL *Duration `json:"default_ttl,omitempty"` // Перекрывает DEFAULT_TTL; не задано - общий
+	MaxLinks   int       `json:"max_links,omitempty"`   // Квота ссылок; 0 - без ограничения
+}
+
+// APIKey - ключ доступа к API рабочего пространства
+type APIKey struct {
+	Key  string `json:"key"`
+	Name string `json:"name,omitempty"` // Имя для журналов и автор ссылок, например "ci"
+	Role string `json:"role,omitempty"` // viewer, editor или admin; по умолчанию editor
+}
+
+// Duration - длительность, которая в JSON задается строкой ("720h")
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
 
-	// Определение флагов
-	flag.StringVar(&cfg.ServerAddress, "address", ":8080", "Server address (HOST:PORT)")
-	flag.StringVar(&cfg.BaseURL, "base-url", "http://localhost:8080", "Base URL for short links")
-	flag.StringVar(&cfg.StorageType, "storage", "memory", "Storage type: memory or postgres")
-	flag.StringVar(&cfg.DatabaseURL, "database-url", "", "PostgreSQL connection string")
-	flag.DurationVar(&cfg.DefaultTTL, "ttl", 0, "Default TTL for links (0 = no expiration)")
-	flag.StringVar(&cfg.LogLevel, "log-level", "info", "Log level: debug, info, warn, error")
+// minAPIKeyLength - минимальная длина ключа API
+const minAPIKeyLength = 16
 
-	flag.Parse()
+// option описывает параметр конфигурации и все его источники
+type option struct {
+	key        string // ключ в файле конфигурации
+	flag       string // имя флага командной строки
+	env        string // имя переменной окружения
+	def        string // значение по умолчанию
+	usage      string
+	reloadable bool // может быть применен по SIGHUP без перезапуска
+	object     bool // в файле задается объектом, в окружении и флагах - JSON
+	set        func(c *Config, v string) error
+	get        func(c *Config) string
+}
 
-	// Переопределение переменными окружения
-	if env := os.Getenv("SERVER_ADDRESS"); env != "" {
-		cfg.ServerAddress = env
+// Все параметры конфигурации
+var options = []option{
+	stringOption("address", "address", "SERVER_ADDRESS", ":8080", "Server address (HOST:PORT)",
+		func(c *Config) *string { return &c.ServerAddress }),
+	stringOption("base_url", "base-url", "BASE_URL", "http://localhost:8080", "Base URL for short links",
+		func(c *Config) *string { return &c.BaseURL }),
+	listOption("domains", "domains", "DOMAINS", "", "Additional short domains, comma-separated",
+		func(c *Config) *[]string { return &c.Domains }),
+	stringOption("storage", "storage", "STORAGE_TYPE", "memory", "Storage type: memory or postgres",
+		func(c *Config) *string { return &c.StorageType }),
+	stringOption("database_url", "database-url", "DATABASE_URL", "", "PostgreSQL connection string",
+		func(c *Config) *string { return &c.DatabaseURL }),
+	reloadable(durationOption("default_ttl", "ttl", "DEFAULT_TTL", "0", "Default TTL for links (0 = no expiration)",
+		func(c *Config) *time.Duration { return &c.DefaultTTL })),
+	durationOption("switch_interval", "switch-interval", "SWITCH_INTERVAL", "30s", "How often scheduled destination switches are applied (0 = never)",
+		func(c *Config) *time.Duration { return &c.SwitchInterval }),
+	durationOption("restore_window", "restore-window", "RESTORE_WINDOW", "720h", "How long deleted links can be restored before they are purged (0 = forever)",
+		func(c *Config) *time.Duration { return &c.RestoreWindow }),
+	durationOption("webhook_interval", "webhook-interval", "WEBHOOK_INTERVAL", "5s", "How often pending webhook deliveries are sent (0 = never)",
+		func(c *Config) *time.Duration { return &c.WebhookInterval }),
+	intOption("webhook_max_attempts", "webhook-max-attempts", "WEBHOOK_MAX_ATTEMPTS", "8", "Delivery attempts before a webhook delivery is marked dead",
+		func(c *Config) *int { return &c.WebhookMaxAttempts }),
+	durationOption("webhook_backoff", "webhook-backoff", "WEBHOOK_BACKOFF", "30s", "Delay after the first failed webhook delivery, doubled after each next one",
+		func(c *Config) *time.Duration { return &c.WebhookBackoff }),
+	durationOption("webhook_retention", "webhook-retention", "WEBHOOK_RETENTION", "168h", "How long delivered webhook deliveries are kept (0 = forever)",
+		func(c *Config) *time.Duration { return &c.WebhookRetention }),
+	boolOption("webhook_allow_private", "webhook-allow-private", "WEBHOOK_ALLOW_PRIVATE", "false", "Allow webhooks to loopback, private and link-local addresses",
+		func(c *Config) *bool { return &c.WebhookAllowPrivate }),
+	stringOption("preview_template", "preview-template", "PREVIEW_TEMPLATE", "", "Path to custom HTML template for link preview page",
+		func(c *Config) *string { return &c.PreviewTemplate }),
+	stringOption("cookie_secret", "cookie-secret", "COOKIE_SECRET", "", "Secret for signing unlock cookies (empty = random per start)",
+		func(c *Config) *string { return &c.CookieSecret }),
+	durationOption("unlock_ttl", "unlock-ttl", "UNLOCK_TTL", "24h", "How long a password-protected link stays unlocked",
+		func(c *Config) *time.Duration { return &c.UnlockTTL }),
+	reloadable(intOption("password_max_attempts", "password-max-attempts", "PASSWORD_MAX_ATTEMPTS", "5", "Failed password attempts per link before lockout (0 = unlimited)",
+		func(c *Config) *int { return &c.PasswordMaxAttempts })),
+	reloadable(durationOption("password_lockout", "password-lockout", "PASSWORD_LOCKOUT", "15m", "Window for counting failed password attempts",
+		func(c *Config) *time.Duration { return &c.PasswordLockout })),
+	reloadable(objectOption("utm_templates", "utm-templates", "UTM_TEMPLATES", "", "UTM templates as JSON object: name -> {source, medium, campaign, term, content}",
+		func(c *Config) *map[string]UTMTemplate { return &c.UTMTemplates })),
+	reloadable(objectOption("workspaces", "workspaces", "WORKSPACES", "", "Workspaces as JSON object: name -> {api_keys: [{key, name}], default_ttl, max_links}",
+		func(c *Config) *map[string]Workspace { return &c.Workspaces })),
+	reloadable(stringOption("log_level", "log-level", "LOG_LEVEL", "info", "Log level: debug, info, warn, error",
+		func(c *Config) *string { return &c.LogLevel })),
+	stringOption("trace_exporter", "trace-exporter", "TRACE_EXPORTER", "none", "Trace exporter: none, stdout or otlp",
+		func(c *Config) *string { return &c.TraceExporter }),
+	stringOption("trace_file", "trace-file", "TRACE_FILE", "", "File for stdout trace exporter (empty = stdout)",
+		func(c *Config) *string { return &c.TraceFile }),
+	stringOption("otlp_endpoint", "otlp-endpoint", "OTLP_ENDPOINT", "", "OTLP/HTTP collector URL, e.g. http://localhost:4318",
+		func(c *Config) *string { return &c.OTLPEndpoint }),
+}
+
+func stringOption(key, flagName, env, def, usage string, field func(*Config) *string) option {
+	return option{
+		key: key, flag: flagName, env: env, def: def, usage: usage,
+		set: func(c *Config, v string) error {
+			*field(c) = v
+			return nil
+		},
+		get: func(c *Config) string { return *field(c) },
 	}
-	if env := os.Getenv("BASE_URL"); env != "" {
-		cfg.BaseURL = env
+}
+
+func durationOption(key, flagName, env, def, usage string, field func(*Config) *time.Duration) option {
+	return option{
+		key: key, flag: flagName, env: env, def: def, usage: usage,
+		set: func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			*field(c) = d
+			return nil
+		},
+		get: func(c *Config) string { return field(c).String() },
 	}
-	if env := os.Getenv("STORAGE_TYPE"); env != "" {
-		cfg.StorageType = env
+}
+
+func intOption(key, flagName, env, def, usage string, field func(*Config) *int) option {
+	return option{
+		key: key, flag: flagName, env: env, def: def, usage: usage,
+		set: func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return err
+			}
+			*field(c) = n
+			return nil
+		},
+		get: func(c *Config) string { return strconv.Itoa(*field(c)) },
 	}
-	if env := os.Getenv("DATABASE_URL"); env != "" {
-		cfg.DatabaseURL = env
+}
+
+func boolOption(key, flagName, env, def, usage string, field func(*Config) *bool) option {
+	return option{
+		key: key, flag: flagName, env: env, def: def, usage: usage,
+		set: func(c *Config, v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			*field(c) = b
+			return nil
+		},
+		get: func(c *Config) string { return strconv.FormatBool(*field(c)) },
 	}
-	if env := os.Getenv("DEFAULT_TTL"); env != "" {
-		ttl, err := time.ParseDuration(env)
+}
+
+func listOption(key, flagName, env, def, usage string, field func(*Config) *[]string) option {
+	return option{
+		key: key, flag: flagName, env: env, def: def, usage: usage,
+		set: func(c *Config, v string) error {
+			var items []string
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			*field(c) = items
+			return nil
+		},
+		get: func(c *Config) string { return strings.Join(*field(c), ",") },
+	}
+}
+
+// objectOption описывает параметр-объект с именованными значениями
+func objectOption[V any](key, flagName, env, def, usage string, field func(*Config) *map[string]V) option {
+	return option{
+		key: key, flag: flagName, env: env, def: def, usage: usage, object: true,
+		set: func(c *Config, v string) error {
+			values := make(map[string]V)
+			if v != "" {
+				dec := json.NewDecoder(strings.NewReader(v))
+				dec.DisallowUnknownFields()
+				if err := dec.Decode(&values); err != nil {
+					return err
+				}
+			}
+			*field(c) = values
+			return nil
+		},
+		get: func(c *Config) string {
+			if len(*field(c)) == 0 {
+				return ""
+			}
+			// Ключи map кодируются в отсортированном порядке, поэтому строка стабильна
+			data, _ := json.Marshal(*field(c))
+			return string(data)
+		},
+	}
+}
+
+func reloadable(o option) option {
+	o.reloadable = true
+	return o
+}
+
+// Load загружает конфиг из аргументов командной строки процесса
+func Load() (*Config, error) {
+	return LoadArgs(os.Args[1:])
+}
+
+// LoadArgs загружает конфиг из файла, переменных окружения и флагов.
+// Приоритет источников: значения по умолчанию < файл < окружение < флаги.
+// Путь к файлу задается флагом --config или переменной CONFIG_FILE
+func LoadArgs(args []string) (*Config, error) {
+	return LoadFlagSet(flag.NewFlagSet("shortener", flag.ContinueOnError), args)
+}
+
+// LoadFlagSet загружает конфиг как LoadArgs, регистрируя флаги конфигурации в fs.
+// Так подкоманды добавляют собственные флаги к общим
+func LoadFlagSet(fs *flag.FlagSet, args []string) (*Config, error) {
+	configFile := fs.String("config", "", "Path to YAML or JSON config file")
+	flagValues := make(map[string]*string, len(options))
+	for _, o := range options {
+		flagValues[o.flag] = fs.String(o.flag, o.def, o.usage)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Учитываются только явно переданные флаги, иначе их значения
+	// по умолчанию перекрыли бы файл и окружение
+	setFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	cfg := &Config{}
+	for _, o := range options {
+		if err := o.set(cfg, o.def); err != nil {
+			return nil, fmt.Errorf("invalid default for %s: %w", o.key, err)
+		}
+	}
+
+	path := *configFile
+	if !setFlags["config"] {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		values, err := readFile(path)
 		if err != nil {
-			return nil, fmt.Errorf("invalid DEFAULT_TTL: %w", err)
+			return nil, err
+		}
+		for _, o := range options {
+			if v, ok := values[o.key]; ok {
+				if err := o.set(cfg, v); err != nil {
+					return nil, fmt.Errorf("invalid %s in %s: %w", o.key, path, err)
+				}
+			}
 		}
-		cfg.DefaultTTL = ttl
 	}
-	if env := os.Getenv("LOG_LEVEL"); env != "" {
-		cfg.LogLevel = env
+
+	for _, o := range options {
+		if env := os.Getenv(o.env); env != "" {
+			if err := o.set(cfg, env); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", o.env, err)
+			}
+		}
+	}
+
+	for _, o := range options {
+		if setFlags[o.flag] {
+			if err := o.set(cfg, *flagValues[o.flag]); err != nil {
+				return nil, fmt.Errorf("invalid --%s: %w", o.flag, err)
+			}
+		}
 	}
 
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
 
 	return cfg, nil
 }
@@ -80,5 +368,154 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("database-url is required when storage=postgres")
 	}
 
+	if c.BaseURL != "" {
+		if err := validateBaseURL(c.BaseURL); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range c.Domains {
+		if err := validateDomain(d); err != nil {
+			return err
+		}
+	}
+
+	if c.SwitchInterval < 0 {
+		return fmt.Errorf("invalid switch-interval: %s (must not be negative)", c.SwitchInterval)
+	}
+	if c.RestoreWindow < 0 {
+		return fmt.Errorf("invalid restore-window: %s (must not be negative)", c.RestoreWindow)
+	}
+	if c.WebhookInterval < 0 {
+		return fmt.Errorf("invalid webhook-interval: %s (must not be negative)", c.WebhookInterval)
+	}
+	if c.WebhookMaxAttempts < 0 {
+		return fmt.Errorf("invalid webhook-max-attempts: %d (must not be negative)", c.WebhookMaxAttempts)
+	}
+	if c.WebhookBackoff < 0 {
+		return fmt.Errorf("invalid webhook-backoff: %s (must not be negative)", c.WebhookBackoff)
+	}
+	if c.WebhookRetention < 0 {
+		return fmt.Errorf("invalid webhook-retention: %s (must not be negative)", c.WebhookRetention)
+	}
+
+	if c.CookieSecret != "" && len(c.CookieSecret) < 32 {
+		return fmt.Errorf("cookie-secret must be at least 32 bytes")
+	}
+
+	if c.PasswordMaxAttempts < 0 {
+		return fmt.Errorf("invalid password-max-attempts: %d (must not be negative)", c.PasswordMaxAttempts)
+	}
+	if c.PasswordMaxAttempts > 0 && c.PasswordLockout <= 0 {
+		return fmt.Errorf("password-lockout must be positive when password-max-attempts is set")
+	}
+
+	for name, t := range c.UTMTemplates {
+		if name == "" {
+			return fmt.Errorf("invalid utm-templates: template name must not be empty")
+		}
+		if t == (UTMTemplate{}) {
+			return fmt.Errorf("invalid utm-templates: template %q has no tags", name)
+		}
+	}
+
+	if err := c.validateWorkspaces(); err != nil {
+		return err
+	}
+
+	switch c.TraceExporter {
+	case "", "none", "stdout", "otlp":
+	default:
+		return fmt.Errorf("invalid trace exporter: %s (must be 'none', 'stdout' or 'otlp')", c.TraceExporter)
+	}
+
+	return nil
+}
+
+// validateWorkspaces проверяет имена пространств, квоты, ключи API и их роли.
+// Один ключ не может принадлежать двум пространствам
+func (c *Config) validateWorkspaces() error {
+	keys := make(map[string]string)
+	for name, ws := range c.Workspaces {
+		if name == "" {
+			return fmt.Errorf("invalid workspaces: workspace name must not be empty")
+		}
+		if ws.MaxLinks < 0 {
+			return fmt.Errorf("invalid workspaces: %q max_links must not be negative", name)
+		}
+		if ws.DefaultTTL != nil && *ws.DefaultTTL < 0 {
+			return fmt.Errorf("invalid workspaces: %q default_ttl must not be negative", name)
+		}
+		for _, k := range ws.APIKeys {
+			if len(k.Key) < minAPIKeyLength {
+				return fmt.Errorf("invalid workspaces: %q API key must be at least %d characters", name, minAPIKeyLength)
+			}
+			switch k.Role {
+			case "", "viewer", "editor", "admin":
+			default:
+				return fmt.Errorf("invalid workspaces: %q API key role %q (must be viewer, editor or admin)", name, k.Role)
+			}
+			if other, ok := keys[k.Key]; ok {
+				return fmt.Errorf("invalid workspaces: API key of %q is also used by %q", name, other)
+			}
+			keys[k.Key] = name
+		}
+	}
+	return nil
+}
+
+// validateBaseURL проверяет, что базовый URL абсолютный и не содержит query и фрагмента
+func validateBaseURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid base-url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid base-url: %s (scheme must be http or https)", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid base-url: %s (host is required)", raw)
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" || u.ForceQuery {
+		return fmt.Errorf("invalid base-url: %s (must not contain credentials, query or fragment)", raw)
+	}
+	// Путь становится частью шаблонов маршрутов, где фигурные скобки и пробелы недопустимы
+	if strings.ContainsAny(u.Path, "{}") || strings.IndexFunc(u.Path, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("invalid base-url: %s (path must not contain braces or whitespace)", raw)
+	}
+	return nil
+}
+
+// validateDomain проверяет, что домен задан как имя хоста без схемы, порта и пути
+func validateDomain(d string) error {
+	u, err := url.Parse("//" + d)
+	if err != nil || u.Host != d || u.Port() != "" || u.User != nil || strings.ContainsAny(d, "/?#") {
+		return fmt.Errorf("invalid domain: %s (must be a bare host name)", d)
+	}
+	return nil
+}
+
+// PathPrefix возвращает путь из BaseURL, под которым монтируются маршруты.
+// Для https://corp.example/s/ это "/s", для URL без пути - пустая строка
+func (c *Config) PathPrefix() string {
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(u.Path, "/")
+}
+
+// CheckReload проверяет, можно ли применить новый конфиг без перезапуска.
+// Возвращает ошибку со списком изменившихся параметров, требующих перезапуска
+func (c *Config) CheckReload(next *Config) error {
+	var changed []string
+	for _, o := range options {
+		if !o.reloadable && o.get(c) != o.get(next) {
+			changed = append(changed, o.key)
+		}
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("settings require restart: %s", strings.Join(changed, ", "))
+	}
 	return nil
 }
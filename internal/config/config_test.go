@@ -2,7 +2,10 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestConfig_Validate(t *testing.T) {
@@ -33,6 +36,174 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "valid trace exporter",
+			config: Config{
+				StorageType:   "memory",
+				TraceExporter: "otlp",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid trace exporter",
+			config: Config{
+				StorageType:   "memory",
+				TraceExporter: "jaeger",
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid base url with path",
+			config: Config{
+				StorageType: "memory",
+				BaseURL:     "https://corp.example/s/",
+			},
+			wantErr: false,
+		},
+		{
+			name: "base url without scheme",
+			config: Config{
+				StorageType: "memory",
+				BaseURL:     "corp.example/s",
+			},
+			wantErr: true,
+		},
+		{
+			name: "base url with query",
+			config: Config{
+				StorageType: "memory",
+				BaseURL:     "https://corp.example/?a=b",
+			},
+			wantErr: true,
+		},
+		{
+			name: "base url with braces in path",
+			config: Config{
+				StorageType: "memory",
+				BaseURL:     "https://corp.example/s{a}/",
+			},
+			wantErr: true,
+		},
+		{
+			name: "base url with escaped braces in path",
+			config: Config{
+				StorageType: "memory",
+				BaseURL:     "https://corp.example/%7Bid%7D/",
+			},
+			wantErr: true,
+		},
+		{
+			name: "base url with whitespace in path",
+			config: Config{
+				StorageType: "memory",
+				BaseURL:     "https://corp.example/a%20b/",
+			},
+			wantErr: true,
+		},
+		{
+			name: "base url with unsupported scheme",
+			config: Config{
+				StorageType: "memory",
+				BaseURL:     "ftp://corp.example",
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid domains",
+			config: Config{
+				StorageType: "memory",
+				Domains:     []string{"go.example", "sho.rt"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "domain with scheme",
+			config: Config{
+				StorageType: "memory",
+				Domains:     []string{"https://go.example"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "domain with port",
+			config: Config{
+				StorageType: "memory",
+				Domains:     []string{"go.example:8080"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative switch interval",
+			config: Config{
+				StorageType:    "memory",
+				SwitchInterval: -time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative restore window",
+			config: Config{
+				StorageType:   "memory",
+				RestoreWindow: -time.Hour,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative webhook interval",
+			config: Config{
+				StorageType:     "memory",
+				WebhookInterval: -time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative webhook max attempts",
+			config: Config{
+				StorageType:        "memory",
+				WebhookMaxAttempts: -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative webhook backoff",
+			config: Config{
+				StorageType:    "memory",
+				WebhookBackoff: -time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative webhook retention",
+			config: Config{
+				StorageType:      "memory",
+				WebhookRetention: -time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "short cookie secret",
+			config: Config{
+				StorageType:  "memory",
+				CookieSecret: "short",
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative password attempts",
+			config: Config{
+				StorageType:         "memory",
+				PasswordMaxAttempts: -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "password attempts without lockout",
+			config: Config{
+				StorageType:         "memory",
+				PasswordMaxAttempts: 5,
+			},
+			wantErr: true,
+		},
 		{
 			name: "postgres without database url",
 			config: Config{
@@ -76,3 +247,319 @@ func TestLoad_EnvOverridesFlags(t *testing.T) {
 		t.Errorf("StorageType = %v, want %v", cfg.StorageType, "memory")
 	}
 }
+
+// clearEnv сбрасывает переменные окружения, влияющие на конфиг
+func clearEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("CONFIG_FILE", "")
+	for _, o := range options {
+		t.Setenv(o.env, "")
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestLoadArgs_Defaults(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := LoadArgs(nil)
+	if err != nil {
+		t.Fatalf("LoadArgs() error = %v", err)
+	}
+
+	if cfg.ServerAddress != ":8080" || cfg.StorageType != "memory" || cfg.LogLevel != "info" {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+	if cfg.PasswordMaxAttempts != 5 || cfg.PasswordLockout != 15*time.Minute || cfg.UnlockTTL != 24*time.Hour {
+		t.Errorf("unexpected password defaults: %+v", cfg)
+	}
+	if cfg.SwitchInterval != 30*time.Second {
+		t.Errorf("SwitchInterval = %v, want 30s", cfg.SwitchInterval)
+	}
+	if cfg.RestoreWindow != 720*time.Hour {
+		t.Errorf("RestoreWindow = %v, want 720h", cfg.RestoreWindow)
+	}
+	if cfg.WebhookInterval != 5*time.Second || cfg.WebhookMaxAttempts != 8 || cfg.WebhookBackoff != 30*time.Second ||
+		cfg.WebhookRetention != 168*time.Hour || cfg.WebhookAllowPrivate {
+		t.Errorf("unexpected webhook defaults: %+v", cfg)
+	}
+}
+
+func TestLoadArgs_Precedence(t *testing.T) {
+	clearEnv(t)
+
+	path := writeFile(t, "config.yaml", `
+log_level: debug
+default_ttl: 1h
+address: ":9000"
+base_url: http://file.example
+`)
+
+	t.Run("file overrides defaults", func(t *testing.T) {
+		cfg, err := LoadArgs([]string{"--config", path})
+		if err != nil {
+			t.Fatalf("LoadArgs() error = %v", err)
+		}
+		if cfg.LogLevel != "debug" {
+			t.Errorf("LogLevel = %v, want %v", cfg.LogLevel, "debug")
+		}
+		if cfg.DefaultTTL != time.Hour {
+			t.Errorf("DefaultTTL = %v, want %v", cfg.DefaultTTL, time.Hour)
+		}
+	})
+
+	t.Run("env overrides file", func(t *testing.T) {
+		t.Setenv("LOG_LEVEL", "warn")
+		t.Setenv("CONFIG_FILE", path)
+
+		cfg, err := LoadArgs(nil)
+		if err != nil {
+			t.Fatalf("LoadArgs() error = %v", err)
+		}
+		if cfg.LogLevel != "warn" {
+			t.Errorf("LogLevel = %v, want %v", cfg.LogLevel, "warn")
+		}
+		if cfg.ServerAddress != ":9000" {
+			t.Errorf("ServerAddress = %v, want %v", cfg.ServerAddress, ":9000")
+		}
+	})
+
+	t.Run("flags override env", func(t *testing.T) {
+		t.Setenv("LOG_LEVEL", "warn")
+
+		cfg, err := LoadArgs([]string{"--config", path, "--log-level", "error"})
+		if err != nil {
+			t.Fatalf("LoadArgs() error = %v", err)
+		}
+		if cfg.LogLevel != "error" {
+			t.Errorf("LogLevel = %v, want %v", cfg.LogLevel, "error")
+		}
+		if cfg.BaseURL != "http://file.example" {
+			t.Errorf("BaseURL = %v, want %v", cfg.BaseURL, "http://file.example")
+		}
+	})
+}
+
+func TestLoadArgs_JSONFile(t *testing.T) {
+	clearEnv(t)
+
+	path := writeFile(t, "config.json", `{"storage": "memory", "default_ttl": "30m", "webhook_allow_private": true}`)
+
+	cfg, err := LoadArgs([]string{"--config", path})
+	if err != nil {
+		t.Fatalf("LoadArgs() error = %v", err)
+	}
+	if cfg.DefaultTTL != 30*time.Minute {
+		t.Errorf("DefaultTTL = %v, want %v", cfg.DefaultTTL, 30*time.Minute)
+	}
+	if !cfg.WebhookAllowPrivate {
+		t.Error("WebhookAllowPrivate = false, want true")
+	}
+}
+
+func TestLoadArgs_FileErrors(t *testing.T) {
+	clearEnv(t)
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{name: "unknown key", file: "config.yaml", content: "log_level: debug\nlog_levle: info\n"},
+		{name: "unknown json key", file: "config.json", content: `{"storage_type": "memory"}`},
+		{name: "invalid duration", file: "config.yaml", content: "default_ttl: soon\n"},
+		{name: "invalid bool", file: "config.yaml", content: "webhook_allow_private: sometimes\n"},
+		{name: "nested object", file: "config.yaml", content: "storage:\n  type: memory\n"},
+		{name: "unsupported format", file: "config.toml", content: "storage = 'memory'\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, tt.file, tt.content)
+			if _, err := LoadArgs([]string{"--config", path}); err == nil {
+				t.Error("LoadArgs() expected error")
+			}
+		})
+	}
+}
+
+func TestConfig_CheckReload(t *testing.T) {
+	base := Config{StorageType: "memory", ServerAddress: ":8080", LogLevel: "info"}
+
+	t.Run("safe settings", func(t *testing.T) {
+		next := base
+		next.LogLevel = "debug"
+		next.DefaultTTL = time.Hour
+		next.PasswordMaxAttempts = 10
+		next.PasswordLockout = time.Hour
+		if err := base.CheckReload(&next); err != nil {
+			t.Errorf("CheckReload() error = %v", err)
+		}
+	})
+
+	t.Run("restart required", func(t *testing.T) {
+		next := base
+		next.StorageType = "postgres"
+		next.ServerAddress = ":9090"
+		err := base.CheckReload(&next)
+		if err == nil {
+			t.Fatal("CheckReload() expected error")
+		}
+		if !strings.Contains(err.Error(), "storage") || !strings.Contains(err.Error(), "address") {
+			t.Errorf("CheckReload() error = %v, want changed keys listed", err)
+		}
+	})
+}
+
+func TestLoadArgs_NormalizesBaseURL(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := LoadArgs([]string{"--base-url", "https://corp.example/s/"})
+	if err != nil {
+		t.Fatalf("LoadArgs() error = %v", err)
+	}
+	if cfg.BaseURL != "https://corp.example/s" {
+		t.Errorf("BaseURL = %v, want %v", cfg.BaseURL, "https://corp.example/s")
+	}
+}
+
+func TestConfig_PathPrefix(t *testing.T) {
+	tests := []struct {
+		baseURL string
+		want    string
+	}{
+		{baseURL: "http://localhost:8080", want: ""},
+		{baseURL: "http://localhost:8080/", want: ""},
+		{baseURL: "https://corp.example/s", want: "/s"},
+		{baseURL: "https://corp.example/s/", want: "/s"},
+		{baseURL: "https://corp.example/a/b/", want: "/a/b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.baseURL, func(t *testing.T) {
+			cfg := Config{BaseURL: tt.baseURL}
+			if got := cfg.PathPrefix(); got != tt.want {
+				t.Errorf("PathPrefix() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadArgs_Domains(t *testing.T) {
+	clearEnv(t)
+
+	t.Run("from env", func(t *testing.T) {
+		t.Setenv("DOMAINS", "go.example, sho.rt")
+		cfg, err := LoadArgs(nil)
+		if err != nil {
+			t.Fatalf("LoadArgs() error = %v", err)
+		}
+		if len(cfg.Domains) != 2 || cfg.Domains[0] != "go.example" || cfg.Domains[1] != "sho.rt" {
+			t.Errorf("Domains = %v", cfg.Domains)
+		}
+	})
+
+	t.Run("from yaml list", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", "domains:\n  - go.example\n  - sho.rt\n")
+		cfg, err := LoadArgs([]string{"--config", path})
+		if err != nil {
+			t.Fatalf("LoadArgs() error = %v", err)
+		}
+		if len(cfg.Domains) != 2 {
+			t.Errorf("Domains = %v", cfg.Domains)
+		}
+	})
+}
+
+func TestLoadArgs_UTMTemplates(t *testing.T) {
+	clearEnv(t)
+
+	t.Run("from yaml object", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", "utm_templates:\n  newsletter:\n    source: newsletter\n    medium: email\n")
+		cfg, err := LoadArgs([]string{"--config", path})
+		if err != nil {
+			t.Fatalf("LoadArgs() error = %v", err)
+		}
+		want := UTMTemplate{Source: "newsletter", Medium: "email"}
+		if cfg.UTMTemplates["newsletter"] != want {
+			t.Errorf("UTMTemplates = %+v", cfg.UTMTemplates)
+		}
+	})
+
+	t.Run("from env json", func(t *testing.T) {
+		t.Setenv("UTM_TEMPLATES", `{"social": {"source": "twitter", "campaign": "launch"}}`)
+		cfg, err := LoadArgs(nil)
+		if err != nil {
+			t.Fatalf("LoadArgs() error = %v", err)
+		}
+		if cfg.UTMTemplates["social"].Campaign != "launch" {
+			t.Errorf("UTMTemplates = %+v", cfg.UTMTemplates)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, v := range []string{`{"x": {"sauce": "typo"}}`, `{"empty": {}}`, `[1, 2]`} {
+			t.Setenv("UTM_TEMPLATES", v)
+			if _, err := LoadArgs(nil); err == nil {
+				t.Errorf("LoadArgs() with %s expected error", v)
+			}
+		}
+	})
+}
+
+func TestLoadArgs_Workspaces(t *testing.T) {
+	clearEnv(t)
+
+	t.Run("from yaml object", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", `workspaces:
+  marketing:
+    default_ttl: 720h
+    max_links: 1000
+    api_keys:
+      - key: mk-0123456789abcdef
+        name: ci
+        role: admin
+  support:
+    default_ttl: 0s
+    api_keys:
+      - key: sp-0123456789abcdef
+`)
+		cfg, err := LoadArgs([]string{"--config", path})
+		if err != nil {
+			t.Fatalf("LoadArgs() error = %v", err)
+		}
+		ws := cfg.Workspaces["marketing"]
+		if ws.MaxLinks != 1000 || ws.DefaultTTL == nil || time.Duration(*ws.DefaultTTL) != 720*time.Hour {
+			t.Errorf("Workspaces[marketing] = %+v", ws)
+		}
+		if len(ws.APIKeys) != 1 || ws.APIKeys[0] != (APIKey{Key: "mk-0123456789abcdef", Name: "ci", Role: "admin"}) {
+			t.Errorf("APIKeys = %+v", ws.APIKeys)
+		}
+		// Явный нулевой срок отключает общий DEFAULT_TTL
+		if ttl := cfg.Workspaces["support"].DefaultTTL; ttl == nil || *ttl != 0 {
+			t.Errorf("Workspaces[support].DefaultTTL = %v, want 0", ttl)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, v := range []string{
+			`{"team": {"api_keys": [{"key": "short"}]}}`,
+			`{"team": {"max_links": -1}}`,
+			`{"team": {"api_keys": [{"key": "team-0123456789abc", "role": "owner"}]}}`,
+			`{"team": {"default_ttl": "soon"}}`,
+			`{"a": {"api_keys": [{"key": "shared-0123456789"}]}, "b": {"api_keys": [{"key": "shared-0123456789"}]}}`,
+		} {
+			t.Setenv("WORKSPACES", v)
+			if _, err := LoadArgs(nil); err == nil {
+				t.Errorf("LoadArgs() with %s expected error", v)
+			}
+		}
+	})
+}
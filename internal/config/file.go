@@ -0,0 +1,97 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// readFile читает YAML или JSON файл конфигурации и возвращает значения
+// параметров по ключам. Неизвестные ключи считаются ошибкой
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	raw := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config file format: %s (must be .json, .yaml or .yml)", path)
+	}
+
+	known := make(map[string]option, len(options))
+	for _, o := range options {
+		known[o.key] = o
+	}
+
+	var unknown []string
+	values := make(map[string]string, len(raw))
+	for key, v := range raw {
+		o, ok := known[key]
+		if !ok {
+			unknown = append(unknown, key)
+			continue
+		}
+		if _, isMap := v.(map[string]any); isMap && o.object {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s in %s: %w", key, path, err)
+			}
+			values[key] = string(data)
+			continue
+		}
+		s, err := scalarString(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in %s: %w", key, path, err)
+		}
+		values[key] = s
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown keys in config file %s: %s", path, strings.Join(unknown, ", "))
+	}
+
+	return values, nil
+}
+
+// scalarString приводит значение из файла к строке в формате флагов.
+// Списки объединяются через запятую
+func scalarString(v any) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return val, nil
+	case []any:
+		parts := make([]string, 0, len(val))
+		for _, item := range val {
+			s, err := scalarString(item)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, ","), nil
+	case map[string]any:
+		return "", fmt.Errorf("nested objects are not supported")
+	default:
+		return fmt.Sprint(val), nil
+	}
+}
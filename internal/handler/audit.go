@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/BuzzLyutic/url-shortener/internal/service"
+)
+
+// Обрабатывает GET /api/links/{code}/history: журнал изменений ссылки,
+// в том числе удаленной. Параметр запроса domain выбирает короткий домен
+func (h *Handler) LinkHistory(w http.ResponseWriter, r *http.Request) {
+	opts, ok := h.auditOptions(w, r)
+	if !ok {
+		return
+	}
+	page, err := h.service.History(r.Context(), r.URL.Query().Get("domain"), r.PathValue("code"), opts)
+	if err != nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, http.StatusOK, auditResponse(page))
+}
+
+// Обрабатывает GET /api/audit: журнал изменений всех ссылок рабочего пространства
+// с фильтрами actor и action и постраничной выборкой по курсору
+func (h *Handler) AuditLog(w http.ResponseWriter, r *http.Request) {
+	opts, ok := h.auditOptions(w, r)
+	if !ok {
+		return
+	}
+	page, err := h.service.Audit(r.Context(), opts)
+	if err != nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, http.StatusOK, auditResponse(page))
+}
+
+// auditOptions разбирает параметры выборки журнала; при ошибке отвечает 400
+func (h *Handler) auditOptions(w http.ResponseWriter, r *http.Request) (service.AuditOptions, bool) {
+	query := r.URL.Query()
+	opts := service.AuditOptions{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Cursor: query.Get("cursor"),
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", "limit must be an integer")
+			return opts, false
+		}
+		opts.Limit = limit
+	}
+	return opts, true
+}
+
+func auditResponse(page *service.AuditPage) AuditResponse {
+	resp := AuditResponse{
+		Entries:    make([]AuditEntryDTO, len(page.Entries)),
+		NextCursor: page.NextCursor,
+	}
+	for i, e := range page.Entries {
+		resp.Entries[i] = AuditEntryDTO(e)
+	}
+	return resp
+}
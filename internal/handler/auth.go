@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/BuzzLyutic/url-shortener/internal/service"
+)
+
+// Заголовок с ключом API; ключ можно передать и как "Authorization: Bearer <ключ>"
+const APIKeyHeader = "X-API-Key"
+
+// requireKey пропускает запрос к API только с действующим ключом, роль которого
+// допускает операцию, и выполняет его от имени рабочего пространства ключа.
+// Если ключи не заданы, API открыт и запросы работают с пространством по умолчанию.
+// Права на конкретную ссылку дополнительно проверяет сервис
+func (h *Handler) requireKey(action service.Action, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.service.AuthRequired() {
+			next(w, r)
+			return
+		}
+		principal, err := h.service.Authenticate(apiKey(r))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			h.writeError(w, r, http.StatusUnauthorized, "unauthorized", "A valid API key is required")
+			return
+		}
+		ctx := service.WithPrincipal(r.Context(), principal)
+		r = r.WithContext(ctx)
+		if required := service.RequiredRole(action); !principal.Role.Allows(required) {
+			h.forbidden(w, r, string(action)+" requires role "+string(required))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// forbidden отвечает 403 и записывает отказ в лог для аудита
+func (h *Handler) forbidden(w http.ResponseWriter, r *http.Request, reason string) {
+	p, _ := service.PrincipalFromContext(r.Context())
+	h.logger.WarnContext(r.Context(), "access denied",
+		slog.String("key", p.KeyName),
+		slog.String("workspace", p.Workspace),
+		slog.String("role", string(p.Role)),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("reason", reason),
+	)
+	h.writeError(w, r, http.StatusForbidden, "forbidden", "API key role does not permit this action")
+}
+
+// apiKey возвращает ключ из заголовка X-API-Key или Authorization
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
@@ -1,22 +1,261 @@
 // Пакет handler предоставляет хэндлеры для API укорачивания ссылок
 package handler
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/BuzzLyutic/url-shortener/internal/rules"
+)
 
 // Тело запроса
 type ShortenRequest struct {
-	URL string `json:"url"`
+	URL       string `json:"url"`
+	Domain    string `json:"domain,omitempty"`     // Короткий домен; по умолчанию основной
+	QR        bool   `json:"qr,omitempty"`         // Вернуть в ответе ссылку на QR-код
+	Password  string `json:"password,omitempty"`   // Пароль для перехода по ссылке
+	MaxClicks int    `json:"max_clicks,omitempty"` // Лимит переходов; 0 - без ограничения
+
+	NotBefore  *time.Time `json:"not_before,omitempty"`  // Начало действия ссылки
+	PendingURL string     `json:"pending_url,omitempty"` // Адрес до начала действия
+	ExpiredURL string     `json:"expired_url,omitempty"` // Адрес после истечения срока
+
+	Targets []TargetDTO `json:"targets,omitempty"` // Варианты адреса для A/B ротации
+	Sticky  bool        `json:"sticky,omitempty"`  // Закреплять вариант за посетителем
+
+	Rules []rules.Rule `json:"rules,omitempty"` // Правила выбора адреса по платформе, языку и параметрам
+
+	ForwardQuery string `json:"forward_query,omitempty"` // Передача параметров запроса: link, request или append
+	ForwardPath  bool   `json:"forward_path,omitempty"`  // Дописывать путь после кода к адресу
+
+	// UTM-метки: шаблон из конфигурации и явные значения, перекрывающие шаблон
+	UTMTemplate string `json:"utm_template,omitempty"`
+	UTMFields
+
+	MetadataFields
+	LabelsFields
+
+	CreatedBy string `json:"created_by,omitempty"` // Автор (владелец) ссылки для фильтрации списка
+}
+
+// Метки и заметка для упорядочивания ссылок
+type LabelsFields struct {
+	Tags []string `json:"tags,omitempty"`
+	Note string   `json:"note,omitempty"`
+}
+
+// Описание ссылки для превью в соцсетях и мессенджерах
+type MetadataFields struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+}
+
+// UTM-метки ссылки
+type UTMFields struct {
+	Source   string `json:"utm_source,omitempty"`
+	Medium   string `json:"utm_medium,omitempty"`
+	Campaign string `json:"utm_campaign,omitempty"`
+	Term     string `json:"utm_term,omitempty"`
+	Content  string `json:"utm_content,omitempty"`
+}
+
+// Шаблон UTM-меток
+type UTMTemplateDTO struct {
+	Name string `json:"name"`
+	UTMFields
+}
+
+// Ответ со списком шаблонов UTM-меток
+type UTMTemplatesResponse struct {
+	Templates []UTMTemplateDTO `json:"templates"`
+}
+
+// Вариант адреса ссылки с ротацией
+type TargetDTO struct {
+	URL    string `json:"url,omitempty"`
+	Weight int    `json:"weight"`
+	Clicks int64  `json:"clicks,omitempty"`
 }
 
 // Тело ответа
 type ShortenResponse struct {
-	ShortURL    string     `json:"short_url"`
-	OriginalURL string     `json:"original_url"`
-	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	ShortURL    string       `json:"short_url"`
+	OriginalURL string       `json:"original_url"`
+	UntaggedURL string       `json:"untagged_url,omitempty"`
+	Domain      string       `json:"domain,omitempty"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+	NotBefore   *time.Time   `json:"not_before,omitempty"`
+	QRURL       string       `json:"qr_url,omitempty"`
+	Protected   bool         `json:"password_protected,omitempty"`
+	MaxClicks   int          `json:"max_clicks,omitempty"`
+	Targets     []TargetDTO  `json:"targets,omitempty"`
+	Sticky      bool         `json:"sticky,omitempty"`
+	Rules       []rules.Rule `json:"rules,omitempty"`
+
+	ForwardQuery string `json:"forward_query,omitempty"`
+	ForwardPath  bool   `json:"forward_path,omitempty"`
+
+	MetadataFields
+	LabelsFields
+	CreatedBy string `json:"created_by,omitempty"`
+	Workspace string `json:"workspace,omitempty"`
+}
+
+// Ответ с состоянием ссылки
+type LinkResponse struct {
+	ShortURL    string       `json:"short_url"`
+	OriginalURL string       `json:"original_url,omitempty"` // Не раскрывается для ссылок с паролем
+	UntaggedURL string       `json:"untagged_url,omitempty"`
+	Domain      string       `json:"domain,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	CreatedBy   string       `json:"created_by,omitempty"`
+	Workspace   string       `json:"workspace,omitempty"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+	NotBefore   *time.Time   `json:"not_before,omitempty"`
+	Protected   bool         `json:"password_protected,omitempty"`
+	ClicksLeft  *int         `json:"clicks_left,omitempty"`
+	Targets     []TargetDTO  `json:"targets,omitempty"`
+	Sticky      bool         `json:"sticky,omitempty"`
+	Rules       []rules.Rule `json:"rules,omitempty"`
+
+	ForwardQuery string `json:"forward_query,omitempty"`
+	ForwardPath  bool   `json:"forward_path,omitempty"`
+
+	NextURL  string     `json:"next_url,omitempty"` // Не раскрывается для ссылок с паролем
+	SwitchAt *time.Time `json:"switch_at,omitempty"`
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	MetadataFields
+	LabelsFields
+}
+
+// Прежние адреса ссылки по возрастанию номера
+type RevisionsResponse struct {
+	Revisions []RevisionDTO `json:"revisions"`
+}
+
+// Прежний адрес назначения ссылки
+type RevisionDTO struct {
+	Number      int       `json:"revision"`
+	OriginalURL string    `json:"original_url"`
+	UntaggedURL string    `json:"untagged_url,omitempty"`
+	ReplacedAt  time.Time `json:"replaced_at"`
+}
+
+// Страница списка ссылок
+type ListLinksResponse struct {
+	Links      []LinkResponse `json:"links"`
+	NextCursor string         `json:"next_cursor,omitempty"` // Пусто на последней странице
+}
+
+// Страница журнала изменений, от новых событий к старым
+type AuditResponse struct {
+	Entries    []AuditEntryDTO `json:"entries"`
+	NextCursor string          `json:"next_cursor,omitempty"` // Пусто на последней странице
+}
+
+// Событие журнала изменений ссылки
+type AuditEntryDTO struct {
+	ID        int64           `json:"id"`
+	Domain    string          `json:"domain,omitempty"`
+	ShortCode string          `json:"short_code"`
+	Action    string          `json:"action"`
+	Actor     string          `json:"actor,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	At        time.Time       `json:"at"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+}
+
+// Тело запроса изменения ссылки. Отсутствующие поля не меняются
+type UpdateLinkRequest struct {
+	URL     *string `json:"url,omitempty"`     // Новый адрес назначения; прежний сохраняется как ревизия
+	Weights []int   `json:"weights,omitempty"` // Новые веса вариантов в порядке их создания
+
+	// Запланированная смена адреса; пустой next_url отменяет ее
+	NextURL  *string    `json:"next_url,omitempty"`
+	SwitchAt *time.Time `json:"switch_at,omitempty"`
+
+	Title       *string `json:"title,omitempty"`
+	Description *string `json:"description,omitempty"`
+	ImageURL    *string `json:"image_url,omitempty"`
+
+	Tags *[]string `json:"tags,omitempty"` // Новый набор меток; пустой массив удаляет все
+	Note *string   `json:"note,omitempty"`
+}
+
+// Итог импорта ссылок. При dry_run счетчики показывают, что было бы сделано
+type ImportResponse struct {
+	DryRun      bool             `json:"dry_run"`
+	Total       int              `json:"total"`
+	Created     int              `json:"created"`
+	Overwritten int              `json:"overwritten"`
+	Skipped     int              `json:"skipped"`
+	Tombstones  int              `json:"tombstones"`
+	Invalid     int              `json:"invalid"`
+	Errors      []ImportErrorDTO `json:"errors,omitempty"` // Первые 100 отклоненных или пропущенных записей
+
+	// Причина остановки импорта; пусто, если обработан весь файл
+	Error   string `json:"error,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Отклоненная или пропущенная при импорте запись
+type ImportErrorDTO struct {
+	Line      int    `json:"line"`
+	ShortCode string `json:"short_code,omitempty"`
+	Message   string `json:"message"`
+}
+
+// Тело запроса подписки на события ссылок
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"` // Типы событий; пусто - все события
+	Secret string   `json:"secret,omitempty"` // Ключ подписи; пусто - сгенерировать
+}
+
+// Подписка на события ссылок. Секрет возвращается только при создании
+type WebhookDTO struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events,omitempty"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Подписки рабочего пространства
+type WebhooksResponse struct {
+	Webhooks []WebhookDTO `json:"webhooks"`
+}
+
+// Страница журнала доставок от новых к старым
+type DeliveriesResponse struct {
+	Deliveries []DeliveryDTO `json:"deliveries"`
+	NextCursor string        `json:"next_cursor,omitempty"` // Пусто на последней странице
+}
+
+// Доставка события подписке
+type DeliveryDTO struct {
+	ID            int64           `json:"id"`
+	WebhookID     int64           `json:"webhook_id"`
+	Event         string          `json:"event"`
+	URL           string          `json:"url"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"` // pending, delivered или dead
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt *time.Time      `json:"next_attempt_at,omitempty"` // Только для pending
+	LastStatus    int             `json:"last_status,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
 }
 
 // Ответ ошибки
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message,omitempty"`
+	Error     string `json:"error"`
+	Message   string `json:"message,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
 }
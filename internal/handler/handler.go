@@ -3,9 +3,15 @@ package handler
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"html/template"
 	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/BuzzLyutic/url-shortener/internal/logging"
+	"github.com/BuzzLyutic/url-shortener/internal/rules"
 	"github.com/BuzzLyutic/url-shortener/internal/service"
 	"github.com/BuzzLyutic/url-shortener/internal/shortcode"
 )
@@ -13,21 +19,63 @@ import (
 type Handler struct {
 	service *service.Shortener
 	logger  *slog.Logger
+	config  Config
+	secret  []byte // Ключ подписи cookie разблокировки
 }
 
-func New(svc *service.Shortener, logger *slog.Logger) *Handler {
+// Config содержит конфиг хэндлера
+type Config struct {
+	PathPrefix      string             // Префикс пути, под которым монтируются маршруты (например, "/s")
+	PreviewTemplate *template.Template // Шаблон страницы предпросмотра; nil - встроенный
+	CookieSecret    []byte             // Ключ подписи cookie; пусто - случайный при запуске
+	UnlockTTL       time.Duration      // Срок действия cookie после ввода пароля
+	SecureCookies   bool               // Выставлять cookie с флагом Secure (BaseURL по https)
+}
+
+func New(svc *service.Shortener, logger *slog.Logger, config Config) *Handler {
+	secret := config.CookieSecret
+	if len(secret) == 0 {
+		secret = randomSecret()
+	}
 	return &Handler{
 		service: svc,
 		logger:  logger,
+		config:  config,
+		secret:  secret,
 	}
 }
 
-// Метод регистрирует все пути к данному мультиплексеру
+// Метод регистрирует все пути к данному мультиплексеру под префиксом из конфига
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
-	// API эндпоинты
-	mux.HandleFunc("POST /api/shorten", h.Shorten)
-	mux.HandleFunc("GET /{code}", h.Redirect)
-	mux.HandleFunc("GET /health", h.Health)
+	prefix := strings.TrimRight(h.config.PathPrefix, "/")
+
+	// API эндпоинты. QR-код содержит только короткую ссылку и встраивается
+	// в страницы, поэтому доступен без ключа
+	mux.HandleFunc("POST "+prefix+"/api/shorten", h.requireKey(service.ActionCreate, h.Shorten))
+	mux.HandleFunc("GET "+prefix+"/api/links", h.requireKey(service.ActionList, h.ListLinks))
+	mux.HandleFunc("GET "+prefix+"/api/links/{code}", h.requireKey(service.ActionView, h.GetLink))
+	mux.HandleFunc("PATCH "+prefix+"/api/links/{code}", h.requireKey(service.ActionUpdate, h.UpdateLink))
+	mux.HandleFunc("DELETE "+prefix+"/api/links/{code}", h.requireKey(service.ActionDelete, h.DeleteLink))
+	mux.HandleFunc("POST "+prefix+"/api/links/{code}/restore", h.requireKey(service.ActionDelete, h.RestoreLink))
+	mux.HandleFunc("GET "+prefix+"/api/links/{code}/history", h.requireKey(service.ActionView, h.LinkHistory))
+	mux.HandleFunc("GET "+prefix+"/api/links/{code}/revisions", h.requireKey(service.ActionView, h.ListRevisions))
+	mux.HandleFunc("POST "+prefix+"/api/links/{code}/revisions/{n}/restore", h.requireKey(service.ActionUpdate, h.RestoreRevision))
+	mux.HandleFunc("GET "+prefix+"/api/audit", h.requireKey(service.ActionAudit, h.AuditLog))
+	mux.HandleFunc("POST "+prefix+"/api/webhooks", h.requireKey(service.ActionWebhooks, h.CreateWebhook))
+	mux.HandleFunc("GET "+prefix+"/api/webhooks", h.requireKey(service.ActionWebhooks, h.ListWebhooks))
+	mux.HandleFunc("DELETE "+prefix+"/api/webhooks/{id}", h.requireKey(service.ActionWebhooks, h.DeleteWebhook))
+	mux.HandleFunc("GET "+prefix+"/api/webhooks/deliveries", h.requireKey(service.ActionWebhooks, h.ListDeliveries))
+	mux.HandleFunc("POST "+prefix+"/api/webhooks/deliveries/{id}/retry", h.requireKey(service.ActionWebhooks, h.RetryDelivery))
+	mux.HandleFunc("GET "+prefix+"/api/links/{code}/qr", h.QRCode)
+	mux.HandleFunc("GET "+prefix+"/api/utm-templates", h.requireKey(service.ActionView, h.UTMTemplates))
+	mux.HandleFunc("GET "+prefix+"/api/export", h.requireKey(service.ActionExport, h.Export))
+	mux.HandleFunc("POST "+prefix+"/api/import", h.requireKey(service.ActionImport, h.Import))
+	mux.HandleFunc("GET "+prefix+"/{code}", h.Redirect)
+	mux.HandleFunc("GET "+prefix+"/{code}/{path...}", h.RedirectPath)
+	mux.HandleFunc("POST "+prefix+"/{code}", h.Unlock)
+	mux.HandleFunc("POST "+prefix+"/{code}/{path...}", h.UnlockPath)
+	mux.HandleFunc("GET "+prefix+"/preview/{code}", h.Preview)
+	mux.HandleFunc("GET "+prefix+"/health", h.Health)
 }
 
 // Обрабатывает запросы POST /api/shorten
@@ -35,13 +83,37 @@ func (h *Handler) Shorten(w http.ResponseWriter, r *http.Request) {
 	var req ShortenRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.writeError(w, http.StatusBadRequest, "invalid json", "Invalid JSON body")
+		h.writeError(w, r, http.StatusBadRequest, "invalid json", "Invalid JSON body")
 		return
 	}
 
-	result, err := h.service.Shorten(r.Context(), req.URL)
+	result, err := h.service.ShortenWithOptions(r.Context(), req.URL, service.ShortenOptions{
+		Domain:    req.Domain,
+		Password:  req.Password,
+		MaxClicks: req.MaxClicks,
+
+		NotBefore:  req.NotBefore,
+		PendingURL: req.PendingURL,
+		ExpiredURL: req.ExpiredURL,
+
+		Targets: fromTargetDTOs(req.Targets),
+		Sticky:  req.Sticky,
+
+		Rules: req.Rules,
+
+		ForwardQuery: req.ForwardQuery,
+		ForwardPath:  req.ForwardPath,
+
+		UTMTemplate: req.UTMTemplate,
+		UTM:         service.UTM(req.UTMFields),
+
+		Metadata: service.Metadata(req.MetadataFields),
+		Labels:   service.Labels(req.LabelsFields),
+
+		CreatedBy: req.CreatedBy,
+	})
 	if err != nil {
-		h.handleServiceError(w, err)
+		h.handleServiceError(w, r, err)
 		return
 	}
 
@@ -50,68 +122,229 @@ func (h *Handler) Shorten(w http.ResponseWriter, r *http.Request) {
 		status = http.StatusOK
 	}
 
-	h.writeJSON(w, status, ShortenResponse{
+	resp := ShortenResponse{
 		ShortURL:    result.ShortURL,
 		OriginalURL: result.OriginalURL,
+		UntaggedURL: result.UntaggedURL,
+		Domain:      result.Domain,
 		ExpiresAt:   result.ExpiresAt,
-	})
+		NotBefore:   result.NotBefore,
+		Protected:   result.Protected,
+		MaxClicks:   result.MaxClicks,
+		Targets:     req.Targets,
+		Sticky:      req.Sticky,
+		Rules:       req.Rules,
+
+		ForwardQuery: req.ForwardQuery,
+		ForwardPath:  req.ForwardPath,
+
+		MetadataFields: req.MetadataFields,
+		LabelsFields:   LabelsFields(result.Labels),
+		CreatedBy:      result.CreatedBy,
+		Workspace:      result.Workspace,
+	}
+	if req.QR {
+		resp.QRURL = h.service.QRCodeURL(result.Domain, result.ShortCode)
+	}
+
+	h.writeJSON(w, r, status, resp)
 }
 
-// Обрабатывает запросы GET /{code}
+// Обрабатывает запросы GET /{code}. Ссылка ищется в домене из заголовка Host.
+// Код с суффиксом "+" открывает страницу предпросмотра вместо редиректа.
+// Для ссылок с паролем без cookie разблокировки отображается форма пароля.
+// Для ссылок с ротацией выбранный вариант закрепляется cookie, если это включено.
+// Правила ссылки проверяются по User-Agent, Accept-Language и параметрам запроса.
+// Ботам соцсетей для ссылок с описанием отдается страница с OpenGraph-разметкой
 func (h *Handler) Redirect(w http.ResponseWriter, r *http.Request) {
 	code := r.PathValue("code")
+	if previewCode, ok := strings.CutSuffix(code, "+"); ok {
+		h.preview(w, r, previewCode)
+		return
+	}
+	if isCrawler(r.UserAgent()) {
+		link, err := h.service.Lookup(r.Context(), h.service.DomainForHost(r.Host), code)
+		if err == nil && link.Metadata != (service.Metadata{}) {
+			h.unfurl(w, r, link)
+			return
+		}
+	}
+	h.redirect(w, r, code, "", false)
+}
+
+// Обрабатывает запросы GET /{code}/{path...}. Путь после кода дописывается
+// к адресу назначения; ссылки без передачи пути отвечают 404
+func (h *Handler) RedirectPath(w http.ResponseWriter, r *http.Request) {
+	h.redirect(w, r, r.PathValue("code"), r.PathValue("path"), true)
+}
+
+func (h *Handler) redirect(w http.ResponseWriter, r *http.Request, code, path string, subPath bool) {
+	domain := h.service.DomainForHost(r.Host)
 
 	// Валидация формата кода
 	if !shortcode.IsValid(code) {
-		h.writeError(w, http.StatusNotFound, "not_found", "Short URL not found")
+		h.writeError(w, r, http.StatusNotFound, "not_found", "Short URL not found")
 		return
 	}
-	originalURL, err := h.service.Resolve(r.Context(), code)
+	req := h.resolveRequest(r, domain, code, subPath)
+	req.Unlocked = h.unlocked(r, domain, code)
+	res, err := h.service.Resolve(r.Context(), req)
 	if err != nil {
-		if errors.Is(err, service.ErrCodeNotFound) {
-			h.writeError(w, http.StatusNotFound, "not_found", "Short URL not found")
+		if errors.Is(err, service.ErrPasswordRequired) {
+			h.passwordForm(w, r, http.StatusUnauthorized, "")
 			return
 		}
-		h.writeError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		h.handleResolveError(w, r, err)
+		return
+	}
+
+	// Постоянный редирект кэшируется браузером и обошел бы пароль, лимит переходов,
+	// смену резервного адреса на основной после начала действия и ротацию вариантов
+	status := http.StatusFound
+	if res.Permanent {
+		status = http.StatusMovedPermanently
+	}
+	h.sendRedirect(w, r, code, path, res, status)
+}
+
+// resolveRequest собирает запрос перехода: вариант из cookie и данные посетителя для правил
+func (h *Handler) resolveRequest(r *http.Request, domain, code string, subPath bool) service.ResolveRequest {
+	return service.ResolveRequest{
+		Domain:  domain,
+		Code:    code,
+		Variant: stickyVariant(r, code),
+		Visitor: rules.FromRequest(r),
+		SubPath: subPath,
+	}
+}
+
+// sendRedirect закрепляет выбранный вариант и перенаправляет на адрес назначения
+// с переданными путем и параметрами запроса. Непостоянный редирект не кэшируется
+func (h *Handler) sendRedirect(w http.ResponseWriter, r *http.Request, code, path string, res *service.Resolution, status int) {
+	if res.Sticky {
+		h.setVariantCookie(w, code, res.Variant)
+	}
+
+	target, err := forwardURL(res, path, r.URL.Query())
+	if err != nil {
+		h.handleResolveError(w, r, fmt.Errorf("building destination: %w", err))
 		return
 	}
-	http.Redirect(w, r, originalURL, http.StatusMovedPermanently)
+
+	if status != http.StatusMovedPermanently {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	http.Redirect(w, r, target, status)
+}
+
+// Обрабатывает GET /api/utm-templates
+func (h *Handler) UTMTemplates(w http.ResponseWriter, r *http.Request) {
+	templates := h.service.UTMTemplates()
+	resp := UTMTemplatesResponse{Templates: make([]UTMTemplateDTO, len(templates))}
+	for i, t := range templates {
+		resp.Templates[i] = UTMTemplateDTO{Name: t.Name, UTMFields: UTMFields(t.UTM)}
+	}
+	h.writeJSON(w, r, http.StatusOK, resp)
 }
 
 // Обрабатывает GET /health
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
-	h.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	h.writeJSON(w, r, http.StatusOK, map[string]string{"status": "ok"})
 }
 
 // Данный метод отображает ошибки сервиса на HTTP ответы
-func (h *Handler) handleServiceError(w http.ResponseWriter, err error) {
+func (h *Handler) handleServiceError(w http.ResponseWriter, r *http.Request, err error) {
 	switch {
 	case errors.Is(err, service.ErrEmptyURL):
-		h.writeError(w, http.StatusBadRequest, "empty_url", "URL cannot be empty")
+		h.writeError(w, r, http.StatusBadRequest, "empty_url", "URL cannot be empty")
 	case errors.Is(err, service.ErrInvalidURL):
-		h.writeError(w, http.StatusBadRequest, "invalid_url", "Invalid URL format.")
+		h.writeError(w, r, http.StatusBadRequest, "invalid_url", "Invalid URL format.")
+	case errors.Is(err, service.ErrUnknownDomain):
+		h.writeError(w, r, http.StatusBadRequest, "unknown_domain", "Unknown short domain")
+	case errors.Is(err, service.ErrPasswordTooLong):
+		h.writeError(w, r, http.StatusBadRequest, "invalid_password", "Password must be at most 72 bytes")
+	case errors.Is(err, service.ErrInvalidMaxClicks):
+		h.writeError(w, r, http.StatusBadRequest, "invalid_max_clicks", "max_clicks must not be negative")
+	case errors.Is(err, service.ErrInvalidFallback):
+		h.writeError(w, r, http.StatusBadRequest, "invalid_fallback_url", "Invalid pending_url or expired_url")
+	case errors.Is(err, service.ErrInvalidTargets):
+		h.writeError(w, r, http.StatusBadRequest, "invalid_targets", "Targets need valid URLs and non-negative weights with a positive sum")
+	case errors.Is(err, service.ErrInvalidRules):
+		h.writeError(w, r, http.StatusBadRequest, "invalid_rules", "Rules need a known platform, at least one condition and a valid URL")
+	case errors.Is(err, service.ErrInvalidQueryMode):
+		h.writeError(w, r, http.StatusBadRequest, "invalid_forward_query", "forward_query must be link, request or append")
+	case errors.Is(err, service.ErrUnknownUTMTemplate):
+		h.writeError(w, r, http.StatusBadRequest, "unknown_utm_template", "Unknown UTM template")
+	case errors.Is(err, service.ErrInvalidMetadata):
+		h.writeError(w, r, http.StatusBadRequest, "invalid_metadata", "Title, description or image_url is invalid or too long")
+	case errors.Is(err, service.ErrInvalidLabels):
+		h.writeError(w, r, http.StatusBadRequest, "invalid_labels", "Up to 20 tags of letters, digits and -_.:/ (50 characters each) and a note of up to 2000 characters are allowed")
+	case errors.Is(err, service.ErrInvalidCursor):
+		h.writeError(w, r, http.StatusBadRequest, "invalid_cursor", "Pagination cursor is invalid")
+	case errors.Is(err, service.ErrInvalidListQuery):
+		h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", "tag, status, order or limit is invalid")
+	case errors.Is(err, service.ErrInvalidConflict):
+		h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", "conflict must be skip, overwrite or fail")
+	case errors.Is(err, service.ErrHasTargets):
+		h.writeError(w, r, http.StatusBadRequest, "has_targets", "Destination of a link with targets is set by its targets")
+	case errors.Is(err, service.ErrInvalidSwitch):
+		h.writeError(w, r, http.StatusBadRequest, "invalid_switch", "next_url needs a switch_at in the future")
+	case errors.Is(err, service.ErrInvalidWebhook):
+		h.writeError(w, r, http.StatusBadRequest, "invalid_webhook", "Webhook needs an http(s) URL, known event types and a secret of up to 256 bytes")
+	case errors.Is(err, service.ErrQuotaExceeded):
+		h.writeError(w, r, http.StatusForbidden, "quota_exceeded", "Workspace link quota exceeded")
+	case errors.Is(err, service.ErrForbidden):
+		h.forbidden(w, r, err.Error())
+	case errors.Is(err, service.ErrCodeNotFound):
+		h.writeError(w, r, http.StatusNotFound, "not_found", "Short URL not found")
+	case errors.Is(err, service.ErrRevisionNotFound):
+		h.writeError(w, r, http.StatusNotFound, "revision_not_found", "Revision not found")
+	case errors.Is(err, service.ErrWebhookNotFound):
+		h.writeError(w, r, http.StatusNotFound, "webhook_not_found", "Webhook not found")
+	case errors.Is(err, service.ErrDeliveryNotFound):
+		h.writeError(w, r, http.StatusNotFound, "delivery_not_found", "Webhook delivery not found")
+	case errors.Is(err, service.ErrRestoreExpired):
+		h.writeError(w, r, http.StatusGone, "restore_expired", "Restore window of the deleted link has passed")
 	case errors.Is(err, service.ErrTooManyCollisions):
-		h.writeError(w, http.StatusInternalServerError, "internal_error", "Failed to generate short URL")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to generate short URL")
+	default:
+		h.logger.ErrorContext(r.Context(), "unexpected error", slog.Any("error", err))
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+	}
+}
+
+// Данный метод отображает ошибки перехода по ссылке на HTTP ответы
+func (h *Handler) handleResolveError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrCodeNotFound):
+		h.writeError(w, r, http.StatusNotFound, "not_found", "Short URL not found")
+	case errors.Is(err, service.ErrLinkExhausted):
+		h.writeError(w, r, http.StatusGone, "link_exhausted", "Short URL has reached its click limit")
+	case errors.Is(err, service.ErrLinkDeleted):
+		h.writeError(w, r, http.StatusGone, "link_deleted", "Short URL has been deleted")
+	case errors.Is(err, service.ErrNotYetActive):
+		h.writeError(w, r, http.StatusForbidden, "not_yet_active", "Short URL is not active yet")
 	default:
-		h.logger.Error("unexpected error", slog.Any("error", err))
-		h.writeError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		h.logger.ErrorContext(r.Context(), "resolve failed", slog.Any("error", err))
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
 	}
 }
 
 // Метод записывает JSON ответ
-func (h *Handler) writeJSON(w http.ResponseWriter, status int, data any) {
+func (h *Handler) writeJSON(w http.ResponseWriter, r *http.Request, status int, data any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 
 	if err := json.NewEncoder(w).Encode(data); err != nil {
-		h.logger.Error("failed to encode response", slog.Any("error", err))
+		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
 	}
 }
 
-// Метод, записывающий сообщение об ошибке
-func (h *Handler) writeError(w http.ResponseWriter, status int, errCode, message string) {
-	h.writeJSON(w, status, ErrorResponse{
-		Error:   errCode,
-		Message: message,
+// Метод, записывающий сообщение об ошибке вместе с идентификатором запроса
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, status int, errCode, message string) {
+	h.writeJSON(w, r, status, ErrorResponse{
+		Error:     errCode,
+		Message:   message,
+		RequestID: logging.RequestIDFromContext(r.Context()),
 	})
 }
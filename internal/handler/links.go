@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BuzzLyutic/url-shortener/internal/service"
+)
+
+const (
+	variantCookiePrefix = "variant_"
+	variantCookieTTL    = 30 * 24 * time.Hour
+)
+
+// Обрабатывает GET /api/links/{code}. Параметр запроса domain выбирает короткий домен
+func (h *Handler) GetLink(w http.ResponseWriter, r *http.Request) {
+	link, err := h.service.Lookup(r.Context(), r.URL.Query().Get("domain"), r.PathValue("code"))
+	if err != nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, http.StatusOK, linkResponse(link))
+}
+
+// Обрабатывает GET /api/links: список ссылок домена с фильтрами и постраничной
+// выборкой по курсору next_cursor из предыдущего ответа
+func (h *Handler) ListLinks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := service.ListOptions{
+		Domain:    query.Get("domain"),
+		CreatedBy: query.Get("created_by"),
+		Tags:      query["tag"],
+		Host:      query.Get("host"),
+		Query:     query.Get("q"),
+		Status:    query.Get("status"),
+		Order:     query.Get("order"),
+		Cursor:    query.Get("cursor"),
+	}
+	var err error
+	if opts.CreatedAfter, err = timeParam(query, "created_after"); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	if opts.CreatedBefore, err = timeParam(query, "created_before"); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", "limit must be an integer")
+			return
+		}
+		opts.Limit = limit
+	}
+
+	result, err := h.service.List(r.Context(), opts)
+	if err != nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+	resp := ListLinksResponse{
+		Links:      make([]LinkResponse, len(result.Links)),
+		NextCursor: result.NextCursor,
+	}
+	for i, link := range result.Links {
+		resp.Links[i] = linkResponse(link)
+	}
+	h.writeJSON(w, r, http.StatusOK, resp)
+}
+
+// timeParam разбирает необязательный параметр запроса в формате RFC 3339
+func timeParam(query url.Values, name string) (*time.Time, error) {
+	v := query.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+	}
+	return &t, nil
+}
+
+// Обрабатывает PATCH /api/links/{code}: меняет адрес назначения, планирует его смену,
+// меняет веса вариантов, описание, метки и заметку ссылки без ее пересоздания
+func (h *Handler) UpdateLink(w http.ResponseWriter, r *http.Request) {
+	var req UpdateLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid json", "Invalid JSON body")
+		return
+	}
+	hasMetadata := req.Title != nil || req.Description != nil || req.ImageURL != nil
+	hasLabels := req.Tags != nil || req.Note != nil
+	hasSwitch := req.NextURL != nil || req.SwitchAt != nil
+	if req.URL == nil && !hasSwitch && req.Weights == nil && !hasMetadata && !hasLabels {
+		h.writeError(w, r, http.StatusBadRequest, "empty_update", "Nothing to update")
+		return
+	}
+
+	link, err := h.service.UpdateLink(r.Context(), r.URL.Query().Get("domain"), r.PathValue("code"), service.LinkUpdate{
+		URL:         req.URL,
+		NextURL:     req.NextURL,
+		SwitchAt:    req.SwitchAt,
+		Weights:     req.Weights,
+		Title:       req.Title,
+		Description: req.Description,
+		ImageURL:    req.ImageURL,
+		Tags:        req.Tags,
+		Note:        req.Note,
+	})
+	if err != nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, http.StatusOK, linkResponse(link))
+}
+
+// Обрабатывает DELETE /api/links/{code}. Свои ссылки удаляет editor, любые - admin.
+// Удаленную ссылку можно восстановить до окончания срока восстановления
+func (h *Handler) DeleteLink(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.Delete(r.Context(), r.URL.Query().Get("domain"), r.PathValue("code")); err != nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Обрабатывает POST /api/links/{code}/restore: восстанавливает удаленную ссылку
+func (h *Handler) RestoreLink(w http.ResponseWriter, r *http.Request) {
+	link, err := h.service.Restore(r.Context(), r.URL.Query().Get("domain"), r.PathValue("code"))
+	if err != nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, http.StatusOK, linkResponse(link))
+}
+
+// linkResponse собирает ответ о ссылке. Адреса ссылок с паролем не раскрываются
+func linkResponse(link *service.Link) LinkResponse {
+	resp := LinkResponse{
+		ShortURL:   link.ShortURL,
+		Domain:     link.Domain,
+		CreatedAt:  link.CreatedAt,
+		CreatedBy:  link.CreatedBy,
+		Workspace:  link.Workspace,
+		ExpiresAt:  link.ExpiresAt,
+		NotBefore:  link.NotBefore,
+		Protected:  link.Protected,
+		ClicksLeft: link.ClicksLeft,
+		Targets:    toTargetDTOs(link.Targets),
+		Sticky:     link.Sticky,
+
+		ForwardQuery: link.ForwardQuery,
+		ForwardPath:  link.ForwardPath,
+
+		SwitchAt: link.SwitchAt,
+
+		DeletedAt: link.DeletedAt,
+
+		MetadataFields: MetadataFields(link.Metadata),
+		LabelsFields:   LabelsFields(link.Labels),
+	}
+	if link.Protected {
+		for i := range resp.Targets {
+			resp.Targets[i].URL = ""
+		}
+	} else {
+		resp.OriginalURL = link.OriginalURL
+		resp.UntaggedURL = link.UntaggedURL
+		resp.Rules = link.Rules
+		resp.NextURL = link.NextURL
+	}
+	return resp
+}
+
+func fromTargetDTOs(targets []TargetDTO) []service.Target {
+	if len(targets) == 0 {
+		return nil
+	}
+	result := make([]service.Target, len(targets))
+	for i, t := range targets {
+		result[i] = service.Target{URL: t.URL, Weight: t.Weight}
+	}
+	return result
+}
+
+func toTargetDTOs(targets []service.Target) []TargetDTO {
+	if len(targets) == 0 {
+		return nil
+	}
+	result := make([]TargetDTO, len(targets))
+	for i, t := range targets {
+		result[i] = TargetDTO{URL: t.URL, Weight: t.Weight, Clicks: t.Clicks}
+	}
+	return result
+}
+
+// stickyVariant возвращает закрепленный за посетителем вариант ссылки (с 1); 0 - нет
+func stickyVariant(r *http.Request, code string) int {
+	cookie, err := r.Cookie(variantCookiePrefix + code)
+	if err != nil {
+		return 0
+	}
+	variant, err := strconv.Atoi(cookie.Value)
+	if err != nil || variant < 1 {
+		return 0
+	}
+	return variant
+}
+
+// setVariantCookie закрепляет выбранный вариант за посетителем. Подпись не нужна:
+// подмена cookie лишь выбирает другой существующий вариант
+func (h *Handler) setVariantCookie(w http.ResponseWriter, code string, variant int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     variantCookiePrefix + code,
+		Value:    strconv.Itoa(variant),
+		Path:     strings.TrimRight(h.config.PathPrefix, "/") + "/" + code,
+		MaxAge:   int(variantCookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   h.config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
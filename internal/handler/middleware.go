@@ -1,12 +1,29 @@
 package handler
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"log/slog"
 	"net/http"
 	"runtime/debug"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BuzzLyutic/url-shortener/internal/logging"
 )
 
+// Заголовок с идентификатором запроса
+const RequestIDHeader = "X-Request-ID"
+
+// Максимальная длина принимаемого от клиента идентификатора запроса
+const maxRequestIDLength = 128
+
 // Оборачивает http.ResponseWriter для захвата кода статуса
 type responseWriter struct {
 	http.ResponseWriter
@@ -31,6 +48,11 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap открывает исходный writer для http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // Возвращает middleware, логирующий HTTP запросы
 func Logging(logger *slog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -39,7 +61,7 @@ func Logging(logger *slog.Logger) func(http.Handler) http.Handler {
 			wrapped := wrapResponseWriter(w)
 			next.ServeHTTP(wrapped, r)
 
-			logger.Info("http request",
+			logger.InfoContext(r.Context(), "http request",
 				slog.String("method", r.Method),
 				slog.String("path", r.URL.Path),
 				slog.Int("status", wrapped.status),
@@ -56,14 +78,95 @@ func Recovery(logger *slog.Logger) func(http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			defer func() {
 				if err := recover(); err != nil {
-					logger.Error("panic recovered",
+					logger.ErrorContext(r.Context(), "panic recovered",
 						slog.Any("error", err),
 						slog.String("stack", string(debug.Stack())),
 					)
-					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusInternalServerError)
+					_ = json.NewEncoder(w).Encode(ErrorResponse{
+						Error:     "internal_error",
+						Message:   "Internal server error",
+						RequestID: logging.RequestIDFromContext(r.Context()),
+					})
 				}
 			}()
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// Возвращает middleware, присваивающий каждому запросу идентификатор.
+// Идентификатор берется из заголовка X-Request-ID или генерируется,
+// возвращается в ответе и сохраняется в контексте запроса
+func RequestID() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if !isValidRequestID(id) {
+				id = newRequestID()
+			}
+
+			w.Header().Set(RequestIDHeader, id)
+			ctx := logging.WithRequestID(r.Context(), id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Генерирует случайный идентификатор запроса
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// Проверяет, что идентификатор от клиента безопасно логировать и возвращать
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// Возвращает middleware, открывающий серверный span на каждый запрос.
+// Входящий заголовок traceparent (W3C Trace Context) продолжает трассу клиента
+func Tracing() func(http.Handler) http.Handler {
+	tracer := otel.Tracer("github.com/BuzzLyutic/url-shortener/internal/handler")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", r.Method),
+					attribute.String("url.path", r.URL.Path),
+				),
+			)
+			defer span.End()
+
+			wrapped := wrapResponseWriter(w)
+			req := r.WithContext(ctx)
+			next.ServeHTTP(wrapped, req)
+
+			// Шаблон маршрута известен только после обработки запроса мультиплексором
+			if req.Pattern != "" {
+				span.SetName(req.Pattern)
+				span.SetAttributes(attribute.String("http.route", req.Pattern))
+			}
+			span.SetAttributes(attribute.Int("http.response.status_code", wrapped.status))
+			if wrapped.status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(wrapped.status))
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/BuzzLyutic/url-shortener/internal/service"
+)
+
+// forwardURL дописывает к адресу назначения путь после короткого кода и
+// параметры запроса, если это включено у ссылки
+func forwardURL(res *service.Resolution, path string, query url.Values) (string, error) {
+	if (!res.ForwardPath || path == "") && (res.ForwardQuery == "" || len(query) == 0) {
+		return res.URL, nil
+	}
+
+	u, err := url.Parse(res.URL)
+	if err != nil {
+		return "", err
+	}
+
+	if res.ForwardPath && path != "" {
+		segments := strings.Split(path, "/")
+		for i, s := range segments {
+			segments[i] = url.PathEscape(s)
+		}
+		u = u.JoinPath(segments...)
+		// JoinPath не сохраняет завершающий слэш пути
+		if strings.HasSuffix(path, "/") && !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/"
+			u.RawPath = ""
+		}
+	}
+
+	if res.ForwardQuery != "" && len(query) > 0 {
+		u.RawQuery = mergeQuery(u.Query(), query, res.ForwardQuery).Encode()
+	}
+
+	return u.String(), nil
+}
+
+// mergeQuery добавляет параметры запроса к параметрам адреса назначения.
+// Совпадающие параметры разрешаются согласно режиму
+func mergeQuery(dest, incoming url.Values, mode string) url.Values {
+	for key, values := range incoming {
+		_, exists := dest[key]
+		switch {
+		case !exists:
+			dest[key] = values
+		case mode == service.QueryPreferRequest:
+			dest[key] = values
+		case mode == service.QueryAppend:
+			dest[key] = append(dest[key], values...)
+		}
+	}
+	return dest
+}
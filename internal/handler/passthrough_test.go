@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/BuzzLyutic/url-shortener/internal/service"
+)
+
+func TestForwardURL(t *testing.T) {
+	tests := []struct {
+		name  string
+		res   service.Resolution
+		path  string
+		query string
+		want  string
+	}{
+		{"disabled", service.Resolution{URL: "https://example.com/a?x=1"}, "b", "x=2", "https://example.com/a?x=1"},
+		{"path", service.Resolution{URL: "https://example.com/docs", ForwardPath: true}, "v2/intro", "", "https://example.com/docs/v2/intro"},
+		{"path with trailing slash", service.Resolution{URL: "https://example.com/docs/", ForwardPath: true}, "v2/", "", "https://example.com/docs/v2/"},
+		{"path escaping", service.Resolution{URL: "https://example.com", ForwardPath: true}, "a b/c?d", "", "https://example.com/a%20b/c%3Fd"},
+		{"prefer link", service.Resolution{URL: "https://example.com/?utm_source=site", ForwardQuery: service.QueryPreferLink}, "", "utm_source=mail&ref=1", "https://example.com/?ref=1&utm_source=site"},
+		{"prefer request", service.Resolution{URL: "https://example.com/?utm_source=site", ForwardQuery: service.QueryPreferRequest}, "", "utm_source=mail", "https://example.com/?utm_source=mail"},
+		{"append", service.Resolution{URL: "https://example.com/?tag=a", ForwardQuery: service.QueryAppend}, "", "tag=b", "https://example.com/?tag=a&tag=b"},
+		{"path and query", service.Resolution{URL: "https://example.com/base#top", ForwardPath: true, ForwardQuery: service.QueryPreferRequest}, "x", "q=1", "https://example.com/base/x?q=1#top"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			got, err := forwardURL(&tt.res, tt.path, query)
+			if err != nil {
+				t.Fatalf("forwardURL() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("forwardURL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_Passthrough(t *testing.T) {
+	_, mux := setupTestHandler()
+	alias := shortenCode(t, mux, `{"url": "https://docs.example.com/v2?lang=en", "forward_path": true, "forward_query": "request"}`)
+	plain := shortenCode(t, mux, `{"url": "https://example.com/plain"}`)
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantURL    string
+	}{
+		{"path and query", "/" + alias + "/guide/start?lang=de&utm_source=x", http.StatusMovedPermanently, "https://docs.example.com/v2/guide/start?lang=de&utm_source=x"},
+		{"code only", "/" + alias, http.StatusMovedPermanently, "https://docs.example.com/v2?lang=en"},
+		{"plain link drops query", "/" + plain + "?utm_source=x", http.StatusMovedPermanently, "https://example.com/plain"},
+		{"plain link rejects path", "/" + plain + "/extra", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if loc := rec.Header().Get("Location"); loc != tt.wantURL {
+				t.Errorf("Location = %s, want %s", loc, tt.wantURL)
+			}
+		})
+	}
+
+	t.Run("invalid mode", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url": "https://example.com", "forward_query": "merge"}`))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+}
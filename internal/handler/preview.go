@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/BuzzLyutic/url-shortener/internal/service"
+)
+
+//go:embed templates/*.html
+var templatesFS embed.FS
+
+var defaultPreviewTemplate = template.Must(template.ParseFS(templatesFS, "templates/preview.html"))
+
+// ParsePreviewTemplate загружает пользовательский шаблон страницы предпросмотра.
+// Шаблон получает поля previewData
+func ParsePreviewTemplate(path string) (*template.Template, error) {
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("parsing preview template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// previewData - данные шаблона страницы предпросмотра
+type previewData struct {
+	ShortURL    string
+	OriginalURL string
+	Host        string // Хост назначения, выделяется на странице
+	ContinueURL string // Короткая ссылка без суффикса предпросмотра
+	CreatedAt   time.Time
+	ExpiresAt   *time.Time
+	NotBefore   *time.Time // Начало действия; nil - ссылка действует сразу
+	Protected   bool       // Ссылка с паролем: адрес назначения не раскрывается
+	Pending     bool       // Ссылка еще не действует: адрес назначения не раскрывается
+	Concealed   bool       // Адрес назначения не раскрывается (см. concealed)
+	ClicksLeft  *int       // Оставшееся число переходов; nil - без ограничения
+	Targets     []previewTarget
+}
+
+// previewTarget - вариант адреса ссылки с ротацией и его доля трафика в процентах
+type previewTarget struct {
+	URL   string
+	Share int
+}
+
+// Обрабатывает GET /preview/{code}
+func (h *Handler) Preview(w http.ResponseWriter, r *http.Request) {
+	h.preview(w, r, r.PathValue("code"))
+}
+
+// preview отображает страницу с адресом назначения вместо редиректа
+func (h *Handler) preview(w http.ResponseWriter, r *http.Request, code string) {
+	link, err := h.service.Lookup(r.Context(), h.service.DomainForHost(r.Host), code)
+	if err != nil {
+		if errors.Is(err, service.ErrCodeNotFound) {
+			h.writeError(w, r, http.StatusNotFound, "not_found", "Short URL not found")
+			return
+		}
+		h.handleServiceError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	data := previewData{
+		ShortURL:    link.ShortURL,
+		ContinueURL: link.ShortURL,
+		CreatedAt:   link.CreatedAt,
+		ExpiresAt:   link.ExpiresAt,
+		NotBefore:   link.NotBefore,
+		Protected:   link.Protected,
+		Pending:     link.NotBefore != nil && link.NotBefore.After(now),
+		Concealed:   concealed(link, now),
+		ClicksLeft:  link.ClicksLeft,
+	}
+	if !data.Concealed {
+		data.OriginalURL = link.OriginalURL
+		if u, err := url.Parse(link.OriginalURL); err == nil {
+			data.Host = u.Hostname()
+		}
+		data.Targets = previewTargets(link.Targets)
+	}
+
+	tmpl := h.config.PreviewTemplate
+	if tmpl == nil {
+		tmpl = defaultPreviewTemplate
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		h.handleServiceError(w, r, fmt.Errorf("rendering preview: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src 'self'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
+func previewTargets(targets []service.Target) []previewTarget {
+	total := 0
+	for _, t := range targets {
+		total += t.Weight
+	}
+	if total == 0 {
+		return nil
+	}
+	result := make([]previewTarget, len(targets))
+	for i, t := range targets {
+		result[i] = previewTarget{URL: t.URL, Share: t.Weight * 100 / total}
+	}
+	return result
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler_Preview(t *testing.T) {
+	_, mux := setupTestHandler()
+	code := shortenCode(t, mux, `{"url": "https://example.com/docs/page"}`)
+
+	for _, path := range []string{"/" + code + "+", "/preview/" + code} {
+		t.Run(path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+				t.Errorf("Content-Type = %s, want text/html", ct)
+			}
+			body := rec.Body.String()
+			if !strings.Contains(body, "https://example.com/docs/page") {
+				t.Error("destination not shown")
+			}
+			if !strings.Contains(body, `href="http://localhost:8080/`+code+`"`) {
+				t.Error("continue button does not point to the short link")
+			}
+			if !strings.Contains(body, "never") {
+				t.Error("expiry not shown")
+			}
+		})
+	}
+
+	for _, path := range []string{"/nonexist12+", "/preview", "/preview+"} {
+		t.Run("not found "+path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("Status = %d, want %d", rec.Code, http.StatusNotFound)
+			}
+		})
+	}
+}
+
+func TestHandler_Preview_EscapesDestination(t *testing.T) {
+	_, mux := setupTestHandler()
+	code := shortenCode(t, mux, `{"url": "https://example.com/?q=\"><script>alert(1)</script>"}`)
+
+	req := httptest.NewRequest(http.MethodGet, "/"+code+"+", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if strings.Contains(body, "<script>alert(1)</script>") {
+		t.Error("destination is not escaped")
+	}
+	if !strings.Contains(body, "&lt;script&gt;") {
+		t.Error("escaped destination not found")
+	}
+}
+
+func TestHandler_Preview_CustomTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "preview.html")
+	os.WriteFile(path, []byte(`<p class="brand">{{.Host}} | {{.OriginalURL}}</p>`), 0o600)
+
+	tmpl, err := ParsePreviewTemplate(path)
+	if err != nil {
+		t.Fatalf("ParsePreviewTemplate() error = %v", err)
+	}
+
+	mux := newTestMux(Config{PreviewTemplate: tmpl})
+	code := shortenCode(t, mux, `{"url": "https://example.com/themed"}`)
+
+	req := httptest.NewRequest(http.MethodGet, "/preview/"+code, nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if !strings.Contains(rec.Body.String(), `<p class="brand">example.com | https://example.com/themed</p>`) {
+		t.Errorf("custom template not used: %s", rec.Body.String())
+	}
+}
+
+func TestParsePreviewTemplate_Missing(t *testing.T) {
+	if _, err := ParsePreviewTemplate(filepath.Join(t.TempDir(), "missing.html")); err == nil {
+		t.Error("ParsePreviewTemplate() expected error")
+	}
+}
+
+func TestHandler_Preview_ClicksLeft(t *testing.T) {
+	_, mux := setupTestHandler()
+	code := shortenCode(t, mux, `{"url": "https://example.com/once", "max_clicks": 3}`)
+
+	req := httptest.NewRequest(http.MethodGet, "/"+code+"+", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "<dt>Uses left</dt><dd>3</dd>") {
+		t.Errorf("remaining uses not shown: %s", body)
+	}
+	// Адрес ссылки с лимитом раскрывается только переходом, как и на странице для ботов
+	if strings.Contains(body, "example.com/once") {
+		t.Errorf("preview leaks destination of click-limited link: %s", body)
+	}
+}
+
+func TestHandler_Preview_Pending(t *testing.T) {
+	_, mux := setupTestHandler()
+	launch := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	code := shortenCode(t, mux, `{"url": "https://example.com/launch",
+		"targets": [{"url": "https://example.com/launch-a", "weight": 1}, {"url": "https://example.com/launch-b", "weight": 1}],
+		"not_before": "`+launch+`"}`)
+
+	for _, path := range []string{"/" + code + "+", "/preview/" + code} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: Status = %d, want %d", path, rec.Code, http.StatusOK)
+		}
+		body := rec.Body.String()
+		if strings.Contains(body, "example.com/launch") || strings.Contains(body, "Continue to") {
+			t.Errorf("%s: preview leaks destination before launch: %s", path, body)
+		}
+		if !strings.Contains(body, "not active yet") || !strings.Contains(body, "Active from") {
+			t.Errorf("%s: pending state not shown: %s", path, body)
+		}
+	}
+}
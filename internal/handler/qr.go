@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/BuzzLyutic/url-shortener/internal/qrcode"
+	"github.com/BuzzLyutic/url-shortener/internal/service"
+)
+
+// Параметры QR-кода по умолчанию и допустимые границы
+const (
+	defaultQRSize   = 256
+	minQRSize       = 64
+	maxQRSize       = 2048
+	defaultQRMargin = 4
+	maxQRMargin     = 16
+)
+
+// Обрабатывает GET /api/links/{code}/qr. Параметры запроса:
+// format (png|svg), size (пиксели), level (L|M|Q|H), margin (модули), domain
+func (h *Handler) QRCode(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = "png"
+	}
+	if format != "png" && format != "svg" {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", "format must be png or svg")
+		return
+	}
+
+	size, ok := intParam(query.Get("size"), defaultQRSize, minQRSize, maxQRSize)
+	if !ok {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", "size must be between 64 and 2048")
+		return
+	}
+
+	margin, ok := intParam(query.Get("margin"), defaultQRMargin, 0, maxQRMargin)
+	if !ok {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", "margin must be between 0 and 16")
+		return
+	}
+
+	level := qrcode.Medium
+	if v := query.Get("level"); v != "" {
+		var err error
+		if level, err = qrcode.ParseLevel(v); err != nil {
+			h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", "level must be L, M, Q or H")
+			return
+		}
+	}
+
+	link, err := h.service.Lookup(r.Context(), query.Get("domain"), r.PathValue("code"))
+	if err != nil {
+		if errors.Is(err, service.ErrCodeNotFound) {
+			h.writeError(w, r, http.StatusNotFound, "not_found", "Short URL not found")
+			return
+		}
+		h.handleServiceError(w, r, err)
+		return
+	}
+
+	code, err := qrcode.Encode([]byte(link.ShortURL), level)
+	if err != nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+
+	// Размер модуля подбирается так, чтобы изображение не превышало size
+	scale := max(1, size/(code.Size+2*margin))
+
+	var buf bytes.Buffer
+	contentType := "image/png"
+	if format == "svg" {
+		contentType = "image/svg+xml"
+		err = code.WriteSVG(&buf, scale, margin)
+	} else {
+		err = code.WritePNG(&buf, scale, margin)
+	}
+	if err != nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// intParam разбирает целый параметр запроса с границами. Пустое значение дает def
+func intParam(raw string, def, minVal, maxVal int) (int, bool) {
+	if raw == "" {
+		return def, true
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < minVal || v > maxVal {
+		return 0, false
+	}
+	return v, true
+}
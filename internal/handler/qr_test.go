@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// shortenCode создает короткую ссылку через API и возвращает ее код
+func shortenCode(t *testing.T, mux *http.ServeMux, body string) string {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated && rec.Code != http.StatusOK {
+		t.Fatalf("shorten status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	var resp ShortenResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	parts := strings.Split(resp.ShortURL, "/")
+	return parts[len(parts)-1]
+}
+
+func TestHandler_QRCode(t *testing.T) {
+	_, mux := setupTestHandler()
+	code := shortenCode(t, mux, `{"url": "https://example.com/poster"}`)
+
+	t.Run("png", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/links/"+code+"/qr?size=300&level=H&margin=2", nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+			t.Errorf("Content-Type = %s, want image/png", ct)
+		}
+		img, err := png.Decode(rec.Body)
+		if err != nil {
+			t.Fatalf("png.Decode() error = %v", err)
+		}
+		if w := img.Bounds().Dx(); w > 300 || w < 150 {
+			t.Errorf("image width = %d, want close to 300", w)
+		}
+	})
+
+	t.Run("svg", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/links/"+code+"/qr?format=svg", nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "image/svg+xml" {
+			t.Errorf("Content-Type = %s, want image/svg+xml", ct)
+		}
+		if !strings.Contains(rec.Body.String(), "<svg") {
+			t.Error("body is not svg")
+		}
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, query := range []string{"format=gif", "size=10", "size=abc", "level=Z", "margin=100"} {
+			req := httptest.NewRequest(http.MethodGet, "/api/links/"+code+"/qr?"+query, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: Status = %d, want %d", query, rec.Code, http.StatusBadRequest)
+			}
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/links/nonexist12/qr", nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Status = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestHandler_Shorten_QRURL(t *testing.T) {
+	_, mux := setupTestHandler()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url": "https://example.com/qr", "qr": true}`))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	var resp ShortenResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+
+	code := strings.TrimPrefix(resp.ShortURL, "http://localhost:8080/")
+	want := "http://localhost:8080/api/links/" + code + "/qr"
+	if resp.QRURL != want {
+		t.Errorf("QRURL = %s, want %s", resp.QRURL, want)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url": "https://example.com/no-qr"}`))
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	resp = ShortenResponse{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.QRURL != "" {
+		t.Errorf("QRURL = %s, want empty", resp.QRURL)
+	}
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Обрабатывает GET /api/links/{code}/revisions: прежние адреса ссылки.
+// Параметр запроса domain выбирает короткий домен
+func (h *Handler) ListRevisions(w http.ResponseWriter, r *http.Request) {
+	revisions, err := h.service.Revisions(r.Context(), r.URL.Query().Get("domain"), r.PathValue("code"))
+	if err != nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+	resp := RevisionsResponse{Revisions: make([]RevisionDTO, len(revisions))}
+	for i, rev := range revisions {
+		resp.Revisions[i] = RevisionDTO(rev)
+	}
+	h.writeJSON(w, r, http.StatusOK, resp)
+}
+
+// Обрабатывает POST /api/links/{code}/revisions/{n}/restore: возвращает ссылке
+// адрес ревизии n и отвечает ссылкой после отката
+func (h *Handler) RestoreRevision(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil || n < 1 {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", "Revision must be a positive integer")
+		return
+	}
+	link, err := h.service.RestoreRevision(r.Context(), r.URL.Query().Get("domain"), r.PathValue("code"), n)
+	if err != nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, http.StatusOK, linkResponse(link))
+}
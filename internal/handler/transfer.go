@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BuzzLyutic/url-shortener/internal/service"
+	"github.com/BuzzLyutic/url-shortener/internal/transfer"
+)
+
+// Обрабатывает GET /api/export: потоковая выгрузка ссылок в JSONL или CSV.
+// Параметр domain ограничивает выгрузку одним доменом, по умолчанию выгружаются все
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = transfer.FormatJSONL
+	}
+
+	domains := h.service.Domains()
+	if query.Has("domain") {
+		domains = []string{query.Get("domain")}
+	}
+
+	// Записи пишутся в ответ по мере чтения, поэтому ошибка посреди выгрузки
+	// уже не может изменить статус ответа и только логируется
+	started := false
+	writer, err := transfer.NewWriter(writeFunc(func(p []byte) (int, error) {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", transfer.ContentType(format))
+			w.Header().Set("Content-Disposition", `attachment; filename="links.`+format+`"`)
+			w.WriteHeader(http.StatusOK)
+		}
+		return w.Write(p)
+	}), format)
+	if err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", "format must be jsonl or csv")
+		return
+	}
+
+	// Большая выгрузка может писаться дольше таймаута записи сервера
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	n, err := h.service.Export(r.Context(), domains, writer)
+	if err != nil {
+		if !started {
+			h.handleServiceError(w, r, err)
+			return
+		}
+		h.logger.ErrorContext(r.Context(), "export interrupted", slog.Int("records", n), slog.Any("error", err))
+	}
+}
+
+// Обрабатывает POST /api/import: потоковая загрузка ссылок из тела запроса.
+// Параметры: format (jsonl или csv), conflict (skip, overwrite или fail), dry_run
+func (h *Handler) Import(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = transfer.FormatJSONL
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+			format = transfer.FormatCSV
+		}
+	}
+	reader, err := transfer.NewReader(r.Body, format)
+	if err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", "format must be jsonl or csv")
+		return
+	}
+
+	opts := service.ImportOptions{Conflict: query.Get("conflict")}
+	if v := query.Get("dry_run"); v != "" {
+		if opts.DryRun, err = strconv.ParseBool(v); err != nil {
+			h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", "dry_run must be a boolean")
+			return
+		}
+	}
+
+	_ = http.NewResponseController(w).SetReadDeadline(time.Time{})
+
+	report, err := h.service.Import(r.Context(), reader, opts)
+	if report == nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+
+	resp := importResponse(report)
+	status := http.StatusOK
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrImportConflict):
+		status, resp.Error, resp.Message = http.StatusConflict, "conflict", err.Error()
+	case errors.Is(err, service.ErrInvalidImport):
+		status, resp.Error, resp.Message = http.StatusBadRequest, "invalid_import", err.Error()
+	case errors.Is(err, service.ErrQuotaExceeded):
+		status, resp.Error, resp.Message = http.StatusForbidden, "quota_exceeded", err.Error()
+	default:
+		h.handleServiceError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, status, resp)
+}
+
+func importResponse(report *service.ImportReport) ImportResponse {
+	resp := ImportResponse{
+		DryRun:      report.DryRun,
+		Total:       report.Total,
+		Created:     report.Created,
+		Overwritten: report.Overwritten,
+		Skipped:     report.Skipped,
+		Tombstones:  report.Tombstones,
+		Invalid:     report.Invalid,
+	}
+	for _, e := range report.Errors {
+		resp.Errors = append(resp.Errors, ImportErrorDTO(e))
+	}
+	return resp
+}
+
+// writeFunc адаптирует функцию к io.Writer
+type writeFunc func(p []byte) (int, error)
+
+func (f writeFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
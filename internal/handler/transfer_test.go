@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler_ExportImport(t *testing.T) {
+	_, src := setupTestHandler()
+	for _, body := range []string{
+		`{"url": "https://example.com/one"}`,
+		`{"url": "https://example.com/two", "password": "secret"}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		src.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	export := func(t *testing.T, format string) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/export?format="+format, nil)
+		rec := httptest.NewRecorder()
+		src.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("export status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "links."+format) {
+			t.Errorf("Content-Disposition = %q", cd)
+		}
+		return rec.Body.String()
+	}
+
+	_, dst := setupTestHandler()
+	importBody := func(t *testing.T, query, body string) (int, ImportResponse) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/import?"+query, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		dst.ServeHTTP(rec, req)
+		var resp ImportResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+
+	t.Run("csv dry run", func(t *testing.T) {
+		status, resp := importBody(t, "format=csv&dry_run=true", export(t, "csv"))
+		if status != http.StatusOK || !resp.DryRun || resp.Created != 2 {
+			t.Errorf("status = %d, report = %+v", status, resp)
+		}
+	})
+
+	jsonl := export(t, "jsonl")
+	t.Run("jsonl import", func(t *testing.T) {
+		status, resp := importBody(t, "", jsonl)
+		if status != http.StatusOK || resp.Created != 2 {
+			t.Fatalf("status = %d, report = %+v", status, resp)
+		}
+
+		// Ссылка доступна по прежнему коду
+		code := strings.SplitN(jsonl, `"short_code":"`, 2)[1][:10]
+		req := httptest.NewRequest(http.MethodGet, "/api/links/"+code, nil)
+		rec := httptest.NewRecorder()
+		dst.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET /api/links/%s status = %d, want %d", code, rec.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("conflict fail", func(t *testing.T) {
+		status, resp := importBody(t, "conflict=fail", jsonl)
+		if status != http.StatusConflict || resp.Error != "conflict" {
+			t.Errorf("status = %d, report = %+v", status, resp)
+		}
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, query := range []string{"format=xml", "conflict=merge", "dry_run=maybe"} {
+			if status, _ := importBody(t, query, jsonl); status != http.StatusBadRequest {
+				t.Errorf("POST /api/import?%s status = %d, want %d", query, status, http.StatusBadRequest)
+			}
+		}
+		req := httptest.NewRequest(http.MethodGet, "/api/export?domain=unknown.example", nil)
+		rec := httptest.NewRecorder()
+		src.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("export unknown domain status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+}
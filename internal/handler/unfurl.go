@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BuzzLyutic/url-shortener/internal/service"
+)
+
+var unfurlTemplate = template.Must(template.ParseFS(templatesFS, "templates/unfurl.html"))
+
+// crawlerAgents - фрагменты User-Agent ботов, которые строят превью ссылок
+var crawlerAgents = []string{
+	"facebookexternalhit",
+	"facebot",
+	"twitterbot",
+	"slackbot",
+	"slack-imgproxy",
+	"linkedinbot",
+	"discordbot",
+	"telegrambot",
+	"whatsapp",
+	"skypeuripreview",
+	"vkshare",
+	"pinterest",
+	"redditbot",
+	"embedly",
+	"mastodon",
+	"applebot",
+}
+
+// isCrawler сообщает, что запрос пришел от бота, строящего превью ссылки
+func isCrawler(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	for _, agent := range crawlerAgents {
+		if strings.Contains(ua, agent) {
+			return true
+		}
+	}
+	return false
+}
+
+// unfurlData - данные страницы с OpenGraph-разметкой
+type unfurlData struct {
+	ShortURL   string
+	RefreshURL string // Адрес назначения; пусто, если переход зависит от условий ссылки
+	service.Metadata
+}
+
+// unfurl отдает боту страницу с OpenGraph и Twitter разметкой вместо редиректа.
+// Переход не засчитывается: лимит переходов и счетчики вариантов не меняются
+func (h *Handler) unfurl(w http.ResponseWriter, r *http.Request, link *service.Link) {
+	data := unfurlData{ShortURL: link.ShortURL, Metadata: link.Metadata}
+	if unconditional(link, time.Now()) {
+		data.RefreshURL = link.OriginalURL
+	}
+
+	var buf bytes.Buffer
+	if err := unfurlTemplate.Execute(&buf, data); err != nil {
+		h.handleServiceError(w, r, fmt.Errorf("rendering unfurl page: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// unconditional сообщает, что переход по ссылке всегда ведет на OriginalURL.
+// Иначе адрес раскрыл бы боту то, что Resolve не отдал бы посетителю
+func unconditional(link *service.Link, now time.Time) bool {
+	return !concealed(link, now) && len(link.Targets) == 0
+}
+
+// concealed сообщает, что адрес назначения ссылки не раскрывается до перехода:
+// у ссылки с паролем, еще не активной, с лимитом переходов или правилами.
+// По нему же страница предпросмотра скрывает адрес
+func concealed(link *service.Link, now time.Time) bool {
+	return link.Protected || (link.NotBefore != nil && link.NotBefore.After(now)) ||
+		link.ClicksLeft != nil || len(link.Rules) > 0
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const slackbotUA = "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"
+
+func TestIsCrawler(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want bool
+	}{
+		{slackbotUA, true},
+		{"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", true},
+		{"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)", true},
+		{"TelegramBot (like TwitterBot)", true},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isCrawler(tt.ua); got != tt.want {
+			t.Errorf("isCrawler(%q) = %v, want %v", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestHandler_Unfurl(t *testing.T) {
+	_, mux := setupTestHandler()
+	code := shortenCode(t, mux, `{"url": "https://example.com/launch",
+		"title": "Launch <day>", "description": "All the news", "image_url": "https://example.com/cover.png"}`)
+	plain := shortenCode(t, mux, `{"url": "https://example.com/plain"}`)
+
+	get := func(target, ua string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("User-Agent", ua)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/"+code, slackbotUA)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`<meta property="og:title" content="Launch &lt;day&gt;">`,
+		`<meta property="og:image" content="https://example.com/cover.png">`,
+		`<meta name="twitter:card" content="summary_large_image">`,
+		`http-equiv="refresh"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body does not contain %s", want)
+		}
+	}
+
+	// Ссылки без описания ботам отдаются обычным редиректом
+	if rec := get("/"+plain, slackbotUA); rec.Code != http.StatusMovedPermanently {
+		t.Errorf("bot status for plain link = %d, want %d", rec.Code, http.StatusMovedPermanently)
+	}
+
+	// Адрес назначения не раскрывается, если посетитель мог бы его не получить
+	launch := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"pending", `{"url": "https://example.com/secret", "title": "Soon", "not_before": "` + launch + `"}`},
+		{"click limited", `{"url": "https://example.com/secret", "title": "Once", "max_clicks": 1}`},
+		{"rules", `{"url": "https://example.com/secret", "title": "App", "rules": [{"platform": "ios", "url": "https://example.com/ios"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := shortenCode(t, mux, tt.body)
+			rec := get("/"+code, slackbotUA)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if body := rec.Body.String(); strings.Contains(body, "example.com/") || strings.Contains(body, "refresh") {
+				t.Errorf("unfurl page leaks destination: %s", body)
+			}
+		})
+	}
+
+	t.Run("exhausted", func(t *testing.T) {
+		code := shortenCode(t, mux, `{"url": "https://example.com/secret", "title": "Once", "max_clicks": 1}`)
+
+		// Превью ботом не списывает переход у ссылки с лимитом
+		if rec := get("/"+code, "Mozilla/5.0"); rec.Code != http.StatusFound {
+			t.Fatalf("human status = %d, want %d", rec.Code, http.StatusFound)
+		}
+		rec := get("/"+code, slackbotUA)
+		if strings.Contains(rec.Body.String(), "example.com/secret") {
+			t.Errorf("unfurl page leaks destination of exhausted link")
+		}
+	})
+}
+
+func TestHandler_UpdateLinkMetadata(t *testing.T) {
+	_, mux := setupTestHandler()
+	code := shortenCode(t, mux, `{"url": "https://example.com/post", "title": "Old", "description": "Kept"}`)
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/api/links/"+code, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := patch(`{"title": "New", "image_url": "https://example.com/new.png"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var link LinkResponse
+	json.NewDecoder(rec.Body).Decode(&link)
+	if link.Title != "New" || link.Description != "Kept" || link.ImageURL != "https://example.com/new.png" {
+		t.Errorf("metadata = %+v", link.MetadataFields)
+	}
+
+	if rec := patch(`{"image_url": "ftp://example.com/x.png"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid image status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := patch(`{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("empty update status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
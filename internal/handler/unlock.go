@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BuzzLyutic/url-shortener/internal/service"
+	"github.com/BuzzLyutic/url-shortener/internal/shortcode"
+)
+
+var passwordTemplate = template.Must(template.ParseFS(templatesFS, "templates/password.html"))
+
+const (
+	defaultUnlockTTL   = 24 * time.Hour
+	unlockCookiePrefix = "unlock_"
+	maxPasswordForm    = 4 << 10 // Ограничение размера тела формы пароля
+)
+
+// passwordData - данные шаблона формы пароля
+type passwordData struct {
+	ShortURL string
+	Error    string
+}
+
+// Обрабатывает POST /{code}: проверяет пароль из формы и выполняет редирект.
+// После успешного ввода пароль запоминается в подписанной cookie
+func (h *Handler) Unlock(w http.ResponseWriter, r *http.Request) {
+	h.unlock(w, r, r.PathValue("code"), "", false)
+}
+
+// Обрабатывает POST /{code}/{path...}: форму пароля ссылки с передачей пути.
+// Форма отправляется на адрес страницы, поэтому путь и параметры сохраняются
+func (h *Handler) UnlockPath(w http.ResponseWriter, r *http.Request) {
+	h.unlock(w, r, r.PathValue("code"), r.PathValue("path"), true)
+}
+
+func (h *Handler) unlock(w http.ResponseWriter, r *http.Request, code, path string, subPath bool) {
+	domain := h.service.DomainForHost(r.Host)
+
+	if !shortcode.IsValid(code) {
+		h.writeError(w, r, http.StatusNotFound, "not_found", "Short URL not found")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPasswordForm)
+	if err := r.ParseForm(); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_form", "Invalid form body")
+		return
+	}
+
+	req := h.resolveRequest(r, domain, code, subPath)
+	req.Password = r.PostForm.Get("password")
+	res, err := h.service.Resolve(r.Context(), req)
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrPasswordRequired):
+		h.passwordForm(w, r, http.StatusUnauthorized, "Enter the password.")
+		return
+	case errors.Is(err, service.ErrInvalidPassword):
+		h.passwordForm(w, r, http.StatusUnauthorized, "Wrong password.")
+		return
+	case errors.Is(err, service.ErrTooManyAttempts):
+		h.passwordForm(w, r, http.StatusTooManyRequests, "Too many attempts. Try again later.")
+		return
+	default:
+		h.handleResolveError(w, r, err)
+		return
+	}
+
+	h.setUnlockCookie(w, domain, code)
+	h.sendRedirect(w, r, code, path, res, http.StatusSeeOther)
+}
+
+// passwordForm отображает форму ввода пароля, не раскрывая адрес назначения
+func (h *Handler) passwordForm(w http.ResponseWriter, r *http.Request, status int, message string) {
+	data := passwordData{Error: message}
+	if link, err := h.service.Lookup(r.Context(), h.service.DomainForHost(r.Host), r.PathValue("code")); err == nil {
+		data.ShortURL = link.ShortURL
+	}
+
+	var buf bytes.Buffer
+	if err := passwordTemplate.Execute(&buf, data); err != nil {
+		h.handleServiceError(w, r, fmt.Errorf("rendering password form: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// setUnlockCookie запоминает успешный ввод пароля для ссылки
+func (h *Handler) setUnlockCookie(w http.ResponseWriter, domain, code string) {
+	expires := time.Now().Add(h.unlockTTL())
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookiePrefix + code,
+		Value:    h.signUnlock(domain, code, expires),
+		Path:     strings.TrimRight(h.config.PathPrefix, "/") + "/" + code,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   h.config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// unlocked проверяет подпись и срок действия cookie разблокировки ссылки
+func (h *Handler) unlocked(r *http.Request, domain, code string) bool {
+	cookie, err := r.Cookie(unlockCookiePrefix + code)
+	if err != nil {
+		return false
+	}
+	expiresStr, _, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil {
+		return false
+	}
+	expires := time.Unix(unix, 0)
+	if time.Now().After(expires) {
+		return false
+	}
+	return hmac.Equal([]byte(cookie.Value), []byte(h.signUnlock(domain, code, expires)))
+}
+
+// signUnlock формирует значение cookie вида "<unix-время истечения>.<HMAC>".
+// Подпись привязана к домену и коду, поэтому cookie нельзя перенести на другую ссылку
+func (h *Handler) signUnlock(domain, code string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(domain + "\x00" + code + "\x00" + exp))
+	return exp + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (h *Handler) unlockTTL() time.Duration {
+	if h.config.UnlockTTL > 0 {
+		return h.config.UnlockTTL
+	}
+	return defaultUnlockTTL
+}
+
+// randomSecret создает ключ подписи cookie, если он не задан в конфиге
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("handler: reading random bytes: %v", err))
+	}
+	return secret
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BuzzLyutic/url-shortener/internal/service"
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+)
+
+// postPassword отправляет форму пароля для короткого кода
+func postPassword(mux *http.ServeMux, code, password string) *httptest.ResponseRecorder {
+	form := url.Values{"password": {password}}
+	req := httptest.NewRequest(http.MethodPost, "/"+code, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandler_PasswordProtected(t *testing.T) {
+	_, mux := setupTestHandler()
+	code := shortenCode(t, mux, `{"url": "https://example.com/internal/doc", "password": "s3cret"}`)
+
+	t.Run("redirect shows password form", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/"+code, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+		body := rec.Body.String()
+		if !strings.Contains(body, `type="password"`) {
+			t.Error("password form not rendered")
+		}
+		if strings.Contains(body, "example.com/internal") {
+			t.Error("password form leaks destination")
+		}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		rec := postPassword(mux, code, "wrong")
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+		if !strings.Contains(rec.Body.String(), "Wrong password") {
+			t.Error("error message not shown")
+		}
+		if len(rec.Result().Cookies()) != 0 {
+			t.Error("cookie set for wrong password")
+		}
+	})
+
+	t.Run("correct password unlocks link", func(t *testing.T) {
+		rec := postPassword(mux, code, "s3cret")
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("Status = %d, want %d", rec.Code, http.StatusSeeOther)
+		}
+		if loc := rec.Header().Get("Location"); loc != "https://example.com/internal/doc" {
+			t.Errorf("Location = %s", loc)
+		}
+
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].Path != "/"+code {
+			t.Fatalf("unexpected cookies: %+v", cookies)
+		}
+
+		// С cookie переход выполняется без повторного ввода пароля
+		req := httptest.NewRequest(http.MethodGet, "/"+code, nil)
+		req.AddCookie(cookies[0])
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusFound {
+			t.Errorf("Status with cookie = %d, want %d", rec.Code, http.StatusFound)
+		}
+		if cc := rec.Header().Get("Cache-Control"); cc != "no-store" {
+			t.Errorf("Cache-Control = %q, want no-store", cc)
+		}
+
+		// Подделанная подпись не принимается
+		forged := *cookies[0]
+		forged.Value = strings.TrimRight(forged.Value, "A") + "B"
+		req = httptest.NewRequest(http.MethodGet, "/"+code, nil)
+		req.AddCookie(&forged)
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Status with forged cookie = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("preview hides destination", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/"+code+"+", nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if strings.Contains(rec.Body.String(), "example.com/internal") {
+			t.Error("preview leaks destination of protected link")
+		}
+	})
+}
+
+func TestHandler_UnlockAppliesRedirectOptions(t *testing.T) {
+	_, mux := setupTestHandler()
+
+	t.Run("rules", func(t *testing.T) {
+		code := shortenCode(t, mux, `{"url": "https://example.com/app", "password": "s3cret", "rules": [
+			{"platform": "ios", "url": "https://apps.apple.com/app/id1"}
+		]}`)
+		form := url.Values{"password": {"s3cret"}}
+		req := httptest.NewRequest(http.MethodPost, "/"+code, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("Status = %d, want %d", rec.Code, http.StatusSeeOther)
+		}
+		if loc := rec.Header().Get("Location"); loc != "https://apps.apple.com/app/id1" {
+			t.Errorf("Location = %s, want rule destination", loc)
+		}
+	})
+
+	t.Run("sticky variant and query", func(t *testing.T) {
+		code := shortenCode(t, mux, `{"password": "s3cret", "targets": [
+			{"url": "https://example.com/a", "weight": 0},
+			{"url": "https://example.com/b", "weight": 1}
+		], "sticky": true, "forward_query": "request"}`)
+		form := url.Values{"password": {"s3cret"}}
+		req := httptest.NewRequest(http.MethodPost, "/"+code+"?ref=mail", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("Status = %d, want %d", rec.Code, http.StatusSeeOther)
+		}
+		if loc := rec.Header().Get("Location"); loc != "https://example.com/b?ref=mail" {
+			t.Errorf("Location = %s, want second target with query", loc)
+		}
+		var variant bool
+		for _, c := range rec.Result().Cookies() {
+			variant = variant || (c.Name == variantCookiePrefix+code && c.Value == "2")
+		}
+		if !variant {
+			t.Errorf("variant cookie not set: %+v", rec.Result().Cookies())
+		}
+	})
+}
+
+func TestHandler_UnlockPath(t *testing.T) {
+	_, mux := setupTestHandler()
+	code := shortenCode(t, mux, `{"url": "https://example.com/docs", "password": "s3cret", "forward_path": true}`)
+
+	// Форма без action отправляется на адрес страницы вместе с путем
+	form := url.Values{"password": {"s3cret"}}
+	req := httptest.NewRequest(http.MethodPost, "/"+code+"/guide/intro", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Status = %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+	if loc := rec.Header().Get("Location"); loc != "https://example.com/docs/guide/intro" {
+		t.Errorf("Location = %s, want destination with path", loc)
+	}
+}
+
+func TestHandler_PasswordRateLimit(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	svc := service.New(store, service.Config{
+		BaseURL:             "http://localhost:8080",
+		PasswordMaxAttempts: 1,
+		PasswordLockout:     time.Minute,
+	})
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	mux := http.NewServeMux()
+	New(svc, logger, Config{}).RegisterRoutes(mux)
+
+	code := shortenCode(t, mux, `{"url": "https://example.com/limited", "password": "s3cret"}`)
+
+	if rec := postPassword(mux, code, "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("first attempt status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := postPassword(mux, code, "s3cret"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("locked attempt status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestHandler_UnlockCookieBoundToLink(t *testing.T) {
+	h, _ := setupTestHandler()
+	expires := time.Now().Add(time.Hour)
+
+	value := h.signUnlock("", "aB3_xY9z12", expires)
+	req := httptest.NewRequest(http.MethodGet, "/aB3_xY9z12", nil)
+	req.AddCookie(&http.Cookie{Name: unlockCookiePrefix + "aB3_xY9z12", Value: value})
+	if !h.unlocked(req, "", "aB3_xY9z12") {
+		t.Error("unlocked() = false for valid cookie")
+	}
+	if h.unlocked(req, "go.example", "aB3_xY9z12") {
+		t.Error("unlocked() = true for cookie of another domain")
+	}
+
+	expired := h.signUnlock("", "aB3_xY9z12", time.Now().Add(-time.Minute))
+	req = httptest.NewRequest(http.MethodGet, "/aB3_xY9z12", nil)
+	req.AddCookie(&http.Cookie{Name: unlockCookiePrefix + "aB3_xY9z12", Value: expired})
+	if h.unlocked(req, "", "aB3_xY9z12") {
+		t.Error("unlocked() = true for expired cookie")
+	}
+}
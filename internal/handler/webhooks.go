@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/BuzzLyutic/url-shortener/internal/service"
+)
+
+// Обрабатывает POST /api/webhooks: подписывает рабочее пространство на события
+// ссылок. Секрет подписи возвращается только в этом ответе
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid json", "Invalid JSON body")
+		return
+	}
+	webhook, err := h.service.CreateWebhook(r.Context(), req.URL, req.Events, req.Secret)
+	if err != nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, http.StatusCreated, WebhookDTO(*webhook))
+}
+
+// Обрабатывает GET /api/webhooks: подписки рабочего пространства без секретов
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := h.service.Webhooks(r.Context())
+	if err != nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+	resp := WebhooksResponse{Webhooks: make([]WebhookDTO, len(webhooks))}
+	for i, webhook := range webhooks {
+		resp.Webhooks[i] = WebhookDTO(webhook)
+	}
+	h.writeJSON(w, r, http.StatusOK, resp)
+}
+
+// Обрабатывает DELETE /api/webhooks/{id}: удаляет подписку вместе с журналом ее доставок
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.service.DeleteWebhook(r.Context(), id); err != nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Обрабатывает GET /api/webhooks/deliveries: журнал доставок с фильтрами
+// webhook_id и status и постраничной выборкой по курсору
+func (h *Handler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := service.DeliveryOptions{
+		Status: query.Get("status"),
+		Cursor: query.Get("cursor"),
+	}
+	if v := query.Get("webhook_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", "webhook_id must be an integer")
+			return
+		}
+		opts.WebhookID = id
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", "limit must be an integer")
+			return
+		}
+		opts.Limit = limit
+	}
+
+	page, err := h.service.Deliveries(r.Context(), opts)
+	if err != nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+	resp := DeliveriesResponse{
+		Deliveries: make([]DeliveryDTO, len(page.Deliveries)),
+		NextCursor: page.NextCursor,
+	}
+	for i, d := range page.Deliveries {
+		resp.Deliveries[i] = DeliveryDTO(d)
+	}
+	h.writeJSON(w, r, http.StatusOK, resp)
+}
+
+// Обрабатывает POST /api/webhooks/deliveries/{id}/retry: возвращает доставку
+// в очередь, в том числе из списка неотправленных
+func (h *Handler) RetryDelivery(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.service.RetryDelivery(r.Context(), id); err != nil {
+		h.handleServiceError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// pathID разбирает ID из пути запроса; при ошибке отвечает 400
+func (h *Handler) pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_parameter", "ID must be a positive integer")
+		return 0, false
+	}
+	return id, true
+}
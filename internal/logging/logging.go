@@ -0,0 +1,61 @@
+// Пакет logging предоставляет логирование с привязкой к контексту запроса.
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type ctxKey struct{}
+
+// WithRequestID возвращает контекст с сохраненным идентификатором запроса
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// RequestIDFromContext возвращает идентификатор запроса из контекста
+// или пустую строку, если он не задан
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// ContextHandler оборачивает slog.Handler и добавляет в каждую запись
+// атрибуты из контекста (идентификатор запроса и трассы)
+type ContextHandler struct {
+	slog.Handler
+}
+
+// NewContextHandler создает ContextHandler поверх переданного обработчика
+func NewContextHandler(h slog.Handler) *ContextHandler {
+	return &ContextHandler{Handler: h}
+}
+
+// Handle добавляет request_id и trace_id к записи и передает ее дальше
+func (h *ContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs возвращает новый ContextHandler с дополнительными атрибутами
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup возвращает новый ContextHandler с группой
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDContext(t *testing.T) {
+	ctx := WithRequestID(context.Background(), "req-123")
+
+	if got := RequestIDFromContext(ctx); got != "req-123" {
+		t.Errorf("RequestIDFromContext() = %v, want %v", got, "req-123")
+	}
+
+	if got := RequestIDFromContext(context.Background()); got != "" {
+		t.Errorf("RequestIDFromContext() = %v, want empty", got)
+	}
+}
+
+func TestContextHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewContextHandler(slog.NewTextHandler(&buf, nil)))
+
+	t.Run("adds request id from context", func(t *testing.T) {
+		buf.Reset()
+		ctx := WithRequestID(context.Background(), "req-abc")
+		logger.InfoContext(ctx, "test message")
+
+		if !strings.Contains(buf.String(), "request_id=req-abc") {
+			t.Errorf("request_id not found in log: %s", buf.String())
+		}
+	})
+
+	t.Run("without request id", func(t *testing.T) {
+		buf.Reset()
+		logger.Info("test message")
+
+		if strings.Contains(buf.String(), "request_id") {
+			t.Errorf("unexpected request_id in log: %s", buf.String())
+		}
+	})
+
+	t.Run("keeps request id with attrs and groups", func(t *testing.T) {
+		buf.Reset()
+		ctx := WithRequestID(context.Background(), "req-grp")
+		logger.With("component", "test").WithGroup("g").InfoContext(ctx, "test message")
+
+		if !strings.Contains(buf.String(), "req-grp") {
+			t.Errorf("request_id not found in log: %s", buf.String())
+		}
+		if !strings.Contains(buf.String(), "component=test") {
+			t.Errorf("attrs not found in log: %s", buf.String())
+		}
+	})
+}
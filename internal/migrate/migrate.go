@@ -0,0 +1,539 @@
+// Пакет migrate переносит ссылки между реализациями storage.Storage:
+// копирует записи, их ревизии, закрытые коды удаленных ссылок и журнал изменений
+// пакетами с контрольной точкой для возобновления
+// и сверяет результат по количеству записей и контрольным суммам выборки
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+)
+
+// DefaultBatchSize - размер пакета копирования по умолчанию
+const DefaultBatchSize = 500
+
+// ErrConflict возвращается, если в целевом хранилище под тем же кодом
+// уже есть другая запись, а перезапись не разрешена
+var ErrConflict = errors.New("target already has a different record with the same code")
+
+// Config - параметры копирования
+type Config struct {
+	BatchSize  int          // Записей в пакете; 0 - DefaultBatchSize
+	Checkpoint string       // Файл контрольной точки; пусто - без возобновления
+	Overwrite  bool         // Заменять отличающиеся записи в целевом хранилище
+	Logger     *slog.Logger // nil - без журнала
+}
+
+// Result - итог копирования. При возобновлении учитываются и записи прошлых запусков
+type Result struct {
+	Copied    int // Новые записи
+	Replaced  int // Отличавшиеся записи, замененные при Overwrite
+	Unchanged int // Записи, уже совпадавшие с исходными
+	Revisions int // Прежние адреса ссылок
+	// Tombstones - закрытые коды окончательно удаленных ссылок
+	Tombstones int
+	Audit      int // События журнала изменений
+}
+
+// checkpoint - состояние копирования, сохраняемое после каждого пакета
+type checkpoint struct {
+	After     *storage.Position     `json:"after"`
+	Revision  *storage.LinkRevision `json:"revision,omitempty"`  // Последняя перенесенная ревизия
+	Tombstone *storage.Tombstone    `json:"tombstone,omitempty"` // Последний перенесенный закрытый код
+	Audit     int64                 `json:"audit,omitempty"`     // ID последнего перенесенного события журнала
+	Result    Result                `json:"result"`
+}
+
+// Copy копирует все записи from в to. Прогресс сохраняется в файл контрольной
+// точки после каждого пакета; повторный запуск продолжает с сохраненной позиции.
+// Записи, уже совпадающие с исходными, пропускаются, поэтому повтор безопасен.
+// После успешного копирования файл контрольной точки удаляется
+func Copy(ctx context.Context, from, to storage.Storage, cfg Config) (*Result, error) {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	state, err := loadCheckpoint(cfg.Checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	if state.After != nil {
+		logger.Info("resuming from checkpoint",
+			slog.String("domain", state.After.Domain),
+			slog.String("short_code", state.After.ShortCode),
+			slog.Int("copied", state.Result.Copied),
+		)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return &state.Result, err
+		}
+
+		batch, err := from.Iterate(ctx, state.After, cfg.BatchSize)
+		if err != nil {
+			return &state.Result, fmt.Errorf("reading source: %w", err)
+		}
+		for _, u := range batch {
+			if err := copyURL(ctx, to, u, cfg.Overwrite, &state.Result); err != nil {
+				return &state.Result, fmt.Errorf("copying %s/%s: %w", u.Domain, u.ShortCode, err)
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		last := storage.PositionOf(batch[len(batch)-1])
+		state.After = &last
+		if err := saveCheckpoint(cfg.Checkpoint, state); err != nil {
+			return &state.Result, err
+		}
+		logger.Info("batch copied",
+			slog.Int("records", len(batch)),
+			slog.Int("copied", state.Result.Copied),
+			slog.Int("replaced", state.Result.Replaced),
+			slog.Int("unchanged", state.Result.Unchanged),
+		)
+		if len(batch) < cfg.BatchSize {
+			break
+		}
+	}
+
+	if err := copyRevisions(ctx, from, to, cfg, &state, logger); err != nil {
+		return &state.Result, err
+	}
+
+	// Коды окончательно удаленных ссылок переносятся после записей,
+	// чтобы закрытый код не помешал сохранить хранящуюся удаленную ссылку
+	if err := copyTombstones(ctx, from, to, cfg, &state, logger); err != nil {
+		return &state.Result, err
+	}
+	if err := copyAudit(ctx, from, to, cfg, &state, logger); err != nil {
+		return &state.Result, err
+	}
+
+	if cfg.Checkpoint != "" {
+		if err := os.Remove(cfg.Checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return &state.Result, fmt.Errorf("removing checkpoint: %w", err)
+		}
+	}
+	return &state.Result, nil
+}
+
+// copyURL сохраняет запись в целевом хранилище. Занятый код не ошибка,
+// если под ним та же запись: так повторяется пакет, прерванный до контрольной точки
+func copyURL(ctx context.Context, to storage.Storage, u *storage.URL, overwrite bool, result *Result) error {
+	existing, err := lookup(ctx, to, u.Domain, u.ShortCode)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		if err := to.Save(ctx, *u); err != nil {
+			return err
+		}
+		result.Copied++
+		return nil
+	}
+	if Checksum(existing) == Checksum(u) {
+		result.Unchanged++
+		return nil
+	}
+	if !overwrite {
+		return ErrConflict
+	}
+	if err := to.Replace(ctx, *u); err != nil {
+		return err
+	}
+	result.Replaced++
+	return nil
+}
+
+// copyRevisions переносит прежние адреса ссылок. Ревизия с тем же номером
+// в целевом хранилище не меняется, поэтому повтор пакета безопасен
+func copyRevisions(ctx context.Context, from, to storage.Storage, cfg Config, state *checkpoint, logger *slog.Logger) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batch, err := from.IterateRevisions(ctx, state.Revision, cfg.BatchSize)
+		if err != nil {
+			return fmt.Errorf("reading source revisions: %w", err)
+		}
+		for _, r := range batch {
+			if err := to.SaveRevision(ctx, r); err != nil {
+				return fmt.Errorf("copying revision %d of %s/%s: %w", r.Number, r.Domain, r.ShortCode, err)
+			}
+			state.Result.Revisions++
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		state.Revision = &batch[len(batch)-1]
+		if err := saveCheckpoint(cfg.Checkpoint, *state); err != nil {
+			return err
+		}
+		logger.Info("revisions copied",
+			slog.Int("records", len(batch)),
+			slog.Int("revisions", state.Result.Revisions),
+		)
+		if len(batch) < cfg.BatchSize {
+			return nil
+		}
+	}
+}
+
+// copyTombstones закрывает в to коды окончательно удаленных ссылок from
+func copyTombstones(ctx context.Context, from, to storage.Storage, cfg Config, state *checkpoint, logger *slog.Logger) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batch, err := from.Tombstones(ctx, storage.TombstoneQuery{All: true, After: state.Tombstone, Limit: cfg.BatchSize})
+		if err != nil {
+			return fmt.Errorf("reading source tombstones: %w", err)
+		}
+		for _, t := range batch {
+			err := to.SaveTombstone(ctx, t)
+			if errors.Is(err, storage.ErrAlreadyExists) {
+				err = ErrConflict
+			}
+			if err != nil {
+				return fmt.Errorf("copying tombstone %s/%s: %w", t.Domain, t.ShortCode, err)
+			}
+			state.Result.Tombstones++
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		state.Tombstone = &batch[len(batch)-1]
+		if err := saveCheckpoint(cfg.Checkpoint, *state); err != nil {
+			return err
+		}
+		logger.Info("tombstones copied",
+			slog.Int("records", len(batch)),
+			slog.Int("tombstones", state.Result.Tombstones),
+		)
+		if len(batch) < cfg.BatchSize {
+			return nil
+		}
+	}
+}
+
+// copyAudit переносит журнал изменений с исходными ID, не отправляя вебхуков.
+// Уже перенесенное событие пропускается, другое событие с тем же ID - ErrConflict
+func copyAudit(ctx context.Context, from, to storage.Storage, cfg Config, state *checkpoint, logger *slog.Logger) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batch, err := from.IterateAudit(ctx, state.Audit, cfg.BatchSize)
+		if err != nil {
+			return fmt.Errorf("reading source audit log: %w", err)
+		}
+		for _, e := range batch {
+			err := to.SaveAudit(ctx, e)
+			if errors.Is(err, storage.ErrAlreadyExists) {
+				err = ErrConflict
+			}
+			if err != nil {
+				return fmt.Errorf("copying audit event %d: %w", e.ID, err)
+			}
+			state.Result.Audit++
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		state.Audit = batch[len(batch)-1].ID
+		if err := saveCheckpoint(cfg.Checkpoint, *state); err != nil {
+			return err
+		}
+		logger.Info("audit events copied",
+			slog.Int("records", len(batch)),
+			slog.Int("audit", state.Result.Audit),
+		)
+		if len(batch) < cfg.BatchSize {
+			return nil
+		}
+	}
+}
+
+// lookup возвращает запись по коду, в том числе истекшую, еще не активную
+// и удаленную; nil - нет записи. Код окончательно удаленной ссылки закрыт
+// и для копирования, поэтому считается конфликтом
+func lookup(ctx context.Context, s storage.Storage, domain, code string) (*storage.URL, error) {
+	u, err := s.GetByCode(ctx, domain, code)
+	if u != nil {
+		return u, nil
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if errors.Is(err, storage.ErrDeleted) {
+		return nil, ErrConflict
+	}
+	return nil, err
+}
+
+// Verification - итог сверки хранилищ
+type Verification struct {
+	SourceCount int
+	TargetCount int
+	Sampled     int      // Записей, сверенных по контрольной сумме
+	Mismatches  []string // "домен/код" отсутствующих или отличающихся записей
+
+	// Число прежних адресов ссылок
+	SourceRevisions int
+	TargetRevisions int
+
+	// Число закрытых кодов окончательно удаленных ссылок
+	SourceTombstones int
+	TargetTombstones int
+
+	// Число событий журнала изменений
+	SourceAudit int
+	TargetAudit int
+}
+
+// OK сообщает, что количество записей, ревизий, закрытых кодов и событий журнала
+// совпало и расхождений в выборке нет
+func (v *Verification) OK() bool {
+	return v.SourceCount == v.TargetCount && v.SourceRevisions == v.TargetRevisions &&
+		v.SourceTombstones == v.TargetTombstones && v.SourceAudit == v.TargetAudit && len(v.Mismatches) == 0
+}
+
+// maxMismatches - сколько расхождений попадает в итог сверки
+const maxMismatches = 100
+
+// Verify пересчитывает записи обоих хранилищ и сверяет контрольные суммы
+// случайной выборки исходных записей с долей sampleRate (от 0 до 1)
+func Verify(ctx context.Context, from, to storage.Storage, sampleRate float64) (*Verification, error) {
+	v := &Verification{}
+
+	err := walk(ctx, from, func(u *storage.URL) error {
+		v.SourceCount++
+		if rand.Float64() >= sampleRate {
+			return nil
+		}
+		v.Sampled++
+		existing, err := lookup(ctx, to, u.Domain, u.ShortCode)
+		if err != nil {
+			return fmt.Errorf("reading target: %w", err)
+		}
+		if (existing == nil || Checksum(existing) != Checksum(u)) && len(v.Mismatches) < maxMismatches {
+			v.Mismatches = append(v.Mismatches, u.Domain+"/"+u.ShortCode)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = walk(ctx, to, func(*storage.URL) error {
+		v.TargetCount++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if v.SourceRevisions, err = countRevisions(ctx, from); err != nil {
+		return nil, err
+	}
+	if v.TargetRevisions, err = countRevisions(ctx, to); err != nil {
+		return nil, err
+	}
+	if v.SourceTombstones, err = countTombstones(ctx, from); err != nil {
+		return nil, err
+	}
+	if v.TargetTombstones, err = countTombstones(ctx, to); err != nil {
+		return nil, err
+	}
+	if v.SourceAudit, err = countAudit(ctx, from); err != nil {
+		return nil, err
+	}
+	if v.TargetAudit, err = countAudit(ctx, to); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// countRevisions пересчитывает ревизии хранилища пакетами
+func countRevisions(ctx context.Context, s storage.Storage) (int, error) {
+	n := 0
+	var after *storage.LinkRevision
+	for {
+		batch, err := s.IterateRevisions(ctx, after, DefaultBatchSize)
+		if err != nil {
+			return n, fmt.Errorf("iterating revisions: %w", err)
+		}
+		n += len(batch)
+		if len(batch) < DefaultBatchSize {
+			return n, nil
+		}
+		after = &batch[len(batch)-1]
+	}
+}
+
+// countTombstones пересчитывает закрытые коды хранилища пакетами
+func countTombstones(ctx context.Context, s storage.Storage) (int, error) {
+	n := 0
+	q := storage.TombstoneQuery{All: true, Limit: DefaultBatchSize}
+	for {
+		batch, err := s.Tombstones(ctx, q)
+		if err != nil {
+			return n, fmt.Errorf("listing tombstones: %w", err)
+		}
+		n += len(batch)
+		if len(batch) < DefaultBatchSize {
+			return n, nil
+		}
+		q.After = &batch[len(batch)-1]
+	}
+}
+
+// countAudit пересчитывает события журнала хранилища пакетами
+func countAudit(ctx context.Context, s storage.Storage) (int, error) {
+	n := 0
+	var after int64
+	for {
+		batch, err := s.IterateAudit(ctx, after, DefaultBatchSize)
+		if err != nil {
+			return n, fmt.Errorf("iterating audit log: %w", err)
+		}
+		n += len(batch)
+		if len(batch) < DefaultBatchSize {
+			return n, nil
+		}
+		after = batch[len(batch)-1].ID
+	}
+}
+
+// walk обходит все записи хранилища пакетами
+func walk(ctx context.Context, s storage.Storage, fn func(*storage.URL) error) error {
+	var after *storage.Position
+	for {
+		batch, err := s.Iterate(ctx, after, DefaultBatchSize)
+		if err != nil {
+			return fmt.Errorf("iterating storage: %w", err)
+		}
+		for _, u := range batch {
+			if err := fn(u); err != nil {
+				return err
+			}
+		}
+		if len(batch) < DefaultBatchSize {
+			return nil
+		}
+		last := storage.PositionOf(batch[len(batch)-1])
+		after = &last
+	}
+}
+
+// Checksum возвращает контрольную сумму содержимого записи. Время приводится
+// к UTC с точностью до микросекунд, как его хранит PostgreSQL, а пустые
+// списки не отличаются от отсутствующих
+func Checksum(u *storage.URL) string {
+	c := *u
+	c.CreatedAt = normalizeTime(c.CreatedAt)
+	if c.ExpiresAt != nil {
+		t := normalizeTime(*c.ExpiresAt)
+		c.ExpiresAt = &t
+	}
+	if c.NotBefore != nil {
+		t := normalizeTime(*c.NotBefore)
+		c.NotBefore = &t
+	}
+	if c.SwitchAt != nil {
+		t := normalizeTime(*c.SwitchAt)
+		c.SwitchAt = &t
+	}
+	if c.DeletedAt != nil {
+		t := normalizeTime(*c.DeletedAt)
+		c.DeletedAt = &t
+	}
+	if len(c.Targets) == 0 {
+		c.Targets = nil
+	}
+	if len(c.Rules) == 0 {
+		c.Rules = nil
+	}
+	if len(c.Tags) == 0 {
+		c.Tags = nil
+	}
+
+	data, _ := json.Marshal(c)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+func loadCheckpoint(path string) (checkpoint, error) {
+	var state checkpoint
+	if path == "" {
+		return state, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("reading checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("parsing checkpoint %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// saveCheckpoint записывает состояние через временный файл,
+// чтобы прерванная запись не испортила прежнюю контрольную точку
+func saveCheckpoint(path string, state checkpoint) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encoding checkpoint: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+)
+
+// failingStorage отказывает в сохранении после limit записей, имитируя обрыв
+type failingStorage struct {
+	storage.Storage
+	limit int
+}
+
+var errInterrupted = errors.New("interrupted")
+
+func (s *failingStorage) Save(ctx context.Context, url storage.URL) error {
+	if s.limit == 0 {
+		return errInterrupted
+	}
+	s.limit--
+	return s.Storage.Save(ctx, url)
+}
+
+func seed(t *testing.T, n int) *storage.MemoryStorage {
+	t.Helper()
+	s := storage.NewMemoryStorage()
+	base := time.Now()
+	for i := 0; i < n; i++ {
+		domain := ""
+		if i%3 == 0 {
+			domain = "go.example"
+		}
+		err := s.Save(context.Background(), storage.URL{
+			Domain:      domain,
+			ShortCode:   fmt.Sprintf("migrate%03d", i),
+			OriginalURL: fmt.Sprintf("https://example.com/%d", i),
+			CreatedAt:   base.Add(time.Duration(i) * time.Second),
+		})
+		if err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+	return s
+}
+
+func TestCopy_Resume(t *testing.T) {
+	ctx := context.Background()
+	from := seed(t, 25)
+	to := storage.NewMemoryStorage()
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint.json")
+	cfg := Config{BatchSize: 4, Checkpoint: checkpointPath}
+
+	// Обрыв посреди третьего пакета: первые два пакета зафиксированы
+	_, err := Copy(ctx, from, &failingStorage{Storage: to, limit: 10}, cfg)
+	if !errors.Is(err, errInterrupted) {
+		t.Fatalf("Copy() error = %v, want %v", err, errInterrupted)
+	}
+	if _, err := os.Stat(checkpointPath); err != nil {
+		t.Fatalf("checkpoint not written: %v", err)
+	}
+
+	result, err := Copy(ctx, from, to, cfg)
+	if err != nil {
+		t.Fatalf("Copy() resume error = %v", err)
+	}
+	// Записи прерванного пакета уже были сохранены и совпадают с исходными
+	if result.Copied+result.Unchanged != 25 || result.Unchanged != 2 {
+		t.Errorf("Copy() = %+v, want 25 records with 2 unchanged", result)
+	}
+	if _, err := os.Stat(checkpointPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("checkpoint not removed after success: %v", err)
+	}
+
+	v, err := Verify(ctx, from, to, 1)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if !v.OK() || v.SourceCount != 25 || v.Sampled != 25 {
+		t.Errorf("Verify() = %+v", v)
+	}
+}
+
+func TestCopy_Conflict(t *testing.T) {
+	ctx := context.Background()
+	from := seed(t, 3)
+	to := storage.NewMemoryStorage()
+	to.Save(ctx, storage.URL{ShortCode: "migrate001", OriginalURL: "https://example.com/other", CreatedAt: time.Now(), Custom: true})
+
+	if _, err := Copy(ctx, from, to, Config{}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Copy() error = %v, want %v", err, ErrConflict)
+	}
+
+	v, err := Verify(ctx, from, to, 1)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if v.OK() || len(v.Mismatches) == 0 {
+		t.Errorf("Verify() = %+v, want mismatches", v)
+	}
+
+	result, err := Copy(ctx, from, to, Config{Overwrite: true})
+	if err != nil {
+		t.Fatalf("Copy() with overwrite error = %v", err)
+	}
+	if result.Replaced != 1 {
+		t.Errorf("Copy() = %+v, want 1 replaced", result)
+	}
+	if v, _ := Verify(ctx, from, to, 1); !v.OK() {
+		t.Errorf("Verify() after overwrite = %+v", v)
+	}
+}
+
+func TestCopy_Tombstones(t *testing.T) {
+	ctx := context.Background()
+	from := seed(t, 3)
+	if err := from.Delete(ctx, "", "migrate001"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := from.Delete(ctx, "", "migrate002"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	// Окончательно удаляется только migrate001: migrate002 остается удаленной записью
+	from.Purge(ctx, time.Now().Add(time.Hour), 1)
+	purged := "migrate001"
+	if u, _ := from.GetByCode(ctx, "", purged); u != nil {
+		purged = "migrate002"
+	}
+
+	to := storage.NewMemoryStorage()
+	result, err := Copy(ctx, from, to, Config{BatchSize: 1, Checkpoint: filepath.Join(t.TempDir(), "checkpoint.json")})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if result.Copied != 2 || result.Tombstones != 1 {
+		t.Errorf("Copy() = %+v, want 2 copied and 1 tombstone", result)
+	}
+
+	// Код окончательно удаленной ссылки остается закрытым после переноса
+	if _, err := to.GetByCode(ctx, "", purged); !errors.Is(err, storage.ErrDeleted) {
+		t.Errorf("GetByCode(purged) error = %v, want %v", err, storage.ErrDeleted)
+	}
+	err = to.Save(ctx, storage.URL{ShortCode: purged, OriginalURL: "https://example.com/reused", CreatedAt: time.Now()})
+	if !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Errorf("Save(purged code) error = %v, want %v", err, storage.ErrAlreadyExists)
+	}
+
+	v, err := Verify(ctx, from, to, 1)
+	if err != nil || !v.OK() || v.TargetTombstones != 1 {
+		t.Errorf("Verify() = %+v, %v", v, err)
+	}
+
+	// Повторный перенос безопасен
+	if _, err := Copy(ctx, from, to, Config{}); err != nil {
+		t.Errorf("Copy() again error = %v", err)
+	}
+}
+
+func TestCopy_Revisions(t *testing.T) {
+	ctx := context.Background()
+	from := seed(t, 3)
+	for i := 1; i <= 2; i++ {
+		dest := storage.Destination{OriginalURL: fmt.Sprintf("https://example.com/1/v%d", i)}
+		if err := from.SetDestination(ctx, "", "migrate001", dest); err != nil {
+			t.Fatalf("SetDestination() error = %v", err)
+		}
+	}
+	if err := from.SetDestination(ctx, "go.example", "migrate000", storage.Destination{OriginalURL: "https://example.com/0/v1"}); err != nil {
+		t.Fatalf("SetDestination() error = %v", err)
+	}
+	// Ревизии удаленной ссылки переносятся вместе с ней
+	if err := from.Delete(ctx, "go.example", "migrate000"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	to := storage.NewMemoryStorage()
+	result, err := Copy(ctx, from, to, Config{BatchSize: 1, Checkpoint: filepath.Join(t.TempDir(), "checkpoint.json")})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if result.Revisions != 3 {
+		t.Errorf("Copy() revisions = %d, want 3", result.Revisions)
+	}
+
+	want, _ := from.ListRevisions(ctx, "", "migrate001")
+	got, err := to.ListRevisions(ctx, "", "migrate001")
+	if err != nil || len(got) != 2 {
+		t.Fatalf("ListRevisions() = %+v, %v, want 2 revisions", got, err)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("revision %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	v, err := Verify(ctx, from, to, 1)
+	if err != nil || !v.OK() || v.TargetRevisions != 3 {
+		t.Errorf("Verify() = %+v, %v", v, err)
+	}
+
+	// Повторный перенос не дублирует ревизии
+	if _, err := Copy(ctx, from, to, Config{}); err != nil {
+		t.Fatalf("Copy() again error = %v", err)
+	}
+	if v, err := Verify(ctx, from, to, 0); err != nil || v.TargetRevisions != 3 {
+		t.Errorf("Verify() after repeat = %+v, %v", v, err)
+	}
+
+	// Пропавшая ревизия видна при сверке
+	if v, err := Verify(ctx, from, storage.NewMemoryStorage(), 0); err != nil || v.OK() || v.SourceRevisions != 3 {
+		t.Errorf("Verify(empty target) = %+v, %v", v, err)
+	}
+}
+
+func TestCopy_Audit(t *testing.T) {
+	ctx := context.Background()
+	from := storage.NewMemoryStorage()
+	for i := 0; i < 3; i++ {
+		audited := storage.WithAudit(ctx, storage.AuditEvent{Action: "create", Actor: "ci", At: time.Now()})
+		u := storage.URL{ShortCode: fmt.Sprintf("audit%05d", i), OriginalURL: fmt.Sprintf("https://example.com/audit/%d", i), CreatedAt: time.Now()}
+		if err := from.Save(audited, u); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	to := storage.NewMemoryStorage()
+	to.CreateWebhook(ctx, storage.Webhook{URL: "https://hooks.example.com", CreatedAt: time.Now()})
+	result, err := Copy(ctx, from, to, Config{BatchSize: 2, Checkpoint: filepath.Join(t.TempDir(), "checkpoint.json")})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if result.Copied != 3 || result.Audit != 3 {
+		t.Errorf("Copy() = %+v, want 3 copied and 3 audit events", result)
+	}
+	events, _ := to.ListAudit(ctx, storage.AuditQuery{})
+	if len(events) != 3 || events[0].ID != 3 || events[0].ShortCode != "audit00002" || events[0].Actor != "ci" {
+		t.Errorf("ListAudit() = %+v", events)
+	}
+	// Перенос журнала не ставит события в очередь вебхуков
+	if deliveries, _ := to.ListDeliveries(ctx, storage.DeliveryQuery{}); len(deliveries) != 0 {
+		t.Errorf("ListDeliveries() = %+v, want none", deliveries)
+	}
+
+	v, err := Verify(ctx, from, to, 1)
+	if err != nil || !v.OK() || v.TargetAudit != 3 {
+		t.Errorf("Verify() = %+v, %v", v, err)
+	}
+	if _, err := Copy(ctx, from, to, Config{}); err != nil {
+		t.Errorf("Copy() again error = %v", err)
+	}
+
+	// Чужой журнал в целевом хранилище не перезаписывается
+	other := storage.NewMemoryStorage()
+	audited := storage.WithAudit(ctx, storage.AuditEvent{Action: "create", Actor: "other", At: time.Now()})
+	other.Save(audited, storage.URL{ShortCode: "other00001", OriginalURL: "https://example.com/other", CreatedAt: time.Now()})
+	if _, err := Copy(ctx, from, other, Config{}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Copy() into foreign audit log error = %v, want %v", err, ErrConflict)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	created := time.Date(2026, 1, 1, 12, 0, 0, 123456789, time.FixedZone("MSK", 3*3600))
+	a := &storage.URL{ShortCode: "aB3xY9kL2m", OriginalURL: "https://example.com", CreatedAt: created}
+	b := &storage.URL{ShortCode: "aB3xY9kL2m", OriginalURL: "https://example.com", CreatedAt: created.UTC().Truncate(time.Microsecond), Targets: []storage.Target{}}
+	if Checksum(a) != Checksum(b) {
+		t.Error("Checksum() differs for records equal up to storage precision")
+	}
+	b.Title = "changed"
+	if Checksum(a) == Checksum(b) {
+		t.Error("Checksum() equal for different records")
+	}
+}
@@ -0,0 +1,535 @@
+// Пакет qrcode реализует кодировщик QR-кодов (ISO/IEC 18004) в байтовом режиме.
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Level - уровень коррекции ошибок
+type Level int
+
+// Уровни коррекции ошибок: восстанавливается примерно 7%, 15%, 25% и 30% данных
+const (
+	Low Level = iota
+	Medium
+	Quartile
+	High
+)
+
+// ErrTooLong возвращается, если данные не помещаются в QR-код версии 40
+var ErrTooLong = errors.New("data too long for QR code")
+
+// ParseLevel разбирает уровень коррекции по букве L, M, Q или H
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "L":
+		return Low, nil
+	case "M":
+		return Medium, nil
+	case "Q":
+		return Quartile, nil
+	case "H":
+		return High, nil
+	default:
+		return 0, fmt.Errorf("invalid error correction level: %q (must be L, M, Q or H)", s)
+	}
+}
+
+// formatBits возвращает двухбитное значение уровня для блока формата
+func (l Level) formatBits() int {
+	switch l {
+	case Low:
+		return 1
+	case Medium:
+		return 0
+	case Quartile:
+		return 3
+	default:
+		return 2
+	}
+}
+
+const (
+	minVersion = 1
+	maxVersion = 40
+)
+
+// Кол-во кодовых слов коррекции на блок по уровню и версии (индекс 0 не используется)
+var eccCodewordsPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// Кол-во блоков коррекции по уровню и версии (индекс 0 не используется)
+var numErrorCorrectionBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// Code - готовый QR-код: квадратная матрица модулей
+type Code struct {
+	Version int
+	Level   Level
+	Size    int // Сторона матрицы в модулях, без отступа
+
+	modules    [][]bool // true - темный модуль
+	isFunction [][]bool // служебные модули, не участвующие в маскировании
+}
+
+// Dark сообщает, является ли модуль (x, y) темным. Координаты вне матрицы светлые
+func (c *Code) Dark(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+		return false
+	}
+	return c.modules[y][x]
+}
+
+// Encode кодирует данные в байтовом режиме в QR-код минимальной версии
+// для заданного уровня коррекции. Маска выбирается по штрафным правилам стандарта
+func Encode(data []byte, level Level) (*Code, error) {
+	if level < Low || level > High {
+		return nil, fmt.Errorf("invalid error correction level: %d", level)
+	}
+
+	version := 0
+	for v := minVersion; v <= maxVersion; v++ {
+		if segmentBits(v, len(data)) <= numDataCodewords(v, level)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	codewords := addECCAndInterleave(encodeData(data, version, level), version, level)
+
+	c := newCode(version, level)
+	c.drawFunctionPatterns()
+	c.drawCodewords(codewords)
+
+	// Выбор маски с минимальным штрафом
+	bestMask, minPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+		if p := c.penalty(); minPenalty < 0 || p < minPenalty {
+			bestMask, minPenalty = mask, p
+		}
+		c.applyMask(mask) // XOR снимает маску
+	}
+	c.applyMask(bestMask)
+	c.drawFormatBits(bestMask)
+
+	return c, nil
+}
+
+func newCode(version int, level Level) *Code {
+	size := version*4 + 17
+	c := &Code{
+		Version:    version,
+		Level:      level,
+		Size:       size,
+		modules:    make([][]bool, size),
+		isFunction: make([][]bool, size),
+	}
+	for i := range c.modules {
+		c.modules[i] = make([]bool, size)
+		c.isFunction[i] = make([]bool, size)
+	}
+	return c
+}
+
+// segmentBits возвращает длину сегмента байтового режима в битах
+func segmentBits(version, n int) int {
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	if n >= 1<<countBits {
+		return 1 << 30 // не помещается в поле длины
+	}
+	return 4 + countBits + n*8
+}
+
+// encodeData формирует кодовые слова данных: режим, длина, данные,
+// терминатор и заполняющие байты
+func encodeData(data []byte, version int, level Level) []byte {
+	var bb bitBuffer
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	bb.append(0x4, 4) // байтовый режим
+	bb.append(len(data), countBits)
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+
+	capacity := numDataCodewords(version, level) * 8
+	bb.append(0, min(4, capacity-len(bb)))
+	bb.append(0, (8-len(bb)%8)%8)
+	for pad := 0xEC; len(bb) < capacity; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	result := make([]byte, len(bb)/8)
+	for i, bit := range bb {
+		if bit {
+			result[i>>3] |= 1 << (7 - uint(i&7))
+		}
+	}
+	return result
+}
+
+// addECCAndInterleave делит данные на блоки, добавляет к каждому кодовые слова
+// Рида-Соломона и перемежает блоки
+func addECCAndInterleave(data []byte, version int, level Level) []byte {
+	numBlocks := numErrorCorrectionBlocks[level][version]
+	blockECCLen := eccCodewordsPerBlock[level][version]
+	rawCodewords := numRawDataModules(version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	generator := rsGenerator(blockECCLen)
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i < numBlocks; i++ {
+		datLen := shortBlockLen - blockECCLen
+		if i >= numShortBlocks {
+			datLen++
+		}
+		dat := append([]byte(nil), data[k:k+datLen]...)
+		k += datLen
+		ecc := rsRemainder(dat, generator)
+		if i < numShortBlocks {
+			dat = append(dat, 0) // выравнивание до длины длинного блока
+		}
+		blocks[i] = append(dat, ecc...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			// Пропуск выравнивающего байта коротких блоков
+			if i != shortBlockLen-blockECCLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// numRawDataModules возвращает кол-во модулей, доступных под данные и коррекцию
+func numRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+// numDataCodewords возвращает кол-во кодовых слов данных для версии и уровня
+func numDataCodewords(version int, level Level) int {
+	return numRawDataModules(version)/8 -
+		eccCodewordsPerBlock[level][version]*numErrorCorrectionBlocks[level][version]
+}
+
+// alignmentPositions возвращает координаты центров выравнивающих узоров
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	result := make([]int, numAlign)
+	result[0] = 6
+	for i, pos := numAlign-1, version*4+17-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.isFunction[y][x] = true
+}
+
+// drawFunctionPatterns рисует служебные узоры и резервирует место под формат
+func (c *Code) drawFunctionPatterns() {
+	// Синхронизирующие линии
+	for i := 0; i < c.Size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Поисковые узоры в трех углах
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+
+	// Выравнивающие узоры, кроме пересекающихся с поисковыми
+	pos := alignmentPositions(c.Version)
+	last := len(pos) - 1
+	for i := range pos {
+		for j := range pos {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			c.drawAlignment(pos[i], pos[j])
+		}
+	}
+
+	c.drawFormatBits(0) // резерв, перезаписывается после выбора маски
+	c.drawVersion()
+}
+
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.Size || yy >= c.Size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			c.setFunction(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// drawFormatBits рисует обе копии блока формата (уровень коррекции и маска)
+func (c *Code) drawFormatBits(mask int) {
+	data := c.Level.formatBits()<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+
+	// Первая копия вокруг левого верхнего поискового узора
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bitAt(bits, i))
+	}
+	c.setFunction(8, 7, bitAt(bits, 6))
+	c.setFunction(8, 8, bitAt(bits, 7))
+	c.setFunction(7, 8, bitAt(bits, 8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bitAt(bits, i))
+	}
+
+	// Вторая копия у двух других поисковых узоров
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bitAt(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bitAt(bits, i))
+	}
+	c.setFunction(8, c.Size-8, true) // всегда темный модуль
+}
+
+// drawVersion рисует блоки версии (только для версий 7 и выше)
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+	rem := c.Version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := c.Version<<12 | rem
+
+	for i := 0; i < 18; i++ {
+		dark := bitAt(bits, i)
+		a, b := c.Size-11+i%3, i/3
+		c.setFunction(a, b, dark)
+		c.setFunction(b, a, dark)
+	}
+}
+
+// drawCodewords размещает кодовые слова зигзагом по парам столбцов справа налево
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // пропуск вертикальной синхронизирующей линии
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert // движение вверх
+				}
+				if !c.isFunction[y][x] && i < len(data)*8 {
+					c.modules[y][x] = (data[i>>3]>>(7-uint(i&7)))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask инвертирует модули данных по шаблону маски. Повторный вызов снимает маску
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.isFunction[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty вычисляет штраф по четырем правилам стандарта
+func (c *Code) penalty() int {
+	result := 0
+
+	// Правило 1: серии из 5 и более модулей одного цвета
+	for y := 0; y < c.Size; y++ {
+		result += runPenalty(func(i int) bool { return c.modules[y][i] }, c.Size)
+	}
+	for x := 0; x < c.Size; x++ {
+		result += runPenalty(func(i int) bool { return c.modules[i][x] }, c.Size)
+	}
+
+	// Правило 2: блоки 2x2 одного цвета
+	for y := 0; y < c.Size-1; y++ {
+		for x := 0; x < c.Size-1; x++ {
+			v := c.modules[y][x]
+			if v == c.modules[y][x+1] && v == c.modules[y+1][x] && v == c.modules[y+1][x+1] {
+				result += 3
+			}
+		}
+	}
+
+	// Правило 3: узоры, похожие на поисковые (1:1:3:1:1 с 4 светлыми модулями)
+	for y := 0; y < c.Size; y++ {
+		result += finderLikePenalty(func(i int) bool { return c.Dark(i, y) }, c.Size)
+	}
+	for x := 0; x < c.Size; x++ {
+		result += finderLikePenalty(func(i int) bool { return c.Dark(x, i) }, c.Size)
+	}
+
+	// Правило 4: отклонение доли темных модулей от 50%
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+		}
+	}
+	total := c.Size * c.Size
+	result += abs(dark*20-total*10) / total * 10 // 10 очков за каждые полные 5%
+
+	return result
+}
+
+func runPenalty(at func(int) bool, n int) int {
+	result := 0
+	runLen := 1
+	for i := 1; i <= n; i++ {
+		if i < n && at(i) == at(i-1) {
+			runLen++
+			continue
+		}
+		if runLen >= 5 {
+			result += 3 + runLen - 5
+		}
+		runLen = 1
+	}
+	return result
+}
+
+var finderLike = [7]bool{true, false, true, true, true, false, true}
+
+func finderLikePenalty(at func(int) bool, n int) int {
+	result := 0
+	for i := 0; i+7 <= n; i++ {
+		match := true
+		for j, want := range finderLike {
+			if at(i+j) != want {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		// Четыре светлых модуля (или граница) перед или после узора
+		if lightRun(at, i-4, i, n) || lightRun(at, i+7, i+11, n) {
+			result += 40
+		}
+	}
+	return result
+}
+
+// lightRun сообщает, что все модули в [from, to) светлые; модули за границей считаются светлыми
+func lightRun(at func(int) bool, from, to, n int) bool {
+	for i := from; i < to; i++ {
+		if i >= 0 && i < n && at(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// bitBuffer - последовательность битов
+type bitBuffer []bool
+
+func (bb *bitBuffer) append(val, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*bb = append(*bb, (val>>uint(i))&1 == 1)
+	}
+}
+
+func bitAt(x, i int) bool {
+	return (x>>uint(i))&1 != 0
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
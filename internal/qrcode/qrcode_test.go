@@ -0,0 +1,179 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// Пример из стандарта: "HELLO WORLD", версия 1-M
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	got := rsRemainder(data, rsGenerator(len(want)))
+	if !bytes.Equal(got, want) {
+		t.Errorf("rsRemainder() = %v, want %v", got, want)
+	}
+}
+
+func TestEncode_VersionSelection(t *testing.T) {
+	tests := []struct {
+		name    string
+		length  int
+		level   Level
+		version int
+	}{
+		{name: "1-L max", length: 17, level: Low, version: 1},
+		{name: "1-L overflow", length: 18, level: Low, version: 2},
+		{name: "1-H max", length: 7, level: High, version: 1},
+		{name: "short url M", length: 32, level: Medium, version: 3},
+		{name: "10-L uses 16-bit length", length: 231, level: Low, version: 10},
+		{name: "40-H max", length: 1273, level: High, version: 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Encode(bytes.Repeat([]byte("a"), tt.length), tt.level)
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if code.Version != tt.version {
+				t.Errorf("Version = %d, want %d", code.Version, tt.version)
+			}
+			if code.Size != tt.version*4+17 {
+				t.Errorf("Size = %d, want %d", code.Size, tt.version*4+17)
+			}
+		})
+	}
+}
+
+func TestEncode_TooLong(t *testing.T) {
+	_, err := Encode(bytes.Repeat([]byte("a"), 1274), High)
+	if err != ErrTooLong {
+		t.Errorf("Encode() error = %v, want %v", err, ErrTooLong)
+	}
+}
+
+func TestEncode_FunctionPatterns(t *testing.T) {
+	code, err := Encode([]byte("https://sho.rt/aB3_xY9z12"), Medium)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	// Поисковые узоры: темная рамка 7x7, светлое кольцо, темный центр 3x3
+	for _, corner := range [][2]int{{0, 0}, {code.Size - 7, 0}, {0, code.Size - 7}} {
+		x0, y0 := corner[0], corner[1]
+		for i := 0; i < 7; i++ {
+			if !code.Dark(x0+i, y0) || !code.Dark(x0, y0+i) || !code.Dark(x0+6, y0+i) || !code.Dark(x0+i, y0+6) {
+				t.Fatalf("finder border at (%d,%d) is not dark", x0, y0)
+			}
+		}
+		if code.Dark(x0+1, y0+1) || !code.Dark(x0+3, y0+3) {
+			t.Errorf("finder interior at (%d,%d) is wrong", x0, y0)
+		}
+	}
+
+	// Синхронизирующие линии чередуются
+	for i := 8; i < code.Size-8; i++ {
+		if code.Dark(i, 6) != (i%2 == 0) || code.Dark(6, i) != (i%2 == 0) {
+			t.Fatalf("timing pattern broken at %d", i)
+		}
+	}
+
+	// Всегда темный модуль
+	if !code.Dark(8, code.Size-8) {
+		t.Error("dark module is not set")
+	}
+}
+
+func TestEncode_FormatBitsCopiesMatch(t *testing.T) {
+	code, err := Encode([]byte("format"), Quartile)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	var first, second []bool
+	for i := 0; i <= 5; i++ {
+		first = append(first, code.Dark(8, i))
+	}
+	first = append(first, code.Dark(8, 7), code.Dark(8, 8), code.Dark(7, 8))
+	for i := 9; i < 15; i++ {
+		first = append(first, code.Dark(14-i, 8))
+	}
+	for i := 0; i < 8; i++ {
+		second = append(second, code.Dark(code.Size-1-i, 8))
+	}
+	for i := 8; i < 15; i++ {
+		second = append(second, code.Dark(8, code.Size-15+i))
+	}
+
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("format bit %d differs between copies", i)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]Level{"L": Low, "m": Medium, "Q": Quartile, "h": High} {
+		got, err := ParseLevel(s)
+		if err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("X"); err == nil {
+		t.Error("ParseLevel(X) expected error")
+	}
+}
+
+func TestRender(t *testing.T) {
+	code, err := Encode([]byte("https://sho.rt/aB3_xY9z12"), Medium)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	t.Run("png", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := code.WritePNG(&buf, 4, 2); err != nil {
+			t.Fatalf("WritePNG() error = %v", err)
+		}
+		img, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatalf("png.Decode() error = %v", err)
+		}
+		want := (code.Size + 4) * 4
+		if img.Bounds().Dx() != want || img.Bounds().Dy() != want {
+			t.Errorf("image size = %v, want %dx%d", img.Bounds(), want, want)
+		}
+
+		// Отступ светлый, угол поискового узора темный
+		r, _, _, _ := img.At(0, 0).RGBA()
+		if r == 0 {
+			t.Error("margin pixel is dark")
+		}
+		r, _, _, _ = img.At(8, 8).RGBA()
+		if r != 0 {
+			t.Error("finder pixel is light")
+		}
+	})
+
+	t.Run("svg", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := code.WriteSVG(&buf, 4, 2); err != nil {
+			t.Fatalf("WriteSVG() error = %v", err)
+		}
+		svg := buf.String()
+		if !strings.Contains(svg, "<svg") || !strings.Contains(svg, "M2,2h1v1h-1z") {
+			t.Errorf("unexpected svg output: %.200s", svg)
+		}
+	})
+}
+
+func BenchmarkEncode(b *testing.B) {
+	data := []byte("https://sho.rt/aB3_xY9z12")
+	for i := 0; i < b.N; i++ {
+		_, _ = Encode(data, Medium)
+	}
+}
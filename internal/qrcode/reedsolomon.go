@@ -0,0 +1,49 @@
+package qrcode
+
+// Арифметика в поле GF(2^8) с порождающим многочленом x^8 + x^4 + x^3 + x^2 + 1
+
+// gfMultiply перемножает два элемента поля
+func gfMultiply(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		hi := z >> 7
+		z <<= 1
+		z ^= hi * 0x1D
+		z ^= ((y >> uint(i)) & 1) * x
+	}
+	return z
+}
+
+// rsGenerator возвращает коэффициенты порождающего многочлена степени degree
+// (старший коэффициент 1 опущен)
+func rsGenerator(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	// Произведение (x - r^0)(x - r^1)...(x - r^{degree-1}), r = 0x02
+	var root byte = 1
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder возвращает кодовые слова коррекции ошибок для данных
+func rsRemainder(data, generator []byte) []byte {
+	result := make([]byte, len(generator))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range generator {
+			result[i] ^= gfMultiply(coef, factor)
+		}
+	}
+	return result
+}
@@ -0,0 +1,64 @@
+package qrcode
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// Image возвращает черно-белое изображение кода: scale пикселей на модуль
+// и margin модулей светлого отступа с каждой стороны
+func (c *Code) Image(scale, margin int) *image.Paletted {
+	scale = max(scale, 1)
+	margin = max(margin, 0)
+	side := (c.Size + 2*margin) * scale
+
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.modules[y][x] {
+				continue
+			}
+			px, py := (x+margin)*scale, (y+margin)*scale
+			for dy := 0; dy < scale; dy++ {
+				row := img.Pix[(py+dy)*img.Stride+px : (py+dy)*img.Stride+px+scale]
+				for i := range row {
+					row[i] = 1
+				}
+			}
+		}
+	}
+	return img
+}
+
+// WritePNG записывает код в формате PNG
+func (c *Code) WritePNG(w io.Writer, scale, margin int) error {
+	return png.Encode(w, c.Image(scale, margin))
+}
+
+// WriteSVG записывает код в формате SVG. Каждый модуль - квадрат 1x1
+// в координатах viewBox, итоговый размер в пикселях задается scale
+func (c *Code) WriteSVG(w io.Writer, scale, margin int) error {
+	scale = max(scale, 1)
+	margin = max(margin, 0)
+	side := c.Size + 2*margin
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n",
+		side*scale, side*scale, side, side)
+	fmt.Fprintf(bw, `<rect width="100%%" height="100%%" fill="#FFFFFF"/>`+"\n")
+	fmt.Fprint(bw, `<path fill="#000000" d="`)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				fmt.Fprintf(bw, "M%d,%dh1v1h-1z", x+margin, y+margin)
+			}
+		}
+	}
+	fmt.Fprint(bw, `"/>`+"\n</svg>\n")
+	return bw.Flush()
+}
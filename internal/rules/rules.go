@@ -0,0 +1,159 @@
+// Пакет rules выбирает адрес назначения по правилам ссылки:
+// платформе из User-Agent, предпочитаемому языку и параметру запроса
+package rules
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Платформы посетителя
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+	PlatformDesktop = "desktop"
+)
+
+// MaxRules - максимальное кол-во правил у одной ссылки
+const MaxRules = 32
+
+var ErrInvalidRule = errors.New("invalid rule")
+
+// Rule - правило перехода. Заданные условия должны выполняться одновременно,
+// пустое условие не проверяется. Правило без условий недопустимо
+type Rule struct {
+	Platform string `json:"platform,omitempty"` // ios, android или desktop
+	Language string `json:"language,omitempty"` // Язык ("de") или язык с регионом ("pt-BR")
+	Param    string `json:"param,omitempty"`    // Имя параметра запроса
+	Value    string `json:"value,omitempty"`    // Значение параметра; пусто - любое
+	URL      string `json:"url"`                // Адрес назначения
+}
+
+// Validate проверяет условия правила. Адрес назначения проверяет вызывающий код
+func (r Rule) Validate() error {
+	switch r.Platform {
+	case "", PlatformIOS, PlatformAndroid, PlatformDesktop:
+	default:
+		return ErrInvalidRule
+	}
+	if r.Value != "" && r.Param == "" {
+		return ErrInvalidRule
+	}
+	if r.Platform == "" && r.Language == "" && r.Param == "" {
+		return ErrInvalidRule
+	}
+	if r.URL == "" {
+		return ErrInvalidRule
+	}
+	return nil
+}
+
+// Visitor - признаки посетителя, по которым проверяются правила
+type Visitor struct {
+	Platform string
+	Language string // Предпочитаемый язык из Accept-Language в нижнем регистре
+	Query    url.Values
+}
+
+// FromRequest извлекает признаки посетителя из HTTP запроса
+func FromRequest(r *http.Request) Visitor {
+	return Visitor{
+		Platform: Platform(r.UserAgent()),
+		Language: PreferredLanguage(r.Header.Get("Accept-Language")),
+		Query:    r.URL.Query(),
+	}
+}
+
+// Match возвращает первое подходящее правило
+func Match(rules []Rule, v Visitor) (Rule, bool) {
+	for _, r := range rules {
+		if r.matches(v) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+func (r Rule) matches(v Visitor) bool {
+	if r.Platform != "" && r.Platform != v.Platform {
+		return false
+	}
+	if r.Language != "" {
+		lang := strings.ToLower(r.Language)
+		if v.Language != lang && !strings.HasPrefix(v.Language, lang+"-") {
+			return false
+		}
+	}
+	if r.Param != "" {
+		values, ok := v.Query[r.Param]
+		if !ok {
+			return false
+		}
+		if r.Value != "" && !contains(values, r.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Platform определяет платформу по User-Agent. Пустой User-Agent не относится
+// ни к одной платформе
+func Platform(userAgent string) string {
+	switch {
+	case userAgent == "":
+		return ""
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"), strings.Contains(userAgent, "iPod"):
+		return PlatformIOS
+	case strings.Contains(userAgent, "Android"):
+		return PlatformAndroid
+	default:
+		return PlatformDesktop
+	}
+}
+
+// PreferredLanguage возвращает язык с наибольшим весом из Accept-Language.
+// При равных весах выбирается указанный раньше, "*" и языки с q=0 пропускаются
+func PreferredLanguage(header string) string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var langs []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+		langs = append(langs, weighted{tag, q})
+	}
+	if len(langs) == 0 {
+		return ""
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	return langs[0].tag
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
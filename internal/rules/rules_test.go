@@ -0,0 +1,116 @@
+package rules
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const (
+	iPhoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148"
+	androidUA = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36"
+	desktopUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36"
+)
+
+func TestPlatform(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want string
+	}{
+		{iPhoneUA, PlatformIOS},
+		{"Mozilla/5.0 (iPad; CPU OS 16_0 like Mac OS X)", PlatformIOS},
+		{androidUA, PlatformAndroid},
+		{desktopUA, PlatformDesktop},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Platform(tt.ua); got != tt.want {
+			t.Errorf("Platform(%q) = %q, want %q", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"de-DE,de;q=0.9,en;q=0.8", "de-de"},
+		{"en;q=0.5, fr", "fr"},
+		{"en;q=0.8, ru;q=0.8", "en"},
+		{"*, es;q=0.5", "es"},
+		{"de;q=0, en;q=0.1", "en"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := PreferredLanguage(tt.header); got != tt.want {
+			t.Errorf("PreferredLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	rules := []Rule{
+		{Param: "channel", Value: "beta", URL: "https://example.com/beta"},
+		{Platform: PlatformIOS, URL: "https://apps.apple.com/app/id1"},
+		{Platform: PlatformAndroid, URL: "https://play.google.com/store/apps/details?id=app"},
+		{Platform: PlatformDesktop, Language: "de", URL: "https://example.com/de"},
+		{Param: "promo", URL: "https://example.com/promo"},
+	}
+
+	tests := []struct {
+		name    string
+		visitor Visitor
+		want    string
+	}{
+		{"ios", Visitor{Platform: PlatformIOS}, "https://apps.apple.com/app/id1"},
+		{"android", Visitor{Platform: PlatformAndroid}, "https://play.google.com/store/apps/details?id=app"},
+		{"desktop with language region", Visitor{Platform: PlatformDesktop, Language: "de-at"}, "https://example.com/de"},
+		{"language prefix is not a match", Visitor{Platform: PlatformDesktop, Language: "dev"}, ""},
+		{"first matching rule wins", Visitor{Platform: PlatformIOS, Query: url.Values{"channel": {"beta"}}}, "https://example.com/beta"},
+		{"param value mismatch", Visitor{Query: url.Values{"channel": {"stable"}}}, ""},
+		{"param presence", Visitor{Query: url.Values{"promo": {""}}}, "https://example.com/promo"},
+		{"no match", Visitor{Platform: PlatformDesktop, Language: "en"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, ok := Match(rules, tt.visitor)
+			if ok != (tt.want != "") || rule.URL != tt.want {
+				t.Errorf("Match() = %q, %v, want %q", rule.URL, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestRule_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr bool
+	}{
+		{"platform", Rule{Platform: PlatformIOS, URL: "https://example.com"}, false},
+		{"all conditions", Rule{Platform: PlatformDesktop, Language: "de", Param: "a", Value: "b", URL: "https://example.com"}, false},
+		{"unknown platform", Rule{Platform: "windows", URL: "https://example.com"}, true},
+		{"no conditions", Rule{URL: "https://example.com"}, true},
+		{"value without param", Rule{Language: "de", Value: "b", URL: "https://example.com"}, true},
+		{"no URL", Rule{Platform: PlatformIOS}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	req := httptest.NewRequest("GET", "/abc?ref=mail", nil)
+	req.Header.Set("User-Agent", androidUA)
+	req.Header.Set("Accept-Language", "pt-BR,pt;q=0.9")
+
+	v := FromRequest(req)
+	if v.Platform != PlatformAndroid || v.Language != "pt-br" || v.Query.Get("ref") != "mail" {
+		t.Errorf("FromRequest() = %+v", v)
+	}
+}
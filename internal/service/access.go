@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"fmt"
+)
+
+// Role - роль ключа API в рабочем пространстве
+type Role string
+
+// Роли по возрастанию прав
+const (
+	RoleViewer Role = "viewer" // Просмотр, список и выгрузка ссылок
+	RoleEditor Role = "editor" // Плюс создание и загрузка ссылок, изменение и удаление своих ссылок
+	RoleAdmin  Role = "admin"  // Плюс изменение и удаление любых ссылок пространства
+)
+
+// DefaultRole - роль ключа, для которого она не указана
+const DefaultRole = RoleEditor
+
+var roleRanks = map[Role]int{RoleViewer: 1, RoleEditor: 2, RoleAdmin: 3}
+
+// Allows сообщает, что роль не ниже требуемой
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+// Action - операция над ссылками, доступ к которой зависит от роли
+type Action string
+
+const (
+	ActionView   Action = "view" // Просмотр ссылки и ее счетчиков
+	ActionList   Action = "list"
+	ActionExport Action = "export"
+	ActionCreate Action = "create"
+	ActionImport Action = "import"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+	ActionAudit  Action = "audit" // Журнал изменений всех ссылок пространства
+
+	ActionWebhooks Action = "webhooks" // Подписки на события и журнал доставок
+)
+
+// RequiredRole возвращает минимальную роль для операции. Изменять и удалять
+// чужие ссылки, а также перезаписывать их при загрузке может только admin
+func RequiredRole(action Action) Role {
+	switch action {
+	case ActionView, ActionList, ActionExport:
+		return RoleViewer
+	case ActionAudit, ActionWebhooks:
+		return RoleAdmin
+	default:
+		return RoleEditor
+	}
+}
+
+// authorize проверяет, что владелец ключа запроса может выполнить операцию над ссылкой
+// автора owner (для операций без конкретной ссылки - пустая строка). Своими считаются
+// ссылки, созданные ключом с тем же именем; для остальных изменение и удаление
+// требуют роли admin. Запросы без ключа (API без ключей, переходы) разрешены
+func authorize(ctx context.Context, action Action, owner string) error {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	required := RequiredRole(action)
+	if (action == ActionUpdate || action == ActionDelete) && (p.KeyName == "" || owner != p.KeyName) {
+		required = RoleAdmin
+	}
+	if !p.Role.Allows(required) {
+		return fmt.Errorf("%w: %s requires role %s", ErrForbidden, action, required)
+	}
+	return nil
+}
+
+// ownerFor возвращает автора новой ссылки. Для запросов с ключом автором
+// становится имя ключа; указать другого автора может только admin
+func ownerFor(ctx context.Context, createdBy string) string {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok || (createdBy != "" && p.Role.Allows(RoleAdmin)) {
+		return createdBy
+	}
+	return p.KeyName
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BuzzLyutic/url-shortener/internal/logging"
+	"github.com/BuzzLyutic/url-shortener/internal/rules"
+	"github.com/BuzzLyutic/url-shortener/internal/shortcode"
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+)
+
+// События журнала изменений ссылок
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditExpire  = "expire" // Истек срок ссылки; записывается фоновой задачей
+)
+
+// AuditEntry - запись журнала изменений ссылки
+type AuditEntry struct {
+	ID        int64
+	Domain    string
+	ShortCode string
+	Action    string
+	Actor     string // Имя ключа API; пусто - запрос без ключа
+	RequestID string
+	At        time.Time
+	Before    json.RawMessage // Состояние ссылки до изменения; nil для create, restore и expire
+	After     json.RawMessage // Состояние ссылки после изменения; nil для delete
+}
+
+// AuditOptions - фильтры и страница журнала
+type AuditOptions struct {
+	Actor  string
+	Action string
+	Limit  int    // По умолчанию defaultListLimit
+	Cursor string // NextCursor предыдущей страницы
+}
+
+// AuditPage - страница журнала от новых событий к старым
+type AuditPage struct {
+	Entries    []AuditEntry
+	NextCursor string // Пусто на последней странице
+}
+
+// linkState - состояние ссылки в журнале. Счетчики переходов не входят:
+// они меняются при каждом переходе и изменением ссылки не считаются
+type linkState struct {
+	OriginalURL string        `json:"original_url"`
+	UntaggedURL string        `json:"untagged_url,omitempty"`
+	ExpiresAt   *time.Time    `json:"expires_at,omitempty"`
+	NotBefore   *time.Time    `json:"not_before,omitempty"`
+	Protected   bool          `json:"password_protected,omitempty"`
+	Targets     []targetState `json:"targets,omitempty"`
+	Sticky      bool          `json:"sticky,omitempty"`
+	Rules       []rules.Rule  `json:"rules,omitempty"`
+
+	ForwardQuery string `json:"forward_query,omitempty"`
+	ForwardPath  bool   `json:"forward_path,omitempty"`
+
+	NextURL  string     `json:"next_url,omitempty"`
+	SwitchAt *time.Time `json:"switch_at,omitempty"`
+
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	ImageURL    string   `json:"image_url,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Note        string   `json:"note,omitempty"`
+	CreatedBy   string   `json:"created_by,omitempty"`
+}
+
+type targetState struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// stateOf возвращает состояние ссылки для журнала; nil - ссылки нет
+func stateOf(link *Link) json.RawMessage {
+	if link == nil {
+		return nil
+	}
+	state := linkState{
+		OriginalURL:  link.OriginalURL,
+		UntaggedURL:  link.UntaggedURL,
+		ExpiresAt:    link.ExpiresAt,
+		NotBefore:    link.NotBefore,
+		Protected:    link.Protected,
+		Sticky:       link.Sticky,
+		Rules:        link.Rules,
+		ForwardQuery: link.ForwardQuery,
+		ForwardPath:  link.ForwardPath,
+		NextURL:      link.NextURL,
+		SwitchAt:     link.SwitchAt,
+		Title:        link.Title,
+		Description:  link.Description,
+		ImageURL:     link.ImageURL,
+		Tags:         link.Tags,
+		Note:         link.Note,
+		CreatedBy:    link.CreatedBy,
+	}
+	for _, t := range link.Targets {
+		state.Targets = append(state.Targets, targetState{URL: t.URL, Weight: t.Weight})
+	}
+	data, _ := json.Marshal(state)
+	return data
+}
+
+// audited возвращает контекст, изменение ссылки в котором хранилище запишет в журнал
+// вместе с автором, идентификатором запроса и состояниями до и после
+func audited(ctx context.Context, action string, before, after *Link) context.Context {
+	workspace := workspaceOf(ctx)
+	if after != nil {
+		workspace = after.Workspace
+	} else if before != nil {
+		workspace = before.Workspace
+	}
+	p, _ := PrincipalFromContext(ctx)
+	return storage.WithAudit(ctx, storage.AuditEvent{
+		Workspace: workspace,
+		Action:    action,
+		Actor:     p.KeyName,
+		RequestID: logging.RequestIDFromContext(ctx),
+		At:        time.Now(),
+		Before:    stateOf(before),
+		After:     stateOf(after),
+	})
+}
+
+// History возвращает журнал изменений ссылки, включая удаленные ссылки
+func (s *Shortener) History(ctx context.Context, domain, code string, opts AuditOptions) (_ *AuditPage, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.History",
+		trace.WithAttributes(attribute.String("shortener.code", code)),
+	)
+	defer func() { finishSpan(span, err) }()
+
+	if err := authorize(ctx, ActionView, ""); err != nil {
+		return nil, err
+	}
+	domain, err = s.normalizeDomain(domain)
+	if err != nil {
+		return nil, err
+	}
+	if !shortcode.IsValid(code) {
+		return nil, ErrCodeNotFound
+	}
+	return s.auditPage(ctx, storage.AuditQuery{Domain: domain, ShortCode: code}, opts)
+}
+
+// Audit возвращает журнал изменений всех ссылок рабочего пространства запроса
+func (s *Shortener) Audit(ctx context.Context, opts AuditOptions) (_ *AuditPage, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.Audit")
+	defer func() { finishSpan(span, err) }()
+
+	if err := authorize(ctx, ActionAudit, ""); err != nil {
+		return nil, err
+	}
+	return s.auditPage(ctx, storage.AuditQuery{}, opts)
+}
+
+// auditPage выбирает страницу журнала пространства запроса. Курсор - ID последнего
+// выданного события, поэтому новые события не сдвигают страницы
+func (s *Shortener) auditPage(ctx context.Context, q storage.AuditQuery, opts AuditOptions) (*AuditPage, error) {
+	switch opts.Action {
+	case "", AuditCreate, AuditUpdate, AuditDelete, AuditRestore, AuditExpire:
+	default:
+		return nil, ErrInvalidListQuery
+	}
+	limit := opts.Limit
+	if limit == 0 {
+		limit = defaultListLimit
+	}
+	if limit < 0 || limit > maxListLimit {
+		return nil, ErrInvalidListQuery
+	}
+	if opts.Cursor != "" {
+		id, err := strconv.ParseInt(opts.Cursor, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, ErrInvalidCursor
+		}
+		q.BeforeID = id
+	}
+	q.Workspace = workspaceOf(ctx)
+	q.Actor, q.Action = opts.Actor, opts.Action
+	// Лишнее событие показывает, есть ли следующая страница
+	q.Limit = limit + 1
+
+	events, err := s.storage.ListAudit(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("listing audit log: %w", err)
+	}
+	page := &AuditPage{}
+	if len(events) > limit {
+		events = events[:limit]
+		page.NextCursor = strconv.FormatInt(events[limit-1].ID, 10)
+	}
+	for _, e := range events {
+		page.Entries = append(page.Entries, AuditEntry{
+			ID:        e.ID,
+			Domain:    e.Domain,
+			ShortCode: e.ShortCode,
+			Action:    e.Action,
+			Actor:     e.Actor,
+			RequestID: e.RequestID,
+			At:        e.At,
+			Before:    e.Before,
+			After:     e.After,
+		})
+	}
+	return page, nil
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+)
+
+// Ограничения меток и заметки ссылки
+const (
+	maxTags       = 20
+	maxTagLength  = 50 // В символах
+	maxNoteLength = 2000
+)
+
+// Labels - метки и заметка для упорядочивания ссылок. На переход не влияют
+type Labels struct {
+	Tags []string
+	Note string
+}
+
+// normalizeLabels приводит метки к нижнему регистру, убирает повторы
+// и проверяет их число, длину и символы, а также длину заметки
+func normalizeLabels(labels Labels) (Labels, error) {
+	if len(labels.Tags) > maxTags || utf8.RuneCountInString(labels.Note) > maxNoteLength {
+		return Labels{}, ErrInvalidLabels
+	}
+	var tags []string
+	for _, tag := range labels.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !validTag(tag) {
+			return Labels{}, ErrInvalidLabels
+		}
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return Labels{Tags: tags, Note: labels.Note}, nil
+}
+
+// validTag допускает буквы, цифры и символы "-_.:/"
+func validTag(tag string) bool {
+	if tag == "" || utf8.RuneCountInString(tag) > maxTagLength {
+		return false
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_.:/", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// SetLabels заменяет метки и заметку ссылки
+func (s *Shortener) SetLabels(ctx context.Context, domain, code string, labels Labels) (_ *Link, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.SetLabels",
+		trace.WithAttributes(attribute.String("shortener.code", code)),
+	)
+	defer func() { finishSpan(span, err) }()
+
+	labels, err = normalizeLabels(labels)
+	if err != nil {
+		return nil, err
+	}
+	link, err := s.Lookup(ctx, domain, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(ctx, ActionUpdate, link.CreatedBy); err != nil {
+		return nil, err
+	}
+
+	after := *link
+	after.Labels = labels
+	if err := s.storage.SetLabels(audited(ctx, AuditUpdate, link, &after), link.Domain, code, storage.Labels(labels)); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrCodeNotFound
+		}
+		return nil, fmt.Errorf("setting labels: %w", err)
+	}
+
+	return s.Lookup(ctx, link.Domain, code)
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// Размер таблицы попыток, после которого из нее удаляются устаревшие записи
+const limiterSweepSize = 1024
+
+// attemptLimiter считает неудачные попытки ввода пароля по ключу ссылки.
+// Попытки учитываются в фиксированном окне, начинающемся с первой неудачи
+type attemptLimiter struct {
+	mu       sync.Mutex
+	attempts map[string]*attemptWindow
+	now      func() time.Time
+}
+
+type attemptWindow struct {
+	count int
+	start time.Time
+}
+
+func newAttemptLimiter() *attemptLimiter {
+	return &attemptLimiter{
+		attempts: make(map[string]*attemptWindow),
+		now:      time.Now,
+	}
+}
+
+// take резервирует попытку для ключа. Возвращает false, если лимит
+// в текущем окне исчерпан. max <= 0 отключает ограничение
+func (l *attemptLimiter) take(key string, max int, window time.Duration) bool {
+	if max <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if len(l.attempts) >= limiterSweepSize {
+		l.sweep(now, window)
+	}
+
+	w, ok := l.attempts[key]
+	if !ok || now.Sub(w.start) >= window {
+		w = &attemptWindow{start: now}
+		l.attempts[key] = w
+	}
+	if w.count >= max {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// reset сбрасывает счетчик после успешного ввода пароля
+func (l *attemptLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.attempts, key)
+}
+
+// sweep удаляет окна, срок которых истек. Вызывается под l.mu
+func (l *attemptLimiter) sweep(now time.Time, window time.Duration) {
+	for key, w := range l.attempts {
+		if now.Sub(w.start) >= window {
+			delete(l.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAttemptLimiter(t *testing.T) {
+	now := time.Now()
+	l := newAttemptLimiter()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if !l.take("code", 3, time.Minute) {
+			t.Fatalf("take() #%d = false, want true", i+1)
+		}
+	}
+	if l.take("code", 3, time.Minute) {
+		t.Error("take() over limit = true, want false")
+	}
+	if !l.take("other", 3, time.Minute) {
+		t.Error("take() for other key = false, want true")
+	}
+
+	// После окна блокировки попытки снова разрешены
+	now = now.Add(time.Minute)
+	if !l.take("code", 3, time.Minute) {
+		t.Error("take() after window = false, want true")
+	}
+
+	l.reset("code")
+	if _, ok := l.attempts["code"]; ok {
+		t.Error("reset() did not remove key")
+	}
+
+	if !l.take("code", 0, time.Minute) {
+		t.Error("take() with max=0 = false, want true")
+	}
+}
+
+func TestAttemptLimiter_Sweep(t *testing.T) {
+	now := time.Now()
+	l := newAttemptLimiter()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < limiterSweepSize; i++ {
+		l.take(fmt.Sprintf("code%d", i), 5, time.Minute)
+	}
+	now = now.Add(2 * time.Minute)
+	l.take("fresh", 5, time.Minute)
+
+	if len(l.attempts) != 1 {
+		t.Errorf("len(attempts) = %d, want 1 after sweep", len(l.attempts))
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+)
+
+// Размер страницы списка ссылок
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// Статусы ссылок для фильтрации списка
+const (
+	StatusActive  = storage.StatusActive
+	StatusExpired = storage.StatusExpired
+	StatusDeleted = storage.StatusDeleted
+)
+
+// Порядок сортировки списка ссылок по дате создания
+const (
+	OrderNewest = "desc" // Сначала новые (по умолчанию)
+	OrderOldest = "asc"  // Сначала старые
+)
+
+// ListOptions - параметры выборки ссылок. Пустые поля не фильтруют
+type ListOptions struct {
+	Domain        string     // Короткий домен; пусто означает основной домен
+	CreatedBy     string     // Автор ссылки
+	Tags          []string   // Метки, каждая из которых должна быть у ссылки
+	Host          string     // Хост адреса назначения
+	Query         string     // Подстрока адреса назначения
+	CreatedAfter  *time.Time // Создана не раньше
+	CreatedBefore *time.Time // Создана раньше
+	Status        string     // StatusActive, StatusExpired или StatusDeleted
+	Order         string     // OrderNewest или OrderOldest
+	Cursor        string     // Курсор следующей страницы из предыдущего ответа
+	Limit         int        // Размер страницы; 0 - по умолчанию
+}
+
+// ListResult - страница списка ссылок
+type ListResult struct {
+	Links      []*Link
+	NextCursor string // Пусто, если страница последняя
+}
+
+// List возвращает страницу ссылок домена в рабочем пространстве запроса,
+// отсортированных по дате создания.
+// Страницы выбираются по курсору, поэтому не сдвигаются при создании новых ссылок
+func (s *Shortener) List(ctx context.Context, opts ListOptions) (_ *ListResult, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.List")
+	defer func() { finishSpan(span, err) }()
+
+	if err := authorize(ctx, ActionList, ""); err != nil {
+		return nil, err
+	}
+
+	domain, err := s.normalizeDomain(opts.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := normalizeLabels(Labels{Tags: opts.Tags})
+	if err != nil {
+		return nil, ErrInvalidListQuery
+	}
+
+	q := storage.ListQuery{
+		Workspace:   workspaceOf(ctx),
+		Domain:      domain,
+		CreatedBy:   opts.CreatedBy,
+		Tags:        filter.Tags,
+		DestHost:    opts.Host,
+		Contains:    opts.Query,
+		CreatedFrom: opts.CreatedAfter,
+		CreatedTo:   opts.CreatedBefore,
+		Status:      opts.Status,
+		Limit:       opts.Limit,
+	}
+	switch opts.Status {
+	case "", StatusActive, StatusExpired, StatusDeleted:
+	default:
+		return nil, ErrInvalidListQuery
+	}
+	switch opts.Order {
+	case "", OrderNewest:
+		q.Descending = true
+	case OrderOldest:
+	default:
+		return nil, ErrInvalidListQuery
+	}
+	switch {
+	case q.Limit < 0 || q.Limit > maxListLimit:
+		return nil, ErrInvalidListQuery
+	case q.Limit == 0:
+		q.Limit = defaultListLimit
+	}
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		q.After = &c
+	}
+
+	// Лишняя запись показывает, есть ли следующая страница
+	limit := q.Limit
+	q.Limit++
+	urls, err := s.storage.List(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("listing URLs: %w", err)
+	}
+
+	result := &ListResult{}
+	if len(urls) > limit {
+		urls = urls[:limit]
+		result.NextCursor = encodeCursor(storage.CursorOf(urls[limit-1]))
+	}
+	result.Links = make([]*Link, len(urls))
+	for i, u := range urls {
+		result.Links[i] = s.toLink(u)
+	}
+	span.SetAttributes(attribute.Int("shortener.links", len(result.Links)))
+	return result, nil
+}
+
+// encodeCursor кодирует позицию записи в непрозрачную строку
+func encodeCursor(c storage.Cursor) string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ShortCode
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (storage.Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return storage.Cursor{}, ErrInvalidCursor
+	}
+	createdAt, code, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return storage.Cursor{}, ErrInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return storage.Cursor{}, ErrInvalidCursor
+	}
+	return storage.Cursor{CreatedAt: t, ShortCode: code}, nil
+}
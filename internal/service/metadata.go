@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+)
+
+// Ограничения длины описания ссылки в символах
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 1000
+)
+
+// Metadata - заголовок, описание и картинка ссылки для превью в соцсетях
+type Metadata struct {
+	Title       string
+	Description string
+	ImageURL    string
+}
+
+// validateMetadata проверяет длину текстов и адрес картинки
+func (s *Shortener) validateMetadata(meta Metadata) error {
+	if utf8.RuneCountInString(meta.Title) > maxTitleLength ||
+		utf8.RuneCountInString(meta.Description) > maxDescriptionLength {
+		return ErrInvalidMetadata
+	}
+	if meta.ImageURL != "" && s.validateURL(meta.ImageURL) != nil {
+		return ErrInvalidMetadata
+	}
+	return nil
+}
+
+// SetMetadata заменяет описание ссылки
+func (s *Shortener) SetMetadata(ctx context.Context, domain, code string, meta Metadata) (_ *Link, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.SetMetadata",
+		trace.WithAttributes(attribute.String("shortener.code", code)),
+	)
+	defer func() { finishSpan(span, err) }()
+
+	if err := s.validateMetadata(meta); err != nil {
+		return nil, err
+	}
+	link, err := s.Lookup(ctx, domain, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(ctx, ActionUpdate, link.CreatedBy); err != nil {
+		return nil, err
+	}
+
+	after := *link
+	after.Metadata = meta
+	if err := s.storage.SetMetadata(audited(ctx, AuditUpdate, link, &after), link.Domain, code, storage.Metadata(meta)); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrCodeNotFound
+		}
+		return nil, fmt.Errorf("setting metadata: %w", err)
+	}
+
+	return s.Lookup(ctx, link.Domain, code)
+}
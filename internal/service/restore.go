@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BuzzLyutic/url-shortener/internal/shortcode"
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+)
+
+// purgeBatch - сколько удаленных ссылок удаляется окончательно за один запрос
+const purgeBatch = 500
+
+// Restore восстанавливает удаленную ссылку, если срок восстановления не прошел.
+// Восстановленная ссылка снова учитывается в квоте пространства, но больше
+// не дедуплицируется. Чужие ссылки может восстановить только admin
+func (s *Shortener) Restore(ctx context.Context, domain, code string) (_ *Link, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.Restore",
+		trace.WithAttributes(attribute.String("shortener.code", code)),
+	)
+	defer func() { finishSpan(span, err) }()
+
+	domain, err = s.normalizeDomain(domain)
+	if err != nil {
+		return nil, err
+	}
+	if !shortcode.IsValid(code) {
+		return nil, ErrCodeNotFound
+	}
+
+	// Неудаленная ссылка восстановлению не подлежит и не находится
+	urlRecord, err := s.storage.GetByCode(ctx, domain, code)
+	switch {
+	case errors.Is(err, storage.ErrDeleted):
+	case err == nil || errors.Is(err, storage.ErrNotFound) ||
+		errors.Is(err, storage.ErrExpired) || errors.Is(err, storage.ErrNotYetActive):
+		return nil, ErrCodeNotFound
+	default:
+		return nil, fmt.Errorf("getting URL: %w", err)
+	}
+	// Окончательно удаленная ссылка не хранится, остался только ее код
+	if urlRecord == nil {
+		return nil, ErrRestoreExpired
+	}
+	if !visible(ctx, urlRecord) {
+		return nil, ErrCodeNotFound
+	}
+	link := s.toLink(urlRecord)
+	if err := authorize(ctx, ActionDelete, link.CreatedBy); err != nil {
+		return nil, err
+	}
+	if window := s.restoreWindow(); window > 0 && time.Since(*urlRecord.DeletedAt) > window {
+		return nil, ErrRestoreExpired
+	}
+	if err := s.checkQuota(ctx, urlRecord.Workspace); err != nil {
+		return nil, err
+	}
+
+	after := *link
+	after.DeletedAt = nil
+	if err := s.storage.Restore(audited(ctx, AuditRestore, nil, &after), domain, code); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrCodeNotFound
+		}
+		return nil, fmt.Errorf("restoring URL: %w", err)
+	}
+
+	return s.Lookup(ctx, domain, code)
+}
+
+// PurgeDeleted окончательно удаляет ссылки всех пространств, срок восстановления
+// которых прошел к моменту now, и возвращает их число. Коды ссылок остаются
+// закрытыми. Без срока восстановления ничего не удаляет
+func (s *Shortener) PurgeDeleted(ctx context.Context, now time.Time) (n int, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.PurgeDeleted")
+	defer func() {
+		span.SetAttributes(attribute.Int("shortener.purged", n))
+		finishSpan(span, err)
+	}()
+
+	window := s.restoreWindow()
+	if window <= 0 {
+		return 0, nil
+	}
+	for {
+		purged, err := s.storage.Purge(ctx, now.Add(-window), purgeBatch)
+		n += purged
+		if err != nil {
+			return n, fmt.Errorf("purging deleted URLs: %w", err)
+		}
+		if purged < purgeBatch {
+			return n, nil
+		}
+	}
+}
+
+func (s *Shortener) restoreWindow() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.RestoreWindow
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+)
+
+// switchBatch - сколько запланированных смен адреса применяется за один проход
+const switchBatch = 100
+
+// Revision - прежний адрес назначения ссылки. Номера идут с 1 в порядке замены
+type Revision struct {
+	Number      int
+	OriginalURL string
+	UntaggedURL string
+	ReplacedAt  time.Time // Когда адрес перестал быть текущим
+}
+
+// SetDestination меняет адрес назначения ссылки. Прежний адрес сохраняется
+// как ревизия, к которой можно вернуться через RestoreRevision.
+// Ссылка с измененным адресом больше не дедуплицируется
+func (s *Shortener) SetDestination(ctx context.Context, domain, code, originalURL string) (_ *Link, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.SetDestination",
+		trace.WithAttributes(attribute.String("shortener.code", code)),
+	)
+	defer func() { finishSpan(span, err) }()
+
+	if err := s.validateURL(originalURL); err != nil {
+		return nil, err
+	}
+	return s.replaceDestination(ctx, domain, code, func(*Link) (storage.Destination, error) {
+		return storage.Destination{OriginalURL: originalURL}, nil
+	})
+}
+
+// Revisions возвращает прежние адреса ссылки по возрастанию номера
+func (s *Shortener) Revisions(ctx context.Context, domain, code string) (_ []Revision, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.Revisions",
+		trace.WithAttributes(attribute.String("shortener.code", code)),
+	)
+	defer func() { finishSpan(span, err) }()
+
+	link, err := s.Lookup(ctx, domain, code)
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := s.storage.ListRevisions(ctx, link.Domain, code)
+	if err != nil {
+		return nil, fmt.Errorf("listing revisions: %w", err)
+	}
+
+	result := make([]Revision, len(revisions))
+	for i, r := range revisions {
+		result[i] = Revision{
+			Number:      r.Number,
+			OriginalURL: r.OriginalURL,
+			UntaggedURL: r.UntaggedURL,
+			ReplacedAt:  r.ReplacedAt,
+		}
+	}
+	return result, nil
+}
+
+// RestoreRevision возвращает ссылке адрес ревизии n. Текущий адрес
+// сохраняется как новая ревизия, поэтому откат тоже можно отменить
+func (s *Shortener) RestoreRevision(ctx context.Context, domain, code string, n int) (_ *Link, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.RestoreRevision",
+		trace.WithAttributes(
+			attribute.String("shortener.code", code),
+			attribute.Int("shortener.revision", n),
+		),
+	)
+	defer func() { finishSpan(span, err) }()
+
+	return s.replaceDestination(ctx, domain, code, func(link *Link) (storage.Destination, error) {
+		revisions, err := s.storage.ListRevisions(ctx, link.Domain, code)
+		if err != nil {
+			return storage.Destination{}, fmt.Errorf("listing revisions: %w", err)
+		}
+		for _, r := range revisions {
+			if r.Number == n {
+				return r.Destination, nil
+			}
+		}
+		return storage.Destination{}, ErrRevisionNotFound
+	})
+}
+
+// replaceDestination заменяет адрес ссылки адресом, который вернула dest.
+// Адрес ссылки с ротацией задается ее вариантами и так не меняется.
+// Совпадающий с текущим адрес ревизию не создает
+func (s *Shortener) replaceDestination(ctx context.Context, domain, code string, dest func(*Link) (storage.Destination, error)) (*Link, error) {
+	link, err := s.Lookup(ctx, domain, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(ctx, ActionUpdate, link.CreatedBy); err != nil {
+		return nil, err
+	}
+	if len(link.Targets) > 0 {
+		return nil, ErrHasTargets
+	}
+	next, err := dest(link)
+	if err != nil {
+		return nil, err
+	}
+	if next.OriginalURL == link.OriginalURL && next.UntaggedURL == link.UntaggedURL {
+		return link, nil
+	}
+
+	after := *link
+	after.OriginalURL, after.UntaggedURL = next.OriginalURL, next.UntaggedURL
+	if after.NextURL == next.OriginalURL {
+		after.NextURL, after.SwitchAt = "", nil
+	}
+	if err := s.storage.SetDestination(audited(ctx, AuditUpdate, link, &after), link.Domain, code, next); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrCodeNotFound
+		}
+		return nil, fmt.Errorf("setting destination: %w", err)
+	}
+
+	return s.Lookup(ctx, link.Domain, code)
+}
+
+// ScheduleSwitch планирует смену адреса ссылки на nextURL в момент at.
+// Смену применяет ApplySwitches. Пустой nextURL отменяет запланированную смену
+func (s *Shortener) ScheduleSwitch(ctx context.Context, domain, code, nextURL string, at *time.Time) (_ *Link, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.ScheduleSwitch",
+		trace.WithAttributes(attribute.String("shortener.code", code)),
+	)
+	defer func() { finishSpan(span, err) }()
+
+	if nextURL != "" {
+		if err := s.validateURL(nextURL); err != nil {
+			return nil, err
+		}
+		if at == nil || !at.After(time.Now()) {
+			return nil, ErrInvalidSwitch
+		}
+	} else {
+		at = nil
+	}
+
+	link, err := s.Lookup(ctx, domain, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(ctx, ActionUpdate, link.CreatedBy); err != nil {
+		return nil, err
+	}
+	if len(link.Targets) > 0 {
+		return nil, ErrHasTargets
+	}
+
+	after := *link
+	after.NextURL, after.SwitchAt = nextURL, at
+	if err := s.storage.ScheduleSwitch(audited(ctx, AuditUpdate, link, &after), link.Domain, code, nextURL, at); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrCodeNotFound
+		}
+		return nil, fmt.Errorf("scheduling switch: %w", err)
+	}
+
+	return s.Lookup(ctx, link.Domain, code)
+}
+
+// ApplySwitches применяет смены адресов всех пространств, наступившие к моменту now,
+// и возвращает их число. Смена записывается в журнал как update без автора.
+// Смену, которую уже применил или отменил другой экземпляр сервиса, пропускает
+func (s *Shortener) ApplySwitches(ctx context.Context, now time.Time) (_ int, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.ApplySwitches")
+	defer func() { finishSpan(span, err) }()
+
+	due, err := s.storage.DueSwitches(ctx, now, switchBatch)
+	if err != nil {
+		return 0, fmt.Errorf("listing due switches: %w", err)
+	}
+
+	applied := 0
+	for _, u := range due {
+		before := s.toLink(u)
+		after := *before
+		after.OriginalURL, after.UntaggedURL = u.NextURL, ""
+		after.NextURL, after.SwitchAt = "", nil
+
+		err := s.storage.ApplySwitch(audited(ctx, AuditUpdate, before, &after), u.Domain, u.ShortCode, now)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return applied, fmt.Errorf("applying switch: %w", err)
+		}
+		applied++
+	}
+	span.SetAttributes(attribute.Int("shortener.switches", applied))
+	return applied, nil
+}
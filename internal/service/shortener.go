@@ -3,45 +3,129 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/BuzzLyutic/url-shortener/internal/rules"
 	"github.com/BuzzLyutic/url-shortener/internal/shortcode"
 	"github.com/BuzzLyutic/url-shortener/internal/storage"
 )
 
+const tracerName = "github.com/BuzzLyutic/url-shortener/internal/service"
+
 // Кастомные ошибки, возвращаемые сервисом
 var (
-	ErrInvalidURL        = errors.New("invalid URL")
-	ErrEmptyURL          = errors.New("URL cannot be empty")
-	ErrCodeNotFound      = errors.New("short code not found")
-	ErrTooManyCollisions = errors.New("failed to generate unique code after max attempts")
+	ErrInvalidURL         = errors.New("invalid URL")
+	ErrEmptyURL           = errors.New("URL cannot be empty")
+	ErrCodeNotFound       = errors.New("short code not found")
+	ErrTooManyCollisions  = errors.New("failed to generate unique code after max attempts")
+	ErrUnknownDomain      = errors.New("unknown short domain")
+	ErrPasswordTooLong    = errors.New("password is too long")
+	ErrPasswordRequired   = errors.New("password required")
+	ErrInvalidPassword    = errors.New("invalid password")
+	ErrTooManyAttempts    = errors.New("too many password attempts")
+	ErrInvalidMaxClicks   = errors.New("max clicks must not be negative")
+	ErrLinkExhausted      = errors.New("link click limit reached")
+	ErrNotYetActive       = errors.New("link is not active yet")
+	ErrInvalidFallback    = errors.New("invalid fallback URL")
+	ErrInvalidTargets     = errors.New("invalid targets")
+	ErrInvalidRules       = errors.New("invalid redirect rules")
+	ErrInvalidQueryMode   = errors.New("invalid query forwarding mode")
+	ErrUnknownUTMTemplate = errors.New("unknown UTM template")
+	ErrInvalidMetadata    = errors.New("invalid link metadata")
+	ErrInvalidLabels      = errors.New("invalid link tags or note")
+	ErrInvalidCursor      = errors.New("invalid pagination cursor")
+	ErrInvalidListQuery   = errors.New("invalid list parameters")
+	ErrInvalidConflict    = errors.New("invalid import conflict policy")
+	ErrInvalidImport      = errors.New("invalid import data")
+	ErrImportConflict     = errors.New("short code already exists")
+	ErrInvalidAPIKey      = errors.New("invalid API key")
+	ErrQuotaExceeded      = errors.New("workspace link quota exceeded")
+	ErrForbidden          = errors.New("action not permitted")
+	ErrRevisionNotFound   = errors.New("revision not found")
+	ErrHasTargets         = errors.New("link with targets has no single destination")
+	ErrInvalidSwitch      = errors.New("switch time must be in the future")
+	ErrLinkDeleted        = errors.New("link has been deleted")
+	ErrRestoreExpired     = errors.New("link can no longer be restored")
+	ErrInvalidWebhook     = errors.New("invalid webhook")
+	ErrWebhookNotFound    = errors.New("webhook not found")
+	ErrDeliveryNotFound   = errors.New("webhook delivery not found")
 )
 
 const (
-	maxAttempts = 10 // Максимальное кол-во попыток разрешения коллизий
+	maxAttempts       = 10 // Максимальное кол-во попыток разрешения коллизий
+	maxPasswordLength = 72 // Ограничение bcrypt на длину пароля в байтах
 )
 
 // Config содержит конфиг сервиса
 type Config struct {
 	BaseURL    string        // Базовый URL для коротких ссылок
 	DefaultTTL time.Duration // TTL для ссылок по умолчанию
+	Domains    []string      // Дополнительные короткие домены
+
+	PasswordMaxAttempts int           // Неудачных попыток ввода пароля до блокировки (0 = без ограничения)
+	PasswordLockout     time.Duration // Окно подсчета попыток и время блокировки
+
+	RestoreWindow time.Duration // Срок восстановления удаленной ссылки (0 = без ограничения)
+
+	WebhookMaxAttempts int           // Попыток доставки вебхука до списка неотправленных (0 = DefaultWebhookMaxAttempts)
+	WebhookBackoff     time.Duration // Пауза после первой неудачной попытки (0 = DefaultWebhookBackoff)
+	WebhookRetention   time.Duration // Срок хранения отправленных доставок (0 = без ограничения)
+	// WebhookAllowPrivate разрешает доставку вебхуков на адреса внутренней сети
+	WebhookAllowPrivate bool
+
+	UTMTemplates map[string]UTM // Именованные шаблоны UTM-меток
+
+	Workspaces map[string]Workspace // Рабочие пространства по именам
+	APIKeys    []APIKey             // Ключи API; пусто - API доступен без ключа
 }
 
 // Shortener предоставляет операции для укорачивания ссылок
 type Shortener struct {
 	storage storage.Storage
-	config  Config
+	base    *url.URL            // Разобранный BaseURL; nil, если BaseURL не задан
+	domains map[string]struct{} // Дополнительные домены в нижнем регистре
+	limiter *attemptLimiter     // Неудачные попытки ввода паролей
+
+	webhookClient *http.Client     // Отправка вебхуков; перенаправления не выполняются
+	subscribers   *subscriberCache // Пространства с подпиской на переходы
+
+	mu     sync.RWMutex // защищает config и keys при горячей перезагрузке
+	config Config
+	keys   map[[sha256.Size]byte]APIKey // Ключи API по хэшу
 }
 
 // New создает новый сервис Shortener
 func New(store storage.Storage, config Config) *Shortener {
-	return &Shortener{
-		storage: store,
-		config:  config,
+	s := &Shortener{
+		storage:       store,
+		domains:       make(map[string]struct{}, len(config.Domains)),
+		limiter:       newAttemptLimiter(),
+		subscribers:   newSubscriberCache(),
+		webhookClient: newWebhookClient(config.WebhookAllowPrivate),
+		config:        config,
+		keys:          hashKeys(config.APIKeys),
+	}
+	if config.BaseURL != "" {
+		s.base, _ = url.Parse(strings.TrimRight(config.BaseURL, "/"))
+	}
+	for _, d := range config.Domains {
+		s.domains[strings.ToLower(d)] = struct{}{}
 	}
+	return s
 }
 
 // ShortenResult содержит результат укорачивания ссылок
@@ -49,27 +133,133 @@ type ShortenResult struct {
 	ShortCode   string
 	ShortURL    string
 	OriginalURL string
+	UntaggedURL string // Адрес до добавления UTM-меток; пусто, если метки не добавлялись
 	ExpiresAt   *time.Time
+	NotBefore   *time.Time
+	Domain      string
+	Workspace   string
+	Protected   bool // Ссылка защищена паролем
+	MaxClicks   int  // Лимит переходов; 0 - без ограничения
+	CreatedBy   string
+	Labels      Labels
 	IsNew       bool // true если новый короткий код создан
 }
 
-// Shorten создает укороченную ссылку по оригинальному URL
+// ShortenOptions содержит необязательные параметры создания ссылки
+type ShortenOptions struct {
+	Domain    string // Короткий домен; пусто означает основной домен
+	Password  string // Пароль для перехода по ссылке; пусто - без пароля
+	MaxClicks int    // Лимит переходов; 0 - без ограничения
+
+	NotBefore  *time.Time // Начало действия ссылки; nil - действует сразу
+	PendingURL string     // Резервный адрес до начала действия ("скоро")
+	ExpiredURL string     // Резервный адрес после истечения срока
+
+	Targets []Target // Варианты адреса для A/B ротации; URL по умолчанию - первый вариант
+	Sticky  bool     // Закреплять выбранный вариант за посетителем
+
+	Rules []rules.Rule // Правила выбора адреса; без совпадений используется адрес по умолчанию
+
+	ForwardQuery string // Режим передачи параметров запроса (Query*); пусто - не передавать
+	ForwardPath  bool   // Дописывать к адресу путь после короткого кода
+
+	UTMTemplate string // Имя шаблона UTM-меток из конфигурации
+	UTM         UTM    // Явно заданные метки; имеют приоритет над шаблоном
+
+	Metadata Metadata // Описание ссылки для превью в соцсетях
+	Labels   Labels   // Метки и заметка; ссылка с ними не дедуплицируется
+
+	CreatedBy string // Автор (владелец) ссылки для фильтрации списка; не влияет на дедупликацию
+}
+
+// Режимы передачи параметров запроса в адрес назначения. Режим определяет,
+// что делать с параметром, который есть и в запросе, и в адресе назначения
+const (
+	QueryPreferLink    = "link"    // Оставить значение из адреса назначения
+	QueryPreferRequest = "request" // Заменить значением из запроса
+	QueryAppend        = "append"  // Передать оба значения
+)
+
+// custom сообщает, задает ли запрос индивидуальные настройки ссылки
+func (o ShortenOptions) custom() bool {
+	return o.Password != "" || o.MaxClicks != 0 ||
+		o.NotBefore != nil || o.PendingURL != "" || o.ExpiredURL != "" ||
+		len(o.Targets) > 0 || o.Sticky || len(o.Rules) > 0 ||
+		o.ForwardQuery != "" || o.ForwardPath || o.Metadata != (Metadata{}) ||
+		len(o.Labels.Tags) > 0 || o.Labels.Note != ""
+}
+
+// Shorten создает укороченную ссылку по оригинальному URL на основном домене
 // Если URL уже был укорочен ранее, возвращается существующий код
 func (s *Shortener) Shorten(ctx context.Context, originalURL string) (*ShortenResult, error) {
+	return s.ShortenWithOptions(ctx, originalURL, ShortenOptions{})
+}
+
+// ShortenWithOptions создает укороченную ссылку с дополнительными параметрами
+// в рабочем пространстве запроса. Дедупликация выполняется в пределах
+// пространства и домена. Ссылки с индивидуальными настройками
+// (пароль, лимит переходов, расписание) не дедуплицируются и получают случайный код,
+// чтобы его нельзя было вычислить по оригинальному URL
+func (s *Shortener) ShortenWithOptions(ctx context.Context, originalURL string, opts ShortenOptions) (_ *ShortenResult, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.Shorten")
+	defer func() { finishSpan(span, err) }()
+
+	if err := authorize(ctx, ActionCreate, ""); err != nil {
+		return nil, err
+	}
+	opts.CreatedBy = ownerFor(ctx, opts.CreatedBy)
+
+	if originalURL == "" && len(opts.Targets) > 0 {
+		originalURL = opts.Targets[0].URL
+	}
+
 	// Валидация URL
 	if err := s.validateURL(originalURL); err != nil {
 		return nil, err
 	}
 
+	// Метки добавляются до дедупликации: ссылки с разными метками различаются
+	utm, err := s.utmFor(opts)
+	if err != nil {
+		return nil, err
+	}
+	var untaggedURL string
+	if utm != (UTM{}) {
+		tagged, err := utm.apply(originalURL)
+		if err != nil {
+			return nil, ErrInvalidURL
+		}
+		untaggedURL, originalURL = originalURL, tagged
+	}
+
+	domain, err := s.normalizeDomain(opts.Domain)
+	if err != nil {
+		return nil, err
+	}
+	workspace := workspaceOf(ctx)
+	span.SetAttributes(
+		attribute.String("shortener.domain", domain),
+		attribute.String("shortener.workspace", workspace),
+	)
+
+	if opts.custom() {
+		return s.shortenCustom(ctx, originalURL, untaggedURL, domain, opts)
+	}
+
 	// Проверить существование URL
-	existing, err := s.storage.GetByOriginalURL(originalURL)
+	existing, err := s.storage.GetByOriginalURL(ctx, workspace, domain, originalURL)
 	if err == nil {
 		// URL уже сокращен
+		span.SetAttributes(attribute.Bool("shortener.new", false))
 		return &ShortenResult{
 			ShortCode:   existing.ShortCode,
-			ShortURL:    s.buildShortURL(existing.ShortCode),
+			ShortURL:    s.buildShortURL(domain, existing.ShortCode),
 			OriginalURL: existing.OriginalURL,
+			UntaggedURL: existing.UntaggedURL,
 			ExpiresAt:   existing.ExpiresAt,
+			Domain:      domain,
+			Workspace:   workspace,
+			CreatedBy:   existing.CreatedBy,
 			IsNew:       false,
 		}, nil
 	}
@@ -77,33 +267,49 @@ func (s *Shortener) Shorten(ctx context.Context, originalURL string) (*ShortenRe
 	if !errors.Is(err, storage.ErrNotFound) && !errors.Is(err, storage.ErrExpired) {
 		return nil, fmt.Errorf("checking existing URL: %w", err)
 	}
+	if err := s.checkQuota(ctx, workspace); err != nil {
+		return nil, err
+	}
 
-	// Сгенерировать новый короткий код с обработкой коллизий
+	// Сгенерировать новый короткий код с обработкой коллизий.
+	// Код другого пространства с тем же адресом тоже считается коллизией
 	for attempt := 0; attempt < maxAttempts; attempt++ {
 		code := shortcode.Generate(originalURL, attempt)
 
 		// Рассчитать срок истечения
 		var expiresAt *time.Time
-		if s.config.DefaultTTL > 0 {
-			t := time.Now().Add(s.config.DefaultTTL)
+		if ttl := s.defaultTTL(workspace); ttl > 0 {
+			t := time.Now().Add(ttl)
 			expiresAt = &t
 		}
 
 		urlRecord := storage.URL{
+			Workspace:   workspace,
+			Domain:      domain,
 			ShortCode:   code,
 			OriginalURL: originalURL,
+			UntaggedURL: untaggedURL,
 			CreatedAt:   time.Now(),
+			CreatedBy:   opts.CreatedBy,
 			ExpiresAt:   expiresAt,
 		}
 
-		err := s.storage.Save(urlRecord)
+		err := s.storage.Save(audited(ctx, AuditCreate, nil, s.toLink(&urlRecord)), urlRecord)
 		if err == nil {
 			// Успешное сохранение
+			span.SetAttributes(
+				attribute.Bool("shortener.new", true),
+				attribute.Int("shortener.attempts", attempt+1),
+			)
 			return &ShortenResult{
 				ShortCode:   code,
-				ShortURL:    s.buildShortURL(code),
+				ShortURL:    s.buildShortURL(domain, code),
 				OriginalURL: originalURL,
+				UntaggedURL: untaggedURL,
 				ExpiresAt:   expiresAt,
+				Domain:      domain,
+				Workspace:   workspace,
+				CreatedBy:   opts.CreatedBy,
 				IsNew:       true,
 			}, nil
 		}
@@ -118,21 +324,500 @@ func (s *Shortener) Shorten(ctx context.Context, originalURL string) (*ShortenRe
 	return nil, ErrTooManyCollisions
 }
 
-// Resolve возвращает оригинальный URL по короткой ссылке
-func (s *Shortener) Resolve(ctx context.Context, code string) (string, error) {
+// shortenCustom сохраняет ссылку с индивидуальными настройками под случайным кодом
+func (s *Shortener) shortenCustom(ctx context.Context, originalURL, untaggedURL, domain string, opts ShortenOptions) (*ShortenResult, error) {
+	if opts.MaxClicks < 0 {
+		return nil, ErrInvalidMaxClicks
+	}
+	for _, fallback := range []string{opts.PendingURL, opts.ExpiredURL} {
+		if fallback != "" && s.validateURL(fallback) != nil {
+			return nil, ErrInvalidFallback
+		}
+	}
+	if len(opts.Targets) > 0 {
+		if err := s.validateTargets(opts.Targets); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.validateRules(opts.Rules); err != nil {
+		return nil, err
+	}
+	if err := validateQueryMode(opts.ForwardQuery); err != nil {
+		return nil, err
+	}
+	if err := s.validateMetadata(opts.Metadata); err != nil {
+		return nil, err
+	}
+	labels, err := normalizeLabels(opts.Labels)
+	if err != nil {
+		return nil, err
+	}
+	workspace := workspaceOf(ctx)
+	if err := s.checkQuota(ctx, workspace); err != nil {
+		return nil, err
+	}
+
+	var passwordHash string
+	if opts.Password != "" {
+		if len(opts.Password) > maxPasswordLength {
+			return nil, ErrPasswordTooLong
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("hashing password: %w", err)
+		}
+		passwordHash = string(hash)
+	}
+
+	var clicksLeft *int
+	if opts.MaxClicks > 0 {
+		clicksLeft = &opts.MaxClicks
+	}
+
+	// Для отложенных ссылок срок жизни отсчитывается от начала действия
+	var expiresAt *time.Time
+	if ttl := s.defaultTTL(workspace); ttl > 0 {
+		start := time.Now()
+		if opts.NotBefore != nil && opts.NotBefore.After(start) {
+			start = *opts.NotBefore
+		}
+		t := start.Add(ttl)
+		expiresAt = &t
+	}
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		urlRecord := storage.URL{
+			Workspace:    workspace,
+			Domain:       domain,
+			ShortCode:    shortcode.Random(),
+			OriginalURL:  originalURL,
+			UntaggedURL:  untaggedURL,
+			CreatedAt:    time.Now(),
+			CreatedBy:    opts.CreatedBy,
+			ExpiresAt:    expiresAt,
+			NotBefore:    opts.NotBefore,
+			PendingURL:   opts.PendingURL,
+			ExpiredURL:   opts.ExpiredURL,
+			Targets:      toStorageTargets(opts.Targets),
+			Sticky:       opts.Sticky,
+			Rules:        opts.Rules,
+			ForwardQuery: opts.ForwardQuery,
+			ForwardPath:  opts.ForwardPath,
+			Metadata:     storage.Metadata(opts.Metadata),
+			Labels:       storage.Labels(labels),
+			ClicksLeft:   clicksLeft,
+			PasswordHash: passwordHash,
+			Custom:       true,
+		}
+
+		err := s.storage.Save(audited(ctx, AuditCreate, nil, s.toLink(&urlRecord)), urlRecord)
+		if err == nil {
+			return &ShortenResult{
+				ShortCode:   urlRecord.ShortCode,
+				ShortURL:    s.buildShortURL(domain, urlRecord.ShortCode),
+				OriginalURL: originalURL,
+				UntaggedURL: untaggedURL,
+				ExpiresAt:   expiresAt,
+				NotBefore:   opts.NotBefore,
+				Domain:      domain,
+				Workspace:   workspace,
+				Protected:   passwordHash != "",
+				MaxClicks:   opts.MaxClicks,
+				CreatedBy:   opts.CreatedBy,
+				Labels:      labels,
+				IsNew:       true,
+			}, nil
+		}
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			continue
+		}
+		return nil, fmt.Errorf("saving URL: %w", err)
+	}
+
+	return nil, ErrTooManyCollisions
+}
+
+// ResolveRequest описывает переход по короткой ссылке
+type ResolveRequest struct {
+	Domain   string
+	Code     string
+	Password string // Пароль, введенный посетителем
+	Unlocked bool   // Пароль уже подтвержден ранее (например, подписанной cookie)
+	Variant  int    // Ранее закрепленный за посетителем вариант (с 1); 0 - нет
+
+	Visitor rules.Visitor // Признаки посетителя для правил перехода
+	SubPath bool          // В запросе есть путь после короткого кода
+}
+
+// Resolution - результат перехода по короткой ссылке
+type Resolution struct {
+	URL       string
+	Permanent bool // Переход не зависит от состояния ссылки и может кэшироваться клиентом
+	Fallback  bool // Переход на резервный адрес неактивной или истекшей ссылки
+	Variant   int  // Выбранный вариант ссылки с ротацией (с 1); 0 - ротации нет
+	Sticky    bool // Вариант нужно закрепить за посетителем
+
+	// Передача параметров запроса и пути в адрес назначения; для резервных адресов не задается
+	ForwardQuery string
+	ForwardPath  bool
+}
+
+// Resolve возвращает оригинальный URL по домену и короткому коду.
+// Для ссылок с паролем без пароля в запросе возвращается ErrPasswordRequired.
+// У ссылок с лимитом каждый успешный переход списывает одно использование.
+// Еще не активные и истекшие ссылки ведут на резервный адрес, если он задан,
+// иначе возвращаются ErrNotYetActive и ErrCodeNotFound соответственно.
+// Для удаленных ссылок, в том числе удаленных окончательно, возвращается ErrLinkDeleted
+func (s *Shortener) Resolve(ctx context.Context, req ResolveRequest) (_ *Resolution, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.Resolve",
+		trace.WithAttributes(
+			attribute.String("shortener.domain", req.Domain),
+			attribute.String("shortener.code", req.Code),
+		),
+	)
+	defer func() { finishSpan(span, err) }()
+
+	if !shortcode.IsValid(req.Code) {
+		return nil, ErrCodeNotFound
+	}
+
+	urlRecord, err := s.storage.GetByCode(ctx, req.Domain, req.Code)
+
+	// Путь после кода допустим только у ссылок-префиксов
+	if req.SubPath && urlRecord != nil && !urlRecord.ForwardPath {
+		return nil, ErrCodeNotFound
+	}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, storage.ErrExpired):
+		return fallback(urlRecord.ExpiredURL, ErrCodeNotFound)
+	case errors.Is(err, storage.ErrNotYetActive):
+		return fallback(urlRecord.PendingURL, ErrNotYetActive)
+	case errors.Is(err, storage.ErrDeleted):
+		return nil, ErrLinkDeleted
+	case errors.Is(err, storage.ErrNotFound):
+		return nil, ErrCodeNotFound
+	default:
+		return nil, fmt.Errorf("getting URL: %w", err)
+	}
+
+	if urlRecord.PasswordHash != "" && !req.Unlocked {
+		span.SetAttributes(attribute.Bool("shortener.protected", true))
+		if err := s.checkPassword(urlRecord, req.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	if urlRecord.ClicksLeft != nil {
+		if err := s.consumeClick(ctx, urlRecord); err != nil {
+			return nil, err
+		}
+	}
+
+	res := s.resolveDestination(ctx, urlRecord, req)
+	res.ForwardQuery = urlRecord.ForwardQuery
+	res.ForwardPath = urlRecord.ForwardPath
+
+	// Ошибка постановки события в очередь не должна мешать самому переходу
+	if err := s.publishClick(ctx, urlRecord, res.URL); err != nil {
+		span.RecordError(err)
+	}
+	return res, nil
+}
+
+// resolveDestination выбирает адрес назначения активной ссылки
+func (s *Shortener) resolveDestination(ctx context.Context, urlRecord *storage.URL, req ResolveRequest) *Resolution {
+	span := trace.SpanFromContext(ctx)
+
+	// Совпавшее правило имеет приоритет над адресом по умолчанию и ротацией
+	if rule, ok := rules.Match(urlRecord.Rules, req.Visitor); ok {
+		span.SetAttributes(attribute.Bool("shortener.rule_matched", true))
+		return &Resolution{URL: rule.URL}
+	}
+
+	if len(urlRecord.Targets) > 0 {
+		variant := pickVariant(urlRecord.Targets, req.Variant)
+		span.SetAttributes(attribute.Int("shortener.variant", variant+1))
+
+		// Ошибка учета перехода не должна мешать самому переходу
+		if err := s.storage.RecordClick(ctx, urlRecord.Domain, urlRecord.ShortCode, variant); err != nil {
+			span.RecordError(err)
+		}
+		return &Resolution{
+			URL:     urlRecord.Targets[variant].URL,
+			Variant: variant + 1,
+			Sticky:  urlRecord.Sticky,
+		}
+	}
+
+	// Переход с запланированной сменой адреса или сроком действия не должен
+	// кэшироваться клиентом: после смены или истечения ответ станет другим
+	return &Resolution{
+		URL: urlRecord.OriginalURL,
+		Permanent: urlRecord.PasswordHash == "" && urlRecord.ClicksLeft == nil && len(urlRecord.Rules) == 0 &&
+			urlRecord.SwitchAt == nil && urlRecord.ExpiresAt == nil && urlRecord.ExpiredURL == "",
+	}
+}
+
+// fallback возвращает переход на резервный адрес или ошибку, если адреса нет
+func fallback(target string, errNoFallback error) (*Resolution, error) {
+	if target == "" {
+		return nil, errNoFallback
+	}
+	return &Resolution{URL: target, Fallback: true}, nil
+}
+
+// consumeClick списывает переход у ссылки с лимитом. Исчерпанная по
+// прочитанной записи ссылка отклоняется без обращения к хранилищу
+func (s *Shortener) consumeClick(ctx context.Context, u *storage.URL) error {
+	if *u.ClicksLeft <= 0 {
+		return ErrLinkExhausted
+	}
+	err := s.storage.ConsumeClick(ctx, u.Domain, u.ShortCode)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, storage.ErrExhausted):
+		return ErrLinkExhausted
+	case errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrExpired):
+		return ErrCodeNotFound
+	case errors.Is(err, storage.ErrNotYetActive):
+		return ErrNotYetActive
+	case errors.Is(err, storage.ErrDeleted):
+		return ErrLinkDeleted
+	default:
+		return fmt.Errorf("consuming click: %w", err)
+	}
+}
+
+// checkPassword сверяет пароль с хэшем ссылки с учетом лимита неудачных попыток.
+// Попытка резервируется до сравнения, чтобы параллельные запросы не обходили лимит
+func (s *Shortener) checkPassword(u *storage.URL, password string) error {
+	if password == "" {
+		return ErrPasswordRequired
+	}
+
+	maxFailures, lockout := s.passwordLimits()
+	key := u.Domain + "/" + u.ShortCode
+	if !s.limiter.take(key, maxFailures, lockout) {
+		return ErrTooManyAttempts
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return ErrInvalidPassword
+	}
+	s.limiter.reset(key)
+	return nil
+}
+
+// SetDefaultTTL меняет TTL новых ссылок без перезапуска сервиса
+func (s *Shortener) SetDefaultTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.DefaultTTL = ttl
+}
+
+// defaultTTL возвращает срок жизни новых ссылок пространства.
+// Срок пространства, если он задан, перекрывает общий
+func (s *Shortener) defaultTTL(workspace string) time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if ttl := s.config.Workspaces[workspace].DefaultTTL; ttl != nil {
+		return *ttl
+	}
+	return s.config.DefaultTTL
+}
+
+// SetPasswordLimits меняет лимит неудачных попыток ввода пароля без перезапуска сервиса
+func (s *Shortener) SetPasswordLimits(maxAttempts int, lockout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.PasswordMaxAttempts = maxAttempts
+	s.config.PasswordLockout = lockout
+}
+
+func (s *Shortener) passwordLimits() (int, time.Duration) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.PasswordMaxAttempts, s.config.PasswordLockout
+}
+
+// Link содержит сведения о сохраненной короткой ссылке
+type Link struct {
+	Workspace   string
+	Domain      string
+	ShortCode   string
+	ShortURL    string
+	OriginalURL string
+	UntaggedURL string
+	CreatedAt   time.Time
+	CreatedBy   string
+	ExpiresAt   *time.Time
+	NotBefore   *time.Time
+	Protected   bool // Ссылка защищена паролем
+	ClicksLeft  *int // Оставшееся число переходов; nil - без ограничения
+	Targets     []Target
+	Sticky      bool
+	Rules       []rules.Rule
+
+	ForwardQuery string
+	ForwardPath  bool
+
+	NextURL  string     // Запланированный адрес назначения; пусто - смены нет
+	SwitchAt *time.Time // Момент запланированной смены адреса
+
+	DeletedAt *time.Time // Момент удаления; nil - ссылка не удалена
+
+	Metadata
+	Labels
+}
+
+// Lookup возвращает сведения о ссылке без учета перехода по ней.
+// Еще не активные ссылки тоже находятся, например, чтобы заранее напечатать QR-код.
+// Ссылки других рабочих пространств не находятся
+func (s *Shortener) Lookup(ctx context.Context, domain, code string) (_ *Link, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.Lookup",
+		trace.WithAttributes(attribute.String("shortener.code", code)),
+	)
+	defer func() { finishSpan(span, err) }()
+
+	domain, err = s.normalizeDomain(domain)
+	if err != nil {
+		return nil, err
+	}
 	if !shortcode.IsValid(code) {
-		return "", ErrCodeNotFound
+		return nil, ErrCodeNotFound
+	}
+
+	urlRecord, err := s.storage.GetByCode(ctx, domain, code)
+	if err != nil && !errors.Is(err, storage.ErrNotYetActive) {
+		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrExpired) ||
+			errors.Is(err, storage.ErrDeleted) {
+			return nil, ErrCodeNotFound
+		}
+		return nil, fmt.Errorf("getting URL: %w", err)
+	}
+	if !visible(ctx, urlRecord) {
+		return nil, ErrCodeNotFound
 	}
 
-	urlRecord, err := s.storage.GetByCode(code)
+	return s.toLink(urlRecord), nil
+}
+
+// Delete удаляет ссылку. Ссылку можно восстановить через Restore в течение
+// RestoreWindow, ее код не выдается повторно. Чужие ссылки может удалить только admin
+func (s *Shortener) Delete(ctx context.Context, domain, code string) (err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.Delete",
+		trace.WithAttributes(attribute.String("shortener.code", code)),
+	)
+	defer func() { finishSpan(span, err) }()
+
+	link, err := s.Lookup(ctx, domain, code)
 	if err != nil {
-		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrExpired) {
-			return "", ErrCodeNotFound
+		return err
+	}
+	if err := authorize(ctx, ActionDelete, link.CreatedBy); err != nil {
+		return err
+	}
+
+	if err := s.storage.Delete(audited(ctx, AuditDelete, link, nil), link.Domain, code); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrCodeNotFound
 		}
-		return "", fmt.Errorf("getting URL: %w", err)
+		return fmt.Errorf("deleting URL: %w", err)
+	}
+	return nil
+}
+
+// QRCodeURL возвращает адрес изображения QR-кода ссылки
+func (s *Shortener) QRCodeURL(domain, code string) string {
+	u := "/api/links/" + code + "/qr"
+	if s.base != nil {
+		u = s.base.String() + u
+	}
+	if domain != "" {
+		u += "?domain=" + url.QueryEscape(domain)
+	}
+	return u
+}
+
+func (s *Shortener) toLink(u *storage.URL) *Link {
+	return &Link{
+		Workspace:   u.Workspace,
+		Domain:      u.Domain,
+		ShortCode:   u.ShortCode,
+		ShortURL:    s.buildShortURL(u.Domain, u.ShortCode),
+		OriginalURL: u.OriginalURL,
+		UntaggedURL: u.UntaggedURL,
+		CreatedAt:   u.CreatedAt,
+		CreatedBy:   u.CreatedBy,
+		ExpiresAt:   u.ExpiresAt,
+		NotBefore:   u.NotBefore,
+		Protected:   u.PasswordHash != "",
+		ClicksLeft:  u.ClicksLeft,
+		Targets:     fromStorageTargets(u.Targets),
+		Sticky:      u.Sticky,
+		Rules:       u.Rules,
+
+		ForwardQuery: u.ForwardQuery,
+		ForwardPath:  u.ForwardPath,
+
+		NextURL:  u.NextURL,
+		SwitchAt: u.SwitchAt,
+
+		DeletedAt: u.DeletedAt,
+
+		Metadata: Metadata(u.Metadata),
+		Labels:   Labels(u.Labels),
 	}
+}
 
-	return urlRecord.OriginalURL, nil
+// finishSpan закрывает span, отмечая в нем внутренние ошибки сервиса
+func finishSpan(span trace.Span, err error) {
+	if err != nil && !isClientError(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// isClientError сообщает, вызвана ли ошибка некорректным запросом клиента
+func isClientError(err error) bool {
+	return errors.Is(err, ErrInvalidURL) ||
+		errors.Is(err, ErrEmptyURL) ||
+		errors.Is(err, ErrUnknownDomain) ||
+		errors.Is(err, ErrCodeNotFound) ||
+		errors.Is(err, ErrPasswordTooLong) ||
+		errors.Is(err, ErrPasswordRequired) ||
+		errors.Is(err, ErrInvalidPassword) ||
+		errors.Is(err, ErrTooManyAttempts) ||
+		errors.Is(err, ErrInvalidMaxClicks) ||
+		errors.Is(err, ErrLinkExhausted) ||
+		errors.Is(err, ErrNotYetActive) ||
+		errors.Is(err, ErrInvalidFallback) ||
+		errors.Is(err, ErrInvalidTargets) ||
+		errors.Is(err, ErrInvalidRules) ||
+		errors.Is(err, ErrInvalidQueryMode) ||
+		errors.Is(err, ErrUnknownUTMTemplate) ||
+		errors.Is(err, ErrInvalidMetadata) ||
+		errors.Is(err, ErrInvalidLabels) ||
+		errors.Is(err, ErrInvalidCursor) ||
+		errors.Is(err, ErrInvalidListQuery) ||
+		errors.Is(err, ErrInvalidConflict) ||
+		errors.Is(err, ErrInvalidImport) ||
+		errors.Is(err, ErrImportConflict) ||
+		errors.Is(err, ErrInvalidAPIKey) ||
+		errors.Is(err, ErrQuotaExceeded) ||
+		errors.Is(err, ErrForbidden) ||
+		errors.Is(err, ErrRevisionNotFound) ||
+		errors.Is(err, ErrHasTargets) ||
+		errors.Is(err, ErrInvalidSwitch) ||
+		errors.Is(err, ErrLinkDeleted) ||
+		errors.Is(err, ErrRestoreExpired) ||
+		errors.Is(err, ErrInvalidWebhook) ||
+		errors.Is(err, ErrWebhookNotFound) ||
+		errors.Is(err, ErrDeliveryNotFound)
 }
 
 // validateURL проверяет валидность URL
@@ -157,10 +842,64 @@ func (s *Shortener) validateURL(rawURL string) error {
 	return nil
 }
 
-// buildShortURL собирает полную укороченную строку
-func (s *Shortener) buildShortURL(code string) string {
-	if s.config.BaseURL == "" {
+// validateQueryMode проверяет режим передачи параметров запроса
+func validateQueryMode(mode string) error {
+	switch mode {
+	case "", QueryPreferLink, QueryPreferRequest, QueryAppend:
+		return nil
+	default:
+		return ErrInvalidQueryMode
+	}
+}
+
+// validateRules проверяет условия и адреса правил перехода
+func (s *Shortener) validateRules(list []rules.Rule) error {
+	if len(list) > rules.MaxRules {
+		return ErrInvalidRules
+	}
+	for _, r := range list {
+		if r.Validate() != nil || s.validateURL(r.URL) != nil {
+			return ErrInvalidRules
+		}
+	}
+	return nil
+}
+
+// DomainForHost возвращает домен ссылок для значения заголовка Host.
+// Неизвестные хосты (в том числе основной) соответствуют основному домену
+func (s *Shortener) DomainForHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if _, ok := s.domains[host]; ok {
+		return host
+	}
+	return ""
+}
+
+// normalizeDomain проверяет запрошенный домен и приводит его к ключу хранилища
+func (s *Shortener) normalizeDomain(domain string) (string, error) {
+	domain = strings.ToLower(domain)
+	if domain == "" || (s.base != nil && domain == strings.ToLower(s.base.Hostname())) {
+		return "", nil
+	}
+	if _, ok := s.domains[domain]; ok {
+		return domain, nil
+	}
+	return "", ErrUnknownDomain
+}
+
+// buildShortURL собирает полную укороченную строку для домена.
+// Для дополнительных доменов берутся схема и путь основного BaseURL
+func (s *Shortener) buildShortURL(domain, code string) string {
+	if s.base == nil {
 		return code
 	}
-	return s.config.BaseURL + "/" + code
+	if domain == "" {
+		return s.base.String() + "/" + code
+	}
+	u := *s.base
+	u.Host = domain
+	return u.String() + "/" + code
 }
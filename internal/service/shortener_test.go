@@ -1,11 +1,25 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/BuzzLyutic/url-shortener/internal/logging"
+	"github.com/BuzzLyutic/url-shortener/internal/rules"
 	"github.com/BuzzLyutic/url-shortener/internal/storage"
+	"github.com/BuzzLyutic/url-shortener/internal/transfer"
 )
 
 func TestShortener_Shorten(t *testing.T) {
@@ -137,31 +151,382 @@ func TestShortener_Resolve(t *testing.T) {
 		originalURL := "https://example.com/resolve-test"
 		result, _ := svc.Shorten(ctx, originalURL)
 
-		resolved, err := svc.Resolve(ctx, result.ShortCode)
+		resolved, err := svc.Resolve(ctx, ResolveRequest{Code: result.ShortCode})
 		if err != nil {
 			t.Fatalf("Resolve() error = %v", err)
 		}
 
-		if resolved != originalURL {
-			t.Errorf("Resolve() = %v, want %v", resolved, originalURL)
+		if resolved.URL != originalURL {
+			t.Errorf("Resolve() = %v, want %v", resolved.URL, originalURL)
+		}
+		if !resolved.Permanent {
+			t.Error("Resolve() Permanent = false for plain link")
 		}
 	})
 
 	t.Run("returns error for non-existent code", func(t *testing.T) {
-		_, err := svc.Resolve(ctx, "nonexist12")
+		_, err := svc.Resolve(ctx, ResolveRequest{Code: "nonexist12"})
 		if err != ErrCodeNotFound {
 			t.Errorf("Resolve() error = %v, want %v", err, ErrCodeNotFound)
 		}
 	})
 
 	t.Run("returns error for invalid code format", func(t *testing.T) {
-		_, err := svc.Resolve(ctx, "short") // слишком короткий
+		_, err := svc.Resolve(ctx, ResolveRequest{Code: "short"}) // слишком короткий
 		if err != ErrCodeNotFound {
 			t.Errorf("Resolve() error = %v, want %v", err, ErrCodeNotFound)
 		}
 	})
 }
 
+func TestShortener_Domains(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	svc := New(store, Config{
+		BaseURL: "https://sho.rt",
+		Domains: []string{"go.example", "Links.Example"},
+	})
+	ctx := context.Background()
+
+	t.Run("same code points to different destinations per domain", func(t *testing.T) {
+		primary, err := svc.Shorten(ctx, "https://example.com/landing")
+		if err != nil {
+			t.Fatalf("Shorten() error = %v", err)
+		}
+
+		// Сохраняем на другом домене запись с тем же кодом напрямую
+		err = store.Save(ctx, storage.URL{
+			Domain:      "go.example",
+			ShortCode:   primary.ShortCode,
+			OriginalURL: "https://example.com/other",
+			CreatedAt:   time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		got, err := svc.Resolve(ctx, ResolveRequest{Code: primary.ShortCode})
+		if err != nil || got.URL != "https://example.com/landing" {
+			t.Errorf("Resolve(primary) = %v, %v", got, err)
+		}
+		got, err = svc.Resolve(ctx, ResolveRequest{Domain: "go.example", Code: primary.ShortCode})
+		if err != nil || got.URL != "https://example.com/other" {
+			t.Errorf("Resolve(go.example) = %v, %v", got, err)
+		}
+	})
+
+	t.Run("shorten on additional domain", func(t *testing.T) {
+		result, err := svc.ShortenWithOptions(ctx, "https://example.com/campaign", ShortenOptions{Domain: "GO.example"})
+		if err != nil {
+			t.Fatalf("ShortenWithOptions() error = %v", err)
+		}
+		if result.Domain != "go.example" {
+			t.Errorf("Domain = %v, want %v", result.Domain, "go.example")
+		}
+		if result.ShortURL != "https://go.example/"+result.ShortCode {
+			t.Errorf("ShortURL = %v", result.ShortURL)
+		}
+
+		// На основном домене такой ссылки нет
+		if _, err := svc.Resolve(ctx, ResolveRequest{Code: result.ShortCode}); err != ErrCodeNotFound {
+			t.Errorf("Resolve(primary) error = %v, want %v", err, ErrCodeNotFound)
+		}
+	})
+
+	t.Run("primary host maps to primary domain", func(t *testing.T) {
+		result, err := svc.ShortenWithOptions(ctx, "https://example.com/primary", ShortenOptions{Domain: "sho.rt"})
+		if err != nil {
+			t.Fatalf("ShortenWithOptions() error = %v", err)
+		}
+		if result.Domain != "" {
+			t.Errorf("Domain = %v, want primary", result.Domain)
+		}
+	})
+
+	t.Run("unknown domain", func(t *testing.T) {
+		_, err := svc.ShortenWithOptions(ctx, "https://example.com/x", ShortenOptions{Domain: "evil.example"})
+		if err != ErrUnknownDomain {
+			t.Errorf("ShortenWithOptions() error = %v, want %v", err, ErrUnknownDomain)
+		}
+	})
+
+	t.Run("domain for host", func(t *testing.T) {
+		tests := map[string]string{
+			"go.example":      "go.example",
+			"GO.EXAMPLE:8080": "go.example",
+			"links.example":   "links.example",
+			"sho.rt":          "",
+			"localhost:8080":  "",
+		}
+		for host, want := range tests {
+			if got := svc.DomainForHost(host); got != want {
+				t.Errorf("DomainForHost(%q) = %q, want %q", host, got, want)
+			}
+		}
+	})
+}
+
+func TestShortener_Lookup(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	svc := New(store, Config{BaseURL: "http://localhost:8080"})
+	ctx := context.Background()
+
+	result, _ := svc.Shorten(ctx, "https://example.com/lookup")
+
+	link, err := svc.Lookup(ctx, "", result.ShortCode)
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if link.ShortURL != result.ShortURL || link.OriginalURL != "https://example.com/lookup" {
+		t.Errorf("Lookup() = %+v", link)
+	}
+	if link.CreatedAt.IsZero() {
+		t.Error("Lookup() CreatedAt is zero")
+	}
+
+	if _, err := svc.Lookup(ctx, "", "nonexist12"); err != ErrCodeNotFound {
+		t.Errorf("Lookup() error = %v, want %v", err, ErrCodeNotFound)
+	}
+}
+
+func TestShortener_Password(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	svc := New(store, Config{
+		BaseURL:             "http://localhost:8080",
+		PasswordMaxAttempts: 2,
+		PasswordLockout:     time.Minute,
+	})
+	ctx := context.Background()
+	originalURL := "https://example.com/internal/doc"
+
+	plain, _ := svc.Shorten(ctx, originalURL)
+	result, err := svc.ShortenWithOptions(ctx, originalURL, ShortenOptions{Password: "s3cret"})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	if !result.Protected || !result.IsNew {
+		t.Errorf("ShortenWithOptions() = %+v, want new protected link", result)
+	}
+	if result.ShortCode == plain.ShortCode {
+		t.Error("protected link must not reuse the code of the plain link")
+	}
+
+	stored, _ := store.GetByCode(ctx, "", result.ShortCode)
+	if stored.PasswordHash == "" || stored.PasswordHash == "s3cret" {
+		t.Errorf("PasswordHash = %q, want bcrypt hash", stored.PasswordHash)
+	}
+
+	// Обычная ссылка на тот же URL по-прежнему дедуплицируется
+	again, _ := svc.Shorten(ctx, originalURL)
+	if again.ShortCode != plain.ShortCode {
+		t.Errorf("Shorten() code = %v, want %v", again.ShortCode, plain.ShortCode)
+	}
+
+	req := ResolveRequest{Code: result.ShortCode}
+	if _, err := svc.Resolve(ctx, req); err != ErrPasswordRequired {
+		t.Errorf("Resolve() without password error = %v, want %v", err, ErrPasswordRequired)
+	}
+
+	req.Password = "s3cret"
+	got, err := svc.Resolve(ctx, req)
+	if err != nil || got.URL != originalURL || got.Permanent {
+		t.Errorf("Resolve() = %+v, %v, want non-permanent %v", got, err, originalURL)
+	}
+
+	req.Password = ""
+	req.Unlocked = true
+	if got, err := svc.Resolve(ctx, req); err != nil || got.URL != originalURL {
+		t.Errorf("Resolve(unlocked) = %v, %v, want %v", got, err, originalURL)
+	}
+
+	t.Run("rate limits failed attempts", func(t *testing.T) {
+		req := ResolveRequest{Code: result.ShortCode, Password: "wrong"}
+		for i := 0; i < 2; i++ {
+			if _, err := svc.Resolve(ctx, req); err != ErrInvalidPassword {
+				t.Fatalf("attempt %d error = %v, want %v", i+1, err, ErrInvalidPassword)
+			}
+		}
+		req.Password = "s3cret"
+		if _, err := svc.Resolve(ctx, req); err != ErrTooManyAttempts {
+			t.Errorf("Resolve() after lockout error = %v, want %v", err, ErrTooManyAttempts)
+		}
+
+		// Снятие лимита применяется без перезапуска
+		svc.SetPasswordLimits(0, time.Minute)
+		if _, err := svc.Resolve(ctx, req); err != nil {
+			t.Errorf("Resolve() without limit error = %v", err)
+		}
+	})
+
+	t.Run("lookup marks protected link", func(t *testing.T) {
+		link, err := svc.Lookup(ctx, "", result.ShortCode)
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		if !link.Protected {
+			t.Error("Lookup() Protected = false, want true")
+		}
+	})
+
+	t.Run("too long password", func(t *testing.T) {
+		_, err := svc.ShortenWithOptions(ctx, originalURL, ShortenOptions{Password: strings.Repeat("x", 73)})
+		if err != ErrPasswordTooLong {
+			t.Errorf("ShortenWithOptions() error = %v, want %v", err, ErrPasswordTooLong)
+		}
+	})
+}
+
+func TestShortener_MaxClicks(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	svc := New(store, Config{BaseURL: "http://localhost:8080"})
+	ctx := context.Background()
+	originalURL := "https://example.com/onboarding"
+
+	plain, _ := svc.Shorten(ctx, originalURL)
+	result, err := svc.ShortenWithOptions(ctx, originalURL, ShortenOptions{MaxClicks: 2})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	if result.MaxClicks != 2 || result.ShortCode == plain.ShortCode {
+		t.Errorf("ShortenWithOptions() = %+v, want new link with limit", result)
+	}
+
+	req := ResolveRequest{Code: result.ShortCode}
+	for i := 0; i < 2; i++ {
+		got, err := svc.Resolve(ctx, req)
+		if err != nil {
+			t.Fatalf("Resolve() #%d error = %v", i+1, err)
+		}
+		if got.Permanent {
+			t.Error("Resolve() Permanent = true for click-limited link")
+		}
+	}
+	if _, err := svc.Resolve(ctx, req); err != ErrLinkExhausted {
+		t.Errorf("Resolve() after limit error = %v, want %v", err, ErrLinkExhausted)
+	}
+
+	link, _ := svc.Lookup(ctx, "", result.ShortCode)
+	if link.ClicksLeft == nil || *link.ClicksLeft != 0 {
+		t.Errorf("Lookup() ClicksLeft = %v, want 0", link.ClicksLeft)
+	}
+
+	t.Run("negative limit", func(t *testing.T) {
+		_, err := svc.ShortenWithOptions(ctx, originalURL, ShortenOptions{MaxClicks: -1})
+		if err != ErrInvalidMaxClicks {
+			t.Errorf("ShortenWithOptions() error = %v, want %v", err, ErrInvalidMaxClicks)
+		}
+	})
+
+	t.Run("concurrent redirects never exceed limit", func(t *testing.T) {
+		result, _ := svc.ShortenWithOptions(ctx, originalURL, ShortenOptions{MaxClicks: 5})
+
+		var wg sync.WaitGroup
+		var served atomic.Int32
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := svc.Resolve(ctx, ResolveRequest{Code: result.ShortCode}); err == nil {
+					served.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if got := served.Load(); got != 5 {
+			t.Errorf("served %d redirects, want 5", got)
+		}
+	})
+}
+
+func TestShortener_Schedule(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	svc := New(store, Config{BaseURL: "http://localhost:8080"})
+	ctx := context.Background()
+	launch := time.Now().Add(time.Hour)
+
+	t.Run("not yet active without fallback", func(t *testing.T) {
+		result, err := svc.ShortenWithOptions(ctx, "https://example.com/launch", ShortenOptions{NotBefore: &launch})
+		if err != nil {
+			t.Fatalf("ShortenWithOptions() error = %v", err)
+		}
+		if _, err := svc.Resolve(ctx, ResolveRequest{Code: result.ShortCode}); err != ErrNotYetActive {
+			t.Errorf("Resolve() error = %v, want %v", err, ErrNotYetActive)
+		}
+
+		// Ссылка уже видна для предпросмотра и QR-кода
+		link, err := svc.Lookup(ctx, "", result.ShortCode)
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		if link.NotBefore == nil || !link.NotBefore.Equal(launch) {
+			t.Errorf("Lookup() NotBefore = %v, want %v", link.NotBefore, launch)
+		}
+	})
+
+	t.Run("coming soon fallback", func(t *testing.T) {
+		result, _ := svc.ShortenWithOptions(ctx, "https://example.com/launch", ShortenOptions{
+			NotBefore:  &launch,
+			PendingURL: "https://example.com/soon",
+		})
+		got, err := svc.Resolve(ctx, ResolveRequest{Code: result.ShortCode})
+		if err != nil {
+			t.Fatalf("Resolve() error = %v", err)
+		}
+		if got.URL != "https://example.com/soon" || !got.Fallback || got.Permanent {
+			t.Errorf("Resolve() = %+v, want non-permanent fallback", got)
+		}
+	})
+
+	t.Run("active link", func(t *testing.T) {
+		past := time.Now().Add(-time.Minute)
+		result, _ := svc.ShortenWithOptions(ctx, "https://example.com/live", ShortenOptions{NotBefore: &past})
+		got, err := svc.Resolve(ctx, ResolveRequest{Code: result.ShortCode})
+		if err != nil || got.URL != "https://example.com/live" || got.Fallback {
+			t.Errorf("Resolve() = %+v, %v", got, err)
+		}
+	})
+
+	t.Run("expired fallback", func(t *testing.T) {
+		expired := time.Now().Add(-time.Hour)
+		store.Save(ctx, storage.URL{
+			ShortCode:   "expiredFb1",
+			OriginalURL: "https://example.com/sale",
+			CreatedAt:   time.Now().Add(-2 * time.Hour),
+			ExpiresAt:   &expired,
+			ExpiredURL:  "https://example.com/sale-over",
+			Custom:      true,
+		})
+		got, err := svc.Resolve(ctx, ResolveRequest{Code: "expiredFb1"})
+		if err != nil || got.URL != "https://example.com/sale-over" || !got.Fallback {
+			t.Errorf("Resolve() = %+v, %v, want expired fallback", got, err)
+		}
+	})
+
+	t.Run("expiring link is not permanent", func(t *testing.T) {
+		svc := New(storage.NewMemoryStorage(), Config{DefaultTTL: time.Hour})
+		result, _ := svc.Shorten(ctx, "https://example.com/expiring")
+		got, err := svc.Resolve(ctx, ResolveRequest{Code: result.ShortCode})
+		if err != nil || got.URL != "https://example.com/expiring" || got.Permanent {
+			t.Errorf("Resolve() = %+v, %v, want non-permanent redirect", got, err)
+		}
+	})
+
+	t.Run("ttl starts at activation", func(t *testing.T) {
+		svc := New(storage.NewMemoryStorage(), Config{DefaultTTL: time.Hour})
+		result, _ := svc.ShortenWithOptions(ctx, "https://example.com/launch", ShortenOptions{NotBefore: &launch})
+		if result.ExpiresAt == nil || !result.ExpiresAt.Equal(launch.Add(time.Hour)) {
+			t.Errorf("ExpiresAt = %v, want %v", result.ExpiresAt, launch.Add(time.Hour))
+		}
+	})
+
+	t.Run("invalid fallback", func(t *testing.T) {
+		_, err := svc.ShortenWithOptions(ctx, "https://example.com/x", ShortenOptions{ExpiredURL: "javascript:alert(1)"})
+		if err != ErrInvalidFallback {
+			t.Errorf("ShortenWithOptions() error = %v, want %v", err, ErrInvalidFallback)
+		}
+	})
+}
+
 func TestShortener_TTL(t *testing.T) {
 	store := storage.NewMemoryStorage()
 	svc := New(store, Config{
@@ -208,6 +573,7 @@ func TestShortener_BuildShortURL(t *testing.T) {
 	tests := []struct {
 		name    string
 		baseURL string
+		domain  string
 		code    string
 		want    string
 	}{
@@ -217,6 +583,25 @@ func TestShortener_BuildShortURL(t *testing.T) {
 			code:    "aB3_xY9z12",
 			want:    "http://localhost:8080/aB3_xY9z12",
 		},
+		{
+			name:    "base URL with trailing slash",
+			baseURL: "http://localhost:8080/",
+			code:    "aB3_xY9z12",
+			want:    "http://localhost:8080/aB3_xY9z12",
+		},
+		{
+			name:    "base URL with path prefix",
+			baseURL: "https://corp.example/s/",
+			code:    "aB3_xY9z12",
+			want:    "https://corp.example/s/aB3_xY9z12",
+		},
+		{
+			name:    "additional domain",
+			baseURL: "https://corp.example:8443/s",
+			domain:  "go.example",
+			code:    "aB3_xY9z12",
+			want:    "https://go.example/s/aB3_xY9z12",
+		},
 		{
 			name:    "empty base URL",
 			baseURL: "",
@@ -227,8 +612,8 @@ func TestShortener_BuildShortURL(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			svc := New(nil, Config{BaseURL: tt.baseURL})
-			got := svc.buildShortURL(tt.code)
+			svc := New(nil, Config{BaseURL: tt.baseURL, Domains: []string{"go.example"}})
+			got := svc.buildShortURL(tt.domain, tt.code)
 			if got != tt.want {
 				t.Errorf("buildShortURL() = %v, want %v", got, tt.want)
 			}
@@ -243,7 +628,7 @@ type mockStorage struct {
 	failUntilAttempt int
 }
 
-func (m *mockStorage) Save(url storage.URL) error {
+func (m *mockStorage) Save(ctx context.Context, url storage.URL) error {
 	m.saveCalls++
 	if m.saveCalls <= m.failUntilAttempt {
 		return storage.ErrAlreadyExists
@@ -251,11 +636,11 @@ func (m *mockStorage) Save(url storage.URL) error {
 	return nil
 }
 
-func (m *mockStorage) GetByOriginalURL(originalURL string) (*storage.URL, error) {
+func (m *mockStorage) GetByOriginalURL(ctx context.Context, workspace, domain, originalURL string) (*storage.URL, error) {
 	return nil, storage.ErrNotFound
 }
 
-func (m *mockStorage) GetByCode(code string) (*storage.URL, error) {
+func (m *mockStorage) GetByCode(ctx context.Context, domain, code string) (*storage.URL, error) {
 	return nil, storage.ErrNotFound
 }
 
@@ -314,6 +699,1232 @@ func BenchmarkShortener_Resolve(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _ = svc.Resolve(ctx, result.ShortCode)
+		_, _ = svc.Resolve(ctx, ResolveRequest{Code: result.ShortCode})
+	}
+}
+
+func TestShortener_Targets(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	svc := New(store, Config{BaseURL: "http://localhost:8080"})
+	ctx := context.Background()
+
+	result, err := svc.ShortenWithOptions(ctx, "", ShortenOptions{
+		Targets: []Target{
+			{URL: "https://example.com/a", Weight: 1},
+			{URL: "https://example.com/b", Weight: 0},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	if result.OriginalURL != "https://example.com/a" {
+		t.Errorf("OriginalURL = %s, want first target", result.OriginalURL)
+	}
+
+	// Вариант с нулевым весом не выбирается
+	for i := 0; i < 10; i++ {
+		res, err := svc.Resolve(ctx, ResolveRequest{Code: result.ShortCode, Variant: 2})
+		if err != nil {
+			t.Fatalf("Resolve() error = %v", err)
+		}
+		if res.URL != "https://example.com/a" || res.Variant != 1 || res.Permanent {
+			t.Fatalf("Resolve() = %+v, want variant 1 without permanent redirect", res)
+		}
+	}
+
+	link, err := svc.SetWeights(ctx, "", result.ShortCode, []int{0, 1})
+	if err != nil {
+		t.Fatalf("SetWeights() error = %v", err)
+	}
+	if link.Targets[0].Clicks != 10 || link.Targets[1].Weight != 1 {
+		t.Errorf("Targets = %+v", link.Targets)
+	}
+
+	res, _ := svc.Resolve(ctx, ResolveRequest{Code: result.ShortCode, Variant: 1})
+	if res.URL != "https://example.com/b" {
+		t.Errorf("Resolve() after SetWeights URL = %s, want second target", res.URL)
+	}
+
+	t.Run("sticky variant is kept", func(t *testing.T) {
+		result, _ := svc.ShortenWithOptions(ctx, "", ShortenOptions{
+			Targets: []Target{
+				{URL: "https://example.com/a", Weight: 1},
+				{URL: "https://example.com/b", Weight: 1},
+			},
+			Sticky: true,
+		})
+		for i := 0; i < 10; i++ {
+			res, _ := svc.Resolve(ctx, ResolveRequest{Code: result.ShortCode, Variant: 2})
+			if res.URL != "https://example.com/b" || !res.Sticky {
+				t.Fatalf("Resolve() = %+v, want sticky second target", res)
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		cases := map[string]ShortenOptions{
+			"zero weights":    {Targets: []Target{{URL: "https://example.com/a"}}},
+			"negative weight": {Targets: []Target{{URL: "https://example.com/a", Weight: 2}, {URL: "https://example.com/b", Weight: -1}}},
+			"invalid URL":     {Targets: []Target{{URL: "https://example.com/a", Weight: 1}, {URL: "not a url", Weight: 1}}},
+		}
+		for name, opts := range cases {
+			if _, err := svc.ShortenWithOptions(ctx, "", opts); err != ErrInvalidTargets {
+				t.Errorf("%s: error = %v, want %v", name, err, ErrInvalidTargets)
+			}
+		}
+		if _, err := svc.SetWeights(ctx, "", result.ShortCode, []int{1}); err != ErrInvalidTargets {
+			t.Errorf("SetWeights() wrong count error = %v, want %v", err, ErrInvalidTargets)
+		}
+	})
+}
+
+func TestShortener_Rules(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	svc := New(store, Config{BaseURL: "http://localhost:8080"})
+	ctx := context.Background()
+
+	result, err := svc.ShortenWithOptions(ctx, "https://example.com/app", ShortenOptions{
+		Rules: []rules.Rule{
+			{Platform: rules.PlatformIOS, URL: "https://apps.apple.com/app/id1"},
+			{Platform: rules.PlatformAndroid, URL: "https://play.google.com/store/apps/details?id=app"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+
+	tests := []struct {
+		platform string
+		want     string
+	}{
+		{rules.PlatformIOS, "https://apps.apple.com/app/id1"},
+		{rules.PlatformAndroid, "https://play.google.com/store/apps/details?id=app"},
+		{rules.PlatformDesktop, "https://example.com/app"},
+	}
+	for _, tt := range tests {
+		res, err := svc.Resolve(ctx, ResolveRequest{Code: result.ShortCode, Visitor: rules.Visitor{Platform: tt.platform}})
+		if err != nil {
+			t.Fatalf("Resolve() error = %v", err)
+		}
+		if res.URL != tt.want || res.Permanent {
+			t.Errorf("Resolve(%s) = %+v, want %s without permanent redirect", tt.platform, res, tt.want)
+		}
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		invalid := [][]rules.Rule{
+			{{URL: "https://example.com/no-conditions"}},
+			{{Platform: "windows", URL: "https://example.com/x"}},
+			{{Platform: rules.PlatformIOS, URL: "not a url"}},
+		}
+		for _, list := range invalid {
+			_, err := svc.ShortenWithOptions(ctx, "https://example.com/app", ShortenOptions{Rules: list})
+			if err != ErrInvalidRules {
+				t.Errorf("ShortenWithOptions(%+v) error = %v, want %v", list, err, ErrInvalidRules)
+			}
+		}
+	})
+}
+
+func TestShortener_Passthrough(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	svc := New(store, Config{BaseURL: "http://localhost:8080"})
+	ctx := context.Background()
+
+	limited, _ := svc.ShortenWithOptions(ctx, "https://example.com/once", ShortenOptions{MaxClicks: 1})
+
+	// Запрос с путем к обычной ссылке не списывает переход
+	if _, err := svc.Resolve(ctx, ResolveRequest{Code: limited.ShortCode, SubPath: true}); err != ErrCodeNotFound {
+		t.Errorf("Resolve() with sub-path error = %v, want %v", err, ErrCodeNotFound)
+	}
+	if _, err := svc.Resolve(ctx, ResolveRequest{Code: limited.ShortCode}); err != nil {
+		t.Errorf("Resolve() error = %v, click must not be consumed by rejected sub-path", err)
+	}
+
+	alias, err := svc.ShortenWithOptions(ctx, "https://docs.example.com", ShortenOptions{
+		ForwardQuery: QueryAppend,
+		ForwardPath:  true,
+	})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	res, err := svc.Resolve(ctx, ResolveRequest{Code: alias.ShortCode, SubPath: true})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if !res.ForwardPath || res.ForwardQuery != QueryAppend {
+		t.Errorf("Resolve() = %+v, want forwarding options", res)
+	}
+
+	if _, err := svc.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{ForwardQuery: "merge"}); err != ErrInvalidQueryMode {
+		t.Errorf("ShortenWithOptions() error = %v, want %v", err, ErrInvalidQueryMode)
+	}
+}
+
+func TestShortener_UTM(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	svc := New(store, Config{
+		BaseURL: "http://localhost:8080",
+		UTMTemplates: map[string]UTM{
+			"newsletter": {Source: "newsletter", Medium: "email", Campaign: "weekly"},
+		},
+	})
+	ctx := context.Background()
+	baseURL := "https://example.com/post?id=7&utm_source=manual"
+
+	result, err := svc.ShortenWithOptions(ctx, baseURL, ShortenOptions{
+		UTMTemplate: "newsletter",
+		UTM:         UTM{Campaign: "spring"},
+	})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	want := "https://example.com/post?id=7&utm_campaign=spring&utm_medium=email&utm_source=newsletter"
+	if result.OriginalURL != want {
+		t.Errorf("OriginalURL = %s, want %s", result.OriginalURL, want)
+	}
+	if result.UntaggedURL != baseURL {
+		t.Errorf("UntaggedURL = %s, want %s", result.UntaggedURL, baseURL)
+	}
+
+	// Дедупликация выполняется по адресу с метками
+	again, _ := svc.ShortenWithOptions(ctx, baseURL, ShortenOptions{UTMTemplate: "newsletter", UTM: UTM{Campaign: "spring"}})
+	if again.IsNew || again.ShortCode != result.ShortCode || again.UntaggedURL != baseURL {
+		t.Errorf("repeated ShortenWithOptions() = %+v, want existing link", again)
+	}
+	plain, _ := svc.Shorten(ctx, baseURL)
+	if plain.ShortCode == result.ShortCode || plain.UntaggedURL != "" {
+		t.Errorf("untagged Shorten() = %+v, want separate link", plain)
+	}
+
+	if _, err := svc.ShortenWithOptions(ctx, baseURL, ShortenOptions{UTMTemplate: "unknown"}); err != ErrUnknownUTMTemplate {
+		t.Errorf("ShortenWithOptions() error = %v, want %v", err, ErrUnknownUTMTemplate)
+	}
+
+	svc.SetUTMTemplates(map[string]UTM{"b": {Source: "b"}, "a": {Source: "a"}})
+	if templates := svc.UTMTemplates(); len(templates) != 2 || templates[0].Name != "a" {
+		t.Errorf("UTMTemplates() = %+v, want sorted by name", templates)
+	}
+}
+
+func TestShortener_Metadata(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	svc := New(store, Config{BaseURL: "http://localhost:8080"})
+	ctx := context.Background()
+
+	result, err := svc.ShortenWithOptions(ctx, "https://example.com/post", ShortenOptions{
+		Metadata: Metadata{Title: "Post"},
+	})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+
+	link, err := svc.SetMetadata(ctx, "", result.ShortCode, Metadata{Title: "Post", ImageURL: "https://example.com/cover.png"})
+	if err != nil {
+		t.Fatalf("SetMetadata() error = %v", err)
+	}
+	if link.Title != "Post" || link.ImageURL != "https://example.com/cover.png" {
+		t.Errorf("Metadata = %+v", link.Metadata)
+	}
+
+	invalid := []Metadata{
+		{Title: strings.Repeat("a", maxTitleLength+1)},
+		{ImageURL: "javascript:alert(1)"},
+	}
+	for _, meta := range invalid {
+		if _, err := svc.SetMetadata(ctx, "", result.ShortCode, meta); err != ErrInvalidMetadata {
+			t.Errorf("SetMetadata(%+v) error = %v, want %v", meta, err, ErrInvalidMetadata)
+		}
+	}
+	if _, err := svc.SetMetadata(ctx, "", "nonexist12", Metadata{Title: "x"}); err != ErrCodeNotFound {
+		t.Errorf("SetMetadata() error = %v, want %v", err, ErrCodeNotFound)
+	}
+}
+
+func TestShortener_Labels(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	svc := New(store, Config{BaseURL: "http://localhost:8080"})
+	ctx := context.Background()
+
+	plain, _ := svc.Shorten(ctx, "https://example.com/post")
+	result, err := svc.ShortenWithOptions(ctx, "https://example.com/post", ShortenOptions{
+		Labels:    Labels{Tags: []string{" Promo", "promo", "Q1"}, Note: "Spring"},
+		CreatedBy: "alice",
+	})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	// Ссылка с метками не совпадает с обычной ссылкой на тот же адрес
+	if !result.IsNew || result.ShortCode == plain.ShortCode {
+		t.Errorf("labeled link reused code %s", plain.ShortCode)
+	}
+	if !slices.Equal(result.Labels.Tags, []string{"promo", "q1"}) || result.CreatedBy != "alice" {
+		t.Errorf("result = %+v", result)
+	}
+
+	link, err := svc.SetLabels(ctx, "", result.ShortCode, Labels{Tags: []string{"Q2"}})
+	if err != nil {
+		t.Fatalf("SetLabels() error = %v", err)
+	}
+	if !slices.Equal(link.Tags, []string{"q2"}) || link.Note != "" {
+		t.Errorf("Labels = %+v", link.Labels)
+	}
+
+	page, err := svc.List(ctx, ListOptions{Tags: []string{"Q2"}})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(page.Links) != 1 || page.Links[0].ShortCode != result.ShortCode {
+		t.Errorf("List(q2) = %+v", page.Links)
+	}
+	if _, err := svc.List(ctx, ListOptions{Tags: []string{"two words"}}); err != ErrInvalidListQuery {
+		t.Errorf("List() error = %v, want %v", err, ErrInvalidListQuery)
+	}
+
+	invalid := []Labels{
+		{Tags: []string{""}},
+		{Tags: []string{"two words"}},
+		{Tags: []string{strings.Repeat("a", maxTagLength+1)}},
+		{Tags: make([]string, maxTags+1)},
+		{Note: strings.Repeat("a", maxNoteLength+1)},
+	}
+	for _, labels := range invalid {
+		if _, err := svc.SetLabels(ctx, "", result.ShortCode, labels); err != ErrInvalidLabels {
+			t.Errorf("SetLabels(%+v) error = %v, want %v", labels, err, ErrInvalidLabels)
+		}
+	}
+	if _, err := svc.SetLabels(ctx, "", "nonexist12", Labels{Note: "x"}); err != ErrCodeNotFound {
+		t.Errorf("SetLabels() error = %v, want %v", err, ErrCodeNotFound)
+	}
+}
+
+func TestShortener_List(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	svc := New(store, Config{BaseURL: "http://localhost:8080"})
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		_, err := svc.ShortenWithOptions(ctx, fmt.Sprintf("https://example.com/page%d", i), ShortenOptions{CreatedBy: "alice"})
+		if err != nil {
+			t.Fatalf("ShortenWithOptions() error = %v", err)
+		}
+	}
+	if _, err := svc.ShortenWithOptions(ctx, "https://other.example/page", ShortenOptions{CreatedBy: "bob"}); err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+
+	// Обход страницами по курсору возвращает каждую ссылку ровно один раз
+	seen := make(map[string]bool)
+	opts := ListOptions{CreatedBy: "alice", Limit: 2}
+	pages := 0
+	for {
+		page, err := svc.List(ctx, opts)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		pages++
+		for _, link := range page.Links {
+			if seen[link.ShortCode] || link.CreatedBy != "alice" {
+				t.Errorf("List() unexpected link %+v", link)
+			}
+			seen[link.ShortCode] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	if len(seen) != 5 || pages != 3 {
+		t.Errorf("List() returned %d links in %d pages, want 5 in 3", len(seen), pages)
+	}
+
+	page, err := svc.List(ctx, ListOptions{Host: "other.example"})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(page.Links) != 1 || page.Links[0].CreatedBy != "bob" || page.NextCursor != "" {
+		t.Errorf("List() by host = %+v", page)
+	}
+
+	invalid := []struct {
+		opts ListOptions
+		want error
+	}{
+		{ListOptions{Cursor: "not a cursor"}, ErrInvalidCursor},
+		{ListOptions{Status: "archived"}, ErrInvalidListQuery},
+		{ListOptions{Order: "random"}, ErrInvalidListQuery},
+		{ListOptions{Limit: maxListLimit + 1}, ErrInvalidListQuery},
+		{ListOptions{Domain: "unknown.example"}, ErrUnknownDomain},
+	}
+	for _, tt := range invalid {
+		if _, err := svc.List(ctx, tt.opts); err != tt.want {
+			t.Errorf("List(%+v) error = %v, want %v", tt.opts, err, tt.want)
+		}
+	}
+}
+
+func TestShortener_Workspaces(t *testing.T) {
+	ttl := time.Hour
+	svc := New(storage.NewMemoryStorage(), Config{
+		BaseURL: "http://localhost:8080",
+		Workspaces: map[string]Workspace{
+			"team-a": {MaxLinks: 2},
+			"team-b": {DefaultTTL: &ttl},
+		},
+		APIKeys: []APIKey{
+			{Key: "key-team-a-0123456789", Name: "ci", Workspace: "team-a"},
+			{Key: "key-team-b-0123456789", Name: "ops", Workspace: "team-b"},
+		},
+	})
+	if !svc.AuthRequired() {
+		t.Fatal("AuthRequired() = false with configured keys")
+	}
+
+	principal, err := svc.Authenticate("key-team-a-0123456789")
+	if err != nil || principal != (Principal{Workspace: "team-a", KeyName: "ci", Role: DefaultRole}) {
+		t.Fatalf("Authenticate() = %+v, %v", principal, err)
+	}
+	for _, key := range []string{"", "key-team-a"} {
+		if _, err := svc.Authenticate(key); err != ErrInvalidAPIKey {
+			t.Errorf("Authenticate(%q) error = %v, want %v", key, err, ErrInvalidAPIKey)
+		}
+	}
+	ctxA := WithPrincipal(context.Background(), principal)
+	ctxB := WithPrincipal(context.Background(), Principal{Workspace: "team-b", KeyName: "ops", Role: RoleEditor})
+
+	a, err := svc.Shorten(ctxA, "https://example.com/shared")
+	if err != nil {
+		t.Fatalf("Shorten(team-a) error = %v", err)
+	}
+	b, err := svc.Shorten(ctxB, "https://example.com/shared")
+	if err != nil {
+		t.Fatalf("Shorten(team-b) error = %v", err)
+	}
+	// Одинаковый адрес в разных пространствах получает разные коды
+	if !b.IsNew || a.ShortCode == b.ShortCode || a.Workspace != "team-a" || b.Workspace != "team-b" {
+		t.Errorf("team-a = %+v, team-b = %+v", a, b)
+	}
+	// Срок жизни пространства перекрывает общий
+	if a.ExpiresAt != nil || b.ExpiresAt == nil {
+		t.Errorf("ExpiresAt: team-a = %v, team-b = %v", a.ExpiresAt, b.ExpiresAt)
+	}
+
+	if _, err := svc.Lookup(ctxB, "", a.ShortCode); err != ErrCodeNotFound {
+		t.Errorf("Lookup() of another workspace error = %v, want %v", err, ErrCodeNotFound)
+	}
+	// Переходы выполняются без ключа и видят ссылки всех пространств
+	if _, err := svc.Resolve(context.Background(), ResolveRequest{Code: a.ShortCode}); err != nil {
+		t.Errorf("Resolve() error = %v", err)
+	}
+	page, err := svc.List(ctxB, ListOptions{})
+	if err != nil || len(page.Links) != 1 || page.Links[0].ShortCode != b.ShortCode {
+		t.Errorf("List(team-b) = %+v, %v", page, err)
+	}
+
+	// Квота: повтор существующей ссылки ее не расходует
+	if _, err := svc.Shorten(ctxA, "https://example.com/second"); err != nil {
+		t.Fatalf("Shorten() error = %v", err)
+	}
+	if again, err := svc.Shorten(ctxA, "https://example.com/shared"); err != nil || again.IsNew {
+		t.Errorf("Shorten(existing) = %+v, %v", again, err)
+	}
+	if _, err := svc.Shorten(ctxA, "https://example.com/third"); err != ErrQuotaExceeded {
+		t.Errorf("Shorten() over quota error = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	svc.SetWorkspaces(nil, nil)
+	if svc.AuthRequired() {
+		t.Error("AuthRequired() = true after keys were removed")
+	}
+}
+
+func TestShortener_Roles(t *testing.T) {
+	svc := New(storage.NewMemoryStorage(), Config{BaseURL: "http://localhost:8080"})
+	as := func(name string, role Role) context.Context {
+		return WithPrincipal(context.Background(), Principal{Workspace: "team", KeyName: name, Role: role})
+	}
+	viewer, alice, bob, admin := as("viewer", RoleViewer), as("alice", RoleEditor), as("bob", RoleEditor), as("root", RoleAdmin)
+
+	if _, err := svc.Shorten(viewer, "https://example.com/viewer"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Shorten() as viewer error = %v, want %v", err, ErrForbidden)
+	}
+	// Автором ссылки становится ключ; указать другого автора может только admin
+	own, err := svc.ShortenWithOptions(alice, "https://example.com/alice", ShortenOptions{CreatedBy: "mallory"})
+	if err != nil || own.CreatedBy != "alice" {
+		t.Fatalf("ShortenWithOptions() as editor = %+v, %v", own, err)
+	}
+	if res, _ := svc.ShortenWithOptions(admin, "https://example.com/team", ShortenOptions{CreatedBy: "marketing"}); res.CreatedBy != "marketing" {
+		t.Errorf("CreatedBy = %s, want marketing", res.CreatedBy)
+	}
+
+	if _, err := svc.Lookup(viewer, "", own.ShortCode); err != nil {
+		t.Errorf("Lookup() as viewer error = %v", err)
+	}
+	if _, err := svc.List(viewer, ListOptions{}); err != nil {
+		t.Errorf("List() as viewer error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want error
+	}{
+		{"viewer", viewer, ErrForbidden},
+		{"other editor", bob, ErrForbidden},
+		{"owner", alice, nil},
+		{"admin", admin, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := svc.SetLabels(tt.ctx, "", own.ShortCode, Labels{Note: tt.name}); !errors.Is(err, tt.want) {
+				t.Errorf("SetLabels() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if err := svc.Delete(bob, "", own.ShortCode); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Delete() as other editor error = %v, want %v", err, ErrForbidden)
+	}
+	if err := svc.Delete(alice, "", own.ShortCode); err != nil {
+		t.Fatalf("Delete() as owner error = %v", err)
+	}
+	if _, err := svc.Lookup(alice, "", own.ShortCode); err != ErrCodeNotFound {
+		t.Errorf("Lookup() after delete error = %v, want %v", err, ErrCodeNotFound)
+	}
+}
+
+func TestShortener_Audit(t *testing.T) {
+	svc := New(storage.NewMemoryStorage(), Config{BaseURL: "http://localhost:8080"})
+	as := func(name string, role Role, requestID string) context.Context {
+		ctx := logging.WithRequestID(context.Background(), requestID)
+		return WithPrincipal(ctx, Principal{Workspace: "team", KeyName: name, Role: role})
+	}
+	alice, admin := as("alice", RoleEditor, "req-1"), as("root", RoleAdmin, "req-2")
+
+	result, err := svc.ShortenWithOptions(alice, "https://example.com/audit", ShortenOptions{Labels: Labels{Tags: []string{"draft"}}})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	// Повтор существующей обычной ссылки ничего не меняет и в журнал не попадает
+	svc.Shorten(alice, "https://example.com/plain")
+	if again, _ := svc.Shorten(alice, "https://example.com/plain"); again.IsNew {
+		t.Fatal("Shorten() created a duplicate")
+	}
+	if _, err := svc.SetLabels(admin, "", result.ShortCode, Labels{Tags: []string{"final"}}); err != nil {
+		t.Fatalf("SetLabels() error = %v", err)
+	}
+	if err := svc.Delete(alice, "", result.ShortCode); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	// История удаленной ссылки остается доступной
+	page, err := svc.History(alice, "", result.ShortCode, AuditOptions{})
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	want := []struct{ action, actor, requestID string }{
+		{AuditDelete, "alice", "req-1"},
+		{AuditUpdate, "root", "req-2"},
+		{AuditCreate, "alice", "req-1"},
+	}
+	if len(page.Entries) != len(want) {
+		t.Fatalf("History() = %+v", page.Entries)
+	}
+	for i, w := range want {
+		e := page.Entries[i]
+		if e.Action != w.action || e.Actor != w.actor || e.RequestID != w.requestID {
+			t.Errorf("entry %d = %+v, want %+v", i, e, w)
+		}
+	}
+	update := page.Entries[1]
+	if !strings.Contains(string(update.Before), `"draft"`) || !strings.Contains(string(update.After), `"final"`) {
+		t.Errorf("update before = %s, after = %s", update.Before, update.After)
+	}
+	if page.Entries[0].After != nil || page.Entries[2].Before != nil {
+		t.Errorf("delete after = %s, create before = %s", page.Entries[0].After, page.Entries[2].Before)
+	}
+
+	// Журнал пространства доступен только admin и выдается страницами
+	if _, err := svc.Audit(alice, AuditOptions{}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Audit() as editor error = %v, want %v", err, ErrForbidden)
+	}
+	first, err := svc.Audit(admin, AuditOptions{Limit: 3})
+	if err != nil || len(first.Entries) != 3 || first.NextCursor == "" {
+		t.Fatalf("Audit() = %+v, %v", first, err)
+	}
+	second, err := svc.Audit(admin, AuditOptions{Limit: 3, Cursor: first.NextCursor})
+	if err != nil || len(second.Entries) != 1 || second.Entries[0].ShortCode != result.ShortCode || second.NextCursor != "" {
+		t.Errorf("Audit() second page = %+v, %v", second, err)
+	}
+	if _, err := svc.Audit(admin, AuditOptions{Cursor: "x"}); err != ErrInvalidCursor {
+		t.Errorf("Audit() error = %v, want %v", err, ErrInvalidCursor)
+	}
+	if _, err := svc.Audit(admin, AuditOptions{Action: "click"}); err != ErrInvalidListQuery {
+		t.Errorf("Audit() error = %v, want %v", err, ErrInvalidListQuery)
+	}
+}
+
+func TestShortener_Revisions(t *testing.T) {
+	svc := New(storage.NewMemoryStorage(), Config{BaseURL: "http://localhost:8080"})
+	ctx := context.Background()
+
+	result, _ := svc.Shorten(ctx, "https://example.com/v1")
+	code := result.ShortCode
+	resolve := func() *Resolution {
+		t.Helper()
+		res, err := svc.Resolve(ctx, ResolveRequest{Code: code})
+		if err != nil {
+			t.Fatalf("Resolve() error = %v", err)
+		}
+		return res
+	}
+
+	if _, err := svc.SetDestination(ctx, "", code, "ftp://example.com"); err != ErrInvalidURL {
+		t.Errorf("SetDestination(ftp) error = %v, want %v", err, ErrInvalidURL)
+	}
+	link, err := svc.SetDestination(ctx, "", code, "https://example.com/v2")
+	if err != nil || link.OriginalURL != "https://example.com/v2" {
+		t.Fatalf("SetDestination() = %+v, %v", link, err)
+	}
+	if res := resolve(); res.URL != "https://example.com/v2" {
+		t.Errorf("Resolve() URL = %s, want v2", res.URL)
+	}
+	// Прежний адрес снова сокращается в новую ссылку, а не в измененную
+	if again, _ := svc.Shorten(ctx, "https://example.com/v1"); !again.IsNew || again.ShortCode == code {
+		t.Errorf("Shorten(v1) = %+v, want a new link", again)
+	}
+
+	link, err = svc.RestoreRevision(ctx, "", code, 1)
+	if err != nil || link.OriginalURL != "https://example.com/v1" {
+		t.Fatalf("RestoreRevision(1) = %+v, %v", link, err)
+	}
+	revisions, err := svc.Revisions(ctx, "", code)
+	if err != nil || len(revisions) != 2 || revisions[1].OriginalURL != "https://example.com/v2" {
+		t.Errorf("Revisions() = %+v, %v", revisions, err)
+	}
+	if _, err := svc.RestoreRevision(ctx, "", code, 9); err != ErrRevisionNotFound {
+		t.Errorf("RestoreRevision(9) error = %v, want %v", err, ErrRevisionNotFound)
+	}
+
+	t.Run("scheduled switch", func(t *testing.T) {
+		past := time.Now().Add(-time.Minute)
+		if _, err := svc.ScheduleSwitch(ctx, "", code, "https://example.com/v3", &past); err != ErrInvalidSwitch {
+			t.Errorf("ScheduleSwitch(past) error = %v, want %v", err, ErrInvalidSwitch)
+		}
+		at := time.Now().Add(time.Hour)
+		link, err := svc.ScheduleSwitch(ctx, "", code, "https://example.com/v3", &at)
+		if err != nil || link.NextURL != "https://example.com/v3" {
+			t.Fatalf("ScheduleSwitch() = %+v, %v", link, err)
+		}
+		// До смены переход не кэшируется клиентом
+		if res := resolve(); res.URL != "https://example.com/v1" || res.Permanent {
+			t.Errorf("Resolve() before switch = %+v", res)
+		}
+		if n, err := svc.ApplySwitches(ctx, time.Now()); err != nil || n != 0 {
+			t.Errorf("ApplySwitches(now) = %d, %v, want 0", n, err)
+		}
+		if n, err := svc.ApplySwitches(ctx, at.Add(time.Second)); err != nil || n != 1 {
+			t.Fatalf("ApplySwitches(after) = %d, %v, want 1", n, err)
+		}
+		if res := resolve(); res.URL != "https://example.com/v3" || !res.Permanent {
+			t.Errorf("Resolve() after switch = %+v", res)
+		}
+		if revisions, _ := svc.Revisions(ctx, "", code); len(revisions) != 3 {
+			t.Errorf("Revisions() after switch = %+v, want 3", revisions)
+		}
+
+		// Пустой адрес отменяет смену
+		svc.ScheduleSwitch(ctx, "", code, "https://example.com/v4", &at)
+		if link, err := svc.ScheduleSwitch(ctx, "", code, "", nil); err != nil || link.NextURL != "" || link.SwitchAt != nil {
+			t.Errorf("ScheduleSwitch(cancel) = %+v, %v", link, err)
+		}
+	})
+
+	t.Run("audited as update", func(t *testing.T) {
+		page, _ := svc.History(ctx, "", code, AuditOptions{Action: AuditUpdate})
+		// Смена, откат, план, применение, план и отмена
+		if len(page.Entries) != 6 {
+			t.Fatalf("History() = %d updates, want 6", len(page.Entries))
+		}
+		if e := page.Entries[5]; !strings.Contains(string(e.Before), "/v1") || !strings.Contains(string(e.After), "/v2") {
+			t.Errorf("first update = %+v", e)
+		}
+	})
+
+	t.Run("link with targets", func(t *testing.T) {
+		rotated, _ := svc.ShortenWithOptions(ctx, "", ShortenOptions{Targets: []Target{
+			{URL: "https://example.com/a", Weight: 1},
+			{URL: "https://example.com/b", Weight: 1},
+		}})
+		if _, err := svc.SetDestination(ctx, "", rotated.ShortCode, "https://example.com/c"); err != ErrHasTargets {
+			t.Errorf("SetDestination() error = %v, want %v", err, ErrHasTargets)
+		}
+	})
+}
+
+func TestShortener_UpdateLink(t *testing.T) {
+	svc := New(storage.NewMemoryStorage(), Config{BaseURL: "http://localhost:8080"})
+	ctx := context.Background()
+	result, _ := svc.Shorten(ctx, "https://example.com/v1")
+	code := result.ShortCode
+	str := func(s string) *string { return &s }
+
+	// Ошибка в одном поле отклоняет все изменение
+	tests := []struct {
+		name string
+		upd  LinkUpdate
+		want error
+	}{
+		{"invalid image", LinkUpdate{URL: str("https://example.com/v2"), ImageURL: str("ftp://example.com/x.png")}, ErrInvalidMetadata},
+		{"invalid tag", LinkUpdate{URL: str("https://example.com/v2"), Title: str("New"), Tags: &[]string{"bad tag"}}, ErrInvalidLabels},
+		{"switch without time", LinkUpdate{Title: str("New"), NextURL: str("https://example.com/v3")}, ErrInvalidSwitch},
+		{"weights without targets", LinkUpdate{URL: str("https://example.com/v2"), Weights: []int{1}}, ErrInvalidTargets},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := svc.UpdateLink(ctx, "", code, tt.upd); err != tt.want {
+				t.Fatalf("UpdateLink() error = %v, want %v", err, tt.want)
+			}
+			link, _ := svc.Lookup(ctx, "", code)
+			if link.OriginalURL != "https://example.com/v1" || link.Title != "" {
+				t.Errorf("link changed by rejected update: %+v", link)
+			}
+		})
+	}
+	if revisions, _ := svc.Revisions(ctx, "", code); len(revisions) != 0 {
+		t.Errorf("Revisions() = %+v, want none", revisions)
+	}
+
+	at := time.Now().Add(time.Hour)
+	link, err := svc.UpdateLink(ctx, "", code, LinkUpdate{
+		URL:      str("https://example.com/v2"),
+		NextURL:  str("https://example.com/v3"),
+		SwitchAt: &at,
+		Title:    str("New"),
+		Tags:     &[]string{"Promo"},
+	})
+	if err != nil {
+		t.Fatalf("UpdateLink() error = %v", err)
+	}
+	if link.OriginalURL != "https://example.com/v2" || link.NextURL != "https://example.com/v3" ||
+		link.Title != "New" || len(link.Tags) != 1 || link.Tags[0] != "promo" {
+		t.Errorf("UpdateLink() = %+v", link)
+	}
+	if revisions, _ := svc.Revisions(ctx, "", code); len(revisions) != 1 || revisions[0].OriginalURL != "https://example.com/v1" {
+		t.Errorf("Revisions() = %+v, want v1", revisions)
+	}
+
+	// Все изменение записывается в журнал одним событием
+	page, err := svc.Audit(ctx, AuditOptions{Action: AuditUpdate})
+	if err != nil || len(page.Entries) != 1 {
+		t.Fatalf("Audit(update) = %+v, %v, want one entry", page, err)
+	}
+	if e := page.Entries[0]; !strings.Contains(string(e.Before), "/v1") || !strings.Contains(string(e.After), "/v2") ||
+		!strings.Contains(string(e.After), "/v3") || !strings.Contains(string(e.After), `"promo"`) {
+		t.Errorf("audit before = %s, after = %s", e.Before, e.After)
+	}
+
+	// Изменение без отличий ничего не записывает
+	if _, err := svc.UpdateLink(ctx, "", code, LinkUpdate{URL: str("https://example.com/v2")}); err != nil {
+		t.Fatalf("UpdateLink() unchanged error = %v", err)
+	}
+	if page, _ := svc.Audit(ctx, AuditOptions{Action: AuditUpdate}); len(page.Entries) != 1 {
+		t.Errorf("Audit(update) after no-op = %d entries, want 1", len(page.Entries))
+	}
+}
+
+func TestShortener_SoftDelete(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	svc := New(store, Config{BaseURL: "http://localhost:8080", RestoreWindow: time.Hour})
+	as := func(name string, role Role) context.Context {
+		return WithPrincipal(context.Background(), Principal{Workspace: "team", KeyName: name, Role: role})
+	}
+	alice, bob := as("alice", RoleEditor), as("bob", RoleEditor)
+
+	result, _ := svc.Shorten(alice, "https://example.com/deleted")
+	code := result.ShortCode
+	if err := svc.Delete(alice, "", code); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	if _, err := svc.Resolve(alice, ResolveRequest{Code: code}); err != ErrLinkDeleted {
+		t.Errorf("Resolve() error = %v, want %v", err, ErrLinkDeleted)
+	}
+	if _, err := svc.Lookup(alice, "", code); err != ErrCodeNotFound {
+		t.Errorf("Lookup() error = %v, want %v", err, ErrCodeNotFound)
+	}
+	if page, _ := svc.List(alice, ListOptions{Status: StatusDeleted}); len(page.Links) != 1 || page.Links[0].DeletedAt == nil {
+		t.Errorf("List(deleted) = %+v, want the deleted link", page.Links)
+	}
+	// Тот же адрес сокращается в новый код, код удаленной ссылки не выдается
+	if again, _ := svc.Shorten(alice, "https://example.com/deleted"); !again.IsNew || again.ShortCode == code {
+		t.Errorf("Shorten() after delete = %+v, want a new code", again)
+	}
+
+	if _, err := svc.Restore(bob, "", code); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Restore() as other editor error = %v, want %v", err, ErrForbidden)
+	}
+	link, err := svc.Restore(alice, "", code)
+	if err != nil || link.DeletedAt != nil {
+		t.Fatalf("Restore() = %+v, %v", link, err)
+	}
+	if res, err := svc.Resolve(alice, ResolveRequest{Code: code}); err != nil || res.URL != "https://example.com/deleted" {
+		t.Errorf("Resolve() after restore = %+v, %v", res, err)
+	}
+	if _, err := svc.Restore(alice, "", code); err != ErrCodeNotFound {
+		t.Errorf("Restore() of active link error = %v, want %v", err, ErrCodeNotFound)
+	}
+	entries, _ := svc.History(alice, "", code, AuditOptions{Action: AuditRestore})
+	if len(entries.Entries) != 1 || entries.Entries[0].Before != nil {
+		t.Errorf("History(restore) = %+v", entries.Entries)
+	}
+
+	// Срок восстановления прошел, но ссылка еще не удалена окончательно
+	deletedAt := time.Now().Add(-2 * time.Hour)
+	store.Save(context.Background(), storage.URL{
+		Workspace:   "team",
+		ShortCode:   "oldtrash01",
+		OriginalURL: "https://example.com/old",
+		CreatedAt:   time.Now().Add(-3 * time.Hour),
+		CreatedBy:   "alice",
+		DeletedAt:   &deletedAt,
+		Custom:      true,
+	})
+	if _, err := svc.Restore(alice, "", "oldtrash01"); err != ErrRestoreExpired {
+		t.Errorf("Restore() after window error = %v, want %v", err, ErrRestoreExpired)
+	}
+
+	// Окончательно удаляются только ссылки с истекшим сроком восстановления
+	svc.Delete(alice, "", code)
+	if n, err := svc.PurgeDeleted(context.Background(), time.Now()); err != nil || n != 1 {
+		t.Fatalf("PurgeDeleted() = %d, %v, want 1", n, err)
+	}
+	if n, _ := svc.PurgeDeleted(context.Background(), time.Now().Add(2*time.Hour)); n != 1 {
+		t.Errorf("PurgeDeleted(later) = %d, want 1", n)
+	}
+	if _, err := svc.Resolve(alice, ResolveRequest{Code: code}); err != ErrLinkDeleted {
+		t.Errorf("Resolve() after purge error = %v, want %v", err, ErrLinkDeleted)
+	}
+	if _, err := svc.Restore(alice, "", code); err != ErrRestoreExpired {
+		t.Errorf("Restore() after purge error = %v, want %v", err, ErrRestoreExpired)
+	}
+	for i := 0; i < 3; i++ {
+		if again, _ := svc.ShortenWithOptions(alice, "https://example.com/deleted", ShortenOptions{Labels: Labels{Note: "new"}}); again.ShortCode == code {
+			t.Fatalf("Shorten() after purge reissued code %s", code)
+		}
+	}
+}
+
+func TestShortener_DeliveryRetention(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	store := storage.NewMemoryStorage()
+	svc := New(store, Config{BaseURL: "http://localhost:8080", WebhookRetention: time.Hour, WebhookAllowPrivate: true})
+	admin := WithPrincipal(context.Background(), Principal{Workspace: "team", KeyName: "ops", Role: RoleAdmin})
+	result, _ := svc.Shorten(admin, "https://example.com/retention")
+	resolve := func() {
+		t.Helper()
+		if _, err := svc.Resolve(context.Background(), ResolveRequest{Code: result.ShortCode}); err != nil {
+			t.Fatalf("Resolve() error = %v", err)
+		}
+	}
+
+	// Переход без подписки на link.clicked в очередь не попадает
+	resolve()
+	if queued, _ := store.ListDeliveries(context.Background(), storage.DeliveryQuery{Workspace: "team"}); len(queued) != 0 {
+		t.Fatalf("deliveries without webhooks = %+v, want none", queued)
+	}
+	// Новая подписка получает переходы сразу
+	if _, err := svc.CreateWebhook(admin, receiver.URL, []string{EventLinkClicked}, ""); err != nil {
+		t.Fatalf("CreateWebhook() error = %v", err)
+	}
+	resolve()
+	now := time.Now()
+	if n, err := svc.DeliverWebhooks(context.Background(), now); err != nil || n != 1 {
+		t.Fatalf("DeliverWebhooks() = %d, %v, want 1", n, err)
+	}
+
+	if n, _ := svc.PurgeDeliveries(context.Background(), now); n != 0 {
+		t.Errorf("PurgeDeliveries() within retention = %d, want 0", n)
+	}
+	if n, err := svc.PurgeDeliveries(context.Background(), now.Add(2*time.Hour)); err != nil || n != 1 {
+		t.Errorf("PurgeDeliveries() after retention = %d, %v, want 1", n, err)
+	}
+
+	// Без срока хранения доставки не удаляются
+	svc = New(store, Config{BaseURL: "http://localhost:8080"})
+	resolve()
+	svc.DeliverWebhooks(context.Background(), now)
+	if n, _ := svc.PurgeDeliveries(context.Background(), now.Add(24*time.Hour)); n != 0 {
+		t.Errorf("PurgeDeliveries() without retention = %d, want 0", n)
+	}
+}
+
+func TestShortener_Webhooks(t *testing.T) {
+	// Получатель проверяет подпись и запоминает события
+	var (
+		mu       sync.Mutex
+		received []storage.Event
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := WebhookSignature("topsecret", r.Header.Get("X-Webhook-Timestamp"), body); got != r.Header.Get("X-Webhook-Signature") {
+			t.Errorf("signature = %q, want %q", r.Header.Get("X-Webhook-Signature"), got)
+		}
+		var e storage.Event
+		if err := json.Unmarshal(body, &e); err != nil || e.Type != r.Header.Get("X-Webhook-Event") {
+			t.Errorf("payload = %s, error = %v", body, err)
+		}
+		mu.Lock()
+		received = append(received, e)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	store := storage.NewMemoryStorage()
+	svc := New(store, Config{BaseURL: "http://localhost:8080", WebhookMaxAttempts: 2, WebhookBackoff: time.Minute, WebhookAllowPrivate: true})
+	as := func(workspace string, role Role) context.Context {
+		return WithPrincipal(context.Background(), Principal{Workspace: workspace, KeyName: "ops", Role: role})
+	}
+	admin, other := as("team", RoleAdmin), as("other", RoleAdmin)
+
+	if _, err := svc.CreateWebhook(as("team", RoleEditor), receiver.URL, nil, ""); !errors.Is(err, ErrForbidden) {
+		t.Errorf("CreateWebhook() as editor error = %v, want %v", err, ErrForbidden)
+	}
+	for name, events := range map[string][]string{"ftp://crm.example/": nil, receiver.URL: {"link.renamed"}} {
+		if _, err := svc.CreateWebhook(admin, name, events, ""); err != ErrInvalidWebhook {
+			t.Errorf("CreateWebhook(%s, %v) error = %v, want %v", name, events, err, ErrInvalidWebhook)
+		}
+	}
+	generated, err := svc.CreateWebhook(admin, receiver.URL+"/unused", []string{EventLinkRestored}, "")
+	if err != nil || len(generated.Secret) != 2*secretBytes {
+		t.Fatalf("CreateWebhook() = %+v, %v, want a generated secret", generated, err)
+	}
+	hook, err := svc.CreateWebhook(admin, receiver.URL, nil, "topsecret")
+	if err != nil {
+		t.Fatalf("CreateWebhook() error = %v", err)
+	}
+	dead, _ := svc.CreateWebhook(admin, failing.URL, []string{EventLinkDeleted}, "")
+	if hooks, _ := svc.Webhooks(admin); len(hooks) != 3 || hooks[1].ID != hook.ID || hooks[1].Secret != "" {
+		t.Errorf("Webhooks() = %+v, want 3 without secrets", hooks)
+	}
+	if hooks, _ := svc.Webhooks(other); len(hooks) != 0 {
+		t.Errorf("Webhooks(other) = %+v, want none", hooks)
+	}
+
+	// Создание и переход доставляются подписке на все события
+	result, _ := svc.Shorten(admin, "https://example.com/crm")
+	if _, err := svc.Resolve(context.Background(), ResolveRequest{Code: result.ShortCode}); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	now := time.Now()
+	if n, err := svc.DeliverWebhooks(context.Background(), now); err != nil || n != 2 {
+		t.Fatalf("DeliverWebhooks() = %d, %v, want 2", n, err)
+	}
+	events := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		var types []string
+		for _, e := range received {
+			types = append(types, e.Type)
+		}
+		slices.Sort(types)
+		return types
+	}
+	if got := events(); !slices.Equal(got, []string{EventLinkClicked, EventLinkCreated}) {
+		t.Errorf("received = %v", got)
+	}
+	for _, e := range received {
+		if e.Type == EventLinkClicked && e.Destination != "https://example.com/crm" {
+			t.Errorf("click event = %+v", e)
+		}
+	}
+
+	// Истечение срока записывается один раз
+	expiredAt := now.Add(-time.Minute)
+	store.Save(context.Background(), storage.URL{
+		Workspace:   "team",
+		ShortCode:   "expiring01",
+		OriginalURL: "https://example.com/expiring",
+		CreatedAt:   now.Add(-time.Hour),
+		ExpiresAt:   &expiredAt,
+	})
+	if n, err := svc.NotifyExpirations(context.Background(), now); err != nil || n != 1 {
+		t.Fatalf("NotifyExpirations() = %d, %v, want 1", n, err)
+	}
+	if n, _ := svc.NotifyExpirations(context.Background(), now); n != 0 {
+		t.Errorf("NotifyExpirations() again = %d, want 0", n)
+	}
+	if page, _ := svc.Audit(admin, AuditOptions{Action: AuditExpire}); len(page.Entries) != 1 || page.Entries[0].ShortCode != "expiring01" {
+		t.Errorf("Audit(expire) = %+v", page.Entries)
+	}
+
+	// Неудачная доставка повторяется после паузы и после попыток попадает в неотправленные
+	if err := svc.Delete(admin, "", result.ShortCode); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	now = time.Now()
+	if n, _ := svc.DeliverWebhooks(context.Background(), now); n != 2 {
+		t.Errorf("DeliverWebhooks() = %d, want 2", n)
+	}
+	if got := events(); !slices.Equal(got, []string{EventLinkClicked, EventLinkCreated, EventLinkDeleted, EventLinkExpired}) {
+		t.Errorf("received = %v", got)
+	}
+	pending, _ := svc.Deliveries(admin, DeliveryOptions{WebhookID: dead.ID, Status: DeliveryPending})
+	if len(pending.Deliveries) != 1 || pending.Deliveries[0].Attempts != 1 || pending.Deliveries[0].LastStatus != http.StatusInternalServerError ||
+		!pending.Deliveries[0].NextAttemptAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Deliveries(pending) = %+v", pending.Deliveries)
+	}
+	if n, _ := svc.DeliverWebhooks(context.Background(), now.Add(30*time.Second)); n != 0 {
+		t.Errorf("DeliverWebhooks() before backoff = %d, want 0", n)
+	}
+	svc.DeliverWebhooks(context.Background(), now.Add(time.Minute))
+	failed, _ := svc.Deliveries(admin, DeliveryOptions{Status: DeliveryDead})
+	if len(failed.Deliveries) != 1 || failed.Deliveries[0].Attempts != 2 || failed.Deliveries[0].NextAttemptAt != nil {
+		t.Fatalf("Deliveries(dead) = %+v", failed.Deliveries)
+	}
+	id := failed.Deliveries[0].ID
+	if err := svc.RetryDelivery(other, id); err != ErrDeliveryNotFound {
+		t.Errorf("RetryDelivery(other) error = %v, want %v", err, ErrDeliveryNotFound)
+	}
+	if err := svc.RetryDelivery(admin, id); err != nil {
+		t.Fatalf("RetryDelivery() error = %v", err)
+	}
+	if page, _ := svc.Deliveries(admin, DeliveryOptions{Status: DeliveryDead}); len(page.Deliveries) != 0 {
+		t.Errorf("Deliveries(dead) after retry = %+v, want none", page.Deliveries)
+	}
+
+	all, _ := svc.Deliveries(admin, DeliveryOptions{Limit: 2})
+	if len(all.Deliveries) != 2 || all.NextCursor == "" {
+		t.Fatalf("Deliveries(limit 2) = %+v", all)
+	}
+	next, _ := svc.Deliveries(admin, DeliveryOptions{Limit: 2, Cursor: all.NextCursor})
+	if len(next.Deliveries) != 2 || next.Deliveries[0].ID >= all.Deliveries[1].ID {
+		t.Errorf("Deliveries(next page) = %+v", next.Deliveries)
+	}
+	if _, err := svc.Deliveries(admin, DeliveryOptions{Status: "failed"}); err != ErrInvalidListQuery {
+		t.Errorf("Deliveries(invalid status) error = %v, want %v", err, ErrInvalidListQuery)
+	}
+
+	if err := svc.DeleteWebhook(other, hook.ID); err != ErrWebhookNotFound {
+		t.Errorf("DeleteWebhook(other) error = %v, want %v", err, ErrWebhookNotFound)
+	}
+	if err := svc.DeleteWebhook(admin, hook.ID); err != nil {
+		t.Fatalf("DeleteWebhook() error = %v", err)
+	}
+	if page, _ := svc.Deliveries(admin, DeliveryOptions{WebhookID: hook.ID}); len(page.Deliveries) != 0 {
+		t.Errorf("Deliveries() of deleted webhook = %+v, want none", page.Deliveries)
+	}
+}
+
+func TestShortener_WebhookPrivateAddress(t *testing.T) {
+	var received int
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { received++ }))
+	defer receiver.Close()
+
+	store := storage.NewMemoryStorage()
+	svc := New(store, Config{BaseURL: "http://localhost:8080"})
+	admin := WithPrincipal(context.Background(), Principal{Workspace: "team", KeyName: "ops", Role: RoleAdmin})
+
+	for _, target := range []string{
+		receiver.URL,
+		"http://localhost:9000/hook",
+		"http://10.0.0.5/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://[::ffff:192.168.1.1]/hook",
+		"http://100.64.0.1/hook",
+	} {
+		if _, err := svc.CreateWebhook(admin, target, nil, ""); err != ErrInvalidWebhook {
+			t.Errorf("CreateWebhook(%s) error = %v, want %v", target, err, ErrInvalidWebhook)
+		}
+	}
+	if _, err := svc.CreateWebhook(admin, "https://hooks.example.com/crm", nil, ""); err != nil {
+		t.Errorf("CreateWebhook(public) error = %v", err)
+	}
+
+	// Имя, которое к моменту доставки ведет во внутреннюю сеть, отклоняется при соединении
+	store.CreateWebhook(context.Background(), storage.Webhook{Workspace: "team", URL: receiver.URL, CreatedAt: time.Now()})
+	svc.Shorten(admin, "https://example.com/private")
+	svc.DeliverWebhooks(context.Background(), time.Now())
+	if received != 0 {
+		t.Errorf("receiver got %d requests, want none", received)
+	}
+	page, _ := svc.Deliveries(admin, DeliveryOptions{Status: DeliveryPending})
+	var blocked bool
+	for _, d := range page.Deliveries {
+		blocked = blocked || strings.Contains(d.LastError, errBlockedAddress.Error())
+	}
+	if !blocked {
+		t.Errorf("Deliveries() = %+v, want a blocked delivery", page.Deliveries)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{20, maxBackoff},
+		{1000, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(time.Minute, tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(1m, %d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestShortener_ExportImport(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{BaseURL: "http://localhost:8080", Domains: []string{"go.example"}}
+	src := New(storage.NewMemoryStorage(), cfg)
+
+	plain, _ := src.Shorten(ctx, "https://example.com/plain")
+	custom, err := src.ShortenWithOptions(ctx, "https://example.com/custom", ShortenOptions{
+		Domain:    "go.example",
+		Password:  "secret",
+		MaxClicks: 5,
+	})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	w, _ := transfer.NewWriter(&buf, transfer.FormatJSONL)
+	n, err := src.Export(ctx, src.Domains(), w)
+	if err != nil || n != 2 {
+		t.Fatalf("Export() = %d, %v, want 2 records", n, err)
+	}
+	data := buf.String()
+
+	importAll := func(t *testing.T, svc *Shortener, opts ImportOptions) (*ImportReport, error) {
+		t.Helper()
+		r, _ := transfer.NewReader(strings.NewReader(data), transfer.FormatJSONL)
+		return svc.Import(ctx, r, opts)
+	}
+
+	dstStore := storage.NewMemoryStorage()
+	dst := New(dstStore, cfg)
+
+	t.Run("dry run does not save", func(t *testing.T) {
+		report, err := importAll(t, dst, ImportOptions{DryRun: true})
+		if err != nil {
+			t.Fatalf("Import() error = %v", err)
+		}
+		if report.Created != 2 || dstStore.Len() != 0 {
+			t.Errorf("report = %+v, stored = %d", report, dstStore.Len())
+		}
+	})
+
+	t.Run("preserves codes", func(t *testing.T) {
+		report, err := importAll(t, dst, ImportOptions{})
+		if err != nil {
+			t.Fatalf("Import() error = %v", err)
+		}
+		if report.Created != 2 {
+			t.Errorf("report = %+v", report)
+		}
+		link, err := dst.Lookup(ctx, "go.example", custom.ShortCode)
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		if !link.Protected || *link.ClicksLeft != 5 {
+			t.Errorf("imported link = %+v", link)
+		}
+		res, err := dst.Resolve(ctx, ResolveRequest{Code: plain.ShortCode})
+		if err != nil || res.URL != "https://example.com/plain" {
+			t.Errorf("Resolve() = %+v, %v", res, err)
+		}
+	})
+
+	t.Run("conflict policies", func(t *testing.T) {
+		report, err := importAll(t, dst, ImportOptions{Conflict: ConflictSkip})
+		if err != nil || report.Skipped != 2 || len(report.Errors) != 2 {
+			t.Errorf("skip: report = %+v, err = %v", report, err)
+		}
+		report, err = importAll(t, dst, ImportOptions{Conflict: ConflictOverwrite})
+		if err != nil || report.Overwritten != 2 {
+			t.Errorf("overwrite: report = %+v, err = %v", report, err)
+		}
+		if dstStore.Len() != 2 {
+			t.Errorf("stored = %d, want 2", dstStore.Len())
+		}
+		if _, err := importAll(t, dst, ImportOptions{Conflict: ConflictFail}); !errors.Is(err, ErrImportConflict) {
+			t.Errorf("fail: error = %v, want %v", err, ErrImportConflict)
+		}
+		if _, err := importAll(t, dst, ImportOptions{Conflict: "merge"}); err != ErrInvalidConflict {
+			t.Errorf("unknown policy: error = %v, want %v", err, ErrInvalidConflict)
+		}
+	})
+
+	t.Run("invalid records", func(t *testing.T) {
+		data := `{"short_code":"bad","original_url":"https://example.com/x"}
+{"short_code":"aB3xY9kL2m","original_url":"javascript:alert(1)"}
+{"short_code":"aB3xY9kL2m","original_url":"https://example.com/x","domain":"unknown.example"}
+{"short_code":"preview","original_url":"https://example.com/x"}
+not json
+`
+		r, _ := transfer.NewReader(strings.NewReader(data), transfer.FormatJSONL)
+		report, err := dst.Import(ctx, r, ImportOptions{})
+		if !errors.Is(err, ErrInvalidImport) {
+			t.Errorf("Import() error = %v, want %v", err, ErrInvalidImport)
+		}
+		if report.Invalid != 4 || report.Errors[1].Line != 2 || report.Errors[3].Line != 4 {
+			t.Errorf("report = %+v", report)
+		}
+	})
+}
+
+func TestShortener_ExportImportDeleted(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{BaseURL: "http://localhost:8080"}
+	srcStore := storage.NewMemoryStorage()
+	src := New(srcStore, cfg)
+
+	live, _ := src.Shorten(ctx, "https://example.com/live")
+	deleted, _ := src.Shorten(ctx, "https://example.com/deleted")
+	purged, _ := src.Shorten(ctx, "https://example.com/purged")
+	src.Delete(ctx, "", purged.ShortCode)
+	srcStore.Purge(ctx, time.Now().Add(time.Hour), 0)
+	src.Delete(ctx, "", deleted.ShortCode)
+
+	var buf bytes.Buffer
+	w, _ := transfer.NewWriter(&buf, transfer.FormatJSONL)
+	n, err := src.Export(ctx, src.Domains(), w)
+	if err != nil || n != 3 {
+		t.Fatalf("Export() = %d, %v, want 3 records", n, err)
+	}
+
+	dstStore := storage.NewMemoryStorage()
+	dst := New(dstStore, cfg)
+	r, _ := transfer.NewReader(&buf, transfer.FormatJSONL)
+	report, err := dst.Import(ctx, r, ImportOptions{})
+	if err != nil || report.Created != 2 || report.Tombstones != 1 {
+		t.Fatalf("Import() = %+v, %v", report, err)
+	}
+
+	if _, err := dst.Lookup(ctx, "", live.ShortCode); err != nil {
+		t.Errorf("Lookup(live) error = %v", err)
+	}
+	// Удаленная ссылка переносится удаленной, ее можно восстановить
+	if _, err := dst.Restore(ctx, "", deleted.ShortCode); err != nil {
+		t.Errorf("Restore(deleted) error = %v", err)
+	}
+	// Код окончательно удаленной ссылки остается закрытым
+	if _, err := dstStore.GetByCode(ctx, "", purged.ShortCode); !errors.Is(err, storage.ErrDeleted) {
+		t.Errorf("GetByCode(purged) error = %v, want %v", err, storage.ErrDeleted)
+	}
+	err = dstStore.Save(ctx, storage.URL{ShortCode: purged.ShortCode, OriginalURL: "https://example.com/x", CreatedAt: time.Now()})
+	if !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Errorf("Save(purged code) error = %v, want %v", err, storage.ErrAlreadyExists)
 	}
 }
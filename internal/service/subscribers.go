@@ -0,0 +1,65 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// subscriberTTL - сколько используется загруженный список пространств с подпиской.
+// Подписка, созданная другим экземпляром сервиса, начинает получать переходы
+// не позже чем через это время
+const subscriberTTL = 10 * time.Second
+
+// subscriberCache хранит пространства, подписанные на переходы по ссылкам,
+// чтобы переход без подписчиков не обращался к хранилищу
+type subscriberCache struct {
+	mu         sync.RWMutex
+	workspaces map[string]struct{}
+	loadedAt   time.Time // Нулевое время - список нужно загрузить
+	now        func() time.Time
+}
+
+func newSubscriberCache() *subscriberCache {
+	return &subscriberCache{now: time.Now}
+}
+
+// subscribed сообщает, есть ли в пространстве подписка. Устаревший список
+// заново загружается через load
+func (c *subscriberCache) subscribed(workspace string, load func() ([]string, error)) (bool, error) {
+	c.mu.RLock()
+	_, ok := c.workspaces[workspace]
+	fresh := c.fresh()
+	c.mu.RUnlock()
+	if fresh {
+		return ok, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Список мог загрузить другой переход, пока ожидалась блокировка
+	if !c.fresh() {
+		list, err := load()
+		if err != nil {
+			return false, err
+		}
+		c.workspaces = make(map[string]struct{}, len(list))
+		for _, w := range list {
+			c.workspaces[w] = struct{}{}
+		}
+		c.loadedAt = c.now()
+	}
+	_, ok = c.workspaces[workspace]
+	return ok, nil
+}
+
+func (c *subscriberCache) fresh() bool {
+	return !c.loadedAt.IsZero() && c.now().Sub(c.loadedAt) < subscriberTTL
+}
+
+// invalidate сбрасывает список после изменения подписок
+func (c *subscriberCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loadedAt = time.Time{}
+}
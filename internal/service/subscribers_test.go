@@ -0,0 +1,52 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSubscriberCache(t *testing.T) {
+	now := time.Now()
+	c := newSubscriberCache()
+	c.now = func() time.Time { return now }
+
+	loads := 0
+	workspaces := []string{"team"}
+	load := func() ([]string, error) {
+		loads++
+		return workspaces, nil
+	}
+
+	if ok, err := c.subscribed("team", load); !ok || err != nil {
+		t.Fatalf("subscribed(team) = %v, %v, want true", ok, err)
+	}
+	if ok, _ := c.subscribed("other", load); ok {
+		t.Error("subscribed(other) = true, want false")
+	}
+	if loads != 1 {
+		t.Errorf("loads = %d, want 1", loads)
+	}
+
+	// Список перезагружается после subscriberTTL и после изменения подписок
+	workspaces = []string{"other"}
+	now = now.Add(subscriberTTL)
+	if ok, _ := c.subscribed("other", load); !ok || loads != 2 {
+		t.Errorf("subscribed(other) after TTL = %v, loads = %d", ok, loads)
+	}
+	workspaces = nil
+	c.invalidate()
+	if ok, _ := c.subscribed("other", load); ok || loads != 3 {
+		t.Errorf("subscribed(other) after invalidate = %v, loads = %d", ok, loads)
+	}
+
+	// Ошибка загрузки не запоминается
+	c.invalidate()
+	failure := errors.New("storage unavailable")
+	if _, err := c.subscribed("team", func() ([]string, error) { return nil, failure }); err != failure {
+		t.Errorf("subscribed() error = %v, want %v", err, failure)
+	}
+	if ok, _ := c.subscribed("team", load); ok || loads != 4 {
+		t.Errorf("subscribed() after error = %v, loads = %d", ok, loads)
+	}
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+)
+
+const maxTargets = 16 // Максимальное кол-во вариантов адреса у одной ссылки
+
+// Target - вариант адреса назначения ссылки с A/B ротацией
+type Target struct {
+	URL    string
+	Weight int   // Доля трафика относительно суммы весов; 0 - вариант выключен
+	Clicks int64 // Число переходов на вариант
+}
+
+// validateTargets проверяет адреса и веса вариантов
+func (s *Shortener) validateTargets(targets []Target) error {
+	if len(targets) > maxTargets {
+		return ErrInvalidTargets
+	}
+	weights := make([]int, len(targets))
+	for i, t := range targets {
+		if s.validateURL(t.URL) != nil {
+			return ErrInvalidTargets
+		}
+		weights[i] = t.Weight
+	}
+	return validateWeights(weights)
+}
+
+// validateWeights проверяет, что веса неотрицательны и хотя бы один вариант включен
+func validateWeights(weights []int) error {
+	total := 0
+	for _, w := range weights {
+		if w < 0 {
+			return ErrInvalidTargets
+		}
+		total += w
+	}
+	if total == 0 {
+		return ErrInvalidTargets
+	}
+	return nil
+}
+
+// pickVariant выбирает вариант (с нуля) пропорционально весам. Закрепленный
+// за посетителем вариант (с единицы) сохраняется, пока он существует и включен
+func pickVariant(targets []storage.Target, sticky int) int {
+	if i := sticky - 1; i >= 0 && i < len(targets) && targets[i].Weight > 0 {
+		return i
+	}
+
+	total := 0
+	for _, t := range targets {
+		total += t.Weight
+	}
+	if total <= 0 {
+		return 0
+	}
+
+	n := rand.IntN(total)
+	for i, t := range targets {
+		if n < t.Weight {
+			return i
+		}
+		n -= t.Weight
+	}
+	return len(targets) - 1
+}
+
+// SetWeights меняет веса вариантов ссылки без пересоздания ссылки.
+// Число весов должно совпадать с числом вариантов
+func (s *Shortener) SetWeights(ctx context.Context, domain, code string, weights []int) (_ *Link, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.SetWeights",
+		trace.WithAttributes(attribute.String("shortener.code", code)),
+	)
+	defer func() { finishSpan(span, err) }()
+
+	link, err := s.Lookup(ctx, domain, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(ctx, ActionUpdate, link.CreatedBy); err != nil {
+		return nil, err
+	}
+	if len(link.Targets) == 0 || len(weights) != len(link.Targets) {
+		return nil, ErrInvalidTargets
+	}
+	if err := validateWeights(weights); err != nil {
+		return nil, err
+	}
+
+	after := *link
+	after.Targets = append([]Target(nil), link.Targets...)
+	for i, w := range weights {
+		after.Targets[i].Weight = w
+	}
+	if err := s.storage.SetWeights(audited(ctx, AuditUpdate, link, &after), link.Domain, code, weights); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrCodeNotFound
+		}
+		return nil, fmt.Errorf("setting weights: %w", err)
+	}
+
+	return s.Lookup(ctx, link.Domain, code)
+}
+
+// toStorageTargets переводит варианты из запроса в записи хранилища без счетчиков
+func toStorageTargets(targets []Target) []storage.Target {
+	if len(targets) == 0 {
+		return nil
+	}
+	result := make([]storage.Target, len(targets))
+	for i, t := range targets {
+		result[i] = storage.Target{URL: t.URL, Weight: t.Weight}
+	}
+	return result
+}
+
+func fromStorageTargets(targets []storage.Target) []Target {
+	if len(targets) == 0 {
+		return nil
+	}
+	result := make([]Target, len(targets))
+	for i, t := range targets {
+		result[i] = Target{URL: t.URL, Weight: t.Weight, Clicks: t.Clicks}
+	}
+	return result
+}
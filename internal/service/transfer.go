@@ -0,0 +1,396 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/BuzzLyutic/url-shortener/internal/shortcode"
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+	"github.com/BuzzLyutic/url-shortener/internal/transfer"
+)
+
+// Политики разрешения конфликтов импорта: код ссылки уже занят
+const (
+	ConflictSkip      = "skip"      // Оставить существующую ссылку (по умолчанию)
+	ConflictOverwrite = "overwrite" // Заменить существующую ссылку импортируемой
+	ConflictFail      = "fail"      // Остановить импорт
+)
+
+const (
+	exportBatchSize = 500 // Размер страницы выборки при выгрузке
+	maxImportErrors = 100 // Сколько ошибок отдельных записей попадает в отчет
+)
+
+// ImportOptions - параметры импорта ссылок
+type ImportOptions struct {
+	Conflict string // Политика конфликтов (Conflict*); пусто - ConflictSkip
+	DryRun   bool   // Только проверить записи, ничего не сохраняя
+}
+
+// ImportReport - итог импорта. При DryRun счетчики показывают, что было бы сделано
+type ImportReport struct {
+	DryRun      bool
+	Total       int // Прочитано записей
+	Created     int
+	Overwritten int
+	Skipped     int // Пропущено из-за конфликтов
+	Invalid     int // Отклонено при проверке
+	Tombstones  int // Закрыто кодов окончательно удаленных ссылок
+	Errors      []ImportError
+}
+
+// ImportError описывает отклоненную или пропущенную запись
+type ImportError struct {
+	Line      int
+	ShortCode string
+	Message   string
+}
+
+func (r *ImportReport) addError(line int, code string, err error) {
+	if len(r.Errors) < maxImportErrors {
+		r.Errors = append(r.Errors, ImportError{Line: line, ShortCode: code, Message: err.Error()})
+	}
+}
+
+// Domains возвращает все короткие домены сервиса: основной (пустая строка)
+// и дополнительные в алфавитном порядке
+func (s *Shortener) Domains() []string {
+	domains := make([]string, 0, len(s.domains)+1)
+	for d := range s.domains {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	return append([]string{""}, domains...)
+}
+
+// Export выгружает ссылки доменов рабочего пространства запроса в порядке создания: сначала
+// действующие, затем удаленные, затем закрытые коды окончательно удаленных ссылок.
+// Записи читаются из хранилища страницами и сразу пишутся в w, не накапливаясь в памяти
+func (s *Shortener) Export(ctx context.Context, domains []string, w transfer.Writer) (n int, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.Export")
+	defer func() {
+		span.SetAttributes(attribute.Int("shortener.links", n))
+		finishSpan(span, err)
+	}()
+
+	if err := authorize(ctx, ActionExport, ""); err != nil {
+		return 0, err
+	}
+	// Домены проверяются до начала записи, чтобы ошибка не оборвала выгрузку
+	normalized := make([]string, len(domains))
+	for i, domain := range domains {
+		if normalized[i], err = s.normalizeDomain(domain); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, domain := range normalized {
+		// Удаленные ссылки List выбирает только отдельным запросом
+		for _, status := range []string{"", storage.StatusDeleted} {
+			q := storage.ListQuery{Workspace: workspaceOf(ctx), Domain: domain, Status: status, Limit: exportBatchSize}
+			if err := s.exportLinks(ctx, q, w, &n); err != nil {
+				return n, err
+			}
+		}
+		if err := s.exportTombstones(ctx, domain, w, &n); err != nil {
+			return n, err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return n, fmt.Errorf("writing records: %w", err)
+	}
+	return n, nil
+}
+
+// exportLinks пишет в w все ссылки выборки q и увеличивает счетчик n
+func (s *Shortener) exportLinks(ctx context.Context, q storage.ListQuery, w transfer.Writer, n *int) error {
+	for {
+		urls, err := s.storage.List(ctx, q)
+		if err != nil {
+			return fmt.Errorf("listing URLs: %w", err)
+		}
+		for _, u := range urls {
+			if err := w.Write(u); err != nil {
+				return fmt.Errorf("writing record: %w", err)
+			}
+			*n++
+		}
+		if len(urls) < q.Limit {
+			return nil
+		}
+		after := storage.CursorOf(urls[len(urls)-1])
+		q.After = &after
+	}
+}
+
+// exportTombstones пишет в w закрытые коды окончательно удаленных ссылок домена
+// как записи с deleted_at без адреса и увеличивает счетчик n
+func (s *Shortener) exportTombstones(ctx context.Context, domain string, w transfer.Writer, n *int) error {
+	q := storage.TombstoneQuery{Workspace: workspaceOf(ctx), Domain: domain, Limit: exportBatchSize}
+	for {
+		tombstones, err := s.storage.Tombstones(ctx, q)
+		if err != nil {
+			return fmt.Errorf("listing tombstones: %w", err)
+		}
+		for _, t := range tombstones {
+			u := &storage.URL{Domain: t.Domain, ShortCode: t.ShortCode, CreatedAt: t.DeletedAt, DeletedAt: &t.DeletedAt}
+			if err := w.Write(u); err != nil {
+				return fmt.Errorf("writing record: %w", err)
+			}
+			*n++
+		}
+		if len(tombstones) < q.Limit {
+			return nil
+		}
+		q.After = &tombstones[len(tombstones)-1]
+	}
+}
+
+// Import загружает ссылки в рабочее пространство запроса с сохранением их коротких
+// кодов. Удаленные ссылки загружаются удаленными, а записи без адреса с deleted_at
+// закрывают коды окончательно удаленных ссылок. Некорректные записи пропускаются
+// и попадают в отчет; ошибка формата файла и исчерпание квоты пространства останавливают импорт.
+// Отчет возвращается и вместе с ошибкой: в нем учтены уже обработанные записи
+func (s *Shortener) Import(ctx context.Context, r transfer.Reader, opts ImportOptions) (_ *ImportReport, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.Import")
+	defer func() { finishSpan(span, err) }()
+
+	switch opts.Conflict {
+	case "":
+		opts.Conflict = ConflictSkip
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+	default:
+		return nil, ErrInvalidConflict
+	}
+	if err := authorize(ctx, ActionImport, ""); err != nil {
+		return nil, err
+	}
+	// Перезапись может затронуть чужие ссылки
+	if opts.Conflict == ConflictOverwrite {
+		if err := authorize(ctx, ActionUpdate, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	workspace := workspaceOf(ctx)
+	quota, err := s.quotaLeft(ctx, workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{DryRun: opts.DryRun}
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("shortener.import.total", report.Total),
+			attribute.Int("shortener.import.created", report.Created),
+			attribute.Int("shortener.import.overwritten", report.Overwritten),
+		)
+	}()
+
+	for {
+		u, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return report, nil
+		}
+		if err != nil {
+			return report, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+		report.Total++
+		line := r.Line()
+
+		u.Workspace = workspace
+		if u.OriginalURL == "" && u.DeletedAt != nil {
+			if err := s.importTombstone(ctx, u, line, opts, report); err != nil {
+				return report, err
+			}
+			continue
+		}
+		if err := s.prepareImport(u); err != nil {
+			report.Invalid++
+			report.addError(line, u.ShortCode, err)
+			continue
+		}
+
+		conflict, err := s.importConflict(ctx, u)
+		if err != nil {
+			return report, err
+		}
+		switch {
+		case conflict == nil:
+			// Удаленная ссылка не учитывается в квоте
+			if u.DeletedAt == nil {
+				if quota == 0 {
+					return report, fmt.Errorf("line %d: %w", line, ErrQuotaExceeded)
+				}
+				quota--
+			}
+			if !opts.DryRun {
+				if err := s.storage.Save(audited(ctx, AuditCreate, nil, s.toLink(u)), *u); err != nil {
+					return report, fmt.Errorf("saving URL: %w", err)
+				}
+			}
+			report.Created++
+		case opts.Conflict == ConflictFail:
+			return report, fmt.Errorf("line %d: %s: %w", line, u.ShortCode, ErrImportConflict)
+		case opts.Conflict == ConflictOverwrite && conflict == ErrImportConflict:
+			if !opts.DryRun {
+				// Истекшая запись возвращается вместе с ошибкой
+				var before *Link
+				if existing, _ := s.storage.GetByCode(ctx, u.Domain, u.ShortCode); existing != nil {
+					before = s.toLink(existing)
+				}
+				if err := s.storage.Replace(audited(ctx, AuditUpdate, before, s.toLink(u)), *u); err != nil {
+					return report, fmt.Errorf("replacing URL: %w", err)
+				}
+			}
+			report.Overwritten++
+		default:
+			report.Skipped++
+			report.addError(line, u.ShortCode, conflict)
+		}
+	}
+}
+
+// importTombstone закрывает код окончательно удаленной ссылки. Уже закрытый код
+// не меняется, а код существующей ссылки - конфликт, который нельзя разрешить перезаписью
+func (s *Shortener) importTombstone(ctx context.Context, u *storage.URL, line int, opts ImportOptions, report *ImportReport) error {
+	domain, err := s.normalizeDomain(u.Domain)
+	if err == nil && !shortcode.IsValid(u.ShortCode) {
+		err = errors.New("invalid short code")
+	}
+	if err != nil {
+		report.Invalid++
+		report.addError(line, u.ShortCode, err)
+		return nil
+	}
+
+	// Код удаленной, но хранящейся ссылки уже закрыт
+	existing, err := s.storage.GetByCode(ctx, domain, u.ShortCode)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrDeleted) {
+		existing, err = nil, nil
+	}
+	if err != nil && existing == nil {
+		return fmt.Errorf("getting URL: %w", err)
+	}
+	if existing == nil && !opts.DryRun {
+		err = s.storage.SaveTombstone(ctx, storage.Tombstone{
+			Workspace: u.Workspace,
+			Domain:    domain,
+			ShortCode: u.ShortCode,
+			DeletedAt: *u.DeletedAt,
+		})
+		if err != nil && !errors.Is(err, storage.ErrAlreadyExists) {
+			return fmt.Errorf("saving tombstone: %w", err)
+		}
+	}
+	if existing != nil || err != nil {
+		if opts.Conflict == ConflictFail {
+			return fmt.Errorf("line %d: %s: %w", line, u.ShortCode, ErrImportConflict)
+		}
+		report.Skipped++
+		report.addError(line, u.ShortCode, errCodeInUse)
+		return nil
+	}
+	report.Tombstones++
+	return nil
+}
+
+// Конфликты, которые нельзя разрешить перезаписью
+var (
+	// Адрес обычной ссылки уже укорочен под другим кодом
+	errOriginalTaken = errors.New("original URL already shortened with another code")
+	// Код занят ссылкой другого рабочего пространства
+	errCodeTaken = errors.New("short code is used by another workspace")
+	// Код принадлежал удаленной ссылке и повторно не выдается
+	errCodeDeleted = errors.New("short code belongs to a deleted link")
+	// Код окончательно удаленной ссылки занят существующей ссылкой
+	errCodeInUse = errors.New("short code of a purged link is used by an existing link")
+)
+
+// importConflict проверяет, занят ли код записи или ее адрес в пространстве и домене.
+// Возвращает причину конфликта или nil
+func (s *Shortener) importConflict(ctx context.Context, u *storage.URL) (conflict error, err error) {
+	if !u.Custom {
+		existing, err := s.storage.GetByOriginalURL(ctx, u.Workspace, u.Domain, u.OriginalURL)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("getting URL: %w", err)
+		}
+		if existing != nil && existing.ShortCode != u.ShortCode {
+			return errOriginalTaken, nil
+		}
+	}
+
+	// Истекшие и еще не активные записи тоже занимают код
+	existing, err := s.storage.GetByCode(ctx, u.Domain, u.ShortCode)
+	if errors.Is(err, storage.ErrDeleted) {
+		return errCodeDeleted, nil
+	}
+	if err != nil && !errors.Is(err, storage.ErrNotFound) &&
+		!errors.Is(err, storage.ErrExpired) && !errors.Is(err, storage.ErrNotYetActive) {
+		return nil, fmt.Errorf("getting URL: %w", err)
+	}
+	if existing != nil && existing.Workspace != u.Workspace {
+		return errCodeTaken, nil
+	}
+	if existing != nil {
+		return ErrImportConflict, nil
+	}
+	return nil, nil
+}
+
+// prepareImport проверяет запись теми же правилами, что и при создании ссылки,
+// и приводит домен к виду, в котором он хранится
+func (s *Shortener) prepareImport(u *storage.URL) error {
+	domain, err := s.normalizeDomain(u.Domain)
+	if err != nil {
+		return err
+	}
+	u.Domain = domain
+
+	if !shortcode.IsValid(u.ShortCode) {
+		return errors.New("invalid short code")
+	}
+	if err := s.validateURL(u.OriginalURL); err != nil {
+		return err
+	}
+	for _, fallback := range []string{u.PendingURL, u.ExpiredURL} {
+		if fallback != "" && s.validateURL(fallback) != nil {
+			return ErrInvalidFallback
+		}
+	}
+	if u.ClicksLeft != nil && *u.ClicksLeft < 0 {
+		return ErrInvalidMaxClicks
+	}
+	if len(u.Targets) > 0 {
+		if err := s.validateTargets(fromStorageTargets(u.Targets)); err != nil {
+			return err
+		}
+	}
+	if err := s.validateRules(u.Rules); err != nil {
+		return err
+	}
+	if err := validateQueryMode(u.ForwardQuery); err != nil {
+		return err
+	}
+	if err := s.validateMetadata(Metadata(u.Metadata)); err != nil {
+		return err
+	}
+	labels, err := normalizeLabels(Labels(u.Labels))
+	if err != nil {
+		return err
+	}
+	u.Labels = storage.Labels(labels)
+
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now()
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+)
+
+// LinkUpdate - изменение нескольких полей ссылки одним запросом. nil - поле не меняется
+type LinkUpdate struct {
+	URL *string // Новый адрес назначения; прежний сохраняется как ревизия
+
+	// Запланированная смена адреса; пустой NextURL отменяет ее
+	NextURL  *string
+	SwitchAt *time.Time
+
+	Weights []int // Новые веса вариантов в порядке их создания
+
+	Title       *string
+	Description *string
+	ImageURL    *string
+
+	Tags *[]string // Новый набор меток; пустой срез удаляет все
+	Note *string
+}
+
+// UpdateLink применяет изменение ссылки. Все поля проверяются по текущему
+// состоянию ссылки, а затем применяются одной записью хранилища, поэтому ошибка
+// не оставляет ссылку измененной частично. Изменение пишется в журнал одним
+// событием update
+func (s *Shortener) UpdateLink(ctx context.Context, domain, code string, upd LinkUpdate) (_ *Link, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.UpdateLink",
+		trace.WithAttributes(attribute.String("shortener.code", code)),
+	)
+	defer func() { finishSpan(span, err) }()
+
+	link, err := s.Lookup(ctx, domain, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(ctx, ActionUpdate, link.CreatedBy); err != nil {
+		return nil, err
+	}
+	changes, after, err := s.checkUpdate(link, upd)
+	if err != nil {
+		return nil, err
+	}
+	if changes == nil {
+		return link, nil
+	}
+
+	if err := s.storage.UpdateLink(audited(ctx, AuditUpdate, link, after), link.Domain, code, *changes); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrCodeNotFound
+		}
+		return nil, fmt.Errorf("updating link: %w", err)
+	}
+	return s.Lookup(ctx, link.Domain, code)
+}
+
+// checkUpdate проверяет все поля изменения так же, как их проверяют
+// SetDestination, ScheduleSwitch, SetWeights, SetMetadata и SetLabels, и
+// возвращает изменение для хранилища вместе с состоянием ссылки после него.
+// Если ничего не меняется, изменение равно nil
+func (s *Shortener) checkUpdate(link *Link, upd LinkUpdate) (*storage.LinkUpdate, *Link, error) {
+	changes := &storage.LinkUpdate{}
+	after := *link
+	changed := false
+
+	if upd.URL != nil {
+		if err := s.validateURL(*upd.URL); err != nil {
+			return nil, nil, err
+		}
+		if len(link.Targets) > 0 {
+			return nil, nil, ErrHasTargets
+		}
+		// Совпадающий с текущим адрес ревизию не создает
+		if *upd.URL != link.OriginalURL || link.UntaggedURL != "" {
+			changes.Destination = &storage.Destination{OriginalURL: *upd.URL}
+			after.OriginalURL, after.UntaggedURL = *upd.URL, ""
+			if after.NextURL == *upd.URL {
+				after.NextURL, after.SwitchAt = "", nil
+			}
+			changed = true
+		}
+	}
+
+	if upd.NextURL != nil || upd.SwitchAt != nil {
+		next, at := after.NextURL, after.SwitchAt
+		setIfPresent(&next, upd.NextURL)
+		if upd.SwitchAt != nil {
+			at = upd.SwitchAt
+		}
+		if next != "" {
+			if err := s.validateURL(next); err != nil {
+				return nil, nil, err
+			}
+			if at == nil || !at.After(time.Now()) {
+				return nil, nil, ErrInvalidSwitch
+			}
+		} else {
+			at = nil
+		}
+		if len(link.Targets) > 0 {
+			return nil, nil, ErrHasTargets
+		}
+		changes.Schedule, changes.NextURL, changes.SwitchAt = true, next, at
+		after.NextURL, after.SwitchAt = next, at
+		changed = true
+	}
+
+	if upd.Weights != nil {
+		if len(link.Targets) == 0 || len(upd.Weights) != len(link.Targets) {
+			return nil, nil, ErrInvalidTargets
+		}
+		if err := validateWeights(upd.Weights); err != nil {
+			return nil, nil, err
+		}
+		changes.Weights = upd.Weights
+		after.Targets = append([]Target(nil), link.Targets...)
+		for i, w := range upd.Weights {
+			after.Targets[i].Weight = w
+		}
+		changed = true
+	}
+
+	if upd.Title != nil || upd.Description != nil || upd.ImageURL != nil {
+		meta := link.Metadata
+		setIfPresent(&meta.Title, upd.Title)
+		setIfPresent(&meta.Description, upd.Description)
+		setIfPresent(&meta.ImageURL, upd.ImageURL)
+		if err := s.validateMetadata(meta); err != nil {
+			return nil, nil, err
+		}
+		m := storage.Metadata(meta)
+		changes.Metadata = &m
+		after.Metadata = meta
+		changed = true
+	}
+
+	if upd.Tags != nil || upd.Note != nil {
+		labels := link.Labels
+		if upd.Tags != nil {
+			labels.Tags = *upd.Tags
+		}
+		setIfPresent(&labels.Note, upd.Note)
+		labels, err := normalizeLabels(labels)
+		if err != nil {
+			return nil, nil, err
+		}
+		l := storage.Labels(labels)
+		changes.Labels = &l
+		after.Labels = labels
+		changed = true
+	}
+
+	if !changed {
+		return nil, nil, nil
+	}
+	return changes, &after, nil
+}
+
+func setIfPresent(dst *string, src *string) {
+	if src != nil {
+		*dst = *src
+	}
+}
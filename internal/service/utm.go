@@ -0,0 +1,95 @@
+package service
+
+import (
+	"net/url"
+	"sort"
+)
+
+// UTM - набор UTM-меток. Пустые поля не добавляются к адресу
+type UTM struct {
+	Source   string
+	Medium   string
+	Campaign string
+	Term     string
+	Content  string
+}
+
+// UTMTemplate - именованный шаблон UTM-меток из конфигурации
+type UTMTemplate struct {
+	Name string
+	UTM
+}
+
+// override возвращает метки, в которых заданные поля other заменяют поля u
+func (u UTM) override(other UTM) UTM {
+	for _, f := range []struct{ dst, src *string }{
+		{&u.Source, &other.Source},
+		{&u.Medium, &other.Medium},
+		{&u.Campaign, &other.Campaign},
+		{&u.Term, &other.Term},
+		{&u.Content, &other.Content},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+	return u
+}
+
+// apply добавляет метки к адресу, заменяя одноименные параметры
+func (u UTM) apply(rawURL string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	query := parsed.Query()
+	for key, value := range map[string]string{
+		"utm_source":   u.Source,
+		"utm_medium":   u.Medium,
+		"utm_campaign": u.Campaign,
+		"utm_term":     u.Term,
+		"utm_content":  u.Content,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
+
+// utmFor собирает метки ссылки из шаблона и явно заданных полей.
+// Явные поля имеют приоритет над шаблоном
+func (s *Shortener) utmFor(opts ShortenOptions) (UTM, error) {
+	var utm UTM
+	if opts.UTMTemplate != "" {
+		s.mu.RLock()
+		t, ok := s.config.UTMTemplates[opts.UTMTemplate]
+		s.mu.RUnlock()
+		if !ok {
+			return UTM{}, ErrUnknownUTMTemplate
+		}
+		utm = t
+	}
+	return utm.override(opts.UTM), nil
+}
+
+// SetUTMTemplates заменяет шаблоны UTM-меток без перезапуска сервиса
+func (s *Shortener) SetUTMTemplates(templates map[string]UTM) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.UTMTemplates = templates
+}
+
+// UTMTemplates возвращает шаблоны UTM-меток, отсортированные по имени
+func (s *Shortener) UTMTemplates() []UTMTemplate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]UTMTemplate, 0, len(s.config.UTMTemplates))
+	for name, utm := range s.config.UTMTemplates {
+		result = append(result, UTMTemplate{Name: name, UTM: utm})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
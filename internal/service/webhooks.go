@@ -0,0 +1,587 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BuzzLyutic/url-shortener/internal/logging"
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+)
+
+// Типы событий вебхуков
+const (
+	EventLinkCreated  = storage.EventLinkCreated
+	EventLinkUpdated  = storage.EventLinkUpdated
+	EventLinkDeleted  = storage.EventLinkDeleted
+	EventLinkRestored = storage.EventLinkRestored
+	EventLinkExpired  = storage.EventLinkExpired
+	EventLinkClicked  = storage.EventLinkClicked
+)
+
+// WebhookEvents - типы событий, на которые можно подписаться
+var WebhookEvents = []string{
+	EventLinkCreated, EventLinkUpdated, EventLinkDeleted, EventLinkRestored, EventLinkExpired, EventLinkClicked,
+}
+
+// Состояния доставки вебхука
+const (
+	DeliveryPending   = storage.DeliveryPending
+	DeliveryDelivered = storage.DeliveryDelivered
+	DeliveryDead      = storage.DeliveryDead
+)
+
+// Параметры доставки по умолчанию
+const (
+	DefaultWebhookMaxAttempts = 8
+	DefaultWebhookBackoff     = 30 * time.Second
+)
+
+const (
+	deliveryBatch   = 20          // Сколько доставок отправляется за один проход
+	deliveryPurge   = 500         // Сколько отправленных доставок удаляется за один запрос
+	deliveryLease   = time.Minute // На сколько откладывается взятая в работу доставка
+	webhookTimeout  = 10 * time.Second
+	maxBackoff      = 6 * time.Hour // Предел паузы между попытками
+	expiryBatch     = 100           // Сколько истекших ссылок обрабатывается за один проход
+	secretBytes     = 32            // Длина сгенерированного секрета
+	maxSecretLength = 256
+	maxErrorLength  = 512 // Предел длины сохраняемой причины неудачи
+)
+
+// errBlockedAddress - адрес получателя во внутренней сети
+var errBlockedAddress = errors.New("webhook address is not allowed")
+
+// sharedAddressSpace - адреса операторского NAT (RFC 6598)
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// blockedAddress сообщает, что адрес ведет во внутреннюю сеть: локальный,
+// частный, link-local (в том числе метаданные облака 169.254.169.254),
+// операторского NAT, групповой или неопределенный
+func blockedAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() ||
+		(ip.Is4() && ip.As4()[0] == 0) || sharedAddressSpace.Contains(ip)
+}
+
+// newWebhookClient возвращает клиент отправки вебхуков. Перенаправления
+// не выполняются; без allowPrivate соединение с адресом внутренней сети
+// отклоняется после разрешения имени, поэтому его не обойти ни DNS-записью,
+// ни сменой адреса между созданием подписки и доставкой
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !allowPrivate {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil || blockedAddress(addr.Addr()) {
+				return fmt.Errorf("%w: %s", errBlockedAddress, address)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Через прокси соединение установилось бы с адресом прокси, а не получателя
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		Timeout:   webhookTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// validateWebhookURL проверяет адрес подписки. Адрес внутренней сети,
+// заданный IP, отклоняется сразу; имена проверяются при каждой доставке
+func (s *Shortener) validateWebhookURL(rawURL string) error {
+	if err := s.validateURL(rawURL); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	allowPrivate := s.config.WebhookAllowPrivate
+	s.mu.RUnlock()
+	if allowPrivate {
+		return nil
+	}
+	parsed, _ := url.Parse(rawURL)
+	host := parsed.Hostname()
+	if ip, err := netip.ParseAddr(host); err == nil && blockedAddress(ip) || strings.EqualFold(host, "localhost") {
+		return errBlockedAddress
+	}
+	return nil
+}
+
+// Webhook - подписка рабочего пространства на события ссылок
+type Webhook struct {
+	ID        int64
+	URL       string
+	Events    []string // Пусто - все события
+	Secret    string   // Возвращается только при создании
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// Delivery - доставка события подписке
+type Delivery struct {
+	ID            int64
+	WebhookID     int64
+	Event         string
+	URL           string
+	Payload       json.RawMessage
+	Status        string
+	Attempts      int
+	NextAttemptAt *time.Time // nil, если доставка больше не ожидает попытки
+	LastStatus    int        // HTTP-статус последней попытки; 0 - ответа не было
+	LastError     string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// DeliveryOptions - фильтры и страница журнала доставок
+type DeliveryOptions struct {
+	WebhookID int64
+	Status    string // DeliveryPending, DeliveryDelivered или DeliveryDead
+	Limit     int    // По умолчанию defaultListLimit
+	Cursor    string // NextCursor предыдущей страницы
+}
+
+// DeliveryPage - страница журнала доставок от новых к старым
+type DeliveryPage struct {
+	Deliveries []Delivery
+	NextCursor string // Пусто на последней странице
+}
+
+// CreateWebhook подписывает рабочее пространство запроса на события ссылок.
+// Пустой events подписывает на все события, пустой secret заменяется случайным
+func (s *Shortener) CreateWebhook(ctx context.Context, rawURL string, events []string, secret string) (_ *Webhook, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.CreateWebhook")
+	defer func() { finishSpan(span, err) }()
+
+	if err := authorize(ctx, ActionWebhooks, ""); err != nil {
+		return nil, err
+	}
+	if err := s.validateWebhookURL(rawURL); err != nil {
+		return nil, ErrInvalidWebhook
+	}
+	var subscribed []string
+	for _, e := range events {
+		if !slices.Contains(WebhookEvents, e) {
+			return nil, ErrInvalidWebhook
+		}
+		if !slices.Contains(subscribed, e) {
+			subscribed = append(subscribed, e)
+		}
+	}
+	if len(secret) > maxSecretLength {
+		return nil, ErrInvalidWebhook
+	}
+	if secret == "" {
+		b := make([]byte, secretBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("generating secret: %w", err)
+		}
+		secret = hex.EncodeToString(b)
+	}
+
+	p, _ := PrincipalFromContext(ctx)
+	w := storage.Webhook{
+		Workspace: workspaceOf(ctx),
+		URL:       rawURL,
+		Secret:    secret,
+		Events:    subscribed,
+		CreatedBy: p.KeyName,
+		CreatedAt: time.Now(),
+	}
+	w.ID, err = s.storage.CreateWebhook(ctx, w)
+	if err != nil {
+		return nil, fmt.Errorf("creating webhook: %w", err)
+	}
+	s.subscribers.invalidate()
+	webhook := toWebhook(&w)
+	webhook.Secret = secret
+	return webhook, nil
+}
+
+// Webhooks возвращает подписки рабочего пространства запроса без секретов
+func (s *Shortener) Webhooks(ctx context.Context) (_ []Webhook, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.Webhooks")
+	defer func() { finishSpan(span, err) }()
+
+	if err := authorize(ctx, ActionWebhooks, ""); err != nil {
+		return nil, err
+	}
+	hooks, err := s.storage.ListWebhooks(ctx, workspaceOf(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("listing webhooks: %w", err)
+	}
+	result := make([]Webhook, 0, len(hooks))
+	for i := range hooks {
+		result = append(result, *toWebhook(&hooks[i]))
+	}
+	return result, nil
+}
+
+// DeleteWebhook удаляет подписку рабочего пространства запроса вместе с журналом ее доставок
+func (s *Shortener) DeleteWebhook(ctx context.Context, id int64) (err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.DeleteWebhook",
+		trace.WithAttributes(attribute.Int64("shortener.webhook", id)),
+	)
+	defer func() { finishSpan(span, err) }()
+
+	if err := authorize(ctx, ActionWebhooks, ""); err != nil {
+		return err
+	}
+	if err := s.storage.DeleteWebhook(ctx, workspaceOf(ctx), id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrWebhookNotFound
+		}
+		return fmt.Errorf("deleting webhook: %w", err)
+	}
+	s.subscribers.invalidate()
+	return nil
+}
+
+// Deliveries возвращает журнал доставок рабочего пространства запроса. Курсор - ID
+// последней выданной доставки, поэтому новые доставки не сдвигают страницы
+func (s *Shortener) Deliveries(ctx context.Context, opts DeliveryOptions) (_ *DeliveryPage, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.Deliveries")
+	defer func() { finishSpan(span, err) }()
+
+	if err := authorize(ctx, ActionWebhooks, ""); err != nil {
+		return nil, err
+	}
+	switch opts.Status {
+	case "", DeliveryPending, DeliveryDelivered, DeliveryDead:
+	default:
+		return nil, ErrInvalidListQuery
+	}
+	limit := opts.Limit
+	if limit == 0 {
+		limit = defaultListLimit
+	}
+	if limit < 0 || limit > maxListLimit || opts.WebhookID < 0 {
+		return nil, ErrInvalidListQuery
+	}
+	q := storage.DeliveryQuery{
+		Workspace: workspaceOf(ctx),
+		WebhookID: opts.WebhookID,
+		Status:    opts.Status,
+		// Лишняя доставка показывает, есть ли следующая страница
+		Limit: limit + 1,
+	}
+	if opts.Cursor != "" {
+		id, err := strconv.ParseInt(opts.Cursor, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, ErrInvalidCursor
+		}
+		q.BeforeID = id
+	}
+
+	deliveries, err := s.storage.ListDeliveries(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("listing deliveries: %w", err)
+	}
+	page := &DeliveryPage{}
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+		page.NextCursor = strconv.FormatInt(deliveries[limit-1].ID, 10)
+	}
+	for i := range deliveries {
+		page.Deliveries = append(page.Deliveries, toDelivery(&deliveries[i]))
+	}
+	return page, nil
+}
+
+// RetryDelivery возвращает доставку рабочего пространства запроса в очередь,
+// в том числе из списка неотправленных. Счетчик попыток обнуляется
+func (s *Shortener) RetryDelivery(ctx context.Context, id int64) (err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.RetryDelivery",
+		trace.WithAttributes(attribute.Int64("shortener.delivery", id)),
+	)
+	defer func() { finishSpan(span, err) }()
+
+	if err := authorize(ctx, ActionWebhooks, ""); err != nil {
+		return err
+	}
+	if err := s.storage.RetryDelivery(ctx, workspaceOf(ctx), id, time.Now()); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrDeliveryNotFound
+		}
+		return fmt.Errorf("retrying delivery: %w", err)
+	}
+	return nil
+}
+
+// DeliverWebhooks отправляет доставки, время попытки которых наступило к моменту now,
+// и возвращает число успешных. Неудачная доставка повторяется с экспоненциально
+// растущей паузой, а после WebhookMaxAttempts попыток переходит в состояние dead
+func (s *Shortener) DeliverWebhooks(ctx context.Context, now time.Time) (n int, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.DeliverWebhooks")
+	defer func() {
+		span.SetAttributes(attribute.Int("shortener.delivered", n))
+		finishSpan(span, err)
+	}()
+
+	deliveries, err := s.storage.ClaimDeliveries(ctx, now, deliveryLease, deliveryBatch)
+	if err != nil {
+		return 0, fmt.Errorf("claiming deliveries: %w", err)
+	}
+	secrets := make(map[int64]string)
+	for _, d := range deliveries {
+		if _, ok := secrets[d.WebhookID]; ok {
+			continue
+		}
+		w, err := s.storage.GetWebhook(ctx, d.WebhookID)
+		// Доставки удаленной подписки удалены вместе с ней
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("getting webhook: %w", err)
+		}
+		secrets[w.ID] = w.Secret
+	}
+
+	maxAttempts, backoff := s.webhookPolicy()
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		updateErr error
+	)
+	for _, d := range deliveries {
+		secret, ok := secrets[d.WebhookID]
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.LastStatus, d.LastError = s.send(ctx, &d, secret, now)
+			d.Attempts++
+			d.UpdatedAt = now
+			switch {
+			case d.LastError == "":
+				d.Status = DeliveryDelivered
+			case d.Attempts >= maxAttempts:
+				d.Status = DeliveryDead
+			default:
+				d.NextAttemptAt = now.Add(retryDelay(backoff, d.Attempts))
+			}
+			err := s.storage.UpdateDelivery(ctx, d)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if d.Status == DeliveryDelivered {
+				n++
+			}
+			if err != nil && !errors.Is(err, storage.ErrNotFound) && updateErr == nil {
+				updateErr = fmt.Errorf("updating delivery: %w", err)
+			}
+		}()
+	}
+	wg.Wait()
+	return n, updateErr
+}
+
+// send отправляет доставку получателю и возвращает HTTP-статус ответа и причину
+// неудачи. Пустая причина означает успешную доставку (ответ 2xx)
+func (s *Shortener) send(ctx context.Context, d *storage.Delivery, secret string, now time.Time) (int, string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, truncateError(err.Error())
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", WebhookSignature(secret, timestamp, d.Payload))
+
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		return 0, truncateError(err.Error())
+	}
+	defer resp.Body.Close()
+	// Тело ответа не нужно, но его чтение позволяет переиспользовать соединение
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, "unexpected status: " + resp.Status
+	}
+	return resp.StatusCode, ""
+}
+
+// WebhookSignature возвращает значение заголовка X-Webhook-Signature:
+// HMAC-SHA256 секретом подписки от строки "<timestamp>.<тело запроса>".
+// Получатель проверяет подпись и отклоняет запросы со старой меткой времени
+func WebhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryDelay возвращает паузу перед повтором после attempts неудачных попыток:
+// backoff, затем вдвое больше после каждой следующей, но не больше maxBackoff
+func retryDelay(backoff time.Duration, attempts int) time.Duration {
+	delay := backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+func truncateError(msg string) string {
+	if len(msg) > maxErrorLength {
+		return msg[:maxErrorLength]
+	}
+	return msg
+}
+
+// NotifyExpirations записывает в журнал истечение срока ссылок всех пространств,
+// наступившее к моменту now, и возвращает их число. Истечение записывается как
+// expire без автора и доставляется подписчикам как link.expired
+func (s *Shortener) NotifyExpirations(ctx context.Context, now time.Time) (_ int, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.NotifyExpirations")
+	defer func() { finishSpan(span, err) }()
+
+	due, err := s.storage.DueExpirations(ctx, now, expiryBatch)
+	if err != nil {
+		return 0, fmt.Errorf("listing due expirations: %w", err)
+	}
+
+	notified := 0
+	for _, u := range due {
+		err := s.storage.MarkExpired(audited(ctx, AuditExpire, nil, s.toLink(u)), u.Domain, u.ShortCode, now)
+		// Истечение уже записал другой экземпляр сервиса или ссылку удалили
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return notified, fmt.Errorf("marking expired: %w", err)
+		}
+		notified++
+	}
+	span.SetAttributes(attribute.Int("shortener.expirations", notified))
+	return notified, nil
+}
+
+// publishClick ставит переход по ссылке в очередь доставки подписчикам.
+// Переход в пространстве без подписки на link.clicked не обращается к хранилищу
+func (s *Shortener) publishClick(ctx context.Context, u *storage.URL, destination string) error {
+	subscribed, err := s.subscribers.subscribed(u.Workspace, func() ([]string, error) {
+		return s.storage.SubscribedWorkspaces(ctx, EventLinkClicked)
+	})
+	// Без списка подписок событие ставится в очередь, как если бы подписка была
+	if err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+	} else if !subscribed {
+		return nil
+	}
+	return s.storage.Publish(ctx, storage.Event{
+		Type:        EventLinkClicked,
+		Workspace:   u.Workspace,
+		Domain:      u.Domain,
+		ShortCode:   u.ShortCode,
+		RequestID:   logging.RequestIDFromContext(ctx),
+		At:          time.Now(),
+		Destination: destination,
+	})
+}
+
+// PurgeDeliveries удаляет доставки всех пространств, отправленные раньше
+// WebhookRetention до момента now, и возвращает их число. Ожидающие
+// и неотправленные доставки остаются. Без срока хранения ничего не удаляет
+func (s *Shortener) PurgeDeliveries(ctx context.Context, now time.Time) (n int, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "Shortener.PurgeDeliveries")
+	defer func() {
+		span.SetAttributes(attribute.Int("shortener.purged", n))
+		finishSpan(span, err)
+	}()
+
+	s.mu.RLock()
+	retention := s.config.WebhookRetention
+	s.mu.RUnlock()
+	if retention <= 0 {
+		return 0, nil
+	}
+	for {
+		purged, err := s.storage.PurgeDeliveries(ctx, now.Add(-retention), deliveryPurge)
+		n += purged
+		if err != nil {
+			return n, fmt.Errorf("purging deliveries: %w", err)
+		}
+		if purged < deliveryPurge {
+			return n, nil
+		}
+	}
+}
+
+// webhookPolicy возвращает число попыток доставки и начальную паузу между ними
+func (s *Shortener) webhookPolicy() (int, time.Duration) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	maxAttempts, backoff := s.config.WebhookMaxAttempts, s.config.WebhookBackoff
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultWebhookMaxAttempts
+	}
+	if backoff <= 0 {
+		backoff = DefaultWebhookBackoff
+	}
+	return maxAttempts, backoff
+}
+
+func toWebhook(w *storage.Webhook) *Webhook {
+	return &Webhook{
+		ID:        w.ID,
+		URL:       w.URL,
+		Events:    w.Events,
+		CreatedBy: w.CreatedBy,
+		CreatedAt: w.CreatedAt,
+	}
+}
+
+func toDelivery(d *storage.Delivery) Delivery {
+	delivery := Delivery{
+		ID:         d.ID,
+		WebhookID:  d.WebhookID,
+		Event:      d.Event,
+		URL:        d.URL,
+		Payload:    d.Payload,
+		Status:     d.Status,
+		Attempts:   d.Attempts,
+		LastStatus: d.LastStatus,
+		LastError:  d.LastError,
+		CreatedAt:  d.CreatedAt,
+		UpdatedAt:  d.UpdatedAt,
+	}
+	if d.Status == DeliveryPending {
+		next := d.NextAttemptAt
+		delivery.NextAttemptAt = &next
+	}
+	return delivery
+}
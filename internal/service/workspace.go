@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+)
+
+// Workspace - настройки рабочего пространства команды
+type Workspace struct {
+	DefaultTTL *time.Duration // Перекрывает Config.DefaultTTL; nil - общий срок
+	MaxLinks   int            // Квота ссылок; 0 - без ограничения
+}
+
+// APIKey - ключ доступа к API рабочего пространства
+type APIKey struct {
+	Key       string
+	Name      string // Имя для журналов и автор создаваемых ссылок
+	Workspace string
+	Role      Role // Пусто - DefaultRole
+}
+
+// Principal - владелец ключа API, от имени которого выполняется запрос
+type Principal struct {
+	Workspace string
+	KeyName   string
+	Role      Role
+}
+
+type principalKey struct{}
+
+// WithPrincipal возвращает контекст запроса, выполняемого от имени владельца ключа
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext возвращает владельца ключа запроса; false - запрос без ключа
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// workspaceOf возвращает рабочее пространство запроса. Запросы без ключа
+// работают с пространством по умолчанию
+func workspaceOf(ctx context.Context) string {
+	p, _ := PrincipalFromContext(ctx)
+	return p.Workspace
+}
+
+// visible сообщает, доступна ли ссылка в контексте запроса. Запросам без ключа
+// (переходы, QR-коды, команды CLI) доступны ссылки всех пространств
+func visible(ctx context.Context, u *storage.URL) bool {
+	p, ok := PrincipalFromContext(ctx)
+	return !ok || p.Workspace == u.Workspace
+}
+
+// SetWorkspaces заменяет рабочие пространства и ключи API (горячая перезагрузка)
+func (s *Shortener) SetWorkspaces(workspaces map[string]Workspace, keys []APIKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.Workspaces = workspaces
+	s.config.APIKeys = keys
+	s.keys = hashKeys(keys)
+}
+
+// hashKeys индексирует ключи API по хэшу
+func hashKeys(keys []APIKey) map[[sha256.Size]byte]APIKey {
+	hashed := make(map[[sha256.Size]byte]APIKey, len(keys))
+	for _, k := range keys {
+		hashed[sha256.Sum256([]byte(k.Key))] = k
+	}
+	return hashed
+}
+
+// AuthRequired сообщает, что заданы ключи API и запросы к API должны их предъявлять
+func (s *Shortener) AuthRequired() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keys) > 0
+}
+
+// Authenticate возвращает владельца ключа API. Ключ ищется по хэшу,
+// поэтому время проверки не зависит от совпадения префикса
+func (s *Shortener) Authenticate(key string) (Principal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	k, ok := s.keys[sha256.Sum256([]byte(key))]
+	if !ok || key == "" {
+		return Principal{}, ErrInvalidAPIKey
+	}
+	role := k.Role
+	if role == "" {
+		role = DefaultRole
+	}
+	return Principal{Workspace: k.Workspace, KeyName: k.Name, Role: role}, nil
+}
+
+// workspace возвращает настройки пространства; для неизвестных - пустые
+func (s *Shortener) workspace(name string) Workspace {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.Workspaces[name]
+}
+
+// quotaLeft возвращает, сколько ссылок пространство еще может создать; -1 - без ограничения.
+// Квота проверяется без блокировки: параллельные запросы могут превысить ее на несколько ссылок
+func (s *Shortener) quotaLeft(ctx context.Context, workspace string) (int, error) {
+	limit := s.workspace(workspace).MaxLinks
+	if limit <= 0 {
+		return -1, nil
+	}
+	count, err := s.storage.CountLinks(ctx, workspace)
+	if err != nil {
+		return 0, fmt.Errorf("counting links: %w", err)
+	}
+	return max(limit-count, 0), nil
+}
+
+// checkQuota проверяет, что пространство может создать еще одну ссылку
+func (s *Shortener) checkQuota(ctx context.Context, workspace string) error {
+	left, err := s.quotaLeft(ctx, workspace)
+	if err != nil {
+		return err
+	}
+	if left == 0 {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
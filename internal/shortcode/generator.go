@@ -2,6 +2,7 @@
 package shortcode
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
@@ -32,6 +33,16 @@ func Generate(url string, attempt int) string {
 	return encode(num)
 }
 
+// Random создает случайный код. Используется для ссылок с индивидуальными
+// настройками, которые не должны совпадать с кодом той же ссылки без них
+func Random() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("shortcode: reading random bytes: %v", err))
+	}
+	return encode(binary.BigEndian.Uint64(b[:]))
+}
+
 // encode конвертирует uint64 число в base63 строку фиксированной длины.
 func encode(num uint64) string {
 	result := make([]byte, Length)
@@ -44,7 +55,9 @@ func encode(num uint64) string {
 	return string(result)
 }
 
-// IsValid проверяет, является ли строка валидным коротким кодом
+// IsValid проверяет, является ли строка валидным коротким кодом.
+// Коды фиксированной длины не совпадают с путями сервиса (api, preview, health),
+// поэтому отдельного списка зарезервированных кодов нет
 func IsValid(code string) bool {
 	if len(code) != Length {
 		return false
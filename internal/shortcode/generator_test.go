@@ -118,6 +118,11 @@ func TestIsValid(t *testing.T) {
 			code:  "a_b_c_d_e_",
 			valid: true,
 		},
+		{
+			name:  "reserved path preview",
+			code:  "preview",
+			valid: false,
+		},
 		{
 			name:  "too short",
 			code:  "abc",
@@ -174,6 +179,20 @@ func TestEncode(t *testing.T) {
 	}
 }
 
+func TestRandom(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		code := Random()
+		if !IsValid(code) {
+			t.Fatalf("Random() returned invalid code: %s", code)
+		}
+		if seen[code] {
+			t.Fatalf("Random() returned duplicate code: %s", code)
+		}
+		seen[code] = true
+	}
+}
+
 // Бенчмарки
 
 func BenchmarkGenerate(b *testing.B) {
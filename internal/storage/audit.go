@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// AuditEvent - запись журнала изменений ссылки. Журнал только дополняется
+type AuditEvent struct {
+	ID        int64
+	Workspace string
+	Domain    string
+	ShortCode string
+	Action    string // create, update, delete, restore или expire
+	Actor     string // Имя ключа API; пусто - запрос без ключа
+	RequestID string
+	At        time.Time
+	Before    json.RawMessage // Состояние до изменения; nil для create
+	After     json.RawMessage // Состояние после изменения; nil для delete
+}
+
+// AuditQuery - фильтры выборки журнала. События возвращаются от новых к старым
+type AuditQuery struct {
+	Workspace string
+	Domain    string // Вместе с ShortCode выбирает историю одной ссылки
+	ShortCode string
+	Actor     string
+	Action    string
+	BeforeID  int64 // Только события с меньшим ID; 0 - с последнего
+	Limit     int
+}
+
+// matches проверяет событие по всем фильтрам запроса, кроме BeforeID и Limit
+func (q *AuditQuery) matches(e *AuditEvent) bool {
+	switch {
+	case e.Workspace != q.Workspace:
+		return false
+	case q.ShortCode != "" && (e.Domain != q.Domain || e.ShortCode != q.ShortCode):
+		return false
+	case q.Actor != "" && e.Actor != q.Actor:
+		return false
+	case q.Action != "" && e.Action != q.Action:
+		return false
+	}
+	return true
+}
+
+// sameAuditEvent сообщает, что a и b - одно и то же событие: совпадают ID,
+// ссылка, действие, автор, запрос и время с точностью до микросекунд
+func sameAuditEvent(a, b *AuditEvent) bool {
+	return a.ID == b.ID && a.Workspace == b.Workspace && a.Domain == b.Domain && a.ShortCode == b.ShortCode &&
+		a.Action == b.Action && a.Actor == b.Actor && a.RequestID == b.RequestID &&
+		a.At.Truncate(time.Microsecond).Equal(b.At.Truncate(time.Microsecond))
+}
+
+type auditKey struct{}
+
+// WithAudit возвращает контекст, изменения в котором записываются в журнал.
+// Хранилище дополняет событие доменом и кодом измененной ссылки и сохраняет его
+// вместе с изменением: в PostgreSQL - в той же транзакции
+func WithAudit(ctx context.Context, e AuditEvent) context.Context {
+	return context.WithValue(ctx, auditKey{}, e)
+}
+
+// auditFrom возвращает событие журнала для изменения ссылки domain/code
+func auditFrom(ctx context.Context, domain, code string) (AuditEvent, bool) {
+	e, ok := ctx.Value(auditKey{}).(AuditEvent)
+	e.Domain, e.ShortCode = domain, code
+	return e, ok
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Статусы ссылок для выборки
+const (
+	StatusActive  = "active"  // Срок не истек (в том числе еще не начавшие действовать)
+	StatusExpired = "expired" // Срок истек
+	StatusDeleted = "deleted" // Удалена, но еще не удалена окончательно
+)
+
+// ListQuery - параметры выборки ссылок домена. Пустые поля не фильтруют
+type ListQuery struct {
+	Workspace   string
+	Domain      string
+	CreatedBy   string
+	Tags        []string   // Метки, каждая из которых должна быть у ссылки
+	DestHost    string     // Хост адреса назначения, без учета регистра
+	Contains    string     // Подстрока адреса назначения или адреса без UTM-меток, без учета регистра
+	CreatedFrom *time.Time // Создана не раньше
+	CreatedTo   *time.Time // Создана раньше
+	Status      string     // Один из Status*; удаленные выбираются только по StatusDeleted
+	Descending  bool       // Сначала новые
+	After       *Cursor    // Продолжить после этой записи в порядке сортировки
+	Limit       int
+}
+
+// Cursor - позиция записи в порядке (CreatedAt, ShortCode)
+type Cursor struct {
+	CreatedAt time.Time
+	ShortCode string
+}
+
+// Position - позиция записи при обходе всего хранилища
+// в порядке (Domain, CreatedAt, ShortCode)
+type Position struct {
+	Domain string
+	Cursor
+}
+
+// PositionOf возвращает позицию записи для продолжения обхода
+func PositionOf(u *URL) Position {
+	return Position{Domain: u.Domain, Cursor: CursorOf(u)}
+}
+
+// CursorOf возвращает позицию записи для продолжения выборки
+func CursorOf(u *URL) Cursor {
+	return Cursor{CreatedAt: u.CreatedAt, ShortCode: u.ShortCode}
+}
+
+// before сообщает, что позиция c идет раньше other в порядке по возрастанию
+func (c Cursor) before(other Cursor) bool {
+	if !c.CreatedAt.Equal(other.CreatedAt) {
+		return c.CreatedAt.Before(other.CreatedAt)
+	}
+	return c.ShortCode < other.ShortCode
+}
+
+// matches проверяет запись по фильтрам запроса, кроме домена и курсора
+func (q ListQuery) matches(u *URL, now time.Time) bool {
+	if u.Workspace != q.Workspace {
+		return false
+	}
+	if q.CreatedBy != "" && u.CreatedBy != q.CreatedBy {
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !slices.Contains(u.Tags, tag) {
+			return false
+		}
+	}
+	if q.DestHost != "" && !strings.EqualFold(destHost(u.OriginalURL), q.DestHost) {
+		return false
+	}
+	if q.Contains != "" {
+		needle := strings.ToLower(q.Contains)
+		if !strings.Contains(strings.ToLower(u.OriginalURL), needle) &&
+			!strings.Contains(strings.ToLower(u.UntaggedURL), needle) {
+			return false
+		}
+	}
+	if q.CreatedFrom != nil && u.CreatedAt.Before(*q.CreatedFrom) {
+		return false
+	}
+	if q.CreatedTo != nil && !u.CreatedAt.Before(*q.CreatedTo) {
+		return false
+	}
+	if (u.DeletedAt != nil) != (q.Status == StatusDeleted) {
+		return false
+	}
+	expired := u.ExpiresAt != nil && !now.Before(*u.ExpiresAt)
+	switch q.Status {
+	case StatusActive:
+		return !expired
+	case StatusExpired:
+		return expired
+	}
+	return true
+}
+
+func destHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -1,73 +1,348 @@
 package storage
 
 import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/BuzzLyutic/url-shortener/internal/rules"
 )
 
+// domainKey - ключ индексов, уникальный в пределах домена
+type domainKey struct {
+	domain string
+	value  string
+}
+
+// originalKey - ключ индекса дедупликации, уникальный в пределах пространства и домена
+type originalKey struct {
+	workspace string
+	domain    string
+	url       string
+}
+
 // MemoryStorage реализация хранилища в памяти
 type MemoryStorage struct {
 	mu            sync.RWMutex
-	byCode        map[string]*URL
-	byOriginalURL map[string]string // Оригинальный URL -> укороченный код
+	byCode        map[domainKey]*URL
+	byOriginalURL map[originalKey]string   // (пространство, домен, оригинальный URL) -> укороченный код, без Custom ссылок
+	byCreated     map[string][]Cursor      // домен -> позиции ссылок по возрастанию (CreatedAt, ShortCode)
+	byTag         map[domainKey][]Cursor   // (домен, метка) -> позиции ссылок с меткой в том же порядке
+	counts        map[string]int           // пространство -> число ссылок
+	revisions     map[domainKey][]Revision // (домен, код) -> прежние адреса по возрастанию номера
+	tombstones    map[domainKey]Tombstone  // (домен, код) удаленных ссылок; не очищается
+	audit         []AuditEvent             // журнал изменений по возрастанию ID
+	webhooks      map[int64]*Webhook       // ID -> подписка
+	deliveries    []Delivery               // доставки вебхуков по возрастанию ID
+	pending       map[int64]struct{}       // ID ожидающих доставок
+	lastWebhookID int64
+	lastDelivery  int64
+}
+
+func originalKeyOf(url *URL) originalKey {
+	return originalKey{url.Workspace, url.Domain, url.OriginalURL}
 }
 
 // NewMemoryStorage создает новое хранилище в памяти
 func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
-		byCode:        make(map[string]*URL),
-		byOriginalURL: make(map[string]string),
+		byCode:        make(map[domainKey]*URL),
+		byOriginalURL: make(map[originalKey]string),
+		byCreated:     make(map[string][]Cursor),
+		byTag:         make(map[domainKey][]Cursor),
+		counts:        make(map[string]int),
+		revisions:     make(map[domainKey][]Revision),
+		tombstones:    make(map[domainKey]Tombstone),
+		webhooks:      make(map[int64]*Webhook),
+		pending:       make(map[int64]struct{}),
 	}
 }
 
 // Save хранит новое отображение URL
-func (s *MemoryStorage) Save(url URL) error {
+func (s *MemoryStorage) Save(ctx context.Context, url URL) (err error) {
+	_, span := startSpan(ctx, "memory.Save", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Проверка существования кода
-	if existing, ok := s.byCode[url.ShortCode]; ok {
-		if existing.OriginalURL == url.OriginalURL {
+	// Проверка существования кода в домене
+	codeKey := domainKey{url.Domain, url.ShortCode}
+	if _, ok := s.tombstones[codeKey]; ok {
+		return ErrAlreadyExists
+	}
+	if existing, ok := s.byCode[codeKey]; ok {
+		if existing.OriginalURL == url.OriginalURL && existing.Workspace == url.Workspace &&
+			!existing.Custom && !url.Custom {
 			return nil
 		}
 		return ErrAlreadyExists
 	}
+	if s.originalTaken(url) {
+		return ErrAlreadyExists
+	}
 
-	// Сохранить URL
-	urlCopy := url // создать копию, чтобы избежать внешних изменений
-	s.byCode[url.ShortCode] = &urlCopy
-	s.byOriginalURL[url.OriginalURL] = url.ShortCode
+	s.put(url)
+	s.recordAudit(ctx, url.Domain, url.ShortCode)
+	return nil
+}
+
+// Replace сохраняет URL, заменяя существующую запись с тем же кодом в домене
+func (s *MemoryStorage) Replace(ctx context.Context, url URL) (err error) {
+	_, span := startSpan(ctx, "memory.Replace", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	if s.originalTaken(url) {
+		return ErrAlreadyExists
+	}
+	// Код удаленной ссылки закрыт и для замены
+	if _, ok := s.tombstones[domainKey{url.Domain, url.ShortCode}]; ok {
+		return ErrAlreadyExists
+	}
+	if existing, ok := s.byCode[domainKey{url.Domain, url.ShortCode}]; ok {
+		s.remove(existing)
+	}
+	s.put(url)
+	s.recordAudit(ctx, url.Domain, url.ShortCode)
 	return nil
 }
 
-// GetByCode возвращает URL по короткому коду
-func (s *MemoryStorage) GetByCode(code string) (*URL, error) {
+// originalTaken сообщает, что оригинальный URL обычной ссылки уже занят другим кодом
+// в том же пространстве
+func (s *MemoryStorage) originalTaken(url URL) bool {
+	if url.Custom {
+		return false
+	}
+	code, ok := s.byOriginalURL[originalKeyOf(&url)]
+	return ok && code != url.ShortCode
+}
+
+// put добавляет копию записи во все индексы. Код удаленной записи
+// закрывается сразу. Вызывается под блокировкой на запись
+func (s *MemoryStorage) put(url URL) {
+	urlCopy := url // создать копию, чтобы избежать внешних изменений
+	urlCopy.Targets = append([]Target(nil), url.Targets...)
+	urlCopy.Rules = append([]rules.Rule(nil), url.Rules...)
+	urlCopy.Tags = append([]string(nil), url.Tags...)
+	s.byCode[domainKey{url.Domain, url.ShortCode}] = &urlCopy
+	if url.DeletedAt != nil {
+		s.tombstones[domainKey{url.Domain, url.ShortCode}] = tombstoneOf(&urlCopy)
+	} else {
+		s.unhide(&urlCopy)
+	}
+	s.byCreated[url.Domain] = insertCursor(s.byCreated[url.Domain], CursorOf(&urlCopy))
+	s.indexTags(&urlCopy)
+}
+
+// remove удаляет запись из всех индексов. Вызывается под блокировкой на запись
+func (s *MemoryStorage) remove(url *URL) {
+	delete(s.byCode, domainKey{url.Domain, url.ShortCode})
+	if url.DeletedAt == nil {
+		s.hide(url)
+	}
+	s.byCreated[url.Domain] = deleteCursor(s.byCreated[url.Domain], CursorOf(url))
+	s.unindexTags(url)
+	delete(s.revisions, domainKey{url.Domain, url.ShortCode})
+}
+
+// unhide учитывает неудаленную запись в дедупликации и счетчике пространства
+func (s *MemoryStorage) unhide(url *URL) {
+	if !url.Custom {
+		s.byOriginalURL[originalKeyOf(url)] = url.ShortCode
+	}
+	s.counts[url.Workspace]++
+}
+
+// hide исключает запись из дедупликации и счетчика пространства
+func (s *MemoryStorage) hide(url *URL) {
+	if !url.Custom {
+		delete(s.byOriginalURL, originalKeyOf(url))
+	}
+	if s.counts[url.Workspace]--; s.counts[url.Workspace] == 0 {
+		delete(s.counts, url.Workspace)
+	}
+}
+
+// indexTags добавляет позицию ссылки в индексы ее меток
+func (s *MemoryStorage) indexTags(url *URL) {
+	for _, tag := range url.Tags {
+		key := domainKey{url.Domain, tag}
+		s.byTag[key] = insertCursor(s.byTag[key], CursorOf(url))
+	}
+}
+
+// unindexTags удаляет позицию ссылки из индексов ее меток
+func (s *MemoryStorage) unindexTags(url *URL) {
+	for _, tag := range url.Tags {
+		key := domainKey{url.Domain, tag}
+		if index := deleteCursor(s.byTag[key], CursorOf(url)); len(index) > 0 {
+			s.byTag[key] = index
+		} else {
+			delete(s.byTag, key)
+		}
+	}
+}
+
+// insertCursor вставляет позицию ссылки в упорядоченный индекс.
+// Новые ссылки обычно оказываются в конце, поэтому сдвиг элементов редок
+func insertCursor(index []Cursor, c Cursor) []Cursor {
+	i := sort.Search(len(index), func(i int) bool { return c.before(index[i]) })
+	index = append(index, Cursor{})
+	copy(index[i+1:], index[i:])
+	index[i] = c
+	return index
+}
+
+// deleteCursor удаляет позицию ссылки из упорядоченного индекса
+func deleteCursor(index []Cursor, c Cursor) []Cursor {
+	i := sort.Search(len(index), func(i int) bool { return !index[i].before(c) })
+	if i < len(index) && index[i].ShortCode == c.ShortCode {
+		return append(index[:i], index[i+1:]...)
+	}
+	return index
+}
+
+// List возвращает ссылки домена по фильтрам. Обход идет по упорядоченному
+// индексу от позиции курсора, без копирования всех записей
+func (s *MemoryStorage) List(ctx context.Context, q ListQuery) (_ []*URL, err error) {
+	_, span := startSpan(ctx, "memory.List", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	url, ok := s.byCode[code]
+	index := s.byCreated[q.Domain]
+	// С метками обходится самый короткий из индексов меток
+	for i, tag := range q.Tags {
+		if tagged := s.byTag[domainKey{q.Domain, tag}]; i == 0 || len(tagged) < len(index) {
+			index = tagged
+		}
+	}
+	now := time.Now()
+
+	// Границы обхода: [start, end) по возрастанию
+	start, end := 0, len(index)
+	if q.After != nil {
+		after := *q.After
+		if q.Descending {
+			end = sort.Search(len(index), func(i int) bool { return !index[i].before(after) })
+		} else {
+			start = sort.Search(len(index), func(i int) bool { return after.before(index[i]) })
+		}
+	}
+
+	var result []*URL
+	for n := 0; n < end-start && (q.Limit <= 0 || len(result) < q.Limit); n++ {
+		i := start + n
+		if q.Descending {
+			i = end - 1 - n
+		}
+		u := s.byCode[domainKey{q.Domain, index[i].ShortCode}]
+		if q.matches(u, now) {
+			result = append(result, u)
+		}
+	}
+	return result, nil
+}
+
+// CountLinks возвращает число ссылок рабочего пространства
+func (s *MemoryStorage) CountLinks(ctx context.Context, workspace string) (_ int, err error) {
+	_, span := startSpan(ctx, "memory.CountLinks", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.counts[workspace], nil
+}
+
+// Iterate возвращает до limit записей, следующих за позицией after;
+// nil - с начала. Домены обходятся по алфавиту, ссылки внутри домена - по индексу создания
+func (s *MemoryStorage) Iterate(ctx context.Context, after *Position, limit int) (_ []*URL, err error) {
+	_, span := startSpan(ctx, "memory.Iterate", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	domains := make([]string, 0, len(s.byCreated))
+	for d := range s.byCreated {
+		if after == nil || d >= after.Domain {
+			domains = append(domains, d)
+		}
+	}
+	sort.Strings(domains)
+
+	var result []*URL
+	for _, d := range domains {
+		index := s.byCreated[d]
+		start := 0
+		if after != nil && d == after.Domain {
+			start = sort.Search(len(index), func(i int) bool { return after.Cursor.before(index[i]) })
+		}
+		for i := start; i < len(index) && len(result) < limit; i++ {
+			result = append(result, s.byCode[domainKey{d, index[i].ShortCode}])
+		}
+		if len(result) == limit {
+			break
+		}
+	}
+	return result, nil
+}
+
+// GetByCode возвращает URL по домену и короткому коду.
+// Для истекших и еще не активных ссылок запись возвращается вместе с ошибкой
+func (s *MemoryStorage) GetByCode(ctx context.Context, domain, code string) (_ *URL, err error) {
+	_, span := startSpan(ctx, "memory.GetByCode", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key := domainKey{domain, code}
+	url, ok := s.byCode[key]
 	if !ok {
+		if _, ok := s.tombstones[key]; ok {
+			return nil, ErrDeleted
+		}
 		return nil, ErrNotFound
 	}
 
+	if url.DeletedAt != nil {
+		return url, ErrDeleted
+	}
 	if url.IsExpired() {
-		return nil, ErrExpired
+		return url, ErrExpired
+	}
+	if url.IsPending() {
+		return url, ErrNotYetActive
 	}
 
 	return url, nil
 }
 
-// GetByOriginalURL возвращает укороченную ссылку по оригинальному URL
-func (s *MemoryStorage) GetByOriginalURL(originalURL string) (*URL, error) {
+// GetByOriginalURL возвращает укороченную ссылку пространства и домена по оригинальному URL
+func (s *MemoryStorage) GetByOriginalURL(ctx context.Context, workspace, domain, originalURL string) (_ *URL, err error) {
+	_, span := startSpan(ctx, "memory.GetByOriginalURL", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	code, ok := s.byOriginalURL[originalURL]
+	code, ok := s.byOriginalURL[originalKey{workspace, domain, originalURL}]
 	if !ok {
 		return nil, ErrNotFound
 	}
 
-	url := s.byCode[code]
+	url := s.byCode[domainKey{domain, code}]
 	if url.IsExpired() {
 		return nil, ErrExpired
 	}
@@ -75,6 +350,827 @@ func (s *MemoryStorage) GetByOriginalURL(originalURL string) (*URL, error) {
 	return url, nil
 }
 
+// ConsumeClick списывает один переход у ссылки с лимитом. Для ссылок
+// без лимита ничего не делает, при исчерпанном лимите возвращает ErrExhausted
+func (s *MemoryStorage) ConsumeClick(ctx context.Context, domain, code string) (err error) {
+	_, span := startSpan(ctx, "memory.ConsumeClick", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	// Проверка и уменьшение счетчика выполняются под блокировкой на запись,
+	// иначе параллельные переходы могут превысить лимит
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := domainKey{domain, code}
+	url, ok := s.byCode[key]
+	if !ok {
+		return ErrNotFound
+	}
+	if url.DeletedAt != nil {
+		return ErrDeleted
+	}
+	if url.IsExpired() {
+		return ErrExpired
+	}
+	if url.IsPending() {
+		return ErrNotYetActive
+	}
+	if url.ClicksLeft == nil {
+		return nil
+	}
+	if *url.ClicksLeft <= 0 {
+		return ErrExhausted
+	}
+
+	// Запись заменяется копией, поэтому выданные ранее указатели не меняются
+	updated := *url
+	left := *url.ClicksLeft - 1
+	updated.ClicksLeft = &left
+	s.byCode[key] = &updated
+
+	return nil
+}
+
+// RecordClick учитывает переход на вариант ссылки с номером variant (с нуля)
+func (s *MemoryStorage) RecordClick(ctx context.Context, domain, code string, variant int) (err error) {
+	_, span := startSpan(ctx, "memory.RecordClick", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	return s.updateTargets(ctx, domain, code, func(targets []Target) error {
+		if variant < 0 || variant >= len(targets) {
+			return ErrNotFound
+		}
+		targets[variant].Clicks++
+		return nil
+	})
+}
+
+// SetWeights меняет веса вариантов ссылки. Число весов должно совпадать с числом вариантов
+func (s *MemoryStorage) SetWeights(ctx context.Context, domain, code string, weights []int) (err error) {
+	_, span := startSpan(ctx, "memory.SetWeights", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	return s.updateTargets(ctx, domain, code, func(targets []Target) error {
+		if len(weights) != len(targets) {
+			return ErrNotFound
+		}
+		for i, w := range weights {
+			targets[i].Weight = w
+		}
+		return nil
+	})
+}
+
+// SetMetadata заменяет описание ссылки
+func (s *MemoryStorage) SetMetadata(ctx context.Context, domain, code string, meta Metadata) (err error) {
+	_, span := startSpan(ctx, "memory.SetMetadata", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	return s.updateURL(ctx, domain, code, func(u *URL) error {
+		u.Metadata = meta
+		return nil
+	})
+}
+
+// SetLabels заменяет метки и заметку ссылки
+func (s *MemoryStorage) SetLabels(ctx context.Context, domain, code string, labels Labels) (err error) {
+	_, span := startSpan(ctx, "memory.SetLabels", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	return s.updateURL(ctx, domain, code, func(u *URL) error {
+		s.unindexTags(u)
+		u.Tags = append([]string(nil), labels.Tags...)
+		u.Note = labels.Note
+		s.indexTags(u)
+		return nil
+	})
+}
+
+// UpdateLink применяет все изменения ссылки одной заменой записи. Если число
+// весов не совпадает с числом вариантов, ссылка не меняется и возвращается ErrNotFound
+func (s *MemoryStorage) UpdateLink(ctx context.Context, domain, code string, upd LinkUpdate) (err error) {
+	_, span := startSpan(ctx, "memory.UpdateLink", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	return s.updateURL(ctx, domain, code, func(u *URL) error {
+		if upd.Weights != nil && len(upd.Weights) != len(u.Targets) {
+			return ErrNotFound
+		}
+		if upd.Destination != nil {
+			s.replaceDestination(u, *upd.Destination)
+		}
+		if upd.Schedule {
+			u.NextURL, u.SwitchAt = upd.NextURL, upd.SwitchAt
+			if upd.NextURL == "" {
+				u.SwitchAt = nil
+			}
+		}
+		if upd.Weights != nil {
+			u.Targets = append([]Target(nil), u.Targets...)
+			for i, w := range upd.Weights {
+				u.Targets[i].Weight = w
+			}
+		}
+		if upd.Metadata != nil {
+			u.Metadata = *upd.Metadata
+		}
+		if upd.Labels != nil {
+			s.unindexTags(u)
+			u.Tags = append([]string(nil), upd.Labels.Tags...)
+			u.Note = upd.Labels.Note
+			s.indexTags(u)
+		}
+		return nil
+	})
+}
+
+// Delete помечает ссылку удаленной. Ссылка выходит из дедупликации и счетчика
+// пространства, а ее код закрывается сразу, до окончательного удаления
+func (s *MemoryStorage) Delete(ctx context.Context, domain, code string) (err error) {
+	_, span := startSpan(ctx, "memory.Delete", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	return s.updateURL(ctx, domain, code, func(u *URL) error {
+		s.hide(u)
+		now := time.Now()
+		u.DeletedAt, u.Custom = &now, true
+		s.tombstones[domainKey{domain, code}] = tombstoneOf(u)
+		return nil
+	})
+}
+
+// Restore снимает с ссылки пометку об удалении. Для неудаленной ссылки возвращает ErrNotFound
+func (s *MemoryStorage) Restore(ctx context.Context, domain, code string) (err error) {
+	_, span := startSpan(ctx, "memory.Restore", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := domainKey{domain, code}
+	url, ok := s.byCode[key]
+	if !ok || url.DeletedAt == nil {
+		return ErrNotFound
+	}
+	updated := *url
+	updated.DeletedAt = nil
+	s.unhide(&updated)
+	s.byCode[key] = &updated
+	s.recordAudit(ctx, domain, code)
+	return nil
+}
+
+// Purge окончательно удаляет до limit ссылок (0 - все), удаленных до before,
+// и возвращает их число. Коды ссылок остаются закрытыми
+func (s *MemoryStorage) Purge(ctx context.Context, before time.Time, limit int) (_ int, err error) {
+	_, span := startSpan(ctx, "memory.Purge", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, u := range s.byCode {
+		if limit > 0 && n == limit {
+			break
+		}
+		if u.DeletedAt != nil && u.DeletedAt.Before(before) {
+			s.remove(u)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// tombstoneOf возвращает закрытый код удаленной записи
+func tombstoneOf(u *URL) Tombstone {
+	return Tombstone{Workspace: u.Workspace, Domain: u.Domain, ShortCode: u.ShortCode, DeletedAt: *u.DeletedAt}
+}
+
+// Tombstones возвращает до q.Limit (0 - все) закрытых кодов окончательно удаленных ссылок
+func (s *MemoryStorage) Tombstones(ctx context.Context, q TombstoneQuery) (_ []Tombstone, err error) {
+	_, span := startSpan(ctx, "memory.Tombstones", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []Tombstone
+	for key, t := range s.tombstones {
+		if _, stored := s.byCode[key]; !stored && q.matches(&t) {
+			result = append(result, t)
+		}
+	}
+	slices.SortFunc(result, func(a, b Tombstone) int {
+		return cmp.Or(cmp.Compare(a.Domain, b.Domain), cmp.Compare(a.ShortCode, b.ShortCode))
+	})
+	if q.Limit > 0 && len(result) > q.Limit {
+		result = result[:q.Limit]
+	}
+	return result, nil
+}
+
+// SaveTombstone закрывает код окончательно удаленной ссылки. Уже закрытый код
+// не меняется; код существующей ссылки закрыть нельзя (ErrAlreadyExists)
+func (s *MemoryStorage) SaveTombstone(ctx context.Context, t Tombstone) (err error) {
+	_, span := startSpan(ctx, "memory.SaveTombstone", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := domainKey{t.Domain, t.ShortCode}
+	if _, ok := s.tombstones[key]; ok {
+		return nil
+	}
+	if _, ok := s.byCode[key]; ok {
+		return ErrAlreadyExists
+	}
+	s.tombstones[key] = t
+	return nil
+}
+
+// SetDestination меняет адрес назначения ссылки, сохраняя прежний как ревизию
+func (s *MemoryStorage) SetDestination(ctx context.Context, domain, code string, dest Destination) (err error) {
+	_, span := startSpan(ctx, "memory.SetDestination", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	return s.updateURL(ctx, domain, code, func(u *URL) error {
+		s.replaceDestination(u, dest)
+		return nil
+	})
+}
+
+// ListRevisions возвращает прежние адреса ссылки по возрастанию номера
+func (s *MemoryStorage) ListRevisions(ctx context.Context, domain, code string) (_ []Revision, err error) {
+	_, span := startSpan(ctx, "memory.ListRevisions", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]Revision(nil), s.revisions[domainKey{domain, code}]...), nil
+}
+
+// IterateRevisions возвращает до limit (0 - все) ревизий всех ссылок,
+// в том числе удаленных, следующих за after в порядке (домен, код, номер)
+func (s *MemoryStorage) IterateRevisions(ctx context.Context, after *LinkRevision, limit int) (_ []LinkRevision, err error) {
+	_, span := startSpan(ctx, "memory.IterateRevisions", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []LinkRevision
+	for key, revisions := range s.revisions {
+		for _, r := range revisions {
+			lr := LinkRevision{Domain: key.domain, ShortCode: key.value, Revision: r}
+			if after == nil || compareRevisions(*after, lr) < 0 {
+				result = append(result, lr)
+			}
+		}
+	}
+	slices.SortFunc(result, compareRevisions)
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// SaveRevision сохраняет перенесенную ревизию ссылки, в том числе удаленной.
+// Ревизия с тем же номером не меняется; для отсутствующей ссылки возвращает ErrNotFound
+func (s *MemoryStorage) SaveRevision(ctx context.Context, r LinkRevision) (err error) {
+	_, span := startSpan(ctx, "memory.SaveRevision", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := domainKey{r.Domain, r.ShortCode}
+	if _, ok := s.byCode[key]; !ok {
+		return ErrNotFound
+	}
+	revisions := s.revisions[key]
+	i, found := slices.BinarySearchFunc(revisions, r.Number, func(rev Revision, n int) int {
+		return cmp.Compare(rev.Number, n)
+	})
+	if !found {
+		s.revisions[key] = slices.Insert(slices.Clip(revisions), i, r.Revision)
+	}
+	return nil
+}
+
+// ScheduleSwitch планирует смену адреса ссылки на nextURL в момент at.
+// Пустой nextURL отменяет запланированную смену
+func (s *MemoryStorage) ScheduleSwitch(ctx context.Context, domain, code, nextURL string, at *time.Time) (err error) {
+	_, span := startSpan(ctx, "memory.ScheduleSwitch", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	return s.updateURL(ctx, domain, code, func(u *URL) error {
+		u.NextURL, u.SwitchAt = nextURL, at
+		if nextURL == "" {
+			u.SwitchAt = nil
+		}
+		return nil
+	})
+}
+
+// DueSwitches возвращает до limit ссылок всех доменов, смена адреса которых
+// наступила к моменту now, в порядке времени смены
+func (s *MemoryStorage) DueSwitches(ctx context.Context, now time.Time, limit int) (_ []*URL, err error) {
+	_, span := startSpan(ctx, "memory.DueSwitches", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []*URL
+	for _, u := range s.byCode {
+		if u.switchDue(now) {
+			result = append(result, u)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].SwitchAt.Before(*result[j].SwitchAt) })
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// ApplySwitch делает запланированный адрес ссылки текущим, если смена наступила
+// к моменту now. Иначе возвращает ErrNotFound
+func (s *MemoryStorage) ApplySwitch(ctx context.Context, domain, code string, now time.Time) (err error) {
+	_, span := startSpan(ctx, "memory.ApplySwitch", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	return s.updateURL(ctx, domain, code, func(u *URL) error {
+		if !u.switchDue(now) {
+			return ErrNotFound
+		}
+		s.replaceDestination(u, Destination{OriginalURL: u.NextURL})
+		return nil
+	})
+}
+
+// DueExpirations возвращает до limit ссылок всех доменов, срок которых истек
+// к моменту now, а истечение еще не записано, в порядке истечения
+func (s *MemoryStorage) DueExpirations(ctx context.Context, now time.Time, limit int) (_ []*URL, err error) {
+	_, span := startSpan(ctx, "memory.DueExpirations", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []*URL
+	for _, u := range s.byCode {
+		if u.expiryDue(now) {
+			result = append(result, u)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ExpiresAt.Before(*result[j].ExpiresAt) })
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// MarkExpired отмечает, что истечение срока ссылки записано, если срок истек
+// к моменту now. Иначе возвращает ErrNotFound
+func (s *MemoryStorage) MarkExpired(ctx context.Context, domain, code string, now time.Time) (err error) {
+	_, span := startSpan(ctx, "memory.MarkExpired", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	return s.updateURL(ctx, domain, code, func(u *URL) error {
+		if !u.expiryDue(now) {
+			return ErrNotFound
+		}
+		u.ExpiryNotified = true
+		return nil
+	})
+}
+
+// replaceDestination сохраняет адрес записи как ревизию и заменяет его.
+// Запланированная смена, ставшая текущим адресом, снимается. Ссылка
+// с измененным адресом больше не участвует в дедупликации.
+// Вызывается под блокировкой на запись последним шагом изменения
+func (s *MemoryStorage) replaceDestination(u *URL, dest Destination) {
+	key := domainKey{u.Domain, u.ShortCode}
+	s.revisions[key] = append(s.revisions[key], Revision{
+		Number:      len(s.revisions[key]) + 1,
+		Destination: Destination{OriginalURL: u.OriginalURL, UntaggedURL: u.UntaggedURL},
+		ReplacedAt:  time.Now(),
+	})
+	if !u.Custom {
+		delete(s.byOriginalURL, originalKeyOf(u))
+		u.Custom = true
+	}
+	if u.NextURL == dest.OriginalURL {
+		u.NextURL, u.SwitchAt = "", nil
+	}
+	u.OriginalURL, u.UntaggedURL = dest.OriginalURL, dest.UntaggedURL
+}
+
+// updateTargets применяет изменение к копии вариантов ссылки
+func (s *MemoryStorage) updateTargets(ctx context.Context, domain, code string, update func([]Target) error) error {
+	return s.updateURL(ctx, domain, code, func(u *URL) error {
+		u.Targets = append([]Target(nil), u.Targets...)
+		return update(u.Targets)
+	})
+}
+
+// updateURL применяет изменение к копии записи под блокировкой на запись.
+// Запись заменяется копией, поэтому выданные ранее указатели не меняются.
+// Событие журнала из ctx сохраняется вместе с изменением.
+// Удаленные ссылки не меняются: для них возвращается ErrNotFound
+func (s *MemoryStorage) updateURL(ctx context.Context, domain, code string, update func(*URL) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := domainKey{domain, code}
+	url, ok := s.byCode[key]
+	if !ok || url.DeletedAt != nil {
+		return ErrNotFound
+	}
+
+	updated := *url
+	if err := update(&updated); err != nil {
+		return err
+	}
+	s.byCode[key] = &updated
+	s.recordAudit(ctx, domain, code)
+
+	return nil
+}
+
+// recordAudit добавляет в журнал событие из ctx, если оно есть, и ставит
+// его в очередь доставки вебхуков.
+// Вызывается под блокировкой на запись вместе с изменением
+func (s *MemoryStorage) recordAudit(ctx context.Context, domain, code string) {
+	e, ok := auditFrom(ctx, domain, code)
+	if !ok {
+		return
+	}
+	e.ID = 1
+	if n := len(s.audit); n > 0 {
+		e.ID = s.audit[n-1].ID + 1
+	}
+	s.audit = append(s.audit, e)
+	if event, ok := eventOf(e); ok {
+		// Событие журнала уже сериализуемо, поэтому ошибки здесь не бывает
+		_ = s.enqueue(event)
+	}
+}
+
+// ListAudit возвращает события журнала по фильтрам, от новых к старым
+func (s *MemoryStorage) ListAudit(ctx context.Context, q AuditQuery) (_ []AuditEvent, err error) {
+	_, span := startSpan(ctx, "memory.ListAudit", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	end := len(s.audit)
+	if q.BeforeID > 0 {
+		end = s.auditIndex(q.BeforeID)
+	}
+	var result []AuditEvent
+	for i := end - 1; i >= 0 && (q.Limit <= 0 || len(result) < q.Limit); i-- {
+		if q.matches(&s.audit[i]) {
+			result = append(result, s.audit[i])
+		}
+	}
+	return result, nil
+}
+
+// auditIndex возвращает позицию первого события журнала с ID не меньше id.
+// Перенесенный журнал может содержать пропуски ID
+func (s *MemoryStorage) auditIndex(id int64) int {
+	i, _ := slices.BinarySearchFunc(s.audit, id, func(e AuditEvent, id int64) int {
+		return cmp.Compare(e.ID, id)
+	})
+	return i
+}
+
+// IterateAudit возвращает до limit (0 - все) событий журнала всех пространств
+// с ID больше afterID по возрастанию ID
+func (s *MemoryStorage) IterateAudit(ctx context.Context, afterID int64, limit int) (_ []AuditEvent, err error) {
+	_, span := startSpan(ctx, "memory.IterateAudit", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := s.audit[s.auditIndex(afterID+1):]
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return slices.Clone(events), nil
+}
+
+// SaveAudit сохраняет перенесенное событие журнала с его ID, не ставя его
+// в очередь доставки. То же событие повторно не сохраняется; другое событие
+// с тем же ID - ErrAlreadyExists
+func (s *MemoryStorage) SaveAudit(ctx context.Context, e AuditEvent) (err error) {
+	_, span := startSpan(ctx, "memory.SaveAudit", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.auditIndex(e.ID)
+	if i < len(s.audit) && s.audit[i].ID == e.ID {
+		if !sameAuditEvent(&s.audit[i], &e) {
+			return ErrAlreadyExists
+		}
+		return nil
+	}
+	s.audit = slices.Insert(s.audit, i, e)
+	return nil
+}
+
+// CreateWebhook сохраняет подписку и возвращает ее ID
+func (s *MemoryStorage) CreateWebhook(ctx context.Context, w Webhook) (_ int64, err error) {
+	_, span := startSpan(ctx, "memory.CreateWebhook", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastWebhookID++
+	w.ID = s.lastWebhookID
+	w.Events = slices.Clone(w.Events)
+	s.webhooks[w.ID] = &w
+	return w.ID, nil
+}
+
+// GetWebhook возвращает подписку по ID
+func (s *MemoryStorage) GetWebhook(ctx context.Context, id int64) (_ *Webhook, err error) {
+	_, span := startSpan(ctx, "memory.GetWebhook", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	w, ok := s.webhooks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	found := *w
+	return &found, nil
+}
+
+// ListWebhooks возвращает подписки пространства по возрастанию ID
+func (s *MemoryStorage) ListWebhooks(ctx context.Context, workspace string) (_ []Webhook, err error) {
+	_, span := startSpan(ctx, "memory.ListWebhooks", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.subscriptions(func(w *Webhook) bool { return w.Workspace == workspace }), nil
+}
+
+// subscriptions возвращает копии подписок, подходящих под filter, по возрастанию ID
+func (s *MemoryStorage) subscriptions(filter func(*Webhook) bool) []Webhook {
+	var result []Webhook
+	for _, w := range s.webhooks {
+		if filter(w) {
+			result = append(result, *w)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// DeleteWebhook удаляет подписку пространства вместе с ее доставками
+func (s *MemoryStorage) DeleteWebhook(ctx context.Context, workspace string, id int64) (err error) {
+	_, span := startSpan(ctx, "memory.DeleteWebhook", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.webhooks[id]
+	if !ok || w.Workspace != workspace {
+		return ErrNotFound
+	}
+	delete(s.webhooks, id)
+	s.deliveries = slices.DeleteFunc(s.deliveries, func(d Delivery) bool {
+		if d.WebhookID != id {
+			return false
+		}
+		delete(s.pending, d.ID)
+		return true
+	})
+	return nil
+}
+
+// SubscribedWorkspaces возвращает пространства, в которых есть подписка на событие event
+func (s *MemoryStorage) SubscribedWorkspaces(ctx context.Context, event string) (_ []string, err error) {
+	_, span := startSpan(ctx, "memory.SubscribedWorkspaces", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []string
+	for _, w := range s.webhooks {
+		e := Event{Type: event, Workspace: w.Workspace}
+		if w.accepts(&e) && !slices.Contains(result, w.Workspace) {
+			result = append(result, w.Workspace)
+		}
+	}
+	slices.Sort(result)
+	return result, nil
+}
+
+// Publish ставит событие в очередь доставки подписчикам его пространства
+func (s *MemoryStorage) Publish(ctx context.Context, e Event) (err error) {
+	_, span := startSpan(ctx, "memory.Publish", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	// Событие без подписчиков не берет блокировку на запись, чтобы переходы
+	// не ждали друг друга
+	s.mu.RLock()
+	subscribed := s.subscribed(&e)
+	s.mu.RUnlock()
+	if !subscribed {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.enqueue(e)
+}
+
+// subscribed сообщает, что событие принимает хотя бы одна подписка
+func (s *MemoryStorage) subscribed(e *Event) bool {
+	for _, w := range s.webhooks {
+		if w.accepts(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue добавляет доставку события каждой подходящей подписке.
+// Вызывается под блокировкой на запись
+func (s *MemoryStorage) enqueue(e Event) error {
+	subs := s.subscriptions(func(w *Webhook) bool { return w.accepts(&e) })
+	if len(subs) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding event: %w", err)
+	}
+	now := time.Now()
+	for _, w := range subs {
+		s.lastDelivery++
+		s.deliveries = append(s.deliveries, Delivery{
+			ID:            s.lastDelivery,
+			WebhookID:     w.ID,
+			Workspace:     w.Workspace,
+			Event:         e.Type,
+			URL:           w.URL,
+			Payload:       payload,
+			Status:        DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+		s.pending[s.lastDelivery] = struct{}{}
+	}
+	return nil
+}
+
+// ClaimDeliveries возвращает до limit ожидающих доставок, время попытки которых
+// наступило к моменту now, и откладывает их следующую попытку на lease, чтобы
+// другие обработчики их не взяли
+func (s *MemoryStorage) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) (_ []Delivery, err error) {
+	_, span := startSpan(ctx, "memory.ClaimDeliveries", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Просматриваются только ожидающие доставки, а не весь журнал
+	var due []*Delivery
+	for id := range s.pending {
+		if d := s.delivery(id); d != nil && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	slices.SortFunc(due, func(a, b *Delivery) int {
+		return cmp.Or(a.NextAttemptAt.Compare(b.NextAttemptAt), cmp.Compare(a.ID, b.ID))
+	})
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+	result := make([]Delivery, 0, len(due))
+	for _, d := range due {
+		d.NextAttemptAt = now.Add(lease)
+		result = append(result, *d)
+	}
+	return result, nil
+}
+
+// UpdateDelivery сохраняет состояние, число попыток и итог последней попытки доставки
+func (s *MemoryStorage) UpdateDelivery(ctx context.Context, d Delivery) (err error) {
+	_, span := startSpan(ctx, "memory.UpdateDelivery", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.delivery(d.ID)
+	if stored == nil {
+		return ErrNotFound
+	}
+	stored.Status, stored.Attempts, stored.NextAttemptAt = d.Status, d.Attempts, d.NextAttemptAt
+	stored.LastStatus, stored.LastError, stored.UpdatedAt = d.LastStatus, d.LastError, d.UpdatedAt
+	if stored.Status == DeliveryPending {
+		s.pending[stored.ID] = struct{}{}
+	} else {
+		delete(s.pending, stored.ID)
+	}
+	return nil
+}
+
+// RetryDelivery возвращает доставку пространства в очередь с немедленной
+// попыткой и обнуляет счетчик попыток
+func (s *MemoryStorage) RetryDelivery(ctx context.Context, workspace string, id int64, now time.Time) (err error) {
+	_, span := startSpan(ctx, "memory.RetryDelivery", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := s.delivery(id)
+	if d == nil || d.Workspace != workspace {
+		return ErrNotFound
+	}
+	d.Status, d.Attempts, d.NextAttemptAt, d.UpdatedAt = DeliveryPending, 0, now, now
+	s.pending[d.ID] = struct{}{}
+	return nil
+}
+
+// delivery возвращает доставку по ID или nil
+func (s *MemoryStorage) delivery(id int64) *Delivery {
+	i, ok := slices.BinarySearchFunc(s.deliveries, id, func(d Delivery, id int64) int {
+		return cmp.Compare(d.ID, id)
+	})
+	if !ok {
+		return nil
+	}
+	return &s.deliveries[i]
+}
+
+// ListDeliveries возвращает доставки по фильтрам, от новых к старым
+func (s *MemoryStorage) ListDeliveries(ctx context.Context, q DeliveryQuery) (_ []Delivery, err error) {
+	_, span := startSpan(ctx, "memory.ListDeliveries", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	end := len(s.deliveries)
+	if q.BeforeID > 0 {
+		end = sort.Search(len(s.deliveries), func(i int) bool { return s.deliveries[i].ID >= q.BeforeID })
+	}
+	var result []Delivery
+	for i := end - 1; i >= 0 && (q.Limit <= 0 || len(result) < q.Limit); i-- {
+		if q.matches(&s.deliveries[i]) {
+			result = append(result, s.deliveries[i])
+		}
+	}
+	return result, nil
+}
+
+// PurgeDeliveries удаляет до limit (0 - все) доставок, отправленных до before,
+// и возвращает их число. Ожидающие и неотправленные доставки не удаляются
+func (s *MemoryStorage) PurgeDeliveries(ctx context.Context, before time.Time, limit int) (_ int, err error) {
+	_, span := startSpan(ctx, "memory.PurgeDeliveries", memoryAttrs()...)
+	defer func() { finishSpan(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	s.deliveries = slices.DeleteFunc(s.deliveries, func(d Delivery) bool {
+		if limit > 0 && n == limit {
+			return false
+		}
+		if d.Status == DeliveryDelivered && d.UpdatedAt.Before(before) {
+			n++
+			return true
+		}
+		return false
+	})
+	return n, nil
+}
+
 // Close закрывает хранилище. Для хранения данных в памяти это не требуется
 func (s *MemoryStorage) Close() error {
 	return nil
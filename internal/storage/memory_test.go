@@ -1,13 +1,21 @@
 package storage
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/BuzzLyutic/url-shortener/internal/rules"
 )
 
 func TestMemoryStorage_Save(t *testing.T) {
 	s := NewMemoryStorage()
+	ctx := context.Background()
 
 	url := URL{
 		ShortCode:   "aB3_xY9z12",
@@ -15,12 +23,12 @@ func TestMemoryStorage_Save(t *testing.T) {
 		CreatedAt:   time.Now(),
 	}
 
-	err := s.Save(url)
+	err := s.Save(ctx, url)
 	if err != nil {
 		t.Fatalf("Save() error = %v", err)
 	}
 
-	err = s.Save(url)
+	err = s.Save(ctx, url)
 	if err != nil {
 		t.Fatalf("Save() idempotent error = %v", err)
 	}
@@ -30,7 +38,7 @@ func TestMemoryStorage_Save(t *testing.T) {
 		OriginalURL: "https://different.com",
 		CreatedAt:   time.Now(),
 	}
-	err = s.Save(url2)
+	err = s.Save(ctx, url2)
 	if err != ErrAlreadyExists {
 		t.Errorf("Save() error = %v, want %v", err, ErrAlreadyExists)
 	}
@@ -38,16 +46,17 @@ func TestMemoryStorage_Save(t *testing.T) {
 
 func TestMemoryStorage_GetByCode(t *testing.T) {
 	s := NewMemoryStorage()
+	ctx := context.Background()
 
 	url := URL{
 		ShortCode:   "aB3_xY9z12",
 		OriginalURL: "https://example.com",
 		CreatedAt:   time.Now(),
 	}
-	_ = s.Save(url)
+	_ = s.Save(ctx, url)
 
 	// Должен найти существующий URL
-	got, err := s.GetByCode("aB3_xY9z12")
+	got, err := s.GetByCode(ctx, "", "aB3_xY9z12")
 	if err != nil {
 		t.Fatalf("GetByCode() error = %v", err)
 	}
@@ -56,7 +65,7 @@ func TestMemoryStorage_GetByCode(t *testing.T) {
 	}
 
 	// Должен вернуть ErrNotFound для несуществующего кода
-	_, err = s.GetByCode("nonexistent")
+	_, err = s.GetByCode(ctx, "", "nonexistent")
 	if err != ErrNotFound {
 		t.Errorf("GetByCode() error = %v, want %v", err, ErrNotFound)
 	}
@@ -64,15 +73,16 @@ func TestMemoryStorage_GetByCode(t *testing.T) {
 
 func TestMemoryStorage_GetByOriginalURL(t *testing.T) {
 	s := NewMemoryStorage()
+	ctx := context.Background()
 
 	url := URL{
 		ShortCode:   "aB3_xY9z12",
 		OriginalURL: "https://example.com",
 		CreatedAt:   time.Now(),
 	}
-	_ = s.Save(url)
+	_ = s.Save(ctx, url)
 
-	got, err := s.GetByOriginalURL("https://example.com")
+	got, err := s.GetByOriginalURL(ctx, "", "", "https://example.com")
 	if err != nil {
 		t.Fatalf("GetByOriginalURL() error = %v", err)
 	}
@@ -80,14 +90,134 @@ func TestMemoryStorage_GetByOriginalURL(t *testing.T) {
 		t.Errorf("GetByOriginalURL() ShortCode = %v, want %v", got.ShortCode, url.ShortCode)
 	}
 
-	_, err = s.GetByOriginalURL("https://nonexistent.com")
+	_, err = s.GetByOriginalURL(ctx, "", "", "https://nonexistent.com")
+	if err != ErrNotFound {
+		t.Errorf("GetByOriginalURL() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestMemoryStorage_Domains(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	primary := URL{ShortCode: "aB3_xY9z12", OriginalURL: "https://example.com/a", CreatedAt: time.Now()}
+	other := URL{Domain: "go.example", ShortCode: "aB3_xY9z12", OriginalURL: "https://example.com/b", CreatedAt: time.Now()}
+
+	if err := s.Save(ctx, primary); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	// Тот же код на другом домене не является коллизией
+	if err := s.Save(ctx, other); err != nil {
+		t.Fatalf("Save() on other domain error = %v", err)
+	}
+
+	got, err := s.GetByCode(ctx, "go.example", "aB3_xY9z12")
+	if err != nil {
+		t.Fatalf("GetByCode() error = %v", err)
+	}
+	if got.OriginalURL != other.OriginalURL {
+		t.Errorf("GetByCode() OriginalURL = %v, want %v", got.OriginalURL, other.OriginalURL)
+	}
+
+	got, err = s.GetByCode(ctx, "", "aB3_xY9z12")
+	if err != nil {
+		t.Fatalf("GetByCode() error = %v", err)
+	}
+	if got.OriginalURL != primary.OriginalURL {
+		t.Errorf("GetByCode() OriginalURL = %v, want %v", got.OriginalURL, primary.OriginalURL)
+	}
+
+	_, err = s.GetByOriginalURL(ctx, "", "go.example", primary.OriginalURL)
 	if err != ErrNotFound {
 		t.Errorf("GetByOriginalURL() error = %v, want %v", err, ErrNotFound)
 	}
 }
 
+func TestMemoryStorage_Custom(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	plain := URL{ShortCode: "aB3_xY9z12", OriginalURL: "https://example.com/doc", CreatedAt: time.Now()}
+	custom := URL{
+		ShortCode:    "cUst0m_123",
+		OriginalURL:  plain.OriginalURL,
+		CreatedAt:    time.Now(),
+		PasswordHash: "hash",
+		Custom:       true,
+	}
+
+	if err := s.Save(ctx, custom); err != nil {
+		t.Fatalf("Save() custom error = %v", err)
+	}
+	// Custom ссылка не находится по оригинальному URL
+	if _, err := s.GetByOriginalURL(ctx, "", "", plain.OriginalURL); err != ErrNotFound {
+		t.Errorf("GetByOriginalURL() error = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.Save(ctx, plain); err != nil {
+		t.Fatalf("Save() plain error = %v", err)
+	}
+
+	got, err := s.GetByOriginalURL(ctx, "", "", plain.OriginalURL)
+	if err != nil {
+		t.Fatalf("GetByOriginalURL() error = %v", err)
+	}
+	if got.ShortCode != plain.ShortCode {
+		t.Errorf("GetByOriginalURL() ShortCode = %v, want %v", got.ShortCode, plain.ShortCode)
+	}
+
+	got, err = s.GetByCode(ctx, "", custom.ShortCode)
+	if err != nil {
+		t.Fatalf("GetByCode() error = %v", err)
+	}
+	if got.PasswordHash != "hash" || !got.Custom {
+		t.Errorf("GetByCode() = %+v, want password hash and custom flag", got)
+	}
+
+	// Повторное сохранение custom ссылки с тем же кодом - коллизия, а не идемпотентность
+	if err := s.Save(ctx, custom); err != ErrAlreadyExists {
+		t.Errorf("Save() duplicate custom error = %v, want %v", err, ErrAlreadyExists)
+	}
+}
+
+func TestMemoryStorage_ConsumeClick(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	limit := 1
+	limited := URL{ShortCode: "limited_01", OriginalURL: "https://example.com/once", CreatedAt: time.Now(), ClicksLeft: &limit, Custom: true}
+	plain := URL{ShortCode: "unlimited1", OriginalURL: "https://example.com/many", CreatedAt: time.Now()}
+	s.Save(ctx, limited)
+	s.Save(ctx, plain)
+
+	before, _ := s.GetByCode(ctx, "", limited.ShortCode)
+
+	if err := s.ConsumeClick(ctx, "", limited.ShortCode); err != nil {
+		t.Fatalf("ConsumeClick() error = %v", err)
+	}
+	if err := s.ConsumeClick(ctx, "", limited.ShortCode); err != ErrExhausted {
+		t.Errorf("ConsumeClick() over limit error = %v, want %v", err, ErrExhausted)
+	}
+
+	got, _ := s.GetByCode(ctx, "", limited.ShortCode)
+	if got.ClicksLeft == nil || *got.ClicksLeft != 0 {
+		t.Errorf("ClicksLeft = %v, want 0", got.ClicksLeft)
+	}
+	// Ранее выданная запись не изменяется
+	if *before.ClicksLeft != 1 {
+		t.Errorf("previously returned ClicksLeft = %d, want 1", *before.ClicksLeft)
+	}
+
+	if err := s.ConsumeClick(ctx, "", plain.ShortCode); err != nil {
+		t.Errorf("ConsumeClick() without limit error = %v", err)
+	}
+	if err := s.ConsumeClick(ctx, "", "nonexist12"); err != ErrNotFound {
+		t.Errorf("ConsumeClick() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
 func TestMemoryStorage_Expiration(t *testing.T) {
 	s := NewMemoryStorage()
+	ctx := context.Background()
 
 	pastTime := time.Now().Add(-1 * time.Hour)
 	url := URL{
@@ -96,21 +226,45 @@ func TestMemoryStorage_Expiration(t *testing.T) {
 		CreatedAt:   time.Now(),
 		ExpiresAt:   &pastTime,
 	}
-	_ = s.Save(url)
+	_ = s.Save(ctx, url)
 
-	_, err := s.GetByCode("expired123")
+	got, err := s.GetByCode(ctx, "", "expired123")
 	if err != ErrExpired {
 		t.Errorf("GetByCode() error = %v, want %v", err, ErrExpired)
 	}
+	if got == nil {
+		t.Error("GetByCode() should return expired record with error")
+	}
 
-	_, err = s.GetByOriginalURL("https://example.com")
+	_, err = s.GetByOriginalURL(ctx, "", "", "https://example.com")
 	if err != ErrExpired {
 		t.Errorf("GetByOriginalURL() error = %v, want %v", err, ErrExpired)
 	}
 }
 
+func TestMemoryStorage_NotYetActive(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	future := time.Now().Add(time.Hour)
+	s.Save(ctx, URL{ShortCode: "pending123", OriginalURL: "https://example.com/launch", CreatedAt: time.Now(), NotBefore: &future, Custom: true})
+
+	got, err := s.GetByCode(ctx, "", "pending123")
+	if err != ErrNotYetActive {
+		t.Errorf("GetByCode() error = %v, want %v", err, ErrNotYetActive)
+	}
+	// Запись возвращается вместе с ошибкой
+	if got == nil || got.OriginalURL != "https://example.com/launch" {
+		t.Errorf("GetByCode() = %v, want record", got)
+	}
+	if err := s.ConsumeClick(ctx, "", "pending123"); err != ErrNotYetActive {
+		t.Errorf("ConsumeClick() error = %v, want %v", err, ErrNotYetActive)
+	}
+}
+
 func TestMemoryStorage_NotExpired(t *testing.T) {
 	s := NewMemoryStorage()
+	ctx := context.Background()
 
 	futureTime := time.Now().Add(1 * time.Hour)
 	url := URL{
@@ -119,9 +273,9 @@ func TestMemoryStorage_NotExpired(t *testing.T) {
 		CreatedAt:   time.Now(),
 		ExpiresAt:   &futureTime,
 	}
-	_ = s.Save(url)
+	_ = s.Save(ctx, url)
 
-	got, err := s.GetByCode("future1234")
+	got, err := s.GetByCode(ctx, "", "future1234")
 	if err != nil {
 		t.Fatalf("GetByCode() error = %v", err)
 	}
@@ -132,6 +286,7 @@ func TestMemoryStorage_NotExpired(t *testing.T) {
 
 func TestMemoryStorage_NoExpiration(t *testing.T) {
 	s := NewMemoryStorage()
+	ctx := context.Background()
 
 	url := URL{
 		ShortCode:   "noexpire12",
@@ -139,9 +294,9 @@ func TestMemoryStorage_NoExpiration(t *testing.T) {
 		CreatedAt:   time.Now(),
 		ExpiresAt:   nil,
 	}
-	_ = s.Save(url)
+	_ = s.Save(ctx, url)
 
-	got, err := s.GetByCode("noexpire12")
+	got, err := s.GetByCode(ctx, "", "noexpire12")
 	if err != nil {
 		t.Fatalf("GetByCode() error = %v", err)
 	}
@@ -152,6 +307,7 @@ func TestMemoryStorage_NoExpiration(t *testing.T) {
 
 func TestMemoryStorage_Concurrent(t *testing.T) {
 	s := NewMemoryStorage()
+	ctx := context.Background()
 
 	const numGoroutines = 100
 	const numOperations = 100
@@ -169,9 +325,9 @@ func TestMemoryStorage_Concurrent(t *testing.T) {
 					OriginalURL: "https://example.com/" + string(rune('A'+id%26)) + string(rune('0'+j%10)),
 					CreatedAt:   time.Now(),
 				}
-				_ = s.Save(url)
-				_, _ = s.GetByCode(url.ShortCode)
-				_, _ = s.GetByOriginalURL(url.OriginalURL)
+				_ = s.Save(ctx, url)
+				_, _ = s.GetByCode(ctx, "", url.ShortCode)
+				_, _ = s.GetByOriginalURL(ctx, "", "", url.OriginalURL)
 			}
 		}(i)
 	}
@@ -216,6 +372,7 @@ func TestURL_IsExpired(t *testing.T) {
 
 func BenchmarkMemoryStorage_Save(b *testing.B) {
 	s := NewMemoryStorage()
+	ctx := context.Background()
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -224,44 +381,47 @@ func BenchmarkMemoryStorage_Save(b *testing.B) {
 			OriginalURL: "https://example.com/" + string(rune('A'+i%26)),
 			CreatedAt:   time.Now(),
 		}
-		_ = s.Save(url)
+		_ = s.Save(ctx, url)
 	}
 }
 
 func BenchmarkMemoryStorage_GetByCode(b *testing.B) {
 	s := NewMemoryStorage()
+	ctx := context.Background()
 
 	url := URL{
 		ShortCode:   "aB3_xY9z12",
 		OriginalURL: "https://example.com",
 		CreatedAt:   time.Now(),
 	}
-	_ = s.Save(url)
+	_ = s.Save(ctx, url)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _ = s.GetByCode("aB3_xY9z12")
+		_, _ = s.GetByCode(ctx, "", "aB3_xY9z12")
 	}
 }
 
 func BenchmarkMemoryStorage_GetByOriginalURL(b *testing.B) {
 	s := NewMemoryStorage()
+	ctx := context.Background()
 
 	url := URL{
 		ShortCode:   "aB3_xY9z12",
 		OriginalURL: "https://example.com",
 		CreatedAt:   time.Now(),
 	}
-	_ = s.Save(url)
+	_ = s.Save(ctx, url)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _ = s.GetByOriginalURL("https://example.com")
+		_, _ = s.GetByOriginalURL(ctx, "", "", "https://example.com")
 	}
 }
 
 func BenchmarkMemoryStorage_Concurrent(b *testing.B) {
 	s := NewMemoryStorage()
+	ctx := context.Background()
 
 	for i := 0; i < 1000; i++ {
 		url := URL{
@@ -269,7 +429,7 @@ func BenchmarkMemoryStorage_Concurrent(b *testing.B) {
 			OriginalURL: "https://example.com/" + string(rune(i)),
 			CreatedAt:   time.Now(),
 		}
-		_ = s.Save(url)
+		_ = s.Save(ctx, url)
 	}
 
 	b.ResetTimer()
@@ -277,8 +437,845 @@ func BenchmarkMemoryStorage_Concurrent(b *testing.B) {
 		i := 0
 		for pb.Next() {
 			code := "code" + string(rune('A'+i%26)) + string(rune('0'+i%10))
-			_, _ = s.GetByCode(code)
+			_, _ = s.GetByCode(ctx, "", code)
 			i++
 		}
 	})
 }
+
+func TestMemoryStorage_Targets(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	u := URL{
+		ShortCode:   "abtest_001",
+		OriginalURL: "https://example.com/a",
+		CreatedAt:   time.Now(),
+		Targets: []Target{
+			{URL: "https://example.com/a", Weight: 1},
+			{URL: "https://example.com/b", Weight: 3},
+		},
+		Custom: true,
+	}
+	s.Save(ctx, u)
+	before, _ := s.GetByCode(ctx, "", u.ShortCode)
+
+	if err := s.RecordClick(ctx, "", u.ShortCode, 1); err != nil {
+		t.Fatalf("RecordClick() error = %v", err)
+	}
+	if err := s.SetWeights(ctx, "", u.ShortCode, []int{5, 0}); err != nil {
+		t.Fatalf("SetWeights() error = %v", err)
+	}
+
+	got, _ := s.GetByCode(ctx, "", u.ShortCode)
+	if got.Targets[1].Clicks != 1 || got.Targets[0].Weight != 5 || got.Targets[1].Weight != 0 {
+		t.Errorf("Targets = %+v", got.Targets)
+	}
+	// Ранее выданная запись не изменяется
+	if before.Targets[1].Clicks != 0 || before.Targets[0].Weight != 1 {
+		t.Errorf("previously returned Targets = %+v", before.Targets)
+	}
+
+	if err := s.RecordClick(ctx, "", u.ShortCode, 2); err != ErrNotFound {
+		t.Errorf("RecordClick() unknown variant error = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.SetWeights(ctx, "", u.ShortCode, []int{1}); err != ErrNotFound {
+		t.Errorf("SetWeights() wrong count error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestMemoryStorage_Rules(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	list := []rules.Rule{{Platform: rules.PlatformIOS, URL: "https://apps.apple.com/app/id1"}}
+	s.Save(ctx, URL{ShortCode: "rules_0001", OriginalURL: "https://example.com/app", CreatedAt: time.Now(), Rules: list, Custom: true})
+
+	// Изменение исходного среза не влияет на сохраненную запись
+	list[0].URL = "https://example.com/changed"
+
+	got, err := s.GetByCode(ctx, "", "rules_0001")
+	if err != nil {
+		t.Fatalf("GetByCode() error = %v", err)
+	}
+	if len(got.Rules) != 1 || got.Rules[0].URL != "https://apps.apple.com/app/id1" {
+		t.Errorf("Rules = %+v", got.Rules)
+	}
+}
+
+func TestMemoryStorage_SetMetadata(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	s.Save(ctx, URL{ShortCode: "meta_00001", OriginalURL: "https://example.com/post", CreatedAt: time.Now()})
+	before, _ := s.GetByCode(ctx, "", "meta_00001")
+
+	meta := Metadata{Title: "Post", Description: "About", ImageURL: "https://example.com/cover.png"}
+	if err := s.SetMetadata(ctx, "", "meta_00001", meta); err != nil {
+		t.Fatalf("SetMetadata() error = %v", err)
+	}
+
+	got, _ := s.GetByCode(ctx, "", "meta_00001")
+	if got.Metadata != meta {
+		t.Errorf("Metadata = %+v, want %+v", got.Metadata, meta)
+	}
+	if !before.Metadata.IsZero() {
+		t.Errorf("previously returned Metadata = %+v, want zero", before.Metadata)
+	}
+	if err := s.SetMetadata(ctx, "", "nonexist12", meta); err != ErrNotFound {
+		t.Errorf("SetMetadata() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestMemoryStorage_SetLabels(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+	base := time.Now()
+
+	for i, tags := range [][]string{{"promo"}, {"promo", "q1"}, {"q1"}} {
+		s.Save(ctx, URL{
+			ShortCode:   fmt.Sprintf("label%05d", i),
+			OriginalURL: fmt.Sprintf("https://example.com/%d", i),
+			CreatedAt:   base.Add(time.Duration(i) * time.Second),
+			Labels:      Labels{Tags: tags},
+			Custom:      true,
+		})
+	}
+	codes := func(tags ...string) []string {
+		urls, _ := s.List(ctx, ListQuery{Tags: tags})
+		var result []string
+		for _, u := range urls {
+			result = append(result, u.ShortCode)
+		}
+		return result
+	}
+
+	if got := codes("promo", "q1"); !slices.Equal(got, []string{"label00001"}) {
+		t.Errorf("List(promo, q1) = %v", got)
+	}
+
+	labels := Labels{Tags: []string{"q1"}, Note: "moved"}
+	if err := s.SetLabels(ctx, "", "label00000", labels); err != nil {
+		t.Fatalf("SetLabels() error = %v", err)
+	}
+	got, _ := s.GetByCode(ctx, "", "label00000")
+	if !slices.Equal(got.Tags, labels.Tags) || got.Note != labels.Note {
+		t.Errorf("Labels = %+v, want %+v", got.Labels, labels)
+	}
+	// Индексы меток обновляются вместе с записью
+	if got := codes("promo"); !slices.Equal(got, []string{"label00001"}) {
+		t.Errorf("List(promo) = %v", got)
+	}
+	if got := codes("q1"); len(got) != 3 {
+		t.Errorf("List(q1) = %v, want 3 links", got)
+	}
+	if got := codes("missing"); len(got) != 0 {
+		t.Errorf("List(missing) = %v, want none", got)
+	}
+	if err := s.SetLabels(ctx, "", "nonexist12", labels); err != ErrNotFound {
+		t.Errorf("SetLabels() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestMemoryStorage_List(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	past := base.Add(time.Hour)
+	// Ссылки сохраняются не в порядке создания
+	for _, u := range []URL{
+		{ShortCode: "list_00003", OriginalURL: "https://docs.example.com/c", CreatedAt: base.Add(3 * time.Minute), CreatedBy: "bob"},
+		{ShortCode: "list_00001", OriginalURL: "https://example.com/a", CreatedAt: base.Add(time.Minute), CreatedBy: "alice"},
+		{ShortCode: "list_00004", OriginalURL: "https://Example.com/d?utm_source=mail", UntaggedURL: "https://Example.com/d", CreatedAt: base.Add(4 * time.Minute), CreatedBy: "alice", ExpiresAt: &past},
+		{ShortCode: "list_00002", OriginalURL: "https://example.com/b", CreatedAt: base.Add(2 * time.Minute), CreatedBy: "bob"},
+		{ShortCode: "other_0001", Domain: "go.example", OriginalURL: "https://example.com/a", CreatedAt: base},
+	} {
+		if err := s.Save(ctx, u); err != nil {
+			t.Fatalf("Save(%s) error = %v", u.ShortCode, err)
+		}
+	}
+
+	codes := func(urls []*URL) []string {
+		result := make([]string, len(urls))
+		for i, u := range urls {
+			result[i] = u.ShortCode
+		}
+		return result
+	}
+
+	// Постраничный обход в обе стороны
+	for _, desc := range []bool{false, true} {
+		var got []string
+		q := ListQuery{Descending: desc, Limit: 3}
+		for {
+			page, err := s.List(ctx, q)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			got = append(got, codes(page)...)
+			if len(page) < q.Limit {
+				break
+			}
+			c := CursorOf(page[len(page)-1])
+			q.After = &c
+		}
+		want := []string{"list_00001", "list_00002", "list_00003", "list_00004"}
+		if desc {
+			want = []string{"list_00004", "list_00003", "list_00002", "list_00001"}
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("List(desc=%v) = %v, want %v", desc, got, want)
+		}
+	}
+
+	from, to := base.Add(2*time.Minute), base.Add(4*time.Minute)
+	tests := []struct {
+		name string
+		q    ListQuery
+		want string
+	}{
+		{"created by", ListQuery{CreatedBy: "alice"}, "list_00001,list_00004"},
+		{"host", ListQuery{DestHost: "EXAMPLE.com"}, "list_00001,list_00002,list_00004"},
+		{"contains", ListQuery{Contains: "EXAMPLE.com/D"}, "list_00004"},
+		{"created range", ListQuery{CreatedFrom: &from, CreatedTo: &to}, "list_00002,list_00003"},
+		{"active", ListQuery{Status: StatusActive}, "list_00001,list_00002,list_00003"},
+		{"expired", ListQuery{Status: StatusExpired}, "list_00004"},
+		{"domain", ListQuery{Domain: "go.example"}, "other_0001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.List(ctx, tt.q)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if strings.Join(codes(got), ",") != tt.want {
+				t.Errorf("List() = %v, want %s", codes(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStorage_Replace(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	s.Save(ctx, URL{ShortCode: "replace001", OriginalURL: "https://example.com/old", CreatedAt: time.Now()})
+	s.Save(ctx, URL{ShortCode: "replace002", OriginalURL: "https://example.com/taken", CreatedAt: time.Now()})
+
+	if err := s.Replace(ctx, URL{ShortCode: "replace001", OriginalURL: "https://example.com/new", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Replace() error = %v", err)
+	}
+	if _, err := s.GetByOriginalURL(ctx, "", "", "https://example.com/old"); err != ErrNotFound {
+		t.Errorf("GetByOriginalURL(old) error = %v, want %v", err, ErrNotFound)
+	}
+	if got, _ := s.GetByOriginalURL(ctx, "", "", "https://example.com/new"); got == nil || got.ShortCode != "replace001" {
+		t.Errorf("GetByOriginalURL(new) = %+v", got)
+	}
+	if list, _ := s.List(ctx, ListQuery{}); len(list) != 2 {
+		t.Errorf("List() returned %d links, want 2", len(list))
+	}
+
+	// Адрес обычной ссылки не может принадлежать двум кодам
+	err := s.Replace(ctx, URL{ShortCode: "replace001", OriginalURL: "https://example.com/taken", CreatedAt: time.Now()})
+	if err != ErrAlreadyExists {
+		t.Errorf("Replace() with taken URL error = %v, want %v", err, ErrAlreadyExists)
+	}
+	err = s.Save(ctx, URL{ShortCode: "replace003", OriginalURL: "https://example.com/taken", CreatedAt: time.Now()})
+	if err != ErrAlreadyExists {
+		t.Errorf("Save() with taken URL error = %v, want %v", err, ErrAlreadyExists)
+	}
+}
+
+func TestMemoryStorage_Iterate(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	base := time.Now()
+	for i, u := range []URL{
+		{Domain: "go.example", ShortCode: "iterate003", OriginalURL: "https://example.com/3"},
+		{ShortCode: "iterate002", OriginalURL: "https://example.com/2"},
+		{ShortCode: "iterate001", OriginalURL: "https://example.com/1"},
+		{Domain: "a.example", ShortCode: "iterate004", OriginalURL: "https://example.com/4"},
+	} {
+		u.CreatedAt = base.Add(time.Duration(-i) * time.Minute)
+		s.Save(ctx, u)
+	}
+
+	var got []string
+	var after *Position
+	for {
+		batch, err := s.Iterate(ctx, after, 3)
+		if err != nil {
+			t.Fatalf("Iterate() error = %v", err)
+		}
+		for _, u := range batch {
+			got = append(got, u.ShortCode)
+		}
+		if len(batch) < 3 {
+			break
+		}
+		p := PositionOf(batch[len(batch)-1])
+		after = &p
+	}
+
+	want := "iterate001,iterate002,iterate004,iterate003"
+	if strings.Join(got, ",") != want {
+		t.Errorf("Iterate() = %v, want %s", got, want)
+	}
+}
+
+func TestMemoryStorage_Workspaces(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	for _, u := range []URL{
+		{Workspace: "team-a", ShortCode: "wsa0000001", OriginalURL: "https://example.com"},
+		{Workspace: "team-a", ShortCode: "wsa0000002", OriginalURL: "https://example.com/2"},
+		{Workspace: "team-b", ShortCode: "wsb0000001", OriginalURL: "https://example.com"},
+	} {
+		u.CreatedAt = time.Now()
+		if err := s.Save(ctx, u); err != nil {
+			t.Fatalf("Save(%s) error = %v", u.ShortCode, err)
+		}
+	}
+
+	// Одинаковый адрес дедуплицируется в каждом пространстве отдельно
+	for workspace, want := range map[string]string{"team-a": "wsa0000001", "team-b": "wsb0000001"} {
+		got, err := s.GetByOriginalURL(ctx, workspace, "", "https://example.com")
+		if err != nil || got.ShortCode != want {
+			t.Errorf("GetByOriginalURL(%s) = %+v, %v, want %s", workspace, got, err, want)
+		}
+	}
+	if _, err := s.GetByOriginalURL(ctx, "", "", "https://example.com"); err != ErrNotFound {
+		t.Errorf("GetByOriginalURL(default) error = %v, want %v", err, ErrNotFound)
+	}
+
+	urls, _ := s.List(ctx, ListQuery{Workspace: "team-a"})
+	if len(urls) != 2 {
+		t.Errorf("List(team-a) returned %d links, want 2", len(urls))
+	}
+
+	for workspace, want := range map[string]int{"team-a": 2, "team-b": 1, "": 0} {
+		if got, _ := s.CountLinks(ctx, workspace); got != want {
+			t.Errorf("CountLinks(%q) = %d, want %d", workspace, got, want)
+		}
+	}
+}
+
+func TestMemoryStorage_Delete(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	u := URL{
+		Workspace:   "team",
+		ShortCode:   "delete0001",
+		OriginalURL: "https://example.com/delete",
+		CreatedAt:   time.Now(),
+		Labels:      Labels{Tags: []string{"promo"}},
+	}
+	s.Save(ctx, u)
+
+	if err := s.Delete(ctx, "", u.ShortCode); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	// Удаленная запись возвращается вместе с ошибкой
+	got, err := s.GetByCode(ctx, "", u.ShortCode)
+	if err != ErrDeleted || got == nil || got.DeletedAt == nil {
+		t.Fatalf("GetByCode() = %+v, %v, want record with %v", got, err, ErrDeleted)
+	}
+	// Запись выходит из дедупликации, выборок и квоты
+	if _, err := s.GetByOriginalURL(ctx, "team", "", u.OriginalURL); err != ErrNotFound {
+		t.Errorf("GetByOriginalURL() error = %v, want %v", err, ErrNotFound)
+	}
+	for _, q := range []ListQuery{{Workspace: "team"}, {Workspace: "team", Tags: []string{"promo"}}} {
+		if urls, _ := s.List(ctx, q); len(urls) != 0 {
+			t.Errorf("List(%+v) = %v, want none", q, urls)
+		}
+	}
+	if urls, _ := s.List(ctx, ListQuery{Workspace: "team", Status: StatusDeleted}); len(urls) != 1 {
+		t.Errorf("List(deleted) returned %d links, want 1", len(urls))
+	}
+	if count, _ := s.CountLinks(ctx, "team"); count != 0 {
+		t.Errorf("CountLinks() = %d, want 0", count)
+	}
+	if err := s.Delete(ctx, "", u.ShortCode); err != ErrNotFound {
+		t.Errorf("Delete() again error = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.SetLabels(ctx, "", u.ShortCode, Labels{Note: "x"}); err != ErrNotFound {
+		t.Errorf("SetLabels() on deleted error = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.Save(ctx, u); err != ErrAlreadyExists {
+		t.Errorf("Save() with deleted code error = %v, want %v", err, ErrAlreadyExists)
+	}
+
+	// Восстановленная ссылка снова учитывается, но не дедуплицируется
+	if err := s.Restore(ctx, "", u.ShortCode); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if got, err := s.GetByCode(ctx, "", u.ShortCode); err != nil || got.DeletedAt != nil || !got.Custom {
+		t.Errorf("GetByCode() after restore = %+v, %v", got, err)
+	}
+	if count, _ := s.CountLinks(ctx, "team"); count != 1 {
+		t.Errorf("CountLinks() after restore = %d, want 1", count)
+	}
+	if err := s.Restore(ctx, "", u.ShortCode); err != ErrNotFound {
+		t.Errorf("Restore() of active link error = %v, want %v", err, ErrNotFound)
+	}
+
+	// Окончательно удаляются только ссылки, удаленные до срока
+	s.Delete(ctx, "", u.ShortCode)
+	if n, _ := s.Purge(ctx, time.Now().Add(-time.Hour), 0); n != 0 {
+		t.Errorf("Purge(before deletion) = %d, want 0", n)
+	}
+	if n, err := s.Purge(ctx, time.Now().Add(time.Second), 0); err != nil || n != 1 {
+		t.Fatalf("Purge() = %d, %v, want 1", n, err)
+	}
+	// Код остается закрытым и после окончательного удаления
+	if got, err := s.GetByCode(ctx, "", u.ShortCode); got != nil || err != ErrDeleted {
+		t.Errorf("GetByCode() after purge = %+v, %v, want nil, %v", got, err, ErrDeleted)
+	}
+	if err := s.Restore(ctx, "", u.ShortCode); err != ErrNotFound {
+		t.Errorf("Restore() after purge error = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.Save(ctx, u); err != ErrAlreadyExists {
+		t.Errorf("Save() after purge error = %v, want %v", err, ErrAlreadyExists)
+	}
+	if err := s.Replace(ctx, u); err != ErrAlreadyExists {
+		t.Errorf("Replace() after purge error = %v, want %v", err, ErrAlreadyExists)
+	}
+	if s.Len() != 0 {
+		t.Errorf("Len() after purge = %d, want 0", s.Len())
+	}
+
+	// Закрытый код выбирается для переноса и закрывается в другом хранилище
+	tombstones, err := s.Tombstones(ctx, TombstoneQuery{Workspace: "team"})
+	if err != nil || len(tombstones) != 1 || tombstones[0].ShortCode != u.ShortCode {
+		t.Fatalf("Tombstones() = %+v, %v", tombstones, err)
+	}
+	if other, _ := s.Tombstones(ctx, TombstoneQuery{Workspace: "other"}); len(other) != 0 {
+		t.Errorf("Tombstones(other workspace) = %+v, want none", other)
+	}
+	target := NewMemoryStorage()
+	if err := target.SaveTombstone(ctx, tombstones[0]); err != nil {
+		t.Fatalf("SaveTombstone() error = %v", err)
+	}
+	if err := target.Save(ctx, u); err != ErrAlreadyExists {
+		t.Errorf("Save() over tombstone error = %v, want %v", err, ErrAlreadyExists)
+	}
+	live := URL{ShortCode: "delete0002", OriginalURL: "https://example.com/live", CreatedAt: time.Now()}
+	target.Save(ctx, live)
+	if err := target.SaveTombstone(ctx, Tombstone{ShortCode: live.ShortCode, DeletedAt: time.Now()}); err != ErrAlreadyExists {
+		t.Errorf("SaveTombstone() over live link error = %v, want %v", err, ErrAlreadyExists)
+	}
+}
+
+func TestMemoryStorage_Audit(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+	audited := func(action string) context.Context {
+		return WithAudit(ctx, AuditEvent{Workspace: "team", Action: action, Actor: "ci", At: time.Now()})
+	}
+
+	u := URL{Workspace: "team", ShortCode: "audit00001", OriginalURL: "https://example.com/audit", CreatedAt: time.Now()}
+	if err := s.Save(audited("create"), u); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	// Изменения без события в контексте журнал не затрагивают
+	if err := s.SetLabels(ctx, "", u.ShortCode, Labels{Note: "quiet"}); err != nil {
+		t.Fatalf("SetLabels() error = %v", err)
+	}
+	if err := s.SetLabels(audited("update"), "", u.ShortCode, Labels{Note: "logged"}); err != nil {
+		t.Fatalf("SetLabels() error = %v", err)
+	}
+	// Неудачное изменение не попадает в журнал
+	if err := s.SetLabels(audited("update"), "", "nonexist12", Labels{}); err != ErrNotFound {
+		t.Fatalf("SetLabels() error = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.Delete(audited("delete"), "", u.ShortCode); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	actions := func(q AuditQuery) []string {
+		events, err := s.ListAudit(ctx, q)
+		if err != nil {
+			t.Fatalf("ListAudit() error = %v", err)
+		}
+		var result []string
+		for _, e := range events {
+			if e.ShortCode != u.ShortCode || e.Actor != "ci" {
+				t.Errorf("event = %+v", e)
+			}
+			result = append(result, e.Action)
+		}
+		return result
+	}
+	history := AuditQuery{Workspace: "team", ShortCode: u.ShortCode}
+	if got := actions(history); !slices.Equal(got, []string{"delete", "update", "create"}) {
+		t.Errorf("ListAudit() = %v", got)
+	}
+	if got := actions(AuditQuery{Workspace: "team", Action: "update"}); !slices.Equal(got, []string{"update"}) {
+		t.Errorf("ListAudit(update) = %v", got)
+	}
+	history.BeforeID, history.Limit = 3, 1
+	if got := actions(history); !slices.Equal(got, []string{"update"}) {
+		t.Errorf("ListAudit(before 3, limit 1) = %v", got)
+	}
+	if got := actions(AuditQuery{Workspace: "other"}); len(got) != 0 {
+		t.Errorf("ListAudit(other) = %v, want none", got)
+	}
+
+	// Перенесенное событие сохраняет ID; пропуск ID не мешает выборке и нумерации
+	all, _ := s.IterateAudit(ctx, 0, 0)
+	if len(all) != 3 || all[0].ID != 1 || all[2].ID != 3 {
+		t.Fatalf("IterateAudit() = %+v", all)
+	}
+	if next, _ := s.IterateAudit(ctx, 1, 1); len(next) != 1 || next[0].ID != 2 {
+		t.Errorf("IterateAudit(after 1) = %+v", next)
+	}
+	moved := all[2]
+	moved.ID = 10
+	if err := s.SaveAudit(ctx, moved); err != nil {
+		t.Fatalf("SaveAudit() error = %v", err)
+	}
+	if err := s.SaveAudit(ctx, moved); err != nil {
+		t.Errorf("SaveAudit(same event) error = %v", err)
+	}
+	moved.Action = "restore"
+	if err := s.SaveAudit(ctx, moved); err != ErrAlreadyExists {
+		t.Errorf("SaveAudit(other event) error = %v, want %v", err, ErrAlreadyExists)
+	}
+	if err := s.Restore(audited("restore"), "", u.ShortCode); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	events, _ := s.ListAudit(ctx, AuditQuery{Workspace: "team", BeforeID: 11})
+	if len(events) != 4 || events[0].ID != 10 || events[1].ID != 3 {
+		t.Errorf("ListAudit(before 11) = %+v", events)
+	}
+	if events, _ := s.ListAudit(ctx, AuditQuery{Workspace: "team", Limit: 1}); events[0].ID != 11 {
+		t.Errorf("ListAudit() newest = %+v, want ID 11", events)
+	}
+}
+
+func TestMemoryStorage_Revisions(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	u := URL{ShortCode: "revision01", OriginalURL: "https://example.com/v1", CreatedAt: time.Now()}
+	s.Save(ctx, u)
+
+	if err := s.SetDestination(ctx, "", u.ShortCode, Destination{OriginalURL: "https://example.com/v2"}); err != nil {
+		t.Fatalf("SetDestination() error = %v", err)
+	}
+	got, _ := s.GetByCode(ctx, "", u.ShortCode)
+	if got.OriginalURL != "https://example.com/v2" || !got.Custom {
+		t.Errorf("GetByCode() = %+v, want v2 and custom", got)
+	}
+	// Ссылка с измененным адресом выходит из дедупликации
+	if _, err := s.GetByOriginalURL(ctx, "", "", u.OriginalURL); err != ErrNotFound {
+		t.Errorf("GetByOriginalURL() error = %v, want %v", err, ErrNotFound)
+	}
+
+	at := time.Now().Add(time.Hour)
+	if err := s.ScheduleSwitch(ctx, "", u.ShortCode, "https://example.com/v3", &at); err != nil {
+		t.Fatalf("ScheduleSwitch() error = %v", err)
+	}
+	if due, _ := s.DueSwitches(ctx, time.Now(), 10); len(due) != 0 {
+		t.Errorf("DueSwitches() before switch = %d links, want 0", len(due))
+	}
+	if err := s.ApplySwitch(ctx, "", u.ShortCode, time.Now()); err != ErrNotFound {
+		t.Errorf("ApplySwitch() before switch error = %v, want %v", err, ErrNotFound)
+	}
+	later := at.Add(time.Minute)
+	due, _ := s.DueSwitches(ctx, later, 10)
+	if len(due) != 1 || due[0].NextURL != "https://example.com/v3" {
+		t.Fatalf("DueSwitches() = %+v", due)
+	}
+	if err := s.ApplySwitch(ctx, "", u.ShortCode, later); err != nil {
+		t.Fatalf("ApplySwitch() error = %v", err)
+	}
+	got, _ = s.GetByCode(ctx, "", u.ShortCode)
+	if got.OriginalURL != "https://example.com/v3" || got.NextURL != "" || got.SwitchAt != nil {
+		t.Errorf("GetByCode() after switch = %+v", got)
+	}
+
+	revisions, _ := s.ListRevisions(ctx, "", u.ShortCode)
+	if len(revisions) != 2 || revisions[0].Number != 1 || revisions[0].OriginalURL != "https://example.com/v1" ||
+		revisions[1].Number != 2 || revisions[1].OriginalURL != "https://example.com/v2" {
+		t.Errorf("ListRevisions() = %+v", revisions)
+	}
+	if err := s.SetDestination(ctx, "", "nonexist12", Destination{OriginalURL: "https://example.com"}); err != ErrNotFound {
+		t.Errorf("SetDestination() error = %v, want %v", err, ErrNotFound)
+	}
+
+	// Перенос ревизий: повтор номера не меняет ревизию, пропущенный номер встает по порядку
+	all, _ := s.IterateRevisions(ctx, nil, 0)
+	if len(all) != 2 || all[0].ShortCode != u.ShortCode || all[0].Number != 1 {
+		t.Errorf("IterateRevisions() = %+v", all)
+	}
+	if next, _ := s.IterateRevisions(ctx, &all[0], 1); len(next) != 1 || next[0].Number != 2 {
+		t.Errorf("IterateRevisions(after 1) = %+v", next)
+	}
+	moved := LinkRevision{Domain: "", ShortCode: u.ShortCode, Revision: Revision{Number: 1, ReplacedAt: time.Now()}}
+	moved.OriginalURL = "https://example.com/other"
+	if err := s.SaveRevision(ctx, moved); err != nil {
+		t.Errorf("SaveRevision(existing) error = %v", err)
+	}
+	moved.ShortCode = "nonexist12"
+	if err := s.SaveRevision(ctx, moved); err != ErrNotFound {
+		t.Errorf("SaveRevision(missing link) error = %v, want %v", err, ErrNotFound)
+	}
+	if revisions, _ := s.ListRevisions(ctx, "", u.ShortCode); revisions[0].OriginalURL != "https://example.com/v1" {
+		t.Errorf("ListRevisions() after SaveRevision = %+v", revisions)
+	}
+
+	// Ревизии удаляются вместе со ссылкой при окончательном удалении
+	s.Delete(ctx, "", u.ShortCode)
+	s.Purge(ctx, time.Now().Add(time.Second), 0)
+	if revisions, _ := s.ListRevisions(ctx, "", u.ShortCode); len(revisions) != 0 {
+		t.Errorf("ListRevisions() after delete = %+v, want none", revisions)
+	}
+}
+
+func TestMemoryStorage_UpdateLink(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	u := URL{ShortCode: "update0001", OriginalURL: "https://example.com/v1", CreatedAt: time.Now()}
+	s.Save(ctx, u)
+	audited := WithAudit(ctx, AuditEvent{Action: "update", At: time.Now()})
+
+	// Несовпадающие веса отклоняют все изменение
+	err := s.UpdateLink(audited, "", u.ShortCode, LinkUpdate{
+		Destination: &Destination{OriginalURL: "https://example.com/v2"},
+		Weights:     []int{1},
+	})
+	if err != ErrNotFound {
+		t.Fatalf("UpdateLink() with weights error = %v, want %v", err, ErrNotFound)
+	}
+	if got, _ := s.GetByCode(ctx, "", u.ShortCode); got.OriginalURL != u.OriginalURL {
+		t.Errorf("link changed by rejected update: %+v", got)
+	}
+
+	at := time.Now().Add(time.Hour)
+	err = s.UpdateLink(audited, "", u.ShortCode, LinkUpdate{
+		Destination: &Destination{OriginalURL: "https://example.com/v2"},
+		Schedule:    true,
+		NextURL:     "https://example.com/v3",
+		SwitchAt:    &at,
+		Metadata:    &Metadata{Title: "New"},
+		Labels:      &Labels{Tags: []string{"promo"}, Note: "launch"},
+	})
+	if err != nil {
+		t.Fatalf("UpdateLink() error = %v", err)
+	}
+	got, _ := s.GetByCode(ctx, "", u.ShortCode)
+	if got.OriginalURL != "https://example.com/v2" || got.NextURL != "https://example.com/v3" ||
+		got.Title != "New" || got.Note != "launch" || !got.Custom {
+		t.Errorf("GetByCode() = %+v", got)
+	}
+	if revisions, _ := s.ListRevisions(ctx, "", u.ShortCode); len(revisions) != 1 || revisions[0].OriginalURL != u.OriginalURL {
+		t.Errorf("ListRevisions() = %+v", revisions)
+	}
+	if tagged, _ := s.List(ctx, ListQuery{Tags: []string{"promo"}}); len(tagged) != 1 {
+		t.Errorf("List(promo) = %d links, want 1", len(tagged))
+	}
+	if events, _ := s.ListAudit(ctx, AuditQuery{}); len(events) != 1 {
+		t.Errorf("ListAudit() = %d events, want 1", len(events))
+	}
+}
+
+func TestMemoryStorage_Webhooks(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+	audited := func(action string) context.Context {
+		return WithAudit(ctx, AuditEvent{Workspace: "team", Action: action, Actor: "ci", At: time.Now()})
+	}
+
+	all, err := s.CreateWebhook(ctx, Webhook{Workspace: "team", URL: "https://crm.example/all", Secret: "s1"})
+	if err != nil {
+		t.Fatalf("CreateWebhook() error = %v", err)
+	}
+	clicks, _ := s.CreateWebhook(ctx, Webhook{Workspace: "team", URL: "https://crm.example/clicks", Secret: "s2",
+		Events: []string{EventLinkClicked}})
+	other, _ := s.CreateWebhook(ctx, Webhook{Workspace: "other", URL: "https://other.example/", Secret: "s3"})
+
+	u := URL{Workspace: "team", ShortCode: "webhook001", OriginalURL: "https://example.com/hook", CreatedAt: time.Now()}
+	if err := s.Save(audited("create"), u); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	// Изменения без события в контексте в очередь не попадают
+	if err := s.SetLabels(ctx, "", u.ShortCode, Labels{Note: "quiet"}); err != nil {
+		t.Fatalf("SetLabels() error = %v", err)
+	}
+	if err := s.Publish(ctx, Event{Type: EventLinkClicked, Workspace: "team", ShortCode: u.ShortCode}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	deliveries, err := s.ListDeliveries(ctx, DeliveryQuery{Workspace: "team"})
+	if err != nil {
+		t.Fatalf("ListDeliveries() error = %v", err)
+	}
+	var got []string
+	for _, d := range deliveries {
+		got = append(got, fmt.Sprintf("%d:%s", d.WebhookID, d.Event))
+	}
+	want := []string{
+		fmt.Sprintf("%d:%s", clicks, EventLinkClicked),
+		fmt.Sprintf("%d:%s", all, EventLinkClicked),
+		fmt.Sprintf("%d:%s", all, EventLinkCreated),
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("ListDeliveries() = %v, want %v", got, want)
+	}
+	var payload Event
+	if err := json.Unmarshal(deliveries[2].Payload, &payload); err != nil || payload.ShortCode != u.ShortCode || payload.Actor != "ci" {
+		t.Errorf("payload = %s, error = %v", deliveries[2].Payload, err)
+	}
+	if others, _ := s.ListDeliveries(ctx, DeliveryQuery{Workspace: "other", WebhookID: other}); len(others) != 0 {
+		t.Errorf("ListDeliveries(other) = %+v, want none", others)
+	}
+
+	// Взятые доставки откладываются на время аренды
+	now := time.Now()
+	claimed, err := s.ClaimDeliveries(ctx, now, time.Minute, 2)
+	if err != nil || len(claimed) != 2 {
+		t.Fatalf("ClaimDeliveries() = %+v, error = %v", claimed, err)
+	}
+	if rest, _ := s.ClaimDeliveries(ctx, now, time.Minute, 0); len(rest) != 1 {
+		t.Fatalf("ClaimDeliveries() again = %+v, want 1", rest)
+	}
+	d := claimed[0]
+	d.Status, d.Attempts, d.LastStatus, d.LastError = DeliveryDead, 3, 500, "unexpected status"
+	if err := s.UpdateDelivery(ctx, d); err != nil {
+		t.Fatalf("UpdateDelivery() error = %v", err)
+	}
+	dead, _ := s.ListDeliveries(ctx, DeliveryQuery{Workspace: "team", Status: DeliveryDead})
+	if len(dead) != 1 || dead[0].ID != d.ID || dead[0].Attempts != 3 || dead[0].LastStatus != 500 {
+		t.Fatalf("ListDeliveries(dead) = %+v", dead)
+	}
+	if err := s.RetryDelivery(ctx, "other", d.ID, now); err != ErrNotFound {
+		t.Errorf("RetryDelivery(other) error = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.RetryDelivery(ctx, "team", d.ID, now); err != nil {
+		t.Fatalf("RetryDelivery() error = %v", err)
+	}
+	// Остальные доставки еще в аренде, поэтому берется только возвращенная
+	retried, _ := s.ClaimDeliveries(ctx, now, time.Minute, 0)
+	if len(retried) != 1 || retried[0].ID != d.ID || retried[0].Attempts != 0 {
+		t.Errorf("ClaimDeliveries() after retry = %+v", retried)
+	}
+
+	if page, _ := s.ListDeliveries(ctx, DeliveryQuery{Workspace: "team", BeforeID: deliveries[0].ID, Limit: 1}); len(page) != 1 || page[0].ID != deliveries[1].ID {
+		t.Errorf("ListDeliveries(before, limit 1) = %+v", page)
+	}
+
+	if err := s.DeleteWebhook(ctx, "other", all); err != ErrNotFound {
+		t.Errorf("DeleteWebhook(other) error = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.DeleteWebhook(ctx, "team", all); err != nil {
+		t.Fatalf("DeleteWebhook() error = %v", err)
+	}
+	if _, err := s.GetWebhook(ctx, all); err != ErrNotFound {
+		t.Errorf("GetWebhook() after delete error = %v, want %v", err, ErrNotFound)
+	}
+	if rest, _ := s.ListDeliveries(ctx, DeliveryQuery{Workspace: "team"}); len(rest) != 1 || rest[0].WebhookID != clicks {
+		t.Errorf("ListDeliveries() after delete = %+v", rest)
+	}
+	if hooks, _ := s.ListWebhooks(ctx, "team"); len(hooks) != 1 || hooks[0].ID != clicks {
+		t.Errorf("ListWebhooks() = %+v", hooks)
+	}
+}
+
+func TestMemoryStorage_DeliveryRetention(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	// Событие без подписчиков в очередь не попадает
+	if err := s.Publish(ctx, Event{Type: EventLinkClicked, Workspace: "team", ShortCode: "retain0001"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got, _ := s.ListDeliveries(ctx, DeliveryQuery{Workspace: "team"}); len(got) != 0 {
+		t.Fatalf("ListDeliveries() without webhooks = %+v, want none", got)
+	}
+
+	s.CreateWebhook(ctx, Webhook{Workspace: "team", URL: "https://crm.example/all", Secret: "s1"})
+	s.CreateWebhook(ctx, Webhook{Workspace: "other", URL: "https://other.example/", Secret: "s2",
+		Events: []string{EventLinkCreated}})
+	workspaces, err := s.SubscribedWorkspaces(ctx, EventLinkClicked)
+	if err != nil || !slices.Equal(workspaces, []string{"team"}) {
+		t.Fatalf("SubscribedWorkspaces(clicked) = %v, %v", workspaces, err)
+	}
+	if workspaces, _ := s.SubscribedWorkspaces(ctx, EventLinkCreated); !slices.Equal(workspaces, []string{"other", "team"}) {
+		t.Errorf("SubscribedWorkspaces(created) = %v", workspaces)
+	}
+
+	for range 3 {
+		s.Publish(ctx, Event{Type: EventLinkClicked, Workspace: "team", ShortCode: "retain0001"})
+	}
+	now := time.Now()
+	claimed, _ := s.ClaimDeliveries(ctx, now, time.Minute, 0)
+	if len(claimed) != 3 {
+		t.Fatalf("ClaimDeliveries() = %+v, want 3", claimed)
+	}
+	sent := now.Add(-2 * time.Hour)
+	for i, status := range []string{DeliveryDelivered, DeliveryDead} {
+		d := claimed[i]
+		d.Status, d.UpdatedAt = status, sent
+		s.UpdateDelivery(ctx, d)
+	}
+	// Завершенные доставки больше не берутся в работу
+	if due, _ := s.ClaimDeliveries(ctx, now.Add(time.Hour), time.Minute, 0); len(due) != 1 || due[0].ID != claimed[2].ID {
+		t.Errorf("ClaimDeliveries() after update = %+v", due)
+	}
+
+	purged, err := s.PurgeDeliveries(ctx, now.Add(-time.Hour), 0)
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeDeliveries() = %d, %v, want 1", purged, err)
+	}
+	rest, _ := s.ListDeliveries(ctx, DeliveryQuery{Workspace: "team"})
+	if len(rest) != 2 || rest[0].ID != claimed[2].ID || rest[1].Status != DeliveryDead {
+		t.Errorf("ListDeliveries() after purge = %+v", rest)
+	}
+	if purged, _ := s.PurgeDeliveries(ctx, now.Add(-time.Hour), 0); purged != 0 {
+		t.Errorf("PurgeDeliveries() again = %d, want 0", purged)
+	}
+}
+
+func TestMemoryStorage_Expirations(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	for _, u := range []URL{
+		{ShortCode: "expired001", OriginalURL: "https://example.com/1", CreatedAt: now, ExpiresAt: &past},
+		{ShortCode: "expired002", OriginalURL: "https://example.com/2", CreatedAt: now, ExpiresAt: &future},
+		{ShortCode: "expired003", OriginalURL: "https://example.com/3", CreatedAt: now},
+	} {
+		if err := s.Save(ctx, u); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	due, err := s.DueExpirations(ctx, now, 10)
+	if err != nil || len(due) != 1 || due[0].ShortCode != "expired001" {
+		t.Fatalf("DueExpirations() = %+v, error = %v", due, err)
+	}
+	if err := s.MarkExpired(ctx, "", "expired002", now); err != ErrNotFound {
+		t.Errorf("MarkExpired(not expired) error = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.MarkExpired(ctx, "", "expired001", now); err != nil {
+		t.Fatalf("MarkExpired() error = %v", err)
+	}
+	// Истечение записывается один раз
+	if err := s.MarkExpired(ctx, "", "expired001", now); err != ErrNotFound {
+		t.Errorf("MarkExpired() again error = %v, want %v", err, ErrNotFound)
+	}
+	if due, _ := s.DueExpirations(ctx, now, 10); len(due) != 0 {
+		t.Errorf("DueExpirations() after mark = %+v, want none", due)
+	}
+	if due, _ := s.DueExpirations(ctx, future, 10); len(due) != 1 || due[0].ShortCode != "expired002" {
+		t.Errorf("DueExpirations(future) = %+v", due)
+	}
+}
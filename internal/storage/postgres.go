@@ -3,11 +3,13 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // PostgreSQL реализация хранилища
@@ -60,100 +62,257 @@ func NewPostgresStorage(cfg PostgresConfig) (*PostgresStorage, error) {
 }
 
 // Save сохраняет новое URL отображение
-func (s *PostgresStorage) Save(url URL) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (s *PostgresStorage) Save(ctx context.Context, url URL) (err error) {
+	ctx, span := startSpan(ctx, "postgres.Save", postgresAttrs("INSERT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	inserted, err := insertURL(ctx, tx, url)
+	if err != nil {
+		return err
+	}
+	if inserted {
+		if err := insertAudit(ctx, tx, url.Domain, url.ShortCode); err != nil {
+			return err
+		}
+	} else {
+		tx.Rollback()
+
+		// Проверить, это тот же самый URL (идемпотентность) или другой (коллизия)
+		// Истекшая или еще не активная запись тоже занимает код
+		existing, err := s.GetByCode(ctx, url.Domain, url.ShortCode)
+		if existing == nil {
+			return fmt.Errorf("checking existing code: %w", err)
+		}
+		if existing.OriginalURL != url.OriginalURL || existing.Workspace != url.Workspace ||
+			existing.Custom || url.Custom {
+			return ErrAlreadyExists
+		}
+		return nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing URL: %w", err)
+	}
+	return nil
+}
+
+// Replace сохраняет URL, заменяя существующую запись с тем же кодом в домене.
+// Варианты прежней записи удаляются каскадно
+func (s *PostgresStorage) Replace(ctx context.Context, url URL) (err error) {
+	ctx, span := startSpan(ctx, "postgres.Replace", postgresAttrs("INSERT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM urls WHERE domain = $1 AND short_code = $2`, url.Domain, url.ShortCode)
+	if err != nil {
+		return fmt.Errorf("deleting URL: %w", err)
+	}
+	inserted, err := insertURL(ctx, tx, url)
+	if err != nil {
+		return err
+	}
+	if !inserted {
+		return ErrAlreadyExists
+	}
+	if err := insertAudit(ctx, tx, url.Domain, url.ShortCode); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing URL: %w", err)
+	}
+	return nil
+}
+
+// insertURL добавляет запись и ее варианты в транзакции.
+// Возвращает false, если код в домене уже занят, и ErrAlreadyExists,
+// если код принадлежал удаленной ссылке
+func insertURL(ctx context.Context, tx *sql.Tx, url URL) (bool, error) {
+	tombstoned, err := isTombstoned(ctx, tx, url.Domain, url.ShortCode)
+	if err != nil {
+		return false, err
+	}
+	if tombstoned {
+		return false, ErrAlreadyExists
+	}
+
+	var rulesJSON []byte
+	if len(url.Rules) > 0 {
+		if rulesJSON, err = json.Marshal(url.Rules); err != nil {
+			return false, fmt.Errorf("encoding rules: %w", err)
+		}
+	}
+
 	query := `
-		INSERT INTO urls (short_code, original_url, created_at, expires_at)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (short_code) DO NOTHING
+		INSERT INTO urls (domain, short_code, original_url, created_at, expires_at,
+			not_before, pending_url, expired_url, clicks_left, password_hash, custom, sticky, rules,
+			forward_query, forward_path, untagged_url, title, description, image_url, created_by, tags, note,
+			workspace, next_url, switch_at, deleted_at, expiry_notified)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22,
+			$23, $24, $25, $26, $27)
+		ON CONFLICT (domain, short_code) DO NOTHING
+		RETURNING id
 	`
 
-	result, err := s.db.ExecContext(ctx, query,
+	var id int64
+	err = tx.QueryRowContext(ctx, query,
+		url.Domain,
 		url.ShortCode,
 		url.OriginalURL,
 		url.CreatedAt,
 		url.ExpiresAt,
-	)
+		url.NotBefore,
+		url.PendingURL,
+		url.ExpiredURL,
+		url.ClicksLeft,
+		url.PasswordHash,
+		url.Custom,
+		url.Sticky,
+		rulesJSON,
+		url.ForwardQuery,
+		url.ForwardPath,
+		url.UntaggedURL,
+		url.Title,
+		url.Description,
+		url.ImageURL,
+		url.CreatedBy,
+		tagsArray(url.Tags),
+		url.Note,
+		url.Workspace,
+		url.NextURL,
+		url.SwitchAt,
+		url.DeletedAt,
+		url.ExpiryNotified,
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
 	if err != nil {
 		if isUniqueViolation(err) {
-			return ErrAlreadyExists
+			return false, ErrAlreadyExists
 		}
-		return fmt.Errorf("inserting URL: %w", err)
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("getting rows affected: %w", err)
+		return false, fmt.Errorf("inserting URL: %w", err)
 	}
 
-	if rowsAffected == 0 {
-		// Проверить, это тот же самый URL (идемпотентность) или другой (коллизия)
-		existing, err := s.GetByCode(url.ShortCode)
+	for i, t := range url.Targets {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO url_targets (url_id, position, url, weight, clicks)
+			VALUES ($1, $2, $3, $4, $5)
+		`, id, i, t.URL, t.Weight, t.Clicks)
 		if err != nil {
-			return fmt.Errorf("checking existing code: %w", err)
+			return false, fmt.Errorf("inserting target: %w", err)
 		}
-		if existing.OriginalURL != url.OriginalURL {
-			return ErrAlreadyExists
+	}
+	// Перенесенная удаленная ссылка закрывает свой код так же, как при удалении
+	if url.DeletedAt != nil {
+		if err := insertTombstone(ctx, tx, tombstoneOf(&url)); err != nil {
+			return false, err
 		}
 	}
+	return true, nil
+}
+
+// isTombstoned сообщает, что код в домене принадлежал удаленной ссылке
+func isTombstoned(ctx context.Context, q rowQuerier, domain, code string) (bool, error) {
+	var exists bool
+	err := q.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM code_tombstones WHERE domain = $1 AND short_code = $2)`,
+		domain, code).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("checking tombstone: %w", err)
+	}
+	return exists, nil
+}
 
+// insertTombstone навсегда закрывает код в домене
+func insertTombstone(ctx context.Context, e execer, t Tombstone) error {
+	_, err := e.ExecContext(ctx, `
+		INSERT INTO code_tombstones (workspace, domain, short_code, deleted_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (domain, short_code) DO NOTHING
+	`, t.Workspace, t.Domain, t.ShortCode, t.DeletedAt)
+	if err != nil {
+		return fmt.Errorf("inserting tombstone: %w", err)
+	}
 	return nil
 }
 
-// GetByCode возвращает URL по короткому коду
-func (s *PostgresStorage) GetByCode(code string) (*URL, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// GetByCode возвращает URL по домену и короткому коду.
+// Для удаленных, истекших и еще не активных ссылок запись возвращается вместе с ошибкой
+func (s *PostgresStorage) GetByCode(ctx context.Context, domain, code string) (_ *URL, err error) {
+	ctx, span := startSpan(ctx, "postgres.GetByCode", postgresAttrs("SELECT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	query := `
-		SELECT short_code, original_url, created_at, expires_at
+		SELECT ` + urlColumns + `
 		FROM urls
-		WHERE short_code = $1
+		WHERE domain = $1 AND short_code = $2
 	`
 
-	var url URL
-	err := s.db.QueryRowContext(ctx, query, code).Scan(
-		&url.ShortCode,
-		&url.OriginalURL,
-		&url.CreatedAt,
-		&url.ExpiresAt,
-	)
-
+	url, err := scanURL(s.db.QueryRowContext(ctx, query, domain, code))
 	if errors.Is(err, sql.ErrNoRows) {
+		// Код окончательно удаленной ссылки остается закрытым
+		tombstoned, err := isTombstoned(ctx, s.db, domain, code)
+		if err != nil {
+			return nil, err
+		}
+		if tombstoned {
+			return nil, ErrDeleted
+		}
 		return nil, ErrNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("querying URL by code: %w", err)
 	}
 
+	if url.DeletedAt != nil {
+		return url, ErrDeleted
+	}
 	if url.IsExpired() {
-		return nil, ErrExpired
+		return url, ErrExpired
+	}
+	if url.IsPending() {
+		return url, ErrNotYetActive
 	}
 
-	return &url, nil
+	return url, nil
 }
 
-// Возвращает укороченную ссылку по оригинальному URL
-func (s *PostgresStorage) GetByOriginalURL(originalURL string) (*URL, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// Возвращает укороченную ссылку пространства и домена по оригинальному URL
+func (s *PostgresStorage) GetByOriginalURL(ctx context.Context, workspace, domain, originalURL string) (_ *URL, err error) {
+	ctx, span := startSpan(ctx, "postgres.GetByOriginalURL", postgresAttrs("SELECT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	query := `
-		SELECT short_code, original_url, created_at, expires_at
+		SELECT ` + urlColumns + `
 		FROM urls
-		WHERE original_url = $1
+		WHERE workspace = $1 AND domain = $2 AND original_url = $3 AND NOT custom
 	`
 
-	var url URL
-	err := s.db.QueryRowContext(ctx, query, originalURL).Scan(
-		&url.ShortCode,
-		&url.OriginalURL,
-		&url.CreatedAt,
-		&url.ExpiresAt,
-	)
-
+	url, err := scanURL(s.db.QueryRowContext(ctx, query, workspace, domain, originalURL))
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, ErrNotFound
 	}
@@ -165,17 +324,1508 @@ func (s *PostgresStorage) GetByOriginalURL(originalURL string) (*URL, error) {
 		return nil, ErrExpired
 	}
 
-	return &url, nil
+	return url, nil
 }
 
-// Close закрывает соединение с БД
-func (s *PostgresStorage) Close() error {
-	return s.db.Close()
+// ConsumeClick атомарно списывает один переход у ссылки с лимитом.
+// Для ссылок без лимита ничего не делает, при исчерпанном лимите возвращает ErrExhausted
+func (s *PostgresStorage) ConsumeClick(ctx context.Context, domain, code string) (err error) {
+	ctx, span := startSpan(ctx, "postgres.ConsumeClick", postgresAttrs("UPDATE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// Условие clicks_left > 0 проверяется в том же UPDATE, поэтому
+	// параллельные переходы не могут списать больше, чем осталось
+	query := `
+		UPDATE urls SET clicks_left = clicks_left - 1
+		WHERE domain = $1 AND short_code = $2 AND clicks_left > 0 AND deleted_at IS NULL
+		RETURNING clicks_left
+	`
+
+	var left int
+	err = s.db.QueryRowContext(ctx, query, domain, code).Scan(&left)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("consuming click: %w", err)
+	}
+
+	// Ничего не списано: ссылки нет, у нее нет лимита или он исчерпан
+	existing, err := s.GetByCode(ctx, domain, code)
+	if err != nil {
+		return err
+	}
+	if existing.ClicksLeft == nil {
+		return nil
+	}
+	return ErrExhausted
 }
 
-// Ping проверяет соединение с БД
-func (s *PostgresStorage) Ping(ctx context.Context) error {
-	return s.db.PingContext(ctx)
+// RecordClick учитывает переход на вариант ссылки с номером variant (с нуля)
+func (s *PostgresStorage) RecordClick(ctx context.Context, domain, code string, variant int) (err error) {
+	ctx, span := startSpan(ctx, "postgres.RecordClick", postgresAttrs("UPDATE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE url_targets SET clicks = clicks + 1
+		WHERE url_id = (SELECT id FROM urls WHERE domain = $1 AND short_code = $2 AND deleted_at IS NULL)
+			AND position = $3
+	`
+
+	result, err := s.db.ExecContext(ctx, query, domain, code, variant)
+	if err != nil {
+		return fmt.Errorf("recording click: %w", err)
+	}
+	return expectRows(result)
+}
+
+// SetWeights меняет веса вариантов ссылки. Число весов должно совпадать с числом вариантов
+func (s *PostgresStorage) SetWeights(ctx context.Context, domain, code string, weights []int) (err error) {
+	ctx, span := startSpan(ctx, "postgres.SetWeights", postgresAttrs("UPDATE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	var count int
+	err = tx.QueryRowContext(ctx, `
+		SELECT u.id, (SELECT COUNT(*) FROM url_targets t WHERE t.url_id = u.id)
+		FROM urls u
+		WHERE u.domain = $1 AND u.short_code = $2 AND u.deleted_at IS NULL
+		FOR UPDATE
+	`, domain, code).Scan(&id, &count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("querying targets: %w", err)
+	}
+	if count != len(weights) {
+		return ErrNotFound
+	}
+
+	for i, w := range weights {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE url_targets SET weight = $1 WHERE url_id = $2 AND position = $3`,
+			w, id, i)
+		if err != nil {
+			return fmt.Errorf("updating weight: %w", err)
+		}
+	}
+	if err := insertAudit(ctx, tx, domain, code); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing weights: %w", err)
+	}
+	return nil
+}
+
+// SetMetadata заменяет описание ссылки
+func (s *PostgresStorage) SetMetadata(ctx context.Context, domain, code string, meta Metadata) (err error) {
+	ctx, span := startSpan(ctx, "postgres.SetMetadata", postgresAttrs("UPDATE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE urls SET title = $3, description = $4, image_url = $5
+		WHERE domain = $1 AND short_code = $2 AND deleted_at IS NULL
+	`
+
+	err = s.execAudited(ctx, domain, code, query, domain, code, meta.Title, meta.Description, meta.ImageURL)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("setting metadata: %w", err)
+	}
+	return err
+}
+
+// SetLabels заменяет метки и заметку ссылки
+func (s *PostgresStorage) SetLabels(ctx context.Context, domain, code string, labels Labels) (err error) {
+	ctx, span := startSpan(ctx, "postgres.SetLabels", postgresAttrs("UPDATE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE urls SET tags = $3, note = $4
+		WHERE domain = $1 AND short_code = $2 AND deleted_at IS NULL
+	`
+
+	err = s.execAudited(ctx, domain, code, query, domain, code, tagsArray(labels.Tags), labels.Note)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("setting labels: %w", err)
+	}
+	return err
+}
+
+// UpdateLink применяет все изменения ссылки и записывает событие журнала из ctx
+// в одной транзакции. Если число весов не совпадает с числом вариантов, ссылка
+// не меняется и возвращается ErrNotFound
+func (s *PostgresStorage) UpdateLink(ctx context.Context, domain, code string, upd LinkUpdate) (err error) {
+	ctx, span := startSpan(ctx, "postgres.UpdateLink", postgresAttrs("UPDATE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	err = s.updateLink(ctx, domain, code, upd)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("updating link: %w", err)
+	}
+	return err
+}
+
+func (s *PostgresStorage) updateLink(ctx context.Context, domain, code string, upd LinkUpdate) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	var count int
+	var current URL
+	err = tx.QueryRowContext(ctx, `
+		SELECT u.id, u.original_url, u.untagged_url, (SELECT COUNT(*) FROM url_targets t WHERE t.url_id = u.id)
+		FROM urls u
+		WHERE u.domain = $1 AND u.short_code = $2 AND u.deleted_at IS NULL
+		FOR UPDATE
+	`, domain, code).Scan(&id, &current.OriginalURL, &current.UntaggedURL, &count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("querying link: %w", err)
+	}
+	if upd.Weights != nil && len(upd.Weights) != count {
+		return ErrNotFound
+	}
+
+	if upd.Destination != nil {
+		if err := setDestination(ctx, tx, id, &current, *upd.Destination); err != nil {
+			return err
+		}
+	}
+	if upd.Schedule {
+		at := upd.SwitchAt
+		if upd.NextURL == "" {
+			at = nil
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE urls SET next_url = $2, switch_at = $3 WHERE id = $1`, id, upd.NextURL, at)
+		if err != nil {
+			return fmt.Errorf("scheduling switch: %w", err)
+		}
+	}
+	for i, w := range upd.Weights {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE url_targets SET weight = $1 WHERE url_id = $2 AND position = $3`,
+			w, id, i)
+		if err != nil {
+			return fmt.Errorf("updating weight: %w", err)
+		}
+	}
+	if m := upd.Metadata; m != nil {
+		_, err := tx.ExecContext(ctx, `UPDATE urls SET title = $2, description = $3, image_url = $4 WHERE id = $1`,
+			id, m.Title, m.Description, m.ImageURL)
+		if err != nil {
+			return fmt.Errorf("setting metadata: %w", err)
+		}
+	}
+	if l := upd.Labels; l != nil {
+		_, err := tx.ExecContext(ctx, `UPDATE urls SET tags = $2, note = $3 WHERE id = $1`, id, tagsArray(l.Tags), l.Note)
+		if err != nil {
+			return fmt.Errorf("setting labels: %w", err)
+		}
+	}
+	if err := insertAudit(ctx, tx, domain, code); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete помечает ссылку удаленной и в той же транзакции закрывает ее код.
+// Ссылка выходит из дедупликации
+func (s *PostgresStorage) Delete(ctx context.Context, domain, code string) (err error) {
+	ctx, span := startSpan(ctx, "postgres.Delete", postgresAttrs("UPDATE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	err = s.softDelete(ctx, domain, code, time.Now())
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("deleting URL: %w", err)
+	}
+	return err
+}
+
+// softDelete помечает ссылку удаленной, закрывает ее код и записывает событие журнала
+func (s *PostgresStorage) softDelete(ctx context.Context, domain, code string, now time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	t := Tombstone{Domain: domain, ShortCode: code, DeletedAt: now}
+	err = tx.QueryRowContext(ctx, `
+		UPDATE urls SET deleted_at = $3, custom = TRUE
+		WHERE domain = $1 AND short_code = $2 AND deleted_at IS NULL
+		RETURNING workspace
+	`, domain, code, now).Scan(&t.Workspace)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := insertTombstone(ctx, tx, t); err != nil {
+		return err
+	}
+	if err := insertAudit(ctx, tx, domain, code); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Restore снимает с ссылки пометку об удалении. Для неудаленной ссылки возвращает ErrNotFound
+func (s *PostgresStorage) Restore(ctx context.Context, domain, code string) (err error) {
+	ctx, span := startSpan(ctx, "postgres.Restore", postgresAttrs("UPDATE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE urls SET deleted_at = NULL
+		WHERE domain = $1 AND short_code = $2 AND deleted_at IS NOT NULL
+	`
+
+	err = s.execAudited(ctx, domain, code, query, domain, code)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("restoring URL: %w", err)
+	}
+	return err
+}
+
+// Purge окончательно удаляет до limit ссылок (0 - все), удаленных до before,
+// и возвращает их число. Варианты и ревизии удаляются каскадно, коды остаются закрытыми
+func (s *PostgresStorage) Purge(ctx context.Context, before time.Time, limit int) (_ int, err error) {
+	ctx, span := startSpan(ctx, "postgres.Purge", postgresAttrs("DELETE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		DELETE FROM urls WHERE id IN (
+			SELECT id FROM urls
+			WHERE deleted_at < $1
+			ORDER BY deleted_at
+			LIMIT NULLIF($2, 0)
+		)
+	`
+
+	result, err := s.db.ExecContext(ctx, query, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("purging URLs: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("getting rows affected: %w", err)
+	}
+	return int(n), nil
+}
+
+// Tombstones возвращает до q.Limit (0 - все) закрытых кодов окончательно удаленных ссылок
+func (s *PostgresStorage) Tombstones(ctx context.Context, q TombstoneQuery) (_ []Tombstone, err error) {
+	ctx, span := startSpan(ctx, "postgres.Tombstones", postgresAttrs("SELECT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	where := []string{`NOT EXISTS (SELECT 1 FROM urls u WHERE u.domain = t.domain AND u.short_code = t.short_code)`}
+	var args queryArgs
+	if !q.All {
+		where = append(where, "t.workspace = "+args.add(q.Workspace), "t.domain = "+args.add(q.Domain))
+	}
+	if q.After != nil {
+		where = append(where, "(t.domain, t.short_code) > ("+args.add(q.After.Domain)+", "+args.add(q.After.ShortCode)+")")
+	}
+	query := `
+		SELECT t.workspace, t.domain, t.short_code, t.deleted_at
+		FROM code_tombstones t
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY t.domain, t.short_code`
+	if q.Limit > 0 {
+		query += " LIMIT " + args.add(q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying tombstones: %w", err)
+	}
+	defer rows.Close()
+
+	var result []Tombstone
+	for rows.Next() {
+		var t Tombstone
+		if err := rows.Scan(&t.Workspace, &t.Domain, &t.ShortCode, &t.DeletedAt); err != nil {
+			return nil, fmt.Errorf("scanning tombstone: %w", err)
+		}
+		result = append(result, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating tombstones: %w", err)
+	}
+	return result, nil
+}
+
+// SaveTombstone закрывает код окончательно удаленной ссылки. Уже закрытый код
+// не меняется; код существующей ссылки закрыть нельзя (ErrAlreadyExists)
+func (s *PostgresStorage) SaveTombstone(ctx context.Context, t Tombstone) (err error) {
+	ctx, span := startSpan(ctx, "postgres.SaveTombstone", postgresAttrs("INSERT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var stored bool
+	err = s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM urls WHERE domain = $1 AND short_code = $2 AND deleted_at IS NULL)`,
+		t.Domain, t.ShortCode).Scan(&stored)
+	if err != nil {
+		return fmt.Errorf("checking URL: %w", err)
+	}
+	if stored {
+		return ErrAlreadyExists
+	}
+	return insertTombstone(ctx, s.db, t)
+}
+
+// SetDestination меняет адрес назначения ссылки, сохраняя прежний как ревизию
+func (s *PostgresStorage) SetDestination(ctx context.Context, domain, code string, dest Destination) (err error) {
+	ctx, span := startSpan(ctx, "postgres.SetDestination", postgresAttrs("UPDATE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	err = s.replaceDestination(ctx, domain, code, func(*URL) (Destination, bool) { return dest, true })
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("setting destination: %w", err)
+	}
+	return err
+}
+
+// ListRevisions возвращает прежние адреса ссылки по возрастанию номера
+func (s *PostgresStorage) ListRevisions(ctx context.Context, domain, code string) (_ []Revision, err error) {
+	ctx, span := startSpan(ctx, "postgres.ListRevisions", postgresAttrs("SELECT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT r.revision, r.original_url, r.untagged_url, r.replaced_at
+		FROM url_revisions r
+		JOIN urls u ON u.id = r.url_id
+		WHERE u.domain = $1 AND u.short_code = $2
+		ORDER BY r.revision
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, domain, code)
+	if err != nil {
+		return nil, fmt.Errorf("querying revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var result []Revision
+	for rows.Next() {
+		var r Revision
+		if err := rows.Scan(&r.Number, &r.OriginalURL, &r.UntaggedURL, &r.ReplacedAt); err != nil {
+			return nil, fmt.Errorf("scanning revision: %w", err)
+		}
+		result = append(result, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating revisions: %w", err)
+	}
+	return result, nil
+}
+
+// IterateRevisions возвращает до limit (0 - все) ревизий всех ссылок,
+// в том числе удаленных, следующих за after в порядке (домен, код, номер)
+func (s *PostgresStorage) IterateRevisions(ctx context.Context, after *LinkRevision, limit int) (_ []LinkRevision, err error) {
+	ctx, span := startSpan(ctx, "postgres.IterateRevisions", postgresAttrs("SELECT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var args queryArgs
+	query := `
+		SELECT u.domain, u.short_code, r.revision, r.original_url, r.untagged_url, r.replaced_at
+		FROM url_revisions r
+		JOIN urls u ON u.id = r.url_id`
+	if after != nil {
+		query += `
+		WHERE (u.domain, u.short_code, r.revision) > (` +
+			args.add(after.Domain) + ", " + args.add(after.ShortCode) + ", " + args.add(after.Number) + ")"
+	}
+	query += `
+		ORDER BY u.domain, u.short_code, r.revision`
+	if limit > 0 {
+		query += " LIMIT " + args.add(limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var result []LinkRevision
+	for rows.Next() {
+		var r LinkRevision
+		if err := rows.Scan(&r.Domain, &r.ShortCode, &r.Number, &r.OriginalURL, &r.UntaggedURL, &r.ReplacedAt); err != nil {
+			return nil, fmt.Errorf("scanning revision: %w", err)
+		}
+		result = append(result, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating revisions: %w", err)
+	}
+	return result, nil
+}
+
+// SaveRevision сохраняет перенесенную ревизию ссылки, в том числе удаленной.
+// Ревизия с тем же номером не меняется; для отсутствующей ссылки возвращает ErrNotFound
+func (s *PostgresStorage) SaveRevision(ctx context.Context, r LinkRevision) (err error) {
+	ctx, span := startSpan(ctx, "postgres.SaveRevision", postgresAttrs("INSERT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO url_revisions (url_id, revision, original_url, untagged_url, replaced_at)
+		SELECT id, $3, $4, $5, $6
+		FROM urls WHERE domain = $1 AND short_code = $2
+		ON CONFLICT (url_id, revision) DO NOTHING
+	`
+
+	result, err := s.db.ExecContext(ctx, query,
+		r.Domain, r.ShortCode, r.Number, r.OriginalURL, r.UntaggedURL, r.ReplacedAt)
+	if err != nil {
+		return fmt.Errorf("inserting revision: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting affected rows: %w", err)
+	}
+	if rows > 0 {
+		return nil
+	}
+
+	var stored bool
+	err = s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM urls WHERE domain = $1 AND short_code = $2)`,
+		r.Domain, r.ShortCode).Scan(&stored)
+	if err != nil {
+		return fmt.Errorf("checking URL: %w", err)
+	}
+	if !stored {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ScheduleSwitch планирует смену адреса ссылки на nextURL в момент at.
+// Пустой nextURL отменяет запланированную смену
+func (s *PostgresStorage) ScheduleSwitch(ctx context.Context, domain, code, nextURL string, at *time.Time) (err error) {
+	ctx, span := startSpan(ctx, "postgres.ScheduleSwitch", postgresAttrs("UPDATE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if nextURL == "" {
+		at = nil
+	}
+	query := `
+		UPDATE urls SET next_url = $3, switch_at = $4
+		WHERE domain = $1 AND short_code = $2 AND deleted_at IS NULL
+	`
+
+	err = s.execAudited(ctx, domain, code, query, domain, code, nextURL, at)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("scheduling switch: %w", err)
+	}
+	return err
+}
+
+// DueSwitches возвращает до limit ссылок всех доменов, смена адреса которых
+// наступила к моменту now, в порядке времени смены
+func (s *PostgresStorage) DueSwitches(ctx context.Context, now time.Time, limit int) (_ []*URL, err error) {
+	ctx, span := startSpan(ctx, "postgres.DueSwitches", postgresAttrs("SELECT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT ` + urlColumns + `
+		FROM urls
+		WHERE switch_at <= $1 AND next_url <> '' AND deleted_at IS NULL
+		ORDER BY switch_at
+		LIMIT $2
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying due switches: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*URL
+	for rows.Next() {
+		url, err := scanURL(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning URL: %w", err)
+		}
+		result = append(result, url)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating due switches: %w", err)
+	}
+	return result, nil
+}
+
+// ApplySwitch делает запланированный адрес ссылки текущим, если смена наступила
+// к моменту now. Иначе возвращает ErrNotFound
+func (s *PostgresStorage) ApplySwitch(ctx context.Context, domain, code string, now time.Time) (err error) {
+	ctx, span := startSpan(ctx, "postgres.ApplySwitch", postgresAttrs("UPDATE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	err = s.replaceDestination(ctx, domain, code, func(u *URL) (Destination, bool) {
+		return Destination{OriginalURL: u.NextURL}, u.switchDue(now)
+	})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("applying switch: %w", err)
+	}
+	return err
+}
+
+// DueExpirations возвращает до limit ссылок всех доменов, срок которых истек
+// к моменту now, а истечение еще не записано, в порядке истечения
+func (s *PostgresStorage) DueExpirations(ctx context.Context, now time.Time, limit int) (_ []*URL, err error) {
+	ctx, span := startSpan(ctx, "postgres.DueExpirations", postgresAttrs("SELECT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT ` + urlColumns + `
+		FROM urls
+		WHERE expires_at <= $1 AND NOT expiry_notified AND deleted_at IS NULL
+		ORDER BY expires_at
+		LIMIT $2
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying due expirations: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*URL
+	for rows.Next() {
+		url, err := scanURL(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning URL: %w", err)
+		}
+		result = append(result, url)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating due expirations: %w", err)
+	}
+	return result, nil
+}
+
+// MarkExpired отмечает, что истечение срока ссылки записано, если срок истек
+// к моменту now. Иначе возвращает ErrNotFound
+func (s *PostgresStorage) MarkExpired(ctx context.Context, domain, code string, now time.Time) (err error) {
+	ctx, span := startSpan(ctx, "postgres.MarkExpired", postgresAttrs("UPDATE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	err = s.execAudited(ctx, domain, code, `
+		UPDATE urls SET expiry_notified = TRUE
+		WHERE domain = $1 AND short_code = $2 AND expires_at <= $3
+			AND NOT expiry_notified AND deleted_at IS NULL
+	`, domain, code, now)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("marking expired: %w", err)
+	}
+	return err
+}
+
+// replaceDestination в одной транзакции блокирует ссылку, сохраняет ее адрес
+// как следующую ревизию и заменяет адресом, который вернула next. Если next
+// вернула false, ничего не меняется и возвращается ErrNotFound. Запланированная
+// смена, ставшая текущим адресом, снимается. Ссылка с измененным адресом
+// больше не участвует в дедупликации
+func (s *PostgresStorage) replaceDestination(ctx context.Context, domain, code string, next func(*URL) (Destination, bool)) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	var current URL
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, original_url, untagged_url, next_url, switch_at
+		FROM urls
+		WHERE domain = $1 AND short_code = $2 AND deleted_at IS NULL
+		FOR UPDATE
+	`, domain, code).Scan(&id, &current.OriginalURL, &current.UntaggedURL, &current.NextURL, &current.SwitchAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("querying destination: %w", err)
+	}
+	dest, ok := next(&current)
+	if !ok {
+		return ErrNotFound
+	}
+
+	if err := setDestination(ctx, tx, id, &current, dest); err != nil {
+		return err
+	}
+	if err := insertAudit(ctx, tx, domain, code); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setDestination сохраняет текущий адрес заблокированной ссылки id как
+// следующую ревизию и заменяет его адресом dest
+func setDestination(ctx context.Context, tx *sql.Tx, id int64, current *URL, dest Destination) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO url_revisions (url_id, revision, original_url, untagged_url, replaced_at)
+		SELECT $1, COALESCE(MAX(revision), 0) + 1, $2::text, $3::text, $4::timestamptz
+		FROM url_revisions WHERE url_id = $1
+	`, id, current.OriginalURL, current.UntaggedURL, time.Now())
+	if err != nil {
+		return fmt.Errorf("inserting revision: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE urls SET original_url = $2, untagged_url = $3, custom = TRUE,
+			next_url = CASE WHEN next_url = $2 THEN '' ELSE next_url END,
+			switch_at = CASE WHEN next_url = $2 THEN NULL ELSE switch_at END
+		WHERE id = $1
+	`, id, dest.OriginalURL, dest.UntaggedURL)
+	if err != nil {
+		return fmt.Errorf("updating destination: %w", err)
+	}
+	return nil
+}
+
+// execAudited изменяет одну ссылку и записывает событие журнала из ctx
+// в одной транзакции. Возвращает ErrNotFound, если ссылки нет
+func (s *PostgresStorage) execAudited(ctx context.Context, domain, code, query string, args ...any) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if err := expectRows(result); err != nil {
+		return err
+	}
+	if err := insertAudit(ctx, tx, domain, code); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertAudit записывает событие журнала из ctx, если оно есть, в транзакции изменения
+func insertAudit(ctx context.Context, tx *sql.Tx, domain, code string) error {
+	e, ok := auditFrom(ctx, domain, code)
+	if !ok {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO audit_log (workspace, domain, short_code, action, actor, request_id, created_at, before, after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, e.Workspace, e.Domain, e.ShortCode, e.Action, e.Actor, e.RequestID, e.At, []byte(e.Before), []byte(e.After))
+	if err != nil {
+		return fmt.Errorf("inserting audit event: %w", err)
+	}
+	if event, ok := eventOf(e); ok {
+		return enqueueDeliveries(ctx, tx, event)
+	}
+	return nil
+}
+
+// ListAudit возвращает события журнала по фильтрам, от новых к старым
+func (s *PostgresStorage) ListAudit(ctx context.Context, q AuditQuery) (_ []AuditEvent, err error) {
+	ctx, span := startSpan(ctx, "postgres.ListAudit", postgresAttrs("SELECT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var where []string
+	var args queryArgs
+
+	where = append(where, "workspace = "+args.add(q.Workspace))
+	if q.ShortCode != "" {
+		where = append(where, "domain = "+args.add(q.Domain), "short_code = "+args.add(q.ShortCode))
+	}
+	if q.Actor != "" {
+		where = append(where, "actor = "+args.add(q.Actor))
+	}
+	if q.Action != "" {
+		where = append(where, "action = "+args.add(q.Action))
+	}
+	if q.BeforeID > 0 {
+		where = append(where, "id < "+args.add(q.BeforeID))
+	}
+	query := `
+		SELECT id, workspace, domain, short_code, action, actor, request_id, created_at, before, after
+		FROM audit_log
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY id DESC`
+	if q.Limit > 0 {
+		query += " LIMIT " + args.add(q.Limit)
+	}
+
+	return s.queryAudit(ctx, query, args...)
+}
+
+// queryAudit выполняет выборку событий журнала в порядке колонок ListAudit
+func (s *PostgresStorage) queryAudit(ctx context.Context, query string, args ...any) ([]AuditEvent, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		var e AuditEvent
+		var before, after []byte
+		err := rows.Scan(&e.ID, &e.Workspace, &e.Domain, &e.ShortCode, &e.Action, &e.Actor, &e.RequestID, &e.At, &before, &after)
+		if err != nil {
+			return nil, fmt.Errorf("scanning audit event: %w", err)
+		}
+		e.Before, e.After = before, after
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating audit log: %w", err)
+	}
+	return events, nil
+}
+
+// IterateAudit возвращает до limit (0 - все) событий журнала всех пространств
+// с ID больше afterID по возрастанию ID
+func (s *PostgresStorage) IterateAudit(ctx context.Context, afterID int64, limit int) (_ []AuditEvent, err error) {
+	ctx, span := startSpan(ctx, "postgres.IterateAudit", postgresAttrs("SELECT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var args queryArgs
+	query := `
+		SELECT id, workspace, domain, short_code, action, actor, request_id, created_at, before, after
+		FROM audit_log
+		WHERE id > ` + args.add(afterID) + `
+		ORDER BY id`
+	if limit > 0 {
+		query += " LIMIT " + args.add(limit)
+	}
+	return s.queryAudit(ctx, query, args...)
+}
+
+// SaveAudit сохраняет перенесенное событие журнала с его ID, не ставя его
+// в очередь доставки. То же событие повторно не сохраняется; другое событие
+// с тем же ID - ErrAlreadyExists. Последовательность ID сдвигается за
+// сохраненное событие, чтобы новые события не заняли перенесенные ID
+func (s *PostgresStorage) SaveAudit(ctx context.Context, e AuditEvent) (err error) {
+	ctx, span := startSpan(ctx, "postgres.SaveAudit", postgresAttrs("INSERT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO audit_log (id, workspace, domain, short_code, action, actor, request_id, created_at, before, after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO NOTHING
+	`, e.ID, e.Workspace, e.Domain, e.ShortCode, e.Action, e.Actor, e.RequestID, e.At, []byte(e.Before), []byte(e.After))
+	if err != nil {
+		return fmt.Errorf("inserting audit event: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting affected rows: %w", err)
+	}
+
+	if rows == 0 {
+		var existing AuditEvent
+		err := tx.QueryRowContext(ctx, `
+			SELECT id, workspace, domain, short_code, action, actor, request_id, created_at
+			FROM audit_log WHERE id = $1
+		`, e.ID).Scan(&existing.ID, &existing.Workspace, &existing.Domain, &existing.ShortCode,
+			&existing.Action, &existing.Actor, &existing.RequestID, &existing.At)
+		if err != nil {
+			return fmt.Errorf("reading audit event: %w", err)
+		}
+		if !sameAuditEvent(&existing, &e) {
+			return ErrAlreadyExists
+		}
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		SELECT setval(pg_get_serial_sequence('audit_log', 'id'), (SELECT MAX(id) FROM audit_log))
+	`)
+	if err != nil {
+		return fmt.Errorf("advancing audit sequence: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing audit event: %w", err)
+	}
+	return nil
+}
+
+// CreateWebhook сохраняет подписку и возвращает ее ID
+func (s *PostgresStorage) CreateWebhook(ctx context.Context, w Webhook) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "postgres.CreateWebhook", postgresAttrs("INSERT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var id int64
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO webhooks (workspace, url, secret, events, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, w.Workspace, w.URL, w.Secret, tagsArray(w.Events), w.CreatedBy, w.CreatedAt).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("inserting webhook: %w", err)
+	}
+	return id, nil
+}
+
+// Колонки webhooks в порядке, ожидаемом scanWebhook
+const webhookColumns = `id, workspace, url, secret, events, created_by, created_at`
+
+// scanWebhook читает строку, выбранную по webhookColumns
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	var w Webhook
+	err := row.Scan(&w.ID, &w.Workspace, &w.URL, &w.Secret, (*pq.StringArray)(&w.Events), &w.CreatedBy, &w.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if len(w.Events) == 0 {
+		w.Events = nil
+	}
+	return &w, nil
+}
+
+// GetWebhook возвращает подписку по ID
+func (s *PostgresStorage) GetWebhook(ctx context.Context, id int64) (_ *Webhook, err error) {
+	ctx, span := startSpan(ctx, "postgres.GetWebhook", postgresAttrs("SELECT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	w, err := scanWebhook(s.db.QueryRowContext(ctx,
+		`SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying webhook: %w", err)
+	}
+	return w, nil
+}
+
+// ListWebhooks возвращает подписки пространства по возрастанию ID
+func (s *PostgresStorage) ListWebhooks(ctx context.Context, workspace string) (_ []Webhook, err error) {
+	ctx, span := startSpan(ctx, "postgres.ListWebhooks", postgresAttrs("SELECT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+webhookColumns+` FROM webhooks WHERE workspace = $1 ORDER BY id`, workspace)
+	if err != nil {
+		return nil, fmt.Errorf("querying webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var result []Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning webhook: %w", err)
+		}
+		result = append(result, *w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating webhooks: %w", err)
+	}
+	return result, nil
+}
+
+// SubscribedWorkspaces возвращает пространства, в которых есть подписка на событие event
+func (s *PostgresStorage) SubscribedWorkspaces(ctx context.Context, event string) (_ []string, err error) {
+	ctx, span := startSpan(ctx, "postgres.SubscribedWorkspaces", postgresAttrs("SELECT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT workspace FROM webhooks
+		WHERE cardinality(events) = 0 OR $1::text = ANY(events)
+		ORDER BY workspace
+	`, event)
+	if err != nil {
+		return nil, fmt.Errorf("querying webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var workspace string
+		if err := rows.Scan(&workspace); err != nil {
+			return nil, fmt.Errorf("scanning workspace: %w", err)
+		}
+		result = append(result, workspace)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating webhooks: %w", err)
+	}
+	return result, nil
+}
+
+// DeleteWebhook удаляет подписку пространства вместе с ее доставками
+func (s *PostgresStorage) DeleteWebhook(ctx context.Context, workspace string, id int64) (err error) {
+	ctx, span := startSpan(ctx, "postgres.DeleteWebhook", postgresAttrs("DELETE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1 AND workspace = $2`, id, workspace)
+	if err != nil {
+		return fmt.Errorf("deleting webhook: %w", err)
+	}
+	return expectRows(result)
+}
+
+// Publish ставит событие в очередь доставки подписчикам его пространства
+func (s *PostgresStorage) Publish(ctx context.Context, e Event) (err error) {
+	ctx, span := startSpan(ctx, "postgres.Publish", postgresAttrs("INSERT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return enqueueDeliveries(ctx, s.db, e)
+}
+
+// enqueueDeliveries добавляет доставку события каждой подходящей подписке
+func enqueueDeliveries(ctx context.Context, db execer, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding event: %w", err)
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, workspace, event, url, payload, status,
+			next_attempt_at, created_at, updated_at)
+		SELECT id, workspace, $2::text, url, $3::jsonb, $4::text, $5::timestamptz, $5::timestamptz, $5::timestamptz
+		FROM webhooks
+		WHERE workspace = $1 AND (cardinality(events) = 0 OR $2::text = ANY(events))
+		ORDER BY id
+	`, e.Workspace, e.Type, payload, DeliveryPending, time.Now())
+	if err != nil {
+		return fmt.Errorf("enqueueing deliveries: %w", err)
+	}
+	return nil
+}
+
+// Колонки webhook_deliveries в порядке, ожидаемом scanDelivery
+const deliveryColumns = `id, webhook_id, workspace, event, url, payload, status, attempts,
+	next_attempt_at, last_status, last_error, created_at, updated_at`
+
+// scanDelivery читает строку, выбранную по deliveryColumns
+func scanDelivery(row rowScanner) (Delivery, error) {
+	var d Delivery
+	var payload []byte
+	err := row.Scan(&d.ID, &d.WebhookID, &d.Workspace, &d.Event, &d.URL, &payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastStatus, &d.LastError, &d.CreatedAt, &d.UpdatedAt)
+	d.Payload = payload
+	return d, err
+}
+
+// ClaimDeliveries возвращает до limit ожидающих доставок, время попытки которых
+// наступило к моменту now, и откладывает их следующую попытку на lease, чтобы
+// другие экземпляры сервиса их не взяли
+func (s *PostgresStorage) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) (_ []Delivery, err error) {
+	ctx, span := startSpan(ctx, "postgres.ClaimDeliveries", postgresAttrs("UPDATE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE webhook_deliveries SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = $3 AND next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT NULLIF($4, 0)
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + deliveryColumns
+
+	rows, err := s.db.QueryContext(ctx, query, now, now.Add(lease), DeliveryPending, limit)
+	if err != nil {
+		return nil, fmt.Errorf("claiming deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var result []Delivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning delivery: %w", err)
+		}
+		result = append(result, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating deliveries: %w", err)
+	}
+	return result, nil
+}
+
+// UpdateDelivery сохраняет состояние, число попыток и итог последней попытки доставки
+func (s *PostgresStorage) UpdateDelivery(ctx context.Context, d Delivery) (err error) {
+	ctx, span := startSpan(ctx, "postgres.UpdateDelivery", postgresAttrs("UPDATE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, next_attempt_at = $4, last_status = $5, last_error = $6, updated_at = $7
+		WHERE id = $1
+	`, d.ID, d.Status, d.Attempts, d.NextAttemptAt, d.LastStatus, d.LastError, d.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("updating delivery: %w", err)
+	}
+	return expectRows(result)
+}
+
+// RetryDelivery возвращает доставку пространства в очередь с немедленной
+// попыткой и обнуляет счетчик попыток
+func (s *PostgresStorage) RetryDelivery(ctx context.Context, workspace string, id int64, now time.Time) (err error) {
+	ctx, span := startSpan(ctx, "postgres.RetryDelivery", postgresAttrs("UPDATE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $3, attempts = 0, next_attempt_at = $4, updated_at = $4
+		WHERE id = $1 AND workspace = $2
+	`, id, workspace, DeliveryPending, now)
+	if err != nil {
+		return fmt.Errorf("retrying delivery: %w", err)
+	}
+	return expectRows(result)
+}
+
+// PurgeDeliveries удаляет до limit (0 - все) доставок, отправленных до before,
+// и возвращает их число. Ожидающие и неотправленные доставки не удаляются
+func (s *PostgresStorage) PurgeDeliveries(ctx context.Context, before time.Time, limit int) (_ int, err error) {
+	ctx, span := startSpan(ctx, "postgres.PurgeDeliveries", postgresAttrs("DELETE")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		DELETE FROM webhook_deliveries WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'delivered' AND updated_at < $1
+			ORDER BY updated_at
+			LIMIT NULLIF($2, 0)
+		)
+	`
+
+	// Состояние указано в запросе, чтобы выборка шла по частичному индексу
+	result, err := s.db.ExecContext(ctx, query, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("purging deliveries: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("getting rows affected: %w", err)
+	}
+	return int(n), nil
+}
+
+// ListDeliveries возвращает доставки по фильтрам, от новых к старым
+func (s *PostgresStorage) ListDeliveries(ctx context.Context, q DeliveryQuery) (_ []Delivery, err error) {
+	ctx, span := startSpan(ctx, "postgres.ListDeliveries", postgresAttrs("SELECT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var where []string
+	var args queryArgs
+
+	where = append(where, "workspace = "+args.add(q.Workspace))
+	if q.WebhookID != 0 {
+		where = append(where, "webhook_id = "+args.add(q.WebhookID))
+	}
+	if q.Status != "" {
+		where = append(where, "status = "+args.add(q.Status))
+	}
+	if q.BeforeID > 0 {
+		where = append(where, "id < "+args.add(q.BeforeID))
+	}
+	query := `
+		SELECT ` + deliveryColumns + `
+		FROM webhook_deliveries
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY id DESC`
+	if q.Limit > 0 {
+		query += " LIMIT " + args.add(q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var result []Delivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning delivery: %w", err)
+		}
+		result = append(result, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating deliveries: %w", err)
+	}
+	return result, nil
+}
+
+// List возвращает ссылки домена по фильтрам. Выборка идет по ключу
+// (created_at, short_code), поэтому страницы не зависят от смещения
+func (s *PostgresStorage) List(ctx context.Context, q ListQuery) (_ []*URL, err error) {
+	ctx, span := startSpan(ctx, "postgres.List", postgresAttrs("SELECT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var where []string
+	var args queryArgs
+
+	where = append(where, "workspace = "+args.add(q.Workspace), "domain = "+args.add(q.Domain))
+	if q.CreatedBy != "" {
+		where = append(where, "created_by = "+args.add(q.CreatedBy))
+	}
+	if len(q.Tags) > 0 {
+		where = append(where, "tags @> "+args.add(pq.StringArray(q.Tags))+"::text[]")
+	}
+	if q.DestHost != "" {
+		where = append(where, "dest_host = "+args.add(strings.ToLower(q.DestHost)))
+	}
+	if q.Contains != "" {
+		pattern := args.add("%" + escapeLike(q.Contains) + "%")
+		where = append(where, "(original_url ILIKE "+pattern+" OR untagged_url ILIKE "+pattern+")")
+	}
+	if q.CreatedFrom != nil {
+		where = append(where, "created_at >= "+args.add(*q.CreatedFrom))
+	}
+	if q.CreatedTo != nil {
+		where = append(where, "created_at < "+args.add(*q.CreatedTo))
+	}
+	if q.Status == StatusDeleted {
+		where = append(where, "deleted_at IS NOT NULL")
+	} else {
+		where = append(where, "deleted_at IS NULL")
+	}
+	switch q.Status {
+	case StatusActive:
+		where = append(where, "(expires_at IS NULL OR expires_at > "+args.add(time.Now())+")")
+	case StatusExpired:
+		where = append(where, "expires_at <= "+args.add(time.Now()))
+	}
+
+	order, cmp := "ASC", ">"
+	if q.Descending {
+		order, cmp = "DESC", "<"
+	}
+	if q.After != nil {
+		where = append(where, "(created_at, short_code) "+cmp+" ("+args.add(q.After.CreatedAt)+", "+args.add(q.After.ShortCode)+")")
+	}
+
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE ` + strings.Join(where, " AND ") +
+		` ORDER BY created_at ` + order + `, short_code ` + order
+	if q.Limit > 0 {
+		query += ` LIMIT ` + args.add(q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing URLs: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*URL
+	for rows.Next() {
+		url, err := scanURL(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning URL: %w", err)
+		}
+		result = append(result, url)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing URLs: %w", err)
+	}
+	return result, nil
+}
+
+// CountLinks возвращает число ссылок рабочего пространства
+func (s *PostgresStorage) CountLinks(ctx context.Context, workspace string) (_ int, err error) {
+	ctx, span := startSpan(ctx, "postgres.CountLinks", postgresAttrs("SELECT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var n int
+	err = s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM urls WHERE workspace = $1 AND deleted_at IS NULL`, workspace).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("counting URLs: %w", err)
+	}
+	return n, nil
+}
+
+// Iterate возвращает до limit записей, следующих за позицией after; nil - с начала.
+// Обход идет по индексу (domain, created_at, short_code)
+func (s *PostgresStorage) Iterate(ctx context.Context, after *Position, limit int) (_ []*URL, err error) {
+	ctx, span := startSpan(ctx, "postgres.Iterate", postgresAttrs("SELECT")...)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + urlColumns + ` FROM urls`
+	args := []any{limit}
+	if after != nil {
+		query += ` WHERE (domain, created_at, short_code) > ($2, $3, $4)`
+		args = append(args, after.Domain, after.CreatedAt, after.ShortCode)
+	}
+	query += ` ORDER BY domain, created_at, short_code LIMIT $1`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("iterating URLs: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*URL
+	for rows.Next() {
+		url, err := scanURL(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning URL: %w", err)
+		}
+		result = append(result, url)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating URLs: %w", err)
+	}
+	return result, nil
+}
+
+// tagsArray приводит метки к параметру массива; пустой массив вместо NULL
+func tagsArray(tags []string) pq.StringArray {
+	if tags == nil {
+		return pq.StringArray{}
+	}
+	return tags
+}
+
+// queryArgs - параметры запроса, собираемого из необязательных условий
+type queryArgs []any
+
+// add добавляет значение в параметры запроса и возвращает его плейсхолдер
+func (a *queryArgs) add(v any) string {
+	*a = append(*a, v)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+// escapeLike экранирует спецсимволы шаблона LIKE
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// expectRows возвращает ErrNotFound, если запрос не затронул ни одной строки
+func expectRows(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting rows affected: %w", err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Close закрывает соединение с БД
+func (s *PostgresStorage) Close() error {
+	return s.db.Close()
+}
+
+// Ping проверяет соединение с БД
+func (s *PostgresStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Колонки urls в порядке, ожидаемом scanURL. Варианты ссылки выбираются
+// подзапросом в виде JSON-массива
+const urlColumns = `workspace, domain, short_code, original_url, created_at, expires_at,
+	not_before, pending_url, expired_url, clicks_left, password_hash, custom, sticky, rules,
+	forward_query, forward_path, untagged_url, title, description, image_url, created_by,
+	tags, note, next_url, switch_at, deleted_at, expiry_notified,
+	(SELECT json_agg(json_build_object('url', t.url, 'weight', t.weight, 'clicks', t.clicks) ORDER BY t.position)
+		FROM url_targets t WHERE t.url_id = urls.id)`
+
+// rowQuerier - общий интерфейс *sql.DB и *sql.Tx
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// execer - общий интерфейс *sql.DB и *sql.Tx для изменений
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// rowScanner - общий интерфейс *sql.Row и *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanURL читает строку, выбранную по urlColumns
+func scanURL(row rowScanner) (*URL, error) {
+	var url URL
+	var rulesJSON, targets []byte
+	err := row.Scan(
+		&url.Workspace,
+		&url.Domain,
+		&url.ShortCode,
+		&url.OriginalURL,
+		&url.CreatedAt,
+		&url.ExpiresAt,
+		&url.NotBefore,
+		&url.PendingURL,
+		&url.ExpiredURL,
+		&url.ClicksLeft,
+		&url.PasswordHash,
+		&url.Custom,
+		&url.Sticky,
+		&rulesJSON,
+		&url.ForwardQuery,
+		&url.ForwardPath,
+		&url.UntaggedURL,
+		&url.Title,
+		&url.Description,
+		&url.ImageURL,
+		&url.CreatedBy,
+		(*pq.StringArray)(&url.Tags),
+		&url.Note,
+		&url.NextURL,
+		&url.SwitchAt,
+		&url.DeletedAt,
+		&url.ExpiryNotified,
+		&targets,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(url.Tags) == 0 {
+		url.Tags = nil
+	}
+	if rulesJSON != nil {
+		if err := json.Unmarshal(rulesJSON, &url.Rules); err != nil {
+			return nil, fmt.Errorf("decoding rules: %w", err)
+		}
+	}
+	if targets != nil {
+		if err := json.Unmarshal(targets, &url.Targets); err != nil {
+			return nil, fmt.Errorf("decoding targets: %w", err)
+		}
+	}
+	return &url, nil
 }
 
 func isUniqueViolation(err error) bool {
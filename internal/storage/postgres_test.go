@@ -3,8 +3,14 @@ package storage
 import (
 	"context"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/BuzzLyutic/url-shortener/internal/rules"
 )
 
 // getTestDSN возвращает DSN БД для тестов
@@ -34,6 +40,10 @@ func skipIfNoDatabase(t *testing.T) *PostgresStorage {
 	// Очистка таблиц перед тестированием
 	ctx := context.Background()
 	_, _ = storage.db.ExecContext(ctx, "DELETE FROM urls")
+	_, _ = storage.db.ExecContext(ctx, "DELETE FROM code_tombstones")
+	_, _ = storage.db.ExecContext(ctx, "DELETE FROM webhooks")
+	// Журнал запрещает DELETE, но TRUNCATE построчные триггеры не вызывает
+	_, _ = storage.db.ExecContext(ctx, "TRUNCATE audit_log")
 
 	return storage
 }
@@ -41,6 +51,7 @@ func skipIfNoDatabase(t *testing.T) *PostgresStorage {
 func TestPostgresStorage_Save(t *testing.T) {
 	s := skipIfNoDatabase(t)
 	defer s.Close()
+	ctx := context.Background()
 
 	url := URL{
 		ShortCode:   "testcode12",
@@ -48,12 +59,12 @@ func TestPostgresStorage_Save(t *testing.T) {
 		CreatedAt:   time.Now(),
 	}
 
-	err := s.Save(url)
+	err := s.Save(ctx, url)
 	if err != nil {
 		t.Fatalf("Save() error = %v", err)
 	}
 
-	err = s.Save(url)
+	err = s.Save(ctx, url)
 	if err != nil {
 		t.Fatalf("Save() idempotent error = %v", err)
 	}
@@ -63,7 +74,7 @@ func TestPostgresStorage_Save(t *testing.T) {
 		OriginalURL: "https://different.com",
 		CreatedAt:   time.Now(),
 	}
-	err = s.Save(url2)
+	err = s.Save(ctx, url2)
 	if err != ErrAlreadyExists {
 		t.Errorf("Save() error = %v, want %v", err, ErrAlreadyExists)
 	}
@@ -72,15 +83,16 @@ func TestPostgresStorage_Save(t *testing.T) {
 func TestPostgresStorage_GetByCode(t *testing.T) {
 	s := skipIfNoDatabase(t)
 	defer s.Close()
+	ctx := context.Background()
 
 	url := URL{
 		ShortCode:   "getcode123",
 		OriginalURL: "https://example.com/get-by-code",
 		CreatedAt:   time.Now(),
 	}
-	_ = s.Save(url)
+	_ = s.Save(ctx, url)
 
-	got, err := s.GetByCode("getcode123")
+	got, err := s.GetByCode(ctx, "", "getcode123")
 	if err != nil {
 		t.Fatalf("GetByCode() error = %v", err)
 	}
@@ -88,7 +100,7 @@ func TestPostgresStorage_GetByCode(t *testing.T) {
 		t.Errorf("GetByCode() OriginalURL = %v, want %v", got.OriginalURL, url.OriginalURL)
 	}
 
-	_, err = s.GetByCode("nonexist12")
+	_, err = s.GetByCode(ctx, "", "nonexist12")
 	if err != ErrNotFound {
 		t.Errorf("GetByCode() error = %v, want %v", err, ErrNotFound)
 	}
@@ -97,15 +109,16 @@ func TestPostgresStorage_GetByCode(t *testing.T) {
 func TestPostgresStorage_GetByOriginalURL(t *testing.T) {
 	s := skipIfNoDatabase(t)
 	defer s.Close()
+	ctx := context.Background()
 
 	url := URL{
 		ShortCode:   "origurl123",
 		OriginalURL: "https://example.com/get-by-original",
 		CreatedAt:   time.Now(),
 	}
-	_ = s.Save(url)
+	_ = s.Save(ctx, url)
 
-	got, err := s.GetByOriginalURL("https://example.com/get-by-original")
+	got, err := s.GetByOriginalURL(ctx, "", "", "https://example.com/get-by-original")
 	if err != nil {
 		t.Fatalf("GetByOriginalURL() error = %v", err)
 	}
@@ -113,15 +126,155 @@ func TestPostgresStorage_GetByOriginalURL(t *testing.T) {
 		t.Errorf("GetByOriginalURL() ShortCode = %v, want %v", got.ShortCode, url.ShortCode)
 	}
 
-	_, err = s.GetByOriginalURL("https://nonexistent.com")
+	_, err = s.GetByOriginalURL(ctx, "", "", "https://nonexistent.com")
+	if err != ErrNotFound {
+		t.Errorf("GetByOriginalURL() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestPostgresStorage_Domains(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	primary := URL{ShortCode: "domcode123", OriginalURL: "https://example.com/pg-a", CreatedAt: time.Now()}
+	other := URL{Domain: "go.example", ShortCode: "domcode123", OriginalURL: "https://example.com/pg-b", CreatedAt: time.Now()}
+
+	if err := s.Save(ctx, primary); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := s.Save(ctx, other); err != nil {
+		t.Fatalf("Save() on other domain error = %v", err)
+	}
+
+	got, err := s.GetByCode(ctx, "go.example", "domcode123")
+	if err != nil {
+		t.Fatalf("GetByCode() error = %v", err)
+	}
+	if got.OriginalURL != other.OriginalURL {
+		t.Errorf("GetByCode() OriginalURL = %v, want %v", got.OriginalURL, other.OriginalURL)
+	}
+
+	_, err = s.GetByOriginalURL(ctx, "", "go.example", primary.OriginalURL)
 	if err != ErrNotFound {
 		t.Errorf("GetByOriginalURL() error = %v, want %v", err, ErrNotFound)
 	}
 }
 
+func TestPostgresStorage_Custom(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	plain := URL{ShortCode: "plaincode1", OriginalURL: "https://example.com/pg-doc", CreatedAt: time.Now()}
+	custom := URL{
+		ShortCode:    "custcode_1",
+		OriginalURL:  plain.OriginalURL,
+		CreatedAt:    time.Now(),
+		PasswordHash: "hash",
+		Custom:       true,
+	}
+
+	if err := s.Save(ctx, plain); err != nil {
+		t.Fatalf("Save() plain error = %v", err)
+	}
+	// Тот же оригинальный URL допустим для custom ссылки
+	if err := s.Save(ctx, custom); err != nil {
+		t.Fatalf("Save() custom error = %v", err)
+	}
+
+	got, err := s.GetByOriginalURL(ctx, "", "", plain.OriginalURL)
+	if err != nil {
+		t.Fatalf("GetByOriginalURL() error = %v", err)
+	}
+	if got.ShortCode != plain.ShortCode {
+		t.Errorf("GetByOriginalURL() ShortCode = %v, want %v", got.ShortCode, plain.ShortCode)
+	}
+
+	got, err = s.GetByCode(ctx, "", custom.ShortCode)
+	if err != nil {
+		t.Fatalf("GetByCode() error = %v", err)
+	}
+	if got.PasswordHash != "hash" || !got.Custom {
+		t.Errorf("GetByCode() = %+v, want password hash and custom flag", got)
+	}
+
+	if err := s.Save(ctx, custom); err != ErrAlreadyExists {
+		t.Errorf("Save() duplicate custom error = %v, want %v", err, ErrAlreadyExists)
+	}
+}
+
+func TestPostgresStorage_ConsumeClick(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	limit := 3
+	limited := URL{ShortCode: "pglimited1", OriginalURL: "https://example.com/pg-once", CreatedAt: time.Now(), ClicksLeft: &limit, Custom: true}
+	if err := s.Save(ctx, limited); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// Параллельные списания не превышают лимит
+	var wg sync.WaitGroup
+	var consumed atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s.ConsumeClick(ctx, "", limited.ShortCode) == nil {
+				consumed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := consumed.Load(); got != 3 {
+		t.Errorf("consumed %d clicks, want 3", got)
+	}
+	if err := s.ConsumeClick(ctx, "", limited.ShortCode); err != ErrExhausted {
+		t.Errorf("ConsumeClick() over limit error = %v, want %v", err, ErrExhausted)
+	}
+	if err := s.ConsumeClick(ctx, "", "nonexist12"); err != ErrNotFound {
+		t.Errorf("ConsumeClick() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestPostgresStorage_Schedule(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	future := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	err := s.Save(ctx, URL{
+		ShortCode:   "pgpending1",
+		OriginalURL: "https://example.com/pg-launch",
+		CreatedAt:   time.Now(),
+		NotBefore:   &future,
+		PendingURL:  "https://example.com/pg-soon",
+		ExpiredURL:  "https://example.com/pg-over",
+		Custom:      true,
+	})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	got, err := s.GetByCode(ctx, "", "pgpending1")
+	if err != ErrNotYetActive {
+		t.Errorf("GetByCode() error = %v, want %v", err, ErrNotYetActive)
+	}
+	if got == nil || got.PendingURL != "https://example.com/pg-soon" || got.ExpiredURL != "https://example.com/pg-over" {
+		t.Fatalf("GetByCode() = %+v, want record with fallbacks", got)
+	}
+	if got.NotBefore == nil || !got.NotBefore.Equal(future) {
+		t.Errorf("NotBefore = %v, want %v", got.NotBefore, future)
+	}
+}
+
 func TestPostgresStorage_Expiration(t *testing.T) {
 	s := skipIfNoDatabase(t)
 	defer s.Close()
+	ctx := context.Background()
 
 	// Создать уже устаревший URL
 	pastTime := time.Now().Add(-1 * time.Hour)
@@ -131,14 +284,14 @@ func TestPostgresStorage_Expiration(t *testing.T) {
 		CreatedAt:   time.Now(),
 		ExpiresAt:   &pastTime,
 	}
-	_ = s.Save(url)
+	_ = s.Save(ctx, url)
 
-	_, err := s.GetByCode("expired123")
+	_, err := s.GetByCode(ctx, "", "expired123")
 	if err != ErrExpired {
 		t.Errorf("GetByCode() error = %v, want %v", err, ErrExpired)
 	}
 
-	_, err = s.GetByOriginalURL("https://example.com/expired-pg")
+	_, err = s.GetByOriginalURL(ctx, "", "", "https://example.com/expired-pg")
 	if err != ErrExpired {
 		t.Errorf("GetByOriginalURL() error = %v, want %v", err, ErrExpired)
 	}
@@ -182,7 +335,7 @@ func BenchmarkPostgresStorage_Save(b *testing.B) {
 			OriginalURL: "https://example.com/bench/" + string(rune(i)),
 			CreatedAt:   time.Now(),
 		}
-		_ = s.Save(url)
+		_ = s.Save(ctx, url)
 	}
 }
 
@@ -198,16 +351,706 @@ func BenchmarkPostgresStorage_GetByCode(b *testing.B) {
 		b.Skipf("Cannot connect: %v", err)
 	}
 	defer s.Close()
+	ctx := context.Background()
 
 	url := URL{
 		ShortCode:   "benchget12",
 		OriginalURL: "https://example.com/bench-get",
 		CreatedAt:   time.Now(),
 	}
-	_ = s.Save(url)
+	_ = s.Save(ctx, url)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _ = s.GetByCode("benchget12")
+		_, _ = s.GetByCode(ctx, "", "benchget12")
+	}
+}
+
+func TestPostgresStorage_Targets(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	err := s.Save(ctx, URL{
+		ShortCode:   "pgabtest01",
+		OriginalURL: "https://example.com/pg-a",
+		CreatedAt:   time.Now(),
+		Targets: []Target{
+			{URL: "https://example.com/pg-a", Weight: 1},
+			{URL: "https://example.com/pg-b", Weight: 2},
+		},
+		Sticky: true,
+		Custom: true,
+	})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	if err := s.RecordClick(ctx, "", "pgabtest01", 1); err != nil {
+		t.Fatalf("RecordClick() error = %v", err)
+	}
+	if err := s.SetWeights(ctx, "", "pgabtest01", []int{3, 0}); err != nil {
+		t.Fatalf("SetWeights() error = %v", err)
+	}
+	if err := s.SetWeights(ctx, "", "pgabtest01", []int{1}); err != ErrNotFound {
+		t.Errorf("SetWeights() wrong count error = %v, want %v", err, ErrNotFound)
+	}
+
+	got, err := s.GetByCode(ctx, "", "pgabtest01")
+	if err != nil {
+		t.Fatalf("GetByCode() error = %v", err)
+	}
+	want := []Target{
+		{URL: "https://example.com/pg-a", Weight: 3},
+		{URL: "https://example.com/pg-b", Weight: 0, Clicks: 1},
+	}
+	if !got.Sticky || len(got.Targets) != 2 || got.Targets[0] != want[0] || got.Targets[1] != want[1] {
+		t.Errorf("GetByCode() = %+v, want targets %+v", got, want)
+	}
+}
+
+func TestPostgresStorage_Rules(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	want := []rules.Rule{
+		{Platform: rules.PlatformAndroid, URL: "https://play.google.com/store/apps/details?id=pg"},
+		{Language: "de", Param: "ref", Value: "mail", URL: "https://example.com/pg-de"},
+	}
+	err := s.Save(ctx, URL{
+		ShortCode:   "pgrules001",
+		OriginalURL: "https://example.com/pg-app",
+		CreatedAt:   time.Now(),
+		Rules:       want,
+		Custom:      true,
+	})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	got, err := s.GetByCode(ctx, "", "pgrules001")
+	if err != nil {
+		t.Fatalf("GetByCode() error = %v", err)
+	}
+	if len(got.Rules) != 2 || got.Rules[0] != want[0] || got.Rules[1] != want[1] {
+		t.Errorf("Rules = %+v, want %+v", got.Rules, want)
+	}
+}
+
+func TestPostgresStorage_Passthrough(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	err := s.Save(ctx, URL{
+		ShortCode:    "pgforward1",
+		OriginalURL:  "https://example.com/pg-docs",
+		CreatedAt:    time.Now(),
+		ForwardQuery: "request",
+		ForwardPath:  true,
+		Custom:       true,
+	})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	got, err := s.GetByCode(ctx, "", "pgforward1")
+	if err != nil {
+		t.Fatalf("GetByCode() error = %v", err)
+	}
+	if got.ForwardQuery != "request" || !got.ForwardPath {
+		t.Errorf("GetByCode() = %+v, want forwarding options", got)
+	}
+}
+
+func TestPostgresStorage_UntaggedURL(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	err := s.Save(ctx, URL{
+		ShortCode:   "pgtagged01",
+		OriginalURL: "https://example.com/pg-post?utm_source=mail",
+		UntaggedURL: "https://example.com/pg-post",
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	got, err := s.GetByCode(ctx, "", "pgtagged01")
+	if err != nil {
+		t.Fatalf("GetByCode() error = %v", err)
+	}
+	if got.UntaggedURL != "https://example.com/pg-post" {
+		t.Errorf("UntaggedURL = %s", got.UntaggedURL)
+	}
+}
+
+func TestPostgresStorage_SetMetadata(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	err := s.Save(ctx, URL{
+		ShortCode:   "pgmeta0001",
+		OriginalURL: "https://example.com/pg-post",
+		CreatedAt:   time.Now(),
+		Metadata:    Metadata{Title: "Draft"},
+		Custom:      true,
+	})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	meta := Metadata{Title: "Post", Description: "About", ImageURL: "https://example.com/pg-cover.png"}
+	if err := s.SetMetadata(ctx, "", "pgmeta0001", meta); err != nil {
+		t.Fatalf("SetMetadata() error = %v", err)
+	}
+	got, err := s.GetByCode(ctx, "", "pgmeta0001")
+	if err != nil {
+		t.Fatalf("GetByCode() error = %v", err)
+	}
+	if got.Metadata != meta {
+		t.Errorf("Metadata = %+v, want %+v", got.Metadata, meta)
+	}
+}
+
+func TestPostgresStorage_SetLabels(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	err := s.Save(ctx, URL{
+		ShortCode:   "pglabel001",
+		OriginalURL: "https://example.com/pg-labels",
+		CreatedAt:   time.Now(),
+		Labels:      Labels{Tags: []string{"pg-draft"}},
+		Custom:      true,
+	})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	labels := Labels{Tags: []string{"pg-promo", "pg-q1"}, Note: "Spring campaign"}
+	if err := s.SetLabels(ctx, "", "pglabel001", labels); err != nil {
+		t.Fatalf("SetLabels() error = %v", err)
+	}
+	got, err := s.GetByCode(ctx, "", "pglabel001")
+	if err != nil {
+		t.Fatalf("GetByCode() error = %v", err)
+	}
+	if !slices.Equal(got.Tags, labels.Tags) || got.Note != labels.Note {
+		t.Errorf("Labels = %+v, want %+v", got.Labels, labels)
+	}
+
+	urls, err := s.List(ctx, ListQuery{Tags: []string{"pg-q1", "pg-promo"}})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(urls) != 1 || urls[0].ShortCode != "pglabel001" {
+		t.Errorf("List() = %v, want pglabel001", urls)
+	}
+	if urls, _ := s.List(ctx, ListQuery{Tags: []string{"pg-draft"}}); len(urls) != 0 {
+		t.Errorf("List(pg-draft) = %v, want none", urls)
+	}
+}
+
+func TestPostgresStorage_Workspaces(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	for _, u := range []URL{
+		{Workspace: "pg-team-a", ShortCode: "pgwsa00001", OriginalURL: "https://example.com/pg-workspace"},
+		{Workspace: "pg-team-b", ShortCode: "pgwsb00001", OriginalURL: "https://example.com/pg-workspace"},
+	} {
+		u.CreatedAt = time.Now()
+		if err := s.Save(ctx, u); err != nil {
+			t.Fatalf("Save(%s) error = %v", u.ShortCode, err)
+		}
+	}
+
+	got, err := s.GetByOriginalURL(ctx, "pg-team-b", "", "https://example.com/pg-workspace")
+	if err != nil || got.ShortCode != "pgwsb00001" || got.Workspace != "pg-team-b" {
+		t.Errorf("GetByOriginalURL() = %+v, %v", got, err)
+	}
+	if count, err := s.CountLinks(ctx, "pg-team-a"); err != nil || count != 1 {
+		t.Errorf("CountLinks() = %d, %v, want 1", count, err)
+	}
+	urls, _ := s.List(ctx, ListQuery{Workspace: "pg-team-a"})
+	if len(urls) != 1 || urls[0].ShortCode != "pgwsa00001" {
+		t.Errorf("List() = %v, want pgwsa00001", urls)
+	}
+}
+
+func TestPostgresStorage_Delete(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	err := s.Save(ctx, URL{
+		ShortCode:   "pgdelete01",
+		OriginalURL: "https://example.com/pg-delete",
+		CreatedAt:   time.Now(),
+		Targets:     []Target{{URL: "https://example.com/pg-delete", Weight: 1}},
+	})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	if err := s.Delete(ctx, "", "pgdelete01"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	got, err := s.GetByCode(ctx, "", "pgdelete01")
+	if err != ErrDeleted || got == nil || got.DeletedAt == nil || len(got.Targets) != 1 {
+		t.Fatalf("GetByCode() = %+v, %v, want record with %v", got, err, ErrDeleted)
+	}
+	if urls, _ := s.List(ctx, ListQuery{}); len(urls) != 0 {
+		t.Errorf("List() = %v, want none", urls)
+	}
+	if urls, _ := s.List(ctx, ListQuery{Status: StatusDeleted}); len(urls) != 1 {
+		t.Errorf("List(deleted) returned %d links, want 1", len(urls))
+	}
+	if count, _ := s.CountLinks(ctx, ""); count != 0 {
+		t.Errorf("CountLinks() = %d, want 0", count)
+	}
+	if err := s.Delete(ctx, "", "pgdelete01"); err != ErrNotFound {
+		t.Errorf("Delete() again error = %v, want %v", err, ErrNotFound)
+	}
+
+	if err := s.Restore(ctx, "", "pgdelete01"); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if got, err := s.GetByCode(ctx, "", "pgdelete01"); err != nil || got.DeletedAt != nil {
+		t.Errorf("GetByCode() after restore = %+v, %v", got, err)
+	}
+	if err := s.Restore(ctx, "", "pgdelete01"); err != ErrNotFound {
+		t.Errorf("Restore() of active link error = %v, want %v", err, ErrNotFound)
+	}
+
+	s.Delete(ctx, "", "pgdelete01")
+	if n, err := s.Purge(ctx, time.Now().Add(time.Second), 10); err != nil || n != 1 {
+		t.Fatalf("Purge() = %d, %v, want 1", n, err)
+	}
+	// Код остается закрытым и после окончательного удаления
+	if got, err := s.GetByCode(ctx, "", "pgdelete01"); got != nil || err != ErrDeleted {
+		t.Errorf("GetByCode() after purge = %+v, %v, want nil, %v", got, err, ErrDeleted)
+	}
+	err = s.Save(ctx, URL{ShortCode: "pgdelete01", OriginalURL: "https://example.com/pg-delete", CreatedAt: time.Now()})
+	if err != ErrAlreadyExists {
+		t.Errorf("Save() after purge error = %v, want %v", err, ErrAlreadyExists)
+	}
+
+	tombstones, err := s.Tombstones(ctx, TombstoneQuery{All: true})
+	if err != nil || len(tombstones) != 1 || tombstones[0].ShortCode != "pgdelete01" {
+		t.Fatalf("Tombstones() = %+v, %v", tombstones, err)
+	}
+	if err := s.SaveTombstone(ctx, tombstones[0]); err != nil {
+		t.Errorf("SaveTombstone() of closed code error = %v", err)
+	}
+	if err := s.SaveTombstone(ctx, Tombstone{ShortCode: "pgdelete02", DeletedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveTombstone() error = %v", err)
+	}
+	if _, err := s.GetByCode(ctx, "", "pgdelete02"); err != ErrDeleted {
+		t.Errorf("GetByCode() of saved tombstone error = %v, want %v", err, ErrDeleted)
+	}
+	if next, _ := s.Tombstones(ctx, TombstoneQuery{After: &tombstones[0]}); len(next) != 1 || next[0].ShortCode != "pgdelete02" {
+		t.Errorf("Tombstones(after) = %+v", next)
+	}
+}
+
+func TestPostgresStorage_Audit(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+	audited := func(action string, after string) context.Context {
+		return WithAudit(ctx, AuditEvent{
+			Workspace: "pg-audit",
+			Action:    action,
+			Actor:     "ci",
+			RequestID: "req-1",
+			At:        time.Now(),
+			After:     []byte(after),
+		})
+	}
+
+	u := URL{Workspace: "pg-audit", ShortCode: "pgaudit001", OriginalURL: "https://example.com/pg-audit", CreatedAt: time.Now()}
+	if err := s.Save(audited("create", `{"original_url": "https://example.com/pg-audit"}`), u); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := s.SetMetadata(audited("update", `{"title": "Audit"}`), "", u.ShortCode, Metadata{Title: "Audit"}); err != nil {
+		t.Fatalf("SetMetadata() error = %v", err)
+	}
+	if err := s.SetMetadata(audited("update", `{}`), "", "nonexist12", Metadata{}); err != ErrNotFound {
+		t.Errorf("SetMetadata() error = %v, want %v", err, ErrNotFound)
+	}
+
+	events, err := s.ListAudit(ctx, AuditQuery{Workspace: "pg-audit", ShortCode: u.ShortCode})
+	if err != nil {
+		t.Fatalf("ListAudit() error = %v", err)
+	}
+	if len(events) != 2 || events[0].Action != "update" || events[1].Action != "create" {
+		t.Fatalf("ListAudit() = %+v", events)
+	}
+	if events[0].RequestID != "req-1" || events[0].Actor != "ci" || events[0].Before != nil ||
+		!strings.Contains(string(events[0].After), "Audit") {
+		t.Errorf("event = %+v", events[0])
+	}
+
+	// Перенесенное событие сохраняет ID, а новые события получают следующие
+	all, err := s.IterateAudit(ctx, events[1].ID-1, 2)
+	if err != nil || len(all) != 2 || all[0].ID != events[1].ID {
+		t.Fatalf("IterateAudit() = %+v, %v", all, err)
+	}
+	moved := all[1]
+	moved.ID += 1000
+	if err := s.SaveAudit(ctx, moved); err != nil {
+		t.Fatalf("SaveAudit() error = %v", err)
+	}
+	if err := s.SaveAudit(ctx, moved); err != nil {
+		t.Errorf("SaveAudit(same event) error = %v", err)
+	}
+	moved.Action = "restore"
+	if err := s.SaveAudit(ctx, moved); err != ErrAlreadyExists {
+		t.Errorf("SaveAudit(other event) error = %v, want %v", err, ErrAlreadyExists)
+	}
+	if err := s.SetMetadata(audited("update", `{}`), "", u.ShortCode, Metadata{}); err != nil {
+		t.Fatalf("SetMetadata() error = %v", err)
+	}
+	latest, _ := s.ListAudit(ctx, AuditQuery{Workspace: "pg-audit", Limit: 1})
+	if len(latest) != 1 || latest[0].ID <= moved.ID {
+		t.Errorf("ListAudit() newest = %+v, want ID after %d", latest, moved.ID)
+	}
+}
+
+func TestPostgresStorage_List(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+	for i, u := range []URL{
+		{ShortCode: "pglist0001", OriginalURL: "https://example.com/pg-a", CreatedBy: "alice"},
+		{ShortCode: "pglist0002", OriginalURL: "https://docs.example.com/pg_b", CreatedBy: "bob"},
+		{ShortCode: "pglist0003", OriginalURL: "https://user@Example.com:8443/pg-c", CreatedBy: "alice"},
+	} {
+		u.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		if err := s.Save(ctx, u); err != nil {
+			t.Fatalf("Save(%s) error = %v", u.ShortCode, err)
+		}
+	}
+
+	page, err := s.List(ctx, ListQuery{Descending: true, Limit: 2})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(page) != 2 || page[0].ShortCode != "pglist0003" || page[1].ShortCode != "pglist0002" {
+		t.Fatalf("List() first page = %v", page)
+	}
+	c := CursorOf(page[1])
+	page, err = s.List(ctx, ListQuery{Descending: true, Limit: 2, After: &c})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(page) != 1 || page[0].ShortCode != "pglist0001" {
+		t.Errorf("List() second page = %v", page)
+	}
+
+	got, err := s.List(ctx, ListQuery{CreatedBy: "alice", DestHost: "EXAMPLE.COM"})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(got) != 2 || got[0].CreatedBy != "alice" {
+		t.Errorf("List() by creator and host = %v", got)
+	}
+
+	// Спецсимволы LIKE ищутся как обычные символы
+	got, err = s.List(ctx, ListQuery{Contains: "PG_B"})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(got) != 1 || got[0].ShortCode != "pglist0002" {
+		t.Errorf("List() contains = %v", got)
+	}
+}
+
+func TestPostgresStorage_Iterate(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	base := time.Now().Truncate(time.Microsecond)
+	for i, u := range []URL{
+		{Domain: "go.example", ShortCode: "pgiter0002", OriginalURL: "https://example.com/pg-iter2"},
+		{ShortCode: "pgiter0001", OriginalURL: "https://example.com/pg-iter1"},
+		{ShortCode: "pgiter0003", OriginalURL: "https://example.com/pg-iter3"},
+	} {
+		u.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		if err := s.Save(ctx, u); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	first, err := s.Iterate(ctx, nil, 2)
+	if err != nil {
+		t.Fatalf("Iterate() error = %v", err)
+	}
+	if len(first) != 2 || first[0].ShortCode != "pgiter0001" || first[1].ShortCode != "pgiter0003" {
+		t.Fatalf("Iterate() first batch = %v", first)
+	}
+	p := PositionOf(first[1])
+	rest, err := s.Iterate(ctx, &p, 2)
+	if err != nil {
+		t.Fatalf("Iterate() error = %v", err)
+	}
+	if len(rest) != 1 || rest[0].ShortCode != "pgiter0002" {
+		t.Errorf("Iterate() second batch = %v", rest)
+	}
+}
+
+func TestPostgresStorage_Revisions(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	u := URL{ShortCode: "pgrevisio1", OriginalURL: "https://example.com/pg-v1", CreatedAt: time.Now()}
+	if err := s.Save(ctx, u); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := s.SetDestination(ctx, "", u.ShortCode, Destination{OriginalURL: "https://example.com/pg-v2"}); err != nil {
+		t.Fatalf("SetDestination() error = %v", err)
+	}
+	if _, err := s.GetByOriginalURL(ctx, "", "", u.OriginalURL); err != ErrNotFound {
+		t.Errorf("GetByOriginalURL() error = %v, want %v", err, ErrNotFound)
+	}
+
+	at := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	if err := s.ScheduleSwitch(ctx, "", u.ShortCode, "https://example.com/pg-v3", &at); err != nil {
+		t.Fatalf("ScheduleSwitch() error = %v", err)
+	}
+	got, _ := s.GetByCode(ctx, "", u.ShortCode)
+	if got.NextURL != "https://example.com/pg-v3" || got.SwitchAt == nil || !got.SwitchAt.Equal(at) {
+		t.Errorf("GetByCode() = %+v, want scheduled switch", got)
+	}
+	if err := s.ApplySwitch(ctx, "", u.ShortCode, time.Now()); err != ErrNotFound {
+		t.Errorf("ApplySwitch() before switch error = %v, want %v", err, ErrNotFound)
+	}
+	later := at.Add(time.Minute)
+	if due, _ := s.DueSwitches(ctx, later, 10); len(due) != 1 || due[0].ShortCode != u.ShortCode {
+		t.Errorf("DueSwitches() = %+v", due)
+	}
+	if err := s.ApplySwitch(ctx, "", u.ShortCode, later); err != nil {
+		t.Fatalf("ApplySwitch() error = %v", err)
+	}
+	got, _ = s.GetByCode(ctx, "", u.ShortCode)
+	if got.OriginalURL != "https://example.com/pg-v3" || got.NextURL != "" || got.SwitchAt != nil || !got.Custom {
+		t.Errorf("GetByCode() after switch = %+v", got)
+	}
+
+	revisions, err := s.ListRevisions(ctx, "", u.ShortCode)
+	if err != nil {
+		t.Fatalf("ListRevisions() error = %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].OriginalURL != "https://example.com/pg-v1" ||
+		revisions[1].Number != 2 || revisions[1].OriginalURL != "https://example.com/pg-v2" {
+		t.Errorf("ListRevisions() = %+v", revisions)
+	}
+
+	all, err := s.IterateRevisions(ctx, &LinkRevision{ShortCode: u.ShortCode}, 0)
+	if err != nil || len(all) < 2 || all[0].ShortCode != u.ShortCode || all[1].Number != 2 {
+		t.Fatalf("IterateRevisions() = %+v, %v", all, err)
+	}
+	moved := all[0]
+	moved.OriginalURL = "https://example.com/pg-other"
+	if err := s.SaveRevision(ctx, moved); err != nil {
+		t.Errorf("SaveRevision(existing) error = %v", err)
+	}
+	if revisions, _ := s.ListRevisions(ctx, "", u.ShortCode); revisions[0].OriginalURL != "https://example.com/pg-v1" {
+		t.Errorf("ListRevisions() after SaveRevision = %+v", revisions)
+	}
+	moved.ShortCode = "nonexist12"
+	if err := s.SaveRevision(ctx, moved); err != ErrNotFound {
+		t.Errorf("SaveRevision(missing link) error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestPostgresStorage_UpdateLink(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	u := URL{ShortCode: "pgupdate01", OriginalURL: "https://example.com/pg-v1", CreatedAt: time.Now()}
+	if err := s.Save(ctx, u); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	audited := WithAudit(ctx, AuditEvent{Action: "update", At: time.Now()})
+
+	err := s.UpdateLink(audited, "", u.ShortCode, LinkUpdate{
+		Destination: &Destination{OriginalURL: "https://example.com/pg-v2"},
+		Weights:     []int{1},
+	})
+	if err != ErrNotFound {
+		t.Fatalf("UpdateLink() with weights error = %v, want %v", err, ErrNotFound)
+	}
+	if revisions, _ := s.ListRevisions(ctx, "", u.ShortCode); len(revisions) != 0 {
+		t.Errorf("ListRevisions() after rejected update = %+v, want none", revisions)
+	}
+
+	at := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	err = s.UpdateLink(audited, "", u.ShortCode, LinkUpdate{
+		Destination: &Destination{OriginalURL: "https://example.com/pg-v2"},
+		Schedule:    true,
+		NextURL:     "https://example.com/pg-v3",
+		SwitchAt:    &at,
+		Metadata:    &Metadata{Title: "New"},
+		Labels:      &Labels{Tags: []string{"promo"}, Note: "launch"},
+	})
+	if err != nil {
+		t.Fatalf("UpdateLink() error = %v", err)
+	}
+	got, _ := s.GetByCode(ctx, "", u.ShortCode)
+	if got.OriginalURL != "https://example.com/pg-v2" || got.NextURL != "https://example.com/pg-v3" ||
+		got.SwitchAt == nil || !got.SwitchAt.Equal(at) || got.Title != "New" || got.Note != "launch" {
+		t.Errorf("GetByCode() = %+v", got)
+	}
+	if revisions, _ := s.ListRevisions(ctx, "", u.ShortCode); len(revisions) != 1 || revisions[0].OriginalURL != u.OriginalURL {
+		t.Errorf("ListRevisions() = %+v", revisions)
+	}
+	if events, _ := s.ListAudit(ctx, AuditQuery{Domain: "", ShortCode: u.ShortCode}); len(events) != 1 {
+		t.Errorf("ListAudit() = %d events, want 1", len(events))
+	}
+}
+
+func TestPostgresStorage_Webhooks(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	all, err := s.CreateWebhook(ctx, Webhook{Workspace: "pg-hooks", URL: "https://crm.example/all", Secret: "s1", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("CreateWebhook() error = %v", err)
+	}
+	clicks, err := s.CreateWebhook(ctx, Webhook{Workspace: "pg-hooks", URL: "https://crm.example/clicks", Secret: "s2",
+		Events: []string{EventLinkClicked}, CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("CreateWebhook() error = %v", err)
+	}
+	if w, err := s.GetWebhook(ctx, clicks); err != nil || w.Secret != "s2" || !slices.Equal(w.Events, []string{EventLinkClicked}) {
+		t.Errorf("GetWebhook() = %+v, error = %v", w, err)
+	}
+
+	// Доставка ставится в очередь в транзакции изменения
+	past := time.Now().Add(-time.Minute)
+	u := URL{Workspace: "pg-hooks", ShortCode: "pghook0001", OriginalURL: "https://example.com/pg-hook",
+		CreatedAt: time.Now(), ExpiresAt: &past}
+	created := WithAudit(ctx, AuditEvent{Workspace: "pg-hooks", Action: "create", Actor: "ci", At: time.Now()})
+	if err := s.Save(created, u); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	due, err := s.DueExpirations(ctx, time.Now(), 10)
+	if err != nil || len(due) != 1 || due[0].ShortCode != u.ShortCode {
+		t.Fatalf("DueExpirations() = %+v, error = %v", due, err)
+	}
+	expired := WithAudit(ctx, AuditEvent{Workspace: "pg-hooks", Action: "expire", At: time.Now()})
+	if err := s.MarkExpired(expired, "", u.ShortCode, time.Now()); err != nil {
+		t.Fatalf("MarkExpired() error = %v", err)
+	}
+	if err := s.MarkExpired(expired, "", u.ShortCode, time.Now()); err != ErrNotFound {
+		t.Errorf("MarkExpired() again error = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.Publish(ctx, Event{Type: EventLinkClicked, Workspace: "pg-hooks", ShortCode: u.ShortCode}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	deliveries, err := s.ListDeliveries(ctx, DeliveryQuery{Workspace: "pg-hooks"})
+	if err != nil {
+		t.Fatalf("ListDeliveries() error = %v", err)
+	}
+	var got []string
+	for _, d := range deliveries {
+		got = append(got, d.Event)
+	}
+	want := []string{EventLinkClicked, EventLinkClicked, EventLinkExpired, EventLinkCreated}
+	if !slices.Equal(got, want) {
+		t.Fatalf("ListDeliveries() = %v, want %v", got, want)
+	}
+
+	now := time.Now()
+	claimed, err := s.ClaimDeliveries(ctx, now, time.Minute, 10)
+	if err != nil || len(claimed) != 4 {
+		t.Fatalf("ClaimDeliveries() = %+v, error = %v", claimed, err)
+	}
+	if again, _ := s.ClaimDeliveries(ctx, now, time.Minute, 10); len(again) != 0 {
+		t.Errorf("ClaimDeliveries() again = %+v, want none", again)
+	}
+	d := claimed[0]
+	d.Status, d.Attempts, d.LastStatus, d.LastError, d.UpdatedAt = DeliveryDead, 3, 502, "bad gateway", now
+	if err := s.UpdateDelivery(ctx, d); err != nil {
+		t.Fatalf("UpdateDelivery() error = %v", err)
+	}
+	dead, _ := s.ListDeliveries(ctx, DeliveryQuery{Workspace: "pg-hooks", Status: DeliveryDead})
+	if len(dead) != 1 || dead[0].ID != d.ID || dead[0].LastStatus != 502 {
+		t.Fatalf("ListDeliveries(dead) = %+v", dead)
+	}
+	if err := s.RetryDelivery(ctx, "pg-hooks", d.ID, now); err != nil {
+		t.Fatalf("RetryDelivery() error = %v", err)
+	}
+	if retried, _ := s.ClaimDeliveries(ctx, now, time.Minute, 10); len(retried) != 1 || retried[0].Attempts != 0 {
+		t.Errorf("ClaimDeliveries() after retry = %+v", retried)
+	}
+
+	if err := s.DeleteWebhook(ctx, "pg-hooks", all); err != nil {
+		t.Fatalf("DeleteWebhook() error = %v", err)
+	}
+	if rest, _ := s.ListDeliveries(ctx, DeliveryQuery{Workspace: "pg-hooks"}); len(rest) != 1 || rest[0].WebhookID != clicks {
+		t.Errorf("ListDeliveries() after delete = %+v", rest)
+	}
+}
+
+func TestPostgresStorage_DeliveryRetention(t *testing.T) {
+	s := skipIfNoDatabase(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	if err := s.Publish(ctx, Event{Type: EventLinkClicked, Workspace: "pg-retain", ShortCode: "pgretain01"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if workspaces, err := s.SubscribedWorkspaces(ctx, EventLinkClicked); err != nil || len(workspaces) != 0 {
+		t.Fatalf("SubscribedWorkspaces() without webhooks = %v, %v", workspaces, err)
+	}
+
+	s.CreateWebhook(ctx, Webhook{Workspace: "pg-retain", URL: "https://crm.example/all", Secret: "s1", CreatedAt: time.Now()})
+	s.CreateWebhook(ctx, Webhook{Workspace: "pg-other", URL: "https://other.example/", Secret: "s2",
+		Events: []string{EventLinkCreated}, CreatedAt: time.Now()})
+	workspaces, err := s.SubscribedWorkspaces(ctx, EventLinkClicked)
+	if err != nil || !slices.Equal(workspaces, []string{"pg-retain"}) {
+		t.Fatalf("SubscribedWorkspaces(clicked) = %v, %v", workspaces, err)
+	}
+
+	for range 2 {
+		s.Publish(ctx, Event{Type: EventLinkClicked, Workspace: "pg-retain", ShortCode: "pgretain01"})
+	}
+	now := time.Now()
+	claimed, _ := s.ClaimDeliveries(ctx, now, time.Minute, 0)
+	if len(claimed) != 2 {
+		t.Fatalf("ClaimDeliveries() = %+v, want 2", claimed)
+	}
+	for i, status := range []string{DeliveryDelivered, DeliveryDead} {
+		d := claimed[i]
+		d.Status, d.UpdatedAt = status, now.Add(-2*time.Hour)
+		if err := s.UpdateDelivery(ctx, d); err != nil {
+			t.Fatalf("UpdateDelivery() error = %v", err)
+		}
+	}
+
+	purged, err := s.PurgeDeliveries(ctx, now.Add(-time.Hour), 0)
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeDeliveries() = %d, %v, want 1", purged, err)
+	}
+	rest, _ := s.ListDeliveries(ctx, DeliveryQuery{Workspace: "pg-retain"})
+	if len(rest) != 1 || rest[0].Status != DeliveryDead {
+		t.Errorf("ListDeliveries() after purge = %+v", rest)
 	}
 }
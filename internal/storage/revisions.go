@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"cmp"
+	"time"
+)
+
+// Destination - адрес назначения ссылки
+type Destination struct {
+	OriginalURL string
+	UntaggedURL string // Адрес до добавления UTM-меток; пусто, если меток нет
+}
+
+// Revision - прежний адрес назначения ссылки. Ревизии нумеруются с 1
+// в порядке замены адреса и не удаляются, пока существует ссылка
+type Revision struct {
+	Number int
+	Destination
+	ReplacedAt time.Time // Когда адрес перестал быть текущим
+}
+
+// LinkRevision - ревизия вместе с кодом ссылки. Ревизии всех ссылок
+// выбираются для переноса в порядке (домен, код, номер)
+type LinkRevision struct {
+	Domain    string
+	ShortCode string
+	Revision
+}
+
+// compareRevisions сравнивает ревизии в порядке (домен, код, номер)
+func compareRevisions(a, b LinkRevision) int {
+	return cmp.Or(cmp.Compare(a.Domain, b.Domain), cmp.Compare(a.ShortCode, b.ShortCode), cmp.Compare(a.Number, b.Number))
+}
+
+// switchDue сообщает, что запланированная смена адреса наступила к моменту now
+func (u *URL) switchDue(now time.Time) bool {
+	return u.NextURL != "" && u.SwitchAt != nil && !u.SwitchAt.After(now) && u.DeletedAt == nil
+}
@@ -2,8 +2,11 @@
 package storage
 
 import (
+	"context"
 	"errors"
 	"time"
+
+	"github.com/BuzzLyutic/url-shortener/internal/rules"
 )
 
 // Кастомные ошибки для реализаций хранилищ
@@ -11,14 +14,106 @@ var (
 	ErrNotFound      = errors.New("url not found")
 	ErrAlreadyExists = errors.New("short code already exists")
 	ErrExpired       = errors.New("url has expired")
+	ErrExhausted     = errors.New("url click limit reached")
+	ErrNotYetActive  = errors.New("url is not active yet")
+	ErrDeleted       = errors.New("url has been deleted")
 )
 
 // URL представляет собой сохраненное отображение URL
 type URL struct {
+	Workspace   string // Рабочее пространство владельца; пустая строка - пространство по умолчанию
+	Domain      string // Короткий домен; пустая строка означает основной домен
 	ShortCode   string
 	OriginalURL string
+	UntaggedURL string // Адрес до добавления UTM-меток для поиска; пусто, если меток нет
 	CreatedAt   time.Time
+	CreatedBy   string     // Автор ссылки; пусто, если не указан
 	ExpiresAt   *time.Time // nil означает отсутствие срока истечения
+	// ExpiryNotified отмечает, что истечение срока записано в журнал
+	ExpiryNotified bool
+	NotBefore      *time.Time // Начало действия ссылки; nil - действует сразу
+
+	// Резервные адреса: до начала действия и после истечения срока.
+	// Пустая строка означает, что резервного адреса нет
+	PendingURL string
+	ExpiredURL string
+
+	// Запланированная смена адреса: с момента SwitchAt адресом назначения
+	// становится NextURL. Пусто - смена не запланирована
+	NextURL  string
+	SwitchAt *time.Time
+
+	// Targets - варианты адреса для A/B ротации; пусто, если адрес один (OriginalURL)
+	Targets []Target
+	// Sticky закрепляет выбранный вариант за посетителем
+	Sticky bool
+
+	// Rules - правила выбора адреса по платформе, языку и параметрам запроса
+	Rules []rules.Rule
+
+	// ForwardQuery - режим передачи параметров запроса в адрес назначения; пусто - не передавать
+	ForwardQuery string
+	// ForwardPath дописывает к адресу назначения путь после короткого кода
+	ForwardPath bool
+
+	// Metadata - описание ссылки для превью в соцсетях и мессенджерах
+	Metadata
+	// Labels - метки и заметка для упорядочивания ссылок
+	Labels
+
+	// DeletedAt - момент удаления; nil - ссылка не удалена. Удаленная ссылка
+	// хранится до окончательного удаления, ее код не выдается повторно никогда
+	DeletedAt *time.Time
+
+	// ClicksLeft - оставшееся число переходов; nil означает отсутствие лимита
+	ClicksLeft *int
+
+	// PasswordHash - соленый хэш пароля ссылки; пусто, если пароля нет
+	PasswordHash string
+	// Custom отмечает ссылку с индивидуальными настройками. Такие ссылки
+	// не участвуют в дедупликации и не находятся через GetByOriginalURL.
+	// Обычные ссылки дедуплицируются в пределах рабочего пространства и домена
+	Custom bool
+}
+
+// Target - вариант адреса назначения ссылки с ротацией
+type Target struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"` // Доля трафика относительно суммы весов; 0 - вариант выключен
+	Clicks int64  `json:"clicks"` // Число переходов на вариант
+}
+
+// Metadata - заголовок, описание и картинка ссылки для OpenGraph-разметки
+type Metadata struct {
+	Title       string
+	Description string
+	ImageURL    string
+}
+
+// IsZero сообщает, что описание ссылки не задано
+func (m Metadata) IsZero() bool {
+	return m == Metadata{}
+}
+
+// Labels - метки и заметка ссылки. На переход не влияют
+type Labels struct {
+	Tags []string // Метки в нижнем регистре, без повторов
+	Note string
+}
+
+// LinkUpdate - изменение нескольких полей ссылки, применяемое одной записью
+// с одним событием журнала. nil - поле не меняется
+type LinkUpdate struct {
+	Destination *Destination // Новый адрес; прежний сохраняется как ревизия
+
+	// Заменить запланированную смену адреса; пустой NextURL отменяет ее
+	Schedule bool
+	NextURL  string
+	SwitchAt *time.Time
+
+	Weights  []int // Новые веса вариантов; число должно совпадать с числом вариантов
+	Metadata *Metadata
+	Labels   *Labels
 }
 
 // IsExpired проверяет, истек ли срок жизни URL
@@ -29,10 +124,61 @@ func (u *URL) IsExpired() bool {
 	return time.Now().After(*u.ExpiresAt)
 }
 
-// Storage определяет интерфейс хранилища URL
+// IsPending проверяет, что срок действия URL еще не начался
+func (u *URL) IsPending() bool {
+	if u.NotBefore == nil {
+		return false
+	}
+	return time.Now().Before(*u.NotBefore)
+}
+
+// Storage определяет интерфейс хранилища URL.
+// GetByCode при ErrExpired и ErrNotYetActive возвращает вместе с ошибкой
+// и саму запись, чтобы по ней можно было выполнить переход на резервный адрес.
+// Для удаленной ссылки GetByCode возвращает ErrDeleted вместе с записью,
+// а после окончательного удаления - ErrDeleted без записи.
+// Вместе с событием журнала хранилище ставит в очередь доставки вебхуков
 type Storage interface {
-	Save(url URL) error                                // Save хранит новое отображение URL.
-	GetByCode(code string) (*URL, error)               // GetByCode возвращает URL по короткому коду.
-	GetByOriginalURL(originalURL string) (*URL, error) // GetByOriginalURL возвращает новый URL по оригинальной ссылке.
-	Close() error                                      // Close закрывает хранилище и освобождает ресурсы.
+	Save(ctx context.Context, url URL) error                                                                // Save хранит новое отображение URL.
+	Replace(ctx context.Context, url URL) error                                                             // Replace сохраняет URL, заменяя запись с тем же кодом.
+	GetByCode(ctx context.Context, domain, code string) (*URL, error)                                       // GetByCode возвращает URL по домену и короткому коду.
+	GetByOriginalURL(ctx context.Context, workspace, domain, originalURL string) (*URL, error)              // GetByOriginalURL возвращает URL пространства и домена по оригинальной ссылке.
+	ConsumeClick(ctx context.Context, domain, code string) error                                            // ConsumeClick атомарно списывает переход у ссылки с лимитом.
+	RecordClick(ctx context.Context, domain, code string, variant int) error                                // RecordClick учитывает переход на вариант ссылки.
+	SetWeights(ctx context.Context, domain, code string, weights []int) error                               // SetWeights меняет веса вариантов ссылки.
+	SetMetadata(ctx context.Context, domain, code string, meta Metadata) error                              // SetMetadata заменяет описание ссылки.
+	SetLabels(ctx context.Context, domain, code string, labels Labels) error                                // SetLabels заменяет метки и заметку ссылки.
+	UpdateLink(ctx context.Context, domain, code string, upd LinkUpdate) error                              // UpdateLink применяет изменение нескольких полей ссылки одной записью.
+	Delete(ctx context.Context, domain, code string) error                                                  // Delete помечает ссылку удаленной и закрывает ее код навсегда.
+	Restore(ctx context.Context, domain, code string) error                                                 // Restore снимает с ссылки пометку об удалении.
+	Purge(ctx context.Context, before time.Time, limit int) (int, error)                                    // Purge окончательно удаляет ссылки, удаленные до before.
+	SetDestination(ctx context.Context, domain, code string, dest Destination) error                        // SetDestination меняет адрес назначения, сохраняя прежний как ревизию.
+	ListRevisions(ctx context.Context, domain, code string) ([]Revision, error)                             // ListRevisions возвращает прежние адреса ссылки по возрастанию номера.
+	IterateRevisions(ctx context.Context, after *LinkRevision, limit int) ([]LinkRevision, error)           // IterateRevisions возвращает следующие ревизии всех ссылок.
+	SaveRevision(ctx context.Context, r LinkRevision) error                                                 // SaveRevision сохраняет перенесенную ревизию ссылки.
+	ScheduleSwitch(ctx context.Context, domain, code, nextURL string, at *time.Time) error                  // ScheduleSwitch планирует смену адреса; пустой nextURL отменяет ее.
+	DueSwitches(ctx context.Context, now time.Time, limit int) ([]*URL, error)                              // DueSwitches возвращает ссылки всех доменов с наступившей сменой адреса.
+	ApplySwitch(ctx context.Context, domain, code string, now time.Time) error                              // ApplySwitch применяет наступившую смену адреса ссылки.
+	DueExpirations(ctx context.Context, now time.Time, limit int) ([]*URL, error)                           // DueExpirations возвращает истекшие ссылки без записанного истечения.
+	MarkExpired(ctx context.Context, domain, code string, now time.Time) error                              // MarkExpired записывает истечение срока ссылки.
+	ListAudit(ctx context.Context, q AuditQuery) ([]AuditEvent, error)                                      // ListAudit возвращает события журнала изменений.
+	IterateAudit(ctx context.Context, afterID int64, limit int) ([]AuditEvent, error)                       // IterateAudit возвращает события всех пространств по возрастанию ID.
+	SaveAudit(ctx context.Context, e AuditEvent) error                                                      // SaveAudit сохраняет перенесенное событие журнала с его ID.
+	CreateWebhook(ctx context.Context, w Webhook) (int64, error)                                            // CreateWebhook сохраняет подписку и возвращает ее ID.
+	GetWebhook(ctx context.Context, id int64) (*Webhook, error)                                             // GetWebhook возвращает подписку по ID.
+	ListWebhooks(ctx context.Context, workspace string) ([]Webhook, error)                                  // ListWebhooks возвращает подписки пространства.
+	DeleteWebhook(ctx context.Context, workspace string, id int64) error                                    // DeleteWebhook удаляет подписку вместе с ее доставками.
+	Publish(ctx context.Context, e Event) error                                                             // Publish ставит событие в очередь доставки подписчикам.
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Delivery, error) // ClaimDeliveries резервирует доставки, время которых наступило.
+	UpdateDelivery(ctx context.Context, d Delivery) error                                                   // UpdateDelivery сохраняет итог попытки доставки.
+	RetryDelivery(ctx context.Context, workspace string, id int64, now time.Time) error                     // RetryDelivery возвращает доставку в очередь.
+	ListDeliveries(ctx context.Context, q DeliveryQuery) ([]Delivery, error)                                // ListDeliveries возвращает журнал доставок.
+	PurgeDeliveries(ctx context.Context, before time.Time, limit int) (int, error)                          // PurgeDeliveries удаляет доставки, отправленные до before.
+	SubscribedWorkspaces(ctx context.Context, event string) ([]string, error)                               // SubscribedWorkspaces возвращает пространства с подпиской на событие.
+	List(ctx context.Context, q ListQuery) ([]*URL, error)                                                  // List возвращает ссылки домена по фильтрам, упорядоченные по CreatedAt.
+	CountLinks(ctx context.Context, workspace string) (int, error)                                          // CountLinks возвращает число ссылок рабочего пространства.
+	Iterate(ctx context.Context, after *Position, limit int) ([]*URL, error)                                // Iterate возвращает следующие записи всех доменов в порядке Position.
+	Tombstones(ctx context.Context, q TombstoneQuery) ([]Tombstone, error)                                  // Tombstones возвращает закрытые коды окончательно удаленных ссылок.
+	SaveTombstone(ctx context.Context, t Tombstone) error                                                   // SaveTombstone закрывает код окончательно удаленной ссылки.
+	Close() error                                                                                           // Close закрывает хранилище и освобождает ресурсы.
 }
@@ -0,0 +1,39 @@
+package storage
+
+import "time"
+
+// Tombstone - закрытый код окончательно удаленной ссылки. Код не выдается
+// повторно, даже когда самой записи ссылки уже нет
+type Tombstone struct {
+	Workspace string
+	Domain    string
+	ShortCode string
+	DeletedAt time.Time
+}
+
+// TombstoneQuery - выборка закрытых кодов в порядке (домен, код). Выбираются
+// только коды окончательно удаленных ссылок: удаленная, но хранящаяся ссылка
+// переносится вместе со своей записью
+type TombstoneQuery struct {
+	All       bool // Коды всех пространств и доменов; иначе только Workspace и Domain
+	Workspace string
+	Domain    string
+	After     *Tombstone // Продолжить после этого кода; nil - с начала
+	Limit     int
+}
+
+// matches проверяет код по пространству, домену и позиции After
+func (q *TombstoneQuery) matches(t *Tombstone) bool {
+	if !q.All && (t.Workspace != q.Workspace || t.Domain != q.Domain) {
+		return false
+	}
+	return q.After == nil || tombstoneBefore(q.After, t)
+}
+
+// tombstoneBefore сообщает, что код a идет раньше b в порядке (домен, код)
+func tombstoneBefore(a, b *Tombstone) bool {
+	if a.Domain != b.Domain {
+		return a.Domain < b.Domain
+	}
+	return a.ShortCode < b.ShortCode
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/BuzzLyutic/url-shortener/internal/storage"
+
+// startSpan открывает span для операции хранилища
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// finishSpan закрывает span и отмечает в нем неожиданные ошибки.
+// Ожидаемые ошибки хранилища (не найдено, истек срок и т.п.) ошибкой span не считаются
+func finishSpan(span trace.Span, err error) {
+	if err != nil {
+		if isExpectedError(err) {
+			span.SetAttributes(attribute.String("storage.result", err.Error()))
+		} else {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	span.End()
+}
+
+func isExpectedError(err error) bool {
+	return errors.Is(err, ErrNotFound) ||
+		errors.Is(err, ErrAlreadyExists) ||
+		errors.Is(err, ErrExpired) ||
+		errors.Is(err, ErrExhausted) ||
+		errors.Is(err, ErrNotYetActive) ||
+		errors.Is(err, ErrDeleted)
+}
+
+// Атрибуты span для конкретных бэкендов
+func memoryAttrs() []attribute.KeyValue {
+	return []attribute.KeyValue{attribute.String("db.system", "memory")}
+}
+
+func postgresAttrs(operation string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation.name", operation),
+	}
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// Типы событий вебхуков
+const (
+	EventLinkCreated  = "link.created"
+	EventLinkUpdated  = "link.updated"
+	EventLinkDeleted  = "link.deleted"
+	EventLinkRestored = "link.restored"
+	EventLinkExpired  = "link.expired"
+	EventLinkClicked  = "link.clicked"
+)
+
+// auditEvents - тип события вебхука для действия журнала
+var auditEvents = map[string]string{
+	"create":  EventLinkCreated,
+	"update":  EventLinkUpdated,
+	"delete":  EventLinkDeleted,
+	"restore": EventLinkRestored,
+	"expire":  EventLinkExpired,
+}
+
+// Состояния доставки вебхука
+const (
+	DeliveryPending   = "pending"   // Ждет отправки или повтора
+	DeliveryDelivered = "delivered" // Получатель ответил 2xx
+	DeliveryDead      = "dead"      // Попытки исчерпаны
+)
+
+// Webhook - подписка рабочего пространства на события ссылок
+type Webhook struct {
+	ID        int64
+	Workspace string
+	URL       string
+	Secret    string   // Ключ подписи HMAC
+	Events    []string // Типы событий; пусто - все события
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// accepts сообщает, что подписка получает событие
+func (w *Webhook) accepts(e *Event) bool {
+	return w.Workspace == e.Workspace && (len(w.Events) == 0 || slices.Contains(w.Events, e.Type))
+}
+
+// Event - событие ссылки для вебхуков. Сериализуется в тело запроса к получателю
+type Event struct {
+	Type        string          `json:"event"`
+	Workspace   string          `json:"workspace,omitempty"`
+	Domain      string          `json:"domain,omitempty"`
+	ShortCode   string          `json:"short_code"`
+	Actor       string          `json:"actor,omitempty"`
+	RequestID   string          `json:"request_id,omitempty"`
+	At          time.Time       `json:"at"`
+	Before      json.RawMessage `json:"before,omitempty"`
+	After       json.RawMessage `json:"after,omitempty"`
+	Destination string          `json:"destination,omitempty"` // Адрес перехода для link.clicked
+}
+
+// eventOf возвращает событие вебхука для события журнала
+func eventOf(e AuditEvent) (Event, bool) {
+	typ, ok := auditEvents[e.Action]
+	return Event{
+		Type:      typ,
+		Workspace: e.Workspace,
+		Domain:    e.Domain,
+		ShortCode: e.ShortCode,
+		Actor:     e.Actor,
+		RequestID: e.RequestID,
+		At:        e.At,
+		Before:    e.Before,
+		After:     e.After,
+	}, ok
+}
+
+// Delivery - доставка события одной подписке
+type Delivery struct {
+	ID            int64
+	WebhookID     int64
+	Workspace     string
+	Event         string
+	URL           string // Адрес подписки на момент события
+	Payload       json.RawMessage
+	Status        string // Delivery*
+	Attempts      int
+	NextAttemptAt time.Time
+	LastStatus    int    // HTTP-статус последней попытки; 0 - ответа не было
+	LastError     string // Причина последней неудачи
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// DeliveryQuery - фильтры журнала доставок. Доставки возвращаются от новых к старым
+type DeliveryQuery struct {
+	Workspace string
+	WebhookID int64  // 0 - все подписки
+	Status    string // Пусто - все состояния
+	BeforeID  int64  // Только доставки с меньшим ID; 0 - с последней
+	Limit     int
+}
+
+// matches проверяет доставку по всем фильтрам запроса, кроме BeforeID и Limit
+func (q *DeliveryQuery) matches(d *Delivery) bool {
+	switch {
+	case d.Workspace != q.Workspace:
+		return false
+	case q.WebhookID != 0 && d.WebhookID != q.WebhookID:
+		return false
+	case q.Status != "" && d.Status != q.Status:
+		return false
+	}
+	return true
+}
+
+// expiryDue сообщает, что срок ссылки истек к моменту now, а событие об этом еще не записано
+func (u *URL) expiryDue(now time.Time) bool {
+	return u.ExpiresAt != nil && !u.ExpiresAt.After(now) && !u.ExpiryNotified && u.DeletedAt == nil
+}
@@ -0,0 +1,111 @@
+// Пакет tracing настраивает трассировку OpenTelemetry для приложения.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Поддерживаемые экспортеры
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Config содержит настройки трассировки
+type Config struct {
+	Exporter     string // none, stdout или otlp
+	File         string // Файл для stdout экспортера (пусто = stdout)
+	OTLPEndpoint string // URL OTLP/HTTP коллектора (пусто = из OTEL_EXPORTER_OTLP_*)
+	ServiceName  string
+}
+
+// ShutdownFunc сбрасывает накопленные span'ы и освобождает ресурсы
+type ShutdownFunc func(ctx context.Context) error
+
+// Setup настраивает глобальные TracerProvider и пропагатор W3C Trace Context.
+// При Exporter=none span'ы не экспортируются, но входящий traceparent
+// все равно пробрасывается дальше
+func Setup(ctx context.Context, cfg Config) (ShutdownFunc, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if cfg.Exporter == "" || cfg.Exporter == ExporterNone {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, closer, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "url-shortener"
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			if cerr := closer.Close(); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}, nil
+}
+
+// newExporter создает экспортер по конфигу. Второе значение - ресурс,
+// который нужно закрыть после остановки провайдера
+func newExporter(ctx context.Context, cfg Config) (sdktrace.SpanExporter, io.Closer, error) {
+	switch cfg.Exporter {
+	case ExporterStdout:
+		if cfg.File == "" {
+			exp, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+			return exp, nil, err
+		}
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening trace file: %w", err)
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return exp, f, nil
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.OTLPEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint))
+		}
+		exp, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating OTLP exporter: %w", err)
+		}
+		return exp, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter: %s", cfg.Exporter)
+	}
+}
@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetup_StdoutFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "traces.json")
+	ctx := context.Background()
+
+	shutdown, err := Setup(ctx, Config{Exporter: ExporterStdout, File: file, ServiceName: "test"})
+	if err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+
+	_, span := otel.Tracer("test").Start(ctx, "test-span")
+	span.End()
+
+	if err := shutdown(ctx); err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !strings.Contains(string(data), "test-span") {
+		t.Errorf("span not exported to file: %s", data)
+	}
+}
+
+func TestSetup_None(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Config{Exporter: ExporterNone})
+	if err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() error = %v", err)
+	}
+}
+
+func TestSetup_UnknownExporter(t *testing.T) {
+	_, err := Setup(context.Background(), Config{Exporter: "zipkin"})
+	if err == nil {
+		t.Error("Setup() expected error for unknown exporter")
+	}
+}
@@ -0,0 +1,451 @@
+// Пакет transfer читает и пишет записи ссылок в форматах JSONL и CSV
+// для переноса между окружениями и резервного копирования.
+// Записи обрабатываются по одной, без загрузки всего файла в память
+package transfer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/BuzzLyutic/url-shortener/internal/rules"
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+)
+
+// Форматы файлов
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// ErrUnknownFormat возвращается для неподдерживаемого формата
+var ErrUnknownFormat = errors.New("unknown format (must be 'jsonl' or 'csv')")
+
+// maxLineSize - максимальная длина строки JSONL
+const maxLineSize = 1 << 20
+
+// ContentType возвращает MIME-тип формата
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// Writer пишет записи ссылок в поток
+type Writer interface {
+	Write(u *storage.URL) error
+	// Flush дописывает буферизованные данные в поток
+	Flush() error
+}
+
+// Reader читает записи ссылок из потока. В конце потока возвращает io.EOF
+type Reader interface {
+	Read() (*storage.URL, error)
+	// Line возвращает номер строки последней прочитанной записи
+	Line() int
+}
+
+// NewWriter создает Writer для формата
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatJSONL:
+		buf := bufio.NewWriter(w)
+		return &jsonlWriter{buf: buf, enc: json.NewEncoder(buf)}, nil
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// NewReader создает Reader для формата
+func NewReader(r io.Reader, format string) (Reader, error) {
+	switch format {
+	case FormatJSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+		return &jsonlReader{scanner: scanner}, nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.ReuseRecord = true
+		return &csvReader{r: cr}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// record - запись ссылки в файле. Поля перечислены явно, чтобы формат
+// не зависел от внутреннего устройства storage.URL. Запись с deleted_at
+// без original_url - закрытый код окончательно удаленной ссылки
+type record struct {
+	Domain       string       `json:"domain,omitempty"`
+	ShortCode    string       `json:"short_code"`
+	OriginalURL  string       `json:"original_url"`
+	UntaggedURL  string       `json:"untagged_url,omitempty"`
+	CreatedAt    time.Time    `json:"created_at"`
+	CreatedBy    string       `json:"created_by,omitempty"`
+	ExpiresAt    *time.Time   `json:"expires_at,omitempty"`
+	NotBefore    *time.Time   `json:"not_before,omitempty"`
+	PendingURL   string       `json:"pending_url,omitempty"`
+	ExpiredURL   string       `json:"expired_url,omitempty"`
+	Targets      []target     `json:"targets,omitempty"`
+	Sticky       bool         `json:"sticky,omitempty"`
+	Rules        []rules.Rule `json:"rules,omitempty"`
+	ForwardQuery string       `json:"forward_query,omitempty"`
+	ForwardPath  bool         `json:"forward_path,omitempty"`
+	Title        string       `json:"title,omitempty"`
+	Description  string       `json:"description,omitempty"`
+	ImageURL     string       `json:"image_url,omitempty"`
+	Tags         []string     `json:"tags,omitempty"`
+	Note         string       `json:"note,omitempty"`
+	ClicksLeft   *int         `json:"clicks_left,omitempty"`
+	PasswordHash string       `json:"password_hash,omitempty"`
+	Custom       bool         `json:"custom,omitempty"`
+	DeletedAt    *time.Time   `json:"deleted_at,omitempty"`
+}
+
+type target struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	Clicks int64  `json:"clicks,omitempty"`
+}
+
+func toRecord(u *storage.URL) record {
+	rec := record{
+		Domain:       u.Domain,
+		ShortCode:    u.ShortCode,
+		OriginalURL:  u.OriginalURL,
+		UntaggedURL:  u.UntaggedURL,
+		CreatedAt:    u.CreatedAt,
+		CreatedBy:    u.CreatedBy,
+		ExpiresAt:    u.ExpiresAt,
+		NotBefore:    u.NotBefore,
+		PendingURL:   u.PendingURL,
+		ExpiredURL:   u.ExpiredURL,
+		Sticky:       u.Sticky,
+		Rules:        u.Rules,
+		ForwardQuery: u.ForwardQuery,
+		ForwardPath:  u.ForwardPath,
+		Title:        u.Title,
+		Description:  u.Description,
+		ImageURL:     u.ImageURL,
+		Tags:         u.Tags,
+		Note:         u.Note,
+		ClicksLeft:   u.ClicksLeft,
+		PasswordHash: u.PasswordHash,
+		Custom:       u.Custom,
+		DeletedAt:    u.DeletedAt,
+	}
+	for _, t := range u.Targets {
+		rec.Targets = append(rec.Targets, target(t))
+	}
+	return rec
+}
+
+func (rec record) url() *storage.URL {
+	u := &storage.URL{
+		Domain:       rec.Domain,
+		ShortCode:    rec.ShortCode,
+		OriginalURL:  rec.OriginalURL,
+		UntaggedURL:  rec.UntaggedURL,
+		CreatedAt:    rec.CreatedAt,
+		CreatedBy:    rec.CreatedBy,
+		ExpiresAt:    rec.ExpiresAt,
+		NotBefore:    rec.NotBefore,
+		PendingURL:   rec.PendingURL,
+		ExpiredURL:   rec.ExpiredURL,
+		Sticky:       rec.Sticky,
+		Rules:        rec.Rules,
+		ForwardQuery: rec.ForwardQuery,
+		ForwardPath:  rec.ForwardPath,
+		Metadata:     storage.Metadata{Title: rec.Title, Description: rec.Description, ImageURL: rec.ImageURL},
+		Labels:       storage.Labels{Tags: rec.Tags, Note: rec.Note},
+		ClicksLeft:   rec.ClicksLeft,
+		PasswordHash: rec.PasswordHash,
+		Custom:       rec.Custom,
+		DeletedAt:    rec.DeletedAt,
+	}
+	for _, t := range rec.Targets {
+		u.Targets = append(u.Targets, storage.Target(t))
+	}
+	return u
+}
+
+type jsonlWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func (w *jsonlWriter) Write(u *storage.URL) error {
+	return w.enc.Encode(toRecord(u))
+}
+
+func (w *jsonlWriter) Flush() error {
+	return w.buf.Flush()
+}
+
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *jsonlReader) Read() (*storage.URL, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := r.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		return rec.url(), nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("line %d: %w", r.line+1, err)
+	}
+	return nil, io.EOF
+}
+
+func (r *jsonlReader) Line() int {
+	return r.line
+}
+
+type csvWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (w *csvWriter) Write(u *storage.URL) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	rec := toRecord(u)
+	row := make([]string, len(columns))
+	for i, c := range columns {
+		v, err := c.get(&rec)
+		if err != nil {
+			return fmt.Errorf("encoding %s: %w", c.name, err)
+		}
+		row[i] = v
+	}
+	return w.w.Write(row)
+}
+
+// Flush пишет заголовок и для пустой выгрузки, чтобы файл можно было импортировать
+func (w *csvWriter) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *csvWriter) writeHeader() error {
+	if w.headerWritten {
+		return nil
+	}
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.name
+	}
+	w.headerWritten = true
+	return w.w.Write(header)
+}
+
+type csvReader struct {
+	r      *csv.Reader
+	header []*column // Колонки в порядке файла
+}
+
+func (r *csvReader) Read() (*storage.URL, error) {
+	if r.header == nil {
+		if err := r.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	row, err := r.r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	if len(row) != len(r.header) {
+		return nil, fmt.Errorf("line %d: expected %d fields, got %d", r.Line(), len(r.header), len(row))
+	}
+
+	var rec record
+	for i, c := range r.header {
+		if row[i] == "" {
+			continue
+		}
+		if err := c.set(&rec, row[i]); err != nil {
+			return nil, fmt.Errorf("line %d: invalid %s: %w", r.Line(), c.name, err)
+		}
+	}
+	return rec.url(), nil
+}
+
+func (r *csvReader) readHeader() error {
+	row, err := r.r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+		return err
+	}
+	byName := make(map[string]*column, len(columns))
+	for i := range columns {
+		byName[columns[i].name] = &columns[i]
+	}
+	header := make([]*column, len(row))
+	for i, name := range row {
+		c, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("line 1: unknown column %q", name)
+		}
+		header[i] = c
+	}
+	r.header = header
+	// Число полей проверяется по заголовку, а не по первой записи
+	r.r.FieldsPerRecord = -1
+	return nil
+}
+
+func (r *csvReader) Line() int {
+	line, _ := r.r.FieldPos(0)
+	return line
+}
+
+// column описывает колонку CSV: имя совпадает с ключом JSONL,
+// вложенные значения (варианты, правила) хранятся в ячейке как JSON
+type column struct {
+	name string
+	get  func(*record) (string, error)
+	set  func(*record, string) error
+}
+
+var columns = []column{
+	stringColumn("domain", func(r *record) *string { return &r.Domain }),
+	stringColumn("short_code", func(r *record) *string { return &r.ShortCode }),
+	stringColumn("original_url", func(r *record) *string { return &r.OriginalURL }),
+	stringColumn("untagged_url", func(r *record) *string { return &r.UntaggedURL }),
+	{
+		name: "created_at",
+		get:  func(r *record) (string, error) { return formatTime(&r.CreatedAt), nil },
+		set: func(r *record, v string) (err error) {
+			r.CreatedAt, err = time.Parse(time.RFC3339Nano, v)
+			return err
+		},
+	},
+	stringColumn("created_by", func(r *record) *string { return &r.CreatedBy }),
+	timeColumn("expires_at", func(r *record) **time.Time { return &r.ExpiresAt }),
+	timeColumn("not_before", func(r *record) **time.Time { return &r.NotBefore }),
+	stringColumn("pending_url", func(r *record) *string { return &r.PendingURL }),
+	stringColumn("expired_url", func(r *record) *string { return &r.ExpiredURL }),
+	jsonColumn("targets", func(r *record) any { return &r.Targets }, func(r *record) bool { return len(r.Targets) == 0 }),
+	boolColumn("sticky", func(r *record) *bool { return &r.Sticky }),
+	jsonColumn("rules", func(r *record) any { return &r.Rules }, func(r *record) bool { return len(r.Rules) == 0 }),
+	stringColumn("forward_query", func(r *record) *string { return &r.ForwardQuery }),
+	boolColumn("forward_path", func(r *record) *bool { return &r.ForwardPath }),
+	stringColumn("title", func(r *record) *string { return &r.Title }),
+	stringColumn("description", func(r *record) *string { return &r.Description }),
+	stringColumn("image_url", func(r *record) *string { return &r.ImageURL }),
+	jsonColumn("tags", func(r *record) any { return &r.Tags }, func(r *record) bool { return len(r.Tags) == 0 }),
+	stringColumn("note", func(r *record) *string { return &r.Note }),
+	{
+		name: "clicks_left",
+		get: func(r *record) (string, error) {
+			if r.ClicksLeft == nil {
+				return "", nil
+			}
+			return strconv.Itoa(*r.ClicksLeft), nil
+		},
+		set: func(r *record, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return err
+			}
+			r.ClicksLeft = &n
+			return nil
+		},
+	},
+	stringColumn("password_hash", func(r *record) *string { return &r.PasswordHash }),
+	boolColumn("custom", func(r *record) *bool { return &r.Custom }),
+	timeColumn("deleted_at", func(r *record) **time.Time { return &r.DeletedAt }),
+}
+
+func stringColumn(name string, field func(*record) *string) column {
+	return column{
+		name: name,
+		get:  func(r *record) (string, error) { return *field(r), nil },
+		set: func(r *record, v string) error {
+			*field(r) = v
+			return nil
+		},
+	}
+}
+
+func boolColumn(name string, field func(*record) *bool) column {
+	return column{
+		name: name,
+		get: func(r *record) (string, error) {
+			if !*field(r) {
+				return "", nil
+			}
+			return "true", nil
+		},
+		set: func(r *record, v string) (err error) {
+			*field(r), err = strconv.ParseBool(v)
+			return err
+		},
+	}
+}
+
+func timeColumn(name string, field func(*record) **time.Time) column {
+	return column{
+		name: name,
+		get:  func(r *record) (string, error) { return formatTime(*field(r)), nil },
+		set: func(r *record, v string) error {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return err
+			}
+			*field(r) = &t
+			return nil
+		},
+	}
+}
+
+func jsonColumn(name string, field func(*record) any, empty func(*record) bool) column {
+	return column{
+		name: name,
+		get: func(r *record) (string, error) {
+			if empty(r) {
+				return "", nil
+			}
+			data, err := json.Marshal(field(r))
+			return string(data), err
+		},
+		set: func(r *record, v string) error {
+			return json.Unmarshal([]byte(v), field(r))
+		},
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
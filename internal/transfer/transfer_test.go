@@ -0,0 +1,130 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BuzzLyutic/url-shortener/internal/rules"
+	"github.com/BuzzLyutic/url-shortener/internal/storage"
+)
+
+func TestRoundTrip(t *testing.T) {
+	created := time.Date(2026, 3, 1, 12, 0, 0, 123456000, time.UTC)
+	expires := created.Add(24 * time.Hour)
+	clicks := 3
+	urls := []*storage.URL{
+		{ShortCode: "aB3xY9kL2m", OriginalURL: "https://example.com/page", CreatedAt: created},
+		{
+			Domain:       "go.example",
+			ShortCode:    "Zx81kLmn0p",
+			OriginalURL:  "https://example.com/a?utm_source=mail",
+			UntaggedURL:  "https://example.com/a",
+			CreatedAt:    created,
+			CreatedBy:    "alice",
+			ExpiresAt:    &expires,
+			ExpiredURL:   "https://example.com/gone",
+			Targets:      []storage.Target{{URL: "https://example.com/a", Weight: 1, Clicks: 7}, {URL: "https://example.com/b", Weight: 3}},
+			Sticky:       true,
+			Rules:        []rules.Rule{{Platform: rules.PlatformIOS, URL: "https://apps.apple.com/app/id1"}},
+			ForwardQuery: "append",
+			ForwardPath:  true,
+			Metadata:     storage.Metadata{Title: "Title, with \"quotes\"", Description: "Line\nbreak"},
+			Labels:       storage.Labels{Tags: []string{"promo", "q1"}, Note: "Spring campaign"},
+			ClicksLeft:   &clicks,
+			PasswordHash: "$2a$10$hash",
+			Custom:       true,
+		},
+		// Удаленная ссылка и закрытый код окончательно удаленной
+		{ShortCode: "dE1eted000", OriginalURL: "https://example.com/old", CreatedAt: created, Custom: true, DeletedAt: &expires},
+		{ShortCode: "pUrged0000", CreatedAt: expires, DeletedAt: &expires},
+	}
+
+	for _, format := range []string{FormatJSONL, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, format)
+			if err != nil {
+				t.Fatalf("NewWriter() error = %v", err)
+			}
+			for _, u := range urls {
+				if err := w.Write(u); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+
+			r, err := NewReader(&buf, format)
+			if err != nil {
+				t.Fatalf("NewReader() error = %v", err)
+			}
+			for _, want := range urls {
+				got, err := r.Read()
+				if err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Read() = %+v, want %+v", got, want)
+				}
+			}
+			if _, err := r.Read(); !errors.Is(err, io.EOF) {
+				t.Errorf("Read() at end error = %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	t.Run("columns in any order", func(t *testing.T) {
+		data := "original_url,short_code,created_at\nhttps://example.com,aB3xY9kL2m,2026-03-01T12:00:00Z\n"
+		r, _ := NewReader(strings.NewReader(data), FormatCSV)
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if got.ShortCode != "aB3xY9kL2m" || got.OriginalURL != "https://example.com" || got.CreatedAt.IsZero() {
+			t.Errorf("Read() = %+v", got)
+		}
+		if r.Line() != 2 {
+			t.Errorf("Line() = %d, want 2", r.Line())
+		}
+	})
+
+	t.Run("unknown column", func(t *testing.T) {
+		r, _ := NewReader(strings.NewReader("short_code,clicks\n"), FormatCSV)
+		if _, err := r.Read(); err == nil {
+			t.Error("Read() expected error for unknown column")
+		}
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		r, _ := NewReader(strings.NewReader("short_code,sticky\naB3xY9kL2m,maybe\n"), FormatCSV)
+		if _, err := r.Read(); err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("Read() error = %v, want error at line 2", err)
+		}
+	})
+
+	t.Run("empty export keeps header", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, _ := NewWriter(&buf, FormatCSV)
+		w.Flush()
+		if !strings.HasPrefix(buf.String(), "domain,short_code,original_url") {
+			t.Errorf("output = %q", buf.String())
+		}
+	})
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := NewWriter(io.Discard, "xml"); err != ErrUnknownFormat {
+		t.Errorf("NewWriter() error = %v, want %v", err, ErrUnknownFormat)
+	}
+	if _, err := NewReader(strings.NewReader(""), "xml"); err != ErrUnknownFormat {
+		t.Errorf("NewReader() error = %v, want %v", err, ErrUnknownFormat)
+	}
+}
@@ -0,0 +1,11 @@
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_domain_original_url_key;
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_domain_short_code_key;
+
+-- Ссылки дополнительных доменов не укладываются в глобальную уникальность
+DELETE FROM urls WHERE domain <> '';
+ALTER TABLE urls DROP COLUMN IF EXISTS domain;
+
+ALTER TABLE urls ADD CONSTRAINT urls_short_code_key UNIQUE (short_code);
+ALTER TABLE urls ADD CONSTRAINT urls_original_url_key UNIQUE (original_url);
+CREATE INDEX IF NOT EXISTS idx_urls_short_code ON urls(short_code);
+CREATE INDEX IF NOT EXISTS idx_urls_original_url ON urls(original_url);
@@ -0,0 +1,11 @@
+-- Короткий домен ссылки; пустая строка означает основной домен
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS domain VARCHAR(253) NOT NULL DEFAULT '';
+
+-- Уникальность кода и оригинального URL теперь в пределах домена
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_short_code_key;
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_original_url_key;
+DROP INDEX IF EXISTS idx_urls_short_code;
+DROP INDEX IF EXISTS idx_urls_original_url;
+
+ALTER TABLE urls ADD CONSTRAINT urls_domain_short_code_key UNIQUE (domain, short_code);
+ALTER TABLE urls ADD CONSTRAINT urls_domain_original_url_key UNIQUE (domain, original_url);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_urls_domain_original_url;
+
+-- Без колонки custom защищенные ссылки неотличимы от обычных и нарушили бы уникальность
+DELETE FROM urls WHERE custom;
+ALTER TABLE urls DROP COLUMN IF EXISTS custom;
+ALTER TABLE urls DROP COLUMN IF EXISTS password_hash;
+
+ALTER TABLE urls ADD CONSTRAINT urls_domain_original_url_key UNIQUE (domain, original_url);
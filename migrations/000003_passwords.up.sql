@@ -0,0 +1,8 @@
+-- Пароль ссылки (соленый хэш); пустая строка означает ссылку без пароля
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS password_hash TEXT NOT NULL DEFAULT '';
+
+-- Ссылки с индивидуальными настройками не участвуют в дедупликации,
+-- поэтому уникальность оригинального URL проверяется только для остальных
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS custom BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_domain_original_url_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_urls_domain_original_url ON urls(domain, original_url) WHERE NOT custom;
@@ -0,0 +1,2 @@
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_clicks_left_check;
+ALTER TABLE urls DROP COLUMN IF EXISTS clicks_left;
@@ -0,0 +1,3 @@
+-- Оставшееся число переходов; NULL означает ссылку без лимита
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS clicks_left INTEGER;
+ALTER TABLE urls ADD CONSTRAINT urls_clicks_left_check CHECK (clicks_left IS NULL OR clicks_left >= 0);
@@ -0,0 +1,3 @@
+ALTER TABLE urls DROP COLUMN IF EXISTS expired_url;
+ALTER TABLE urls DROP COLUMN IF EXISTS pending_url;
+ALTER TABLE urls DROP COLUMN IF EXISTS not_before;
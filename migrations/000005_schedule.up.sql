@@ -0,0 +1,6 @@
+-- Начало действия ссылки; NULL означает, что ссылка действует сразу
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS not_before TIMESTAMPTZ;
+
+-- Резервные адреса до начала действия и после истечения срока; пустая строка - нет
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS pending_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS expired_url TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS url_targets;
+ALTER TABLE urls DROP COLUMN IF EXISTS sticky;
@@ -0,0 +1,12 @@
+-- Закреплять выбранный вариант за посетителем
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS sticky BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Варианты адреса назначения для A/B ротации и счетчики переходов по ним
+CREATE TABLE IF NOT EXISTS url_targets (
+    url_id BIGINT NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    weight INTEGER NOT NULL CHECK (weight >= 0),
+    clicks BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (url_id, position)
+);
@@ -0,0 +1,2 @@
+-- Правила выбора адреса по платформе, языку и параметрам запроса (JSON массив)
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS rules JSONB;
@@ -0,0 +1,3 @@
+-- Передача параметров запроса и пути после короткого кода в адрес назначения
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS forward_query TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS forward_path BOOLEAN NOT NULL DEFAULT FALSE;
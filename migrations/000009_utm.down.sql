@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_urls_untagged_url;
+ALTER TABLE urls DROP COLUMN IF EXISTS untagged_url;
@@ -0,0 +1,3 @@
+-- Адрес до добавления UTM-меток, чтобы ссылки находились и по нему
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS untagged_url TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_urls_untagged_url ON urls(untagged_url) WHERE untagged_url <> '';
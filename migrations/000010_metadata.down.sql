@@ -0,0 +1,3 @@
+ALTER TABLE urls DROP COLUMN IF EXISTS image_url;
+ALTER TABLE urls DROP COLUMN IF EXISTS description;
+ALTER TABLE urls DROP COLUMN IF EXISTS title;
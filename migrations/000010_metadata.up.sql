@@ -0,0 +1,4 @@
+-- Описание ссылки для OpenGraph-разметки при превью в соцсетях и мессенджерах
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS image_url TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_urls_untagged_url_trgm;
+DROP INDEX IF EXISTS idx_urls_original_url_trgm;
+DROP INDEX IF EXISTS idx_urls_domain_dest_host;
+DROP INDEX IF EXISTS idx_urls_domain_created_by;
+DROP INDEX IF EXISTS idx_urls_domain_created;
+ALTER TABLE urls DROP COLUMN IF EXISTS dest_host;
+ALTER TABLE urls DROP COLUMN IF EXISTS created_by;
@@ -0,0 +1,15 @@
+-- Автор ссылки и хост назначения для фильтрации списка ссылок
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS created_by TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS dest_host TEXT GENERATED ALWAYS AS (
+    lower(substring(original_url from '^[A-Za-z][A-Za-z0-9+.-]*://(?:[^@/?#]*@)?([^/:?#]+)'))
+) STORED;
+
+-- Индексы для постраничной выборки по ключу (created_at, short_code)
+CREATE INDEX IF NOT EXISTS idx_urls_domain_created ON urls(domain, created_at, short_code);
+CREATE INDEX IF NOT EXISTS idx_urls_domain_created_by ON urls(domain, created_by, created_at, short_code);
+CREATE INDEX IF NOT EXISTS idx_urls_domain_dest_host ON urls(domain, dest_host, created_at, short_code);
+
+-- Триграммные индексы для поиска по подстроке адреса
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS idx_urls_original_url_trgm ON urls USING GIN (original_url gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_urls_untagged_url_trgm ON urls USING GIN (untagged_url gin_trgm_ops);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_urls_tags;
+ALTER TABLE urls DROP COLUMN IF EXISTS note;
+ALTER TABLE urls DROP COLUMN IF EXISTS tags;
@@ -0,0 +1,6 @@
+-- Метки и заметка для упорядочивания ссылок
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS note TEXT NOT NULL DEFAULT '';
+
+-- Индекс для фильтра списка по меткам (tags @> ...)
+CREATE INDEX IF NOT EXISTS idx_urls_tags ON urls USING GIN (tags);
@@ -0,0 +1,8 @@
+-- Ссылки разных пространств на один адрес нарушили бы прежнюю уникальность
+DELETE FROM urls a USING urls b
+WHERE NOT a.custom AND NOT b.custom AND a.domain = b.domain AND a.original_url = b.original_url AND a.id > b.id;
+
+DROP INDEX IF EXISTS idx_urls_workspace_created;
+DROP INDEX IF EXISTS idx_urls_workspace_original_url;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_urls_domain_original_url ON urls(domain, original_url) WHERE NOT custom;
+ALTER TABLE urls DROP COLUMN IF EXISTS workspace;
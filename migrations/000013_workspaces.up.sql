@@ -0,0 +1,9 @@
+-- Рабочее пространство владельца ссылки; пустая строка - пространство по умолчанию
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS workspace TEXT NOT NULL DEFAULT '';
+
+-- Дедупликация обычных ссылок выполняется в пределах пространства
+DROP INDEX IF EXISTS idx_urls_domain_original_url;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_urls_workspace_original_url ON urls(workspace, domain, original_url) WHERE NOT custom;
+
+-- Список ссылок и квоты выбираются по пространству
+CREATE INDEX IF NOT EXISTS idx_urls_workspace_created ON urls(workspace, domain, created_at, short_code);
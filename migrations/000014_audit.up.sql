@@ -0,0 +1,29 @@
+-- Журнал изменений ссылок: кто, когда и в рамках какого запроса изменил ссылку,
+-- и ее состояние до и после изменения
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    workspace TEXT NOT NULL DEFAULT '',
+    domain TEXT NOT NULL DEFAULT '',
+    short_code TEXT NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL,
+    before JSONB,
+    after JSONB
+);
+
+-- История ссылки и журнал пространства выбираются от новых событий к старым
+CREATE INDEX IF NOT EXISTS idx_audit_log_link ON audit_log(domain, short_code, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_workspace ON audit_log(workspace, id);
+
+-- Журнал только дополняется: изменение и удаление записей запрещены
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_urls_switch_at;
+ALTER TABLE urls DROP COLUMN IF EXISTS switch_at;
+ALTER TABLE urls DROP COLUMN IF EXISTS next_url;
+DROP TABLE IF EXISTS url_revisions;
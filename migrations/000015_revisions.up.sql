@@ -0,0 +1,16 @@
+-- Прежние адреса назначения ссылок, пронумерованные в порядке замены
+CREATE TABLE IF NOT EXISTS url_revisions (
+    url_id BIGINT NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    revision INTEGER NOT NULL,
+    original_url TEXT NOT NULL,
+    untagged_url TEXT NOT NULL DEFAULT '',
+    replaced_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (url_id, revision)
+);
+
+-- Запланированная смена адреса назначения
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS next_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS switch_at TIMESTAMPTZ;
+
+-- Фоновая смена адресов выбирает только ссылки с запланированной сменой
+CREATE INDEX IF NOT EXISTS idx_urls_switch_at ON urls(switch_at) WHERE switch_at IS NOT NULL;
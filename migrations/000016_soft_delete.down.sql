@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS code_tombstones;
+DROP INDEX IF EXISTS idx_urls_deleted_at;
+ALTER TABLE urls DROP COLUMN IF EXISTS deleted_at;
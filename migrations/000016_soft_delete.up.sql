@@ -0,0 +1,16 @@
+-- Момент удаления ссылки; NULL - ссылка не удалена
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+-- Окончательное удаление выбирает только удаленные ссылки
+CREATE INDEX IF NOT EXISTS idx_urls_deleted_at ON urls(deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Коды удаленных ссылок. Записи не удаляются, поэтому код не выдается повторно
+-- и после окончательного удаления ссылки. По пространству удаленной ссылки
+-- закрытые коды попадают в выгрузку
+CREATE TABLE IF NOT EXISTS code_tombstones (
+    workspace TEXT NOT NULL DEFAULT '',
+    domain TEXT NOT NULL,
+    short_code TEXT NOT NULL,
+    deleted_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (domain, short_code)
+);
@@ -0,0 +1,46 @@
+-- Подписки рабочих пространств на события ссылок; пустой events - все события
+CREATE TABLE IF NOT EXISTS webhooks (
+    id BIGSERIAL PRIMARY KEY,
+    workspace TEXT NOT NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT[] NOT NULL DEFAULT '{}',
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhooks_workspace ON webhooks(workspace);
+
+-- Очередь и журнал доставок. Доставки ставятся в очередь в транзакции изменения ссылки
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_id BIGINT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    workspace TEXT NOT NULL,
+    event TEXT NOT NULL,
+    url TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    last_status INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- Фоновая отправка выбирает только ожидающие доставки
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at)
+    WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_workspace ON webhook_deliveries(workspace, id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id);
+-- Отправленные доставки удаляются по истечении WEBHOOK_RETENTION
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_delivered ON webhook_deliveries(updated_at)
+    WHERE status = 'delivered';
+
+-- Истечение срока ссылки записывается в журнал один раз. Ссылки, истекшие
+-- до появления вебхуков, событий не порождают
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS expiry_notified BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE urls SET expiry_notified = TRUE WHERE expires_at <= NOW();
+
+CREATE INDEX IF NOT EXISTS idx_urls_expiry_due ON urls(expires_at)
+    WHERE expires_at IS NOT NULL AND NOT expiry_notified;